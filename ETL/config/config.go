@@ -2,15 +2,20 @@ package config
 
 import (
 	"time"
+
+	appconfig "github.com/LilVoxy/coursework_chat/config"
 )
 
 // ETLConfig содержит конфигурацию для ETL-процесса
 type ETLConfig struct {
 	// Конфигурация для подключения к OLTP БД (исходной)
-	OLTPConfig DatabaseConfig `json:"oltp_config"`
+	OLTPConfig appconfig.DatabaseConfig `json:"oltp_config"`
 
 	// Конфигурация для подключения к OLAP БД (целевой)
-	OLAPConfig DatabaseConfig `json:"olap_config"`
+	OLAPConfig appconfig.DatabaseConfig `json:"olap_config"`
+
+	// Ключи шифрования сообщений OLTP БД (расшифровка при извлечении)
+	Encryption appconfig.EncryptionConfig `json:"-"`
 
 	// Интервал запуска ETL
 	RunInterval time.Duration `json:"run_interval"`
@@ -34,48 +39,17 @@ type ETLConfig struct {
 	EnableDetailedLogging bool `json:"enable_detailed_logging"`
 }
 
-// DatabaseConfig содержит настройки подключения к базе данных
-type DatabaseConfig struct {
-	Driver   string `json:"driver"`
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-	DBName   string `json:"dbname"`
-}
-
-// Значения конфигурации по умолчанию
-var (
-	DefaultOLTPConfig = DatabaseConfig{
-		Driver:   "mysql",
-		Host:     "localhost",
-		Port:     3306,
-		User:     "root",
-		Password: "Vjnbkmlf40782",
-		DBName:   "chatdb",
-	}
-
-	DefaultOLAPConfig = DatabaseConfig{
-		Driver:   "mysql",
-		Host:     "localhost",
-		Port:     3306,
-		User:     "root",
-		Password: "Vjnbkmlf40782",
-		DBName:   "chat_analytics",
-	}
-
-	DefaultETLConfig = ETLConfig{
-		OLTPConfig:            DefaultOLTPConfig,
-		OLAPConfig:            DefaultOLAPConfig,
-		RunInterval:           1 * time.Hour,
-		BatchSize:             10000,
-		EnableDetailedLogging: true,
+// GetConfig возвращает конфигурацию ETL из общей конфигурации сервера чата:
+// OLTP - секция database, OLAP - секция analytics, расписание - секция etl
+func GetConfig(app *appconfig.Config) ETLConfig {
+	config := ETLConfig{
+		OLTPConfig:            app.Database,
+		OLAPConfig:            app.Analytics,
+		Encryption:            app.Encryption,
+		RunInterval:           app.ETL.RunInterval,
+		BatchSize:             app.ETL.BatchSize,
+		EnableDetailedLogging: app.ETL.DetailedLogging,
 	}
-)
-
-// GetConfig возвращает конфигурацию ETL
-func GetConfig() ETLConfig {
-	config := DefaultETLConfig
 
 	// Настройка порогов активности
 	config.ActivityThresholds.High = 20  // 20+ сообщений - высокая активность
@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -21,23 +20,15 @@ func ConnectDatabases(config ETLConfig) (*DBConnections, error) {
 	var err error
 
 	// Подключение к OLTP базе данных (исходная)
-	oltpDSN := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		config.OLTPConfig.User,
-		config.OLTPConfig.Password,
-		config.OLTPConfig.Host,
-		config.OLTPConfig.Port,
-		config.OLTPConfig.DBName,
-	)
-
-	connections.OLTPDB, err = sql.Open(config.OLTPConfig.Driver, oltpDSN)
+	connections.OLTPDB, err = sql.Open("mysql", config.OLTPConfig.DSN("parseTime=true"))
 	if err != nil {
 		return nil, fmt.Errorf("ошибка подключения к OLTP базе данных: %w", err)
 	}
 
 	// Настройка параметров подключения к OLTP
-	connections.OLTPDB.SetMaxOpenConns(10)
-	connections.OLTPDB.SetMaxIdleConns(5)
-	connections.OLTPDB.SetConnMaxLifetime(5 * time.Minute)
+	connections.OLTPDB.SetMaxOpenConns(config.OLTPConfig.MaxOpenConns)
+	connections.OLTPDB.SetMaxIdleConns(config.OLTPConfig.MaxIdleConns)
+	connections.OLTPDB.SetConnMaxLifetime(config.OLTPConfig.ConnMaxLifetime)
 
 	// Проверка подключения к OLTP
 	if err := connections.OLTPDB.Ping(); err != nil {
@@ -45,15 +36,7 @@ func ConnectDatabases(config ETLConfig) (*DBConnections, error) {
 	}
 
 	// Подключение к OLAP базе данных (целевая)
-	olapDSN := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		config.OLAPConfig.User,
-		config.OLAPConfig.Password,
-		config.OLAPConfig.Host,
-		config.OLAPConfig.Port,
-		config.OLAPConfig.DBName,
-	)
-
-	connections.OLAPDB, err = sql.Open(config.OLAPConfig.Driver, olapDSN)
+	connections.OLAPDB, err = sql.Open("mysql", config.OLAPConfig.DSN("parseTime=true"))
 	if err != nil {
 		// Закрываем первое подключение при ошибке
 		connections.OLTPDB.Close()
@@ -61,9 +44,9 @@ func ConnectDatabases(config ETLConfig) (*DBConnections, error) {
 	}
 
 	// Настройка параметров подключения к OLAP
-	connections.OLAPDB.SetMaxOpenConns(10)
-	connections.OLAPDB.SetMaxIdleConns(5)
-	connections.OLAPDB.SetConnMaxLifetime(5 * time.Minute)
+	connections.OLAPDB.SetMaxOpenConns(config.OLAPConfig.MaxOpenConns)
+	connections.OLAPDB.SetMaxIdleConns(config.OLAPConfig.MaxIdleConns)
+	connections.OLAPDB.SetConnMaxLifetime(config.OLAPConfig.ConnMaxLifetime)
 
 	// Проверка подключения к OLAP
 	if err := connections.OLAPDB.Ping(); err != nil {
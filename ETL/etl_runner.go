@@ -18,6 +18,9 @@ import (
 	"github.com/LilVoxy/coursework_chat/ETL/models"
 	"github.com/LilVoxy/coursework_chat/ETL/transform"
 	"github.com/LilVoxy/coursework_chat/ETL/utils"
+	appconfig "github.com/LilVoxy/coursework_chat/config"
+	"github.com/LilVoxy/coursework_chat/dbcrypt"
+	"github.com/LilVoxy/coursework_chat/migrate"
 	"github.com/go-co-op/gocron"
 )
 
@@ -34,10 +37,7 @@ type ETLRunner struct {
 }
 
 // NewETLRunner создает новый экземпляр ETLRunner
-func NewETLRunner() (*ETLRunner, error) {
-	// Получаем конфигурацию
-	etlConfig := config.GetConfig()
-
+func NewETLRunner(etlConfig config.ETLConfig) (*ETLRunner, error) {
 	// Инициализируем логгер
 	logger := utils.NewETLLogger(etlConfig.EnableDetailedLogging)
 	logger.Info("Инициализация ETL Runner")
@@ -48,16 +48,31 @@ func NewETLRunner() (*ETLRunner, error) {
 		return nil, fmt.Errorf("ошибка подключения к базам данных: %w", err)
 	}
 
+	// Приводим схему аналитической базы к текущей версии
+	if etlConfig.OLAPConfig.AutoMigrate {
+		migrator, err := migrate.New(connections.OLAPDB, migrate.OLAP)
+		if err != nil {
+			config.CloseDatabases(connections)
+			return nil, err
+		}
+		if _, err := migrator.Up(context.Background(), 0); err != nil {
+			config.CloseDatabases(connections)
+			return nil, fmt.Errorf("ошибка применения миграций OLAP: %w", err)
+		}
+	}
+
 	// Инициализируем репозиторий логов ETL
 	etlLogRepo := models.NewMySQLETLLogRepository(connections.OLAPDB)
 
-	// Создаем таблицу логов, если она еще не существует
-	if err := etlLogRepo.CreateETLLogTable(); err != nil {
-		return nil, fmt.Errorf("ошибка при создании таблицы логов ETL: %w", err)
+	// Сообщения в OLTP зашифрованы тем же ключом, что использует сервер чата
+	messageCipher, err := dbcrypt.NewCipherFromConfig(etlConfig.Encryption)
+	if err != nil {
+		config.CloseDatabases(connections)
+		return nil, fmt.Errorf("ошибка настройки расшифровки сообщений: %w", err)
 	}
 
 	// Создаем экстрактор
-	extractor := extractors.NewExtractor(connections.OLTPDB, logger, etlConfig.BatchSize)
+	extractor := extractors.NewExtractor(connections.OLTPDB, logger, etlConfig.BatchSize, messageCipher)
 
 	// Создаем трансформатор
 	transformer := transform.NewTransformer(connections.OLTPDB, connections.OLAPDB, logger)
@@ -258,8 +273,8 @@ func (r *ETLRunner) StartScheduler(ctx context.Context) {
 }
 
 // RunOnce запускает ETL процесс один раз
-func RunOnce() {
-	runner, err := NewETLRunner()
+func RunOnce(etlConfig config.ETLConfig) {
+	runner, err := NewETLRunner(etlConfig)
 	if err != nil {
 		log.Fatalf("Ошибка при создании ETL Runner: %v", err)
 	}
@@ -271,7 +286,7 @@ func RunOnce() {
 }
 
 // RunScheduled запускает ETL процесс по расписанию
-func RunScheduled() {
+func RunScheduled(etlConfig config.ETLConfig) {
 	// Создаем контекст, который будет отменен при получении сигнала завершения
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -287,7 +302,7 @@ func RunScheduled() {
 		cancel()
 	}()
 
-	runner, err := NewETLRunner()
+	runner, err := NewETLRunner(etlConfig)
 	if err != nil {
 		log.Fatalf("Ошибка при создании ETL Runner: %v", err)
 	}
@@ -324,12 +339,12 @@ func (r *ETLRunner) runLinearRegressionWithParams(days, forecast int, confidence
 }
 
 // RunLinearRegression запускает только линейную регрессию с пользовательскими параметрами
-func RunLinearRegression(days, forecast int, confidence, minR2 float64) {
+func RunLinearRegression(etlConfig config.ETLConfig, days, forecast int, confidence, minR2 float64) {
 	log.Println("Запуск утилиты линейной регрессии")
 	startTime := time.Now()
 
 	// Создаем ETL Runner
-	runner, err := NewETLRunner()
+	runner, err := NewETLRunner(etlConfig)
 	if err != nil {
 		log.Fatalf("Ошибка при создании ETL Runner: %v", err)
 	}
@@ -377,6 +392,7 @@ func (r *ETLRunner) runChatRankWithParams(
 
 // RunChatRank запускает только ChatRank
 func RunChatRank(
+	etlConfig config.ETLConfig,
 	damping float64,
 	maxIterations int,
 	epsilon float64,
@@ -386,7 +402,7 @@ func RunChatRank(
 	startTime := time.Now()
 
 	// Создаем ETL Runner
-	runner, err := NewETLRunner()
+	runner, err := NewETLRunner(etlConfig)
 	if err != nil {
 		log.Fatalf("Ошибка при создании ETL Runner: %v", err)
 	}
@@ -422,19 +438,31 @@ func main() {
 	lengthFactorPtr := flag.Float64("length-factor", 0.25, "Вес фактора длины сообщений (только для режима cr)")
 	continuationFactorPtr := flag.Float64("continuation-factor", 0.25, "Вес фактора продолжения беседы (только для режима cr)")
 
-	flag.Parse()
+	// Подключения к БД и расписание - из общей конфигурации с сервером чата
+	// (файл --config, переменные окружения CHAT_*, флаги); она же разбирает флаги выше
+	appConfig, err := appconfig.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Ошибка конфигурации: %v", err)
+	}
+	if appConfig.PrintConfig {
+		if err := appConfig.Print(os.Stdout); err != nil {
+			log.Fatalf("Ошибка вывода конфигурации: %v", err)
+		}
+		return
+	}
+	etlConfig := config.GetConfig(appConfig)
 
 	log.Println("Запуск ETL Runner в режиме:", *modePtr)
 
 	switch *modePtr {
 	case "once":
-		RunOnce()
+		RunOnce(etlConfig)
 	case "scheduled":
-		RunScheduled()
+		RunScheduled(etlConfig)
 	case "lr":
-		RunLinearRegression(*daysPtr, *forecastPtr, *confidencePtr, *minR2Ptr)
+		RunLinearRegression(etlConfig, *daysPtr, *forecastPtr, *confidencePtr, *minR2Ptr)
 	case "cr":
-		RunChatRank(*dampingPtr, *iterationsPtr, *epsilonPtr,
+		RunChatRank(etlConfig, *dampingPtr, *iterationsPtr, *epsilonPtr,
 			*timeFactorPtr, *responseFactorPtr, *lengthFactorPtr, *continuationFactorPtr)
 	default:
 		log.Println("Неизвестный режим работы:", *modePtr)
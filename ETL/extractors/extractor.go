@@ -7,6 +7,7 @@ import (
 
 	"github.com/LilVoxy/coursework_chat/ETL/models"
 	"github.com/LilVoxy/coursework_chat/ETL/utils"
+	"github.com/LilVoxy/coursework_chat/dbcrypt"
 )
 
 // Extractor координирует процесс извлечения данных из OLTP
@@ -20,13 +21,13 @@ type Extractor struct {
 }
 
 // NewExtractor создает новый экземпляр Extractor
-func NewExtractor(db *sql.DB, logger *utils.ETLLogger, batchSize int) *Extractor {
+func NewExtractor(db *sql.DB, logger *utils.ETLLogger, batchSize int, cipher *dbcrypt.Cipher) *Extractor {
 	return &Extractor{
 		db:               db,
 		logger:           logger,
 		userExtractor:    NewUserExtractor(db, logger),
 		chatExtractor:    NewChatExtractor(db, logger),
-		messageExtractor: NewMessageExtractor(db, logger),
+		messageExtractor: NewMessageExtractor(db, logger, cipher),
 		batchSize:        batchSize,
 	}
 }
@@ -7,22 +7,35 @@ import (
 
 	"github.com/LilVoxy/coursework_chat/ETL/models"
 	"github.com/LilVoxy/coursework_chat/ETL/utils"
+	"github.com/LilVoxy/coursework_chat/dbcrypt"
 )
 
 // MessageExtractor извлекает данные о сообщениях из OLTP БД
 type MessageExtractor struct {
 	db     *sql.DB
 	logger *utils.ETLLogger
+	cipher *dbcrypt.Cipher // Текст сообщений хранится в OLTP в зашифрованном виде
 }
 
 // NewMessageExtractor создает новый экземпляр MessageExtractor
-func NewMessageExtractor(db *sql.DB, logger *utils.ETLLogger) *MessageExtractor {
+func NewMessageExtractor(db *sql.DB, logger *utils.ETLLogger, cipher *dbcrypt.Cipher) *MessageExtractor {
 	return &MessageExtractor{
 		db:     db,
 		logger: logger,
+		cipher: cipher,
 	}
 }
 
+// decrypt расшифровывает текст сообщения; при ошибке текст считается пустым
+func (e *MessageExtractor) decrypt(message *models.MessageOLTP) {
+	plaintext, err := e.cipher.Decrypt(message.Message)
+	if err != nil {
+		e.logger.Error("Не удалось расшифровать сообщение %d: %v", message.ID, err)
+		plaintext = ""
+	}
+	message.Message = plaintext
+}
+
 // ExtractMessages извлекает данные о сообщениях
 // Теперь извлекает только по id (lastMessageID), игнорируя дату
 func (e *MessageExtractor) ExtractMessages(_ time.Time, lastMessageID int, batchSize int) ([]models.MessageOLTP, error) {
@@ -62,6 +75,7 @@ func (e *MessageExtractor) ExtractMessages(_ time.Time, lastMessageID int, batch
 			e.logger.Error("Ошибка при обработке данных сообщения: %v", err)
 			return nil, fmt.Errorf("ошибка обработки данных сообщения: %w", err)
 		}
+		e.decrypt(&message)
 		messages = append(messages, message)
 	}
 
@@ -101,6 +115,7 @@ func (e *MessageExtractor) GetMessagesByChatID(chatID int) ([]models.MessageOLTP
 			e.logger.Error("Ошибка при обработке данных сообщения: %v", err)
 			return nil, fmt.Errorf("ошибка обработки данных сообщения: %w", err)
 		}
+		e.decrypt(&message)
 		messages = append(messages, message)
 	}
 
@@ -59,12 +59,7 @@ func (p *RegressionProcessor) Process() error {
 	startTime := time.Now()
 	p.logger.Info("Запуск процесса линейной регрессии для прогнозирования активности")
 
-	// 1. Убеждаемся, что таблица существует
-	if err := p.repository.EnsureTableExists(); err != nil {
-		return fmt.Errorf("ошибка при проверке/создании таблицы: %w", err)
-	}
-
-	// 2. Получаем данные для анализа
+	// 1. Получаем данные для анализа
 	p.logger.Info("Получение данных о ежедневной активности за последние %d дней", p.config.AnalysisPeriodDays)
 	dataPoints, err := p.dataService.GetDailyActivityData(p.config.AnalysisPeriodDays)
 	if err != nil {
@@ -73,14 +68,14 @@ func (p *RegressionProcessor) Process() error {
 
 	p.logger.Info("Получено %d точек данных для анализа", len(dataPoints))
 
-	// 3. Строим модель линейной регрессии
+	// 2. Строим модель линейной регрессии
 	p.logger.Info("Построение модели линейной регрессии (все значения округляются до тысячных)")
 	regressionResult, err := LinearRegression(dataPoints)
 	if err != nil {
 		return fmt.Errorf("ошибка при построении модели линейной регрессии: %w", err)
 	}
 
-	// 4. Оцениваем качество модели
+	// 3. Оцениваем качество модели
 	p.logger.Info("Результаты модели: коэффициент наклона (a)=%.3f, сдвиг (b)=%.3f, R=%.3f, R²=%.3f",
 		regressionResult.A, regressionResult.B, regressionResult.R, regressionResult.R2)
 
@@ -95,19 +90,19 @@ func (p *RegressionProcessor) Process() error {
 			regressionResult.R2, p.config.MinR2Threshold)
 	}
 
-	// 5. Генерируем прогнозы
+	// 4. Генерируем прогнозы
 	p.logger.Info("Генерация прогнозов на %d дней вперед от %v",
 		p.config.ForecastDays,
 		regressionResult.PeriodEnd.Format("2006-01-02"))
 	forecasts := GenerateForecasts(regressionResult, p.config.ForecastDays, p.config.ConfidenceLevel)
 
-	// 6. Сохраняем прогнозы в БД
+	// 5. Сохраняем прогнозы в БД
 	p.logger.Info("Сохранение %d прогнозов в базу данных", len(forecasts))
 	if err := p.repository.SaveMultiplePredictions(*regressionResult, forecasts); err != nil {
 		return fmt.Errorf("ошибка при сохранении прогнозов: %w", err)
 	}
 
-	// 7. Удаляем устаревшие прогнозы (старше 90 дней)
+	// 6. Удаляем устаревшие прогнозы (старше 90 дней)
 	deleteOlderThan := time.Now().AddDate(0, 0, -90)
 	if err := p.repository.DeleteOldPredictions(deleteOlderThan); err != nil {
 		// Это некритическая ошибка, просто логируем
@@ -18,30 +18,6 @@ func NewMySQLPredictionRepository(db *sql.DB) *MySQLPredictionRepository {
 	}
 }
 
-// EnsureTableExists проверяет наличие таблицы и создает ее при необходимости
-func (r *MySQLPredictionRepository) EnsureTableExists() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS chat_analytics.activity_trend_predictions (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		period_start DATE NOT NULL,
-		period_end DATE NOT NULL,
-		a DOUBLE NOT NULL,
-		b DOUBLE NOT NULL,
-		r DOUBLE NOT NULL,
-		r2 DOUBLE NOT NULL,
-		forecast_date DATE NOT NULL,
-		forecast_value DOUBLE NOT NULL,
-		ci_lower DOUBLE NOT NULL,
-		ci_upper DOUBLE NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		INDEX idx_forecast_date (forecast_date),
-		INDEX idx_period (period_start, period_end)
-	);`
-
-	_, err := r.db.Exec(query)
-	return err
-}
-
 // SavePrediction сохраняет прогноз в БД
 func (r *MySQLPredictionRepository) SavePrediction(result RegressionResult, forecast ForecastPoint) error {
 	query := `
@@ -18,31 +18,6 @@ func NewMySQLETLLogRepository(db *sql.DB) *MySQLETLLogRepository {
 	}
 }
 
-// CreateETLLogTable создает таблицу для логирования ETL процесса, если она не существует
-func (r *MySQLETLLogRepository) CreateETLLogTable() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS chat_analytics.etl_run_log (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		start_time TIMESTAMP NOT NULL,
-		end_time TIMESTAMP NULL,
-		status ENUM('success', 'failed', 'in_progress') NOT NULL DEFAULT 'in_progress',
-		users_processed INT DEFAULT 0,
-		chats_processed INT DEFAULT 0,
-		messages_processed INT DEFAULT 0,
-		last_processed_message_id INT DEFAULT 0,
-		error_message TEXT,
-		execution_time_seconds FLOAT
-	);
-	`
-
-	_, err := r.db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("ошибка при создании таблицы etl_run_log: %w", err)
-	}
-
-	return nil
-}
-
 // CreateLogEntry создает новую запись о запуске ETL
 func (r *MySQLETLLogRepository) CreateLogEntry(startTime time.Time) (int, error) {
 	query := `
@@ -0,0 +1,103 @@
+// attachments/blobstore.go
+package attachments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBlobNotFound возвращается, если объекта с таким ключом нет в хранилище
+var ErrBlobNotFound = errors.New("объект не найден в хранилище")
+
+// BlobStore - хранилище содержимого вложений. Ключи формирует сервис вложений,
+// хранилище только сохраняет и отдает байты. Реализация для локального диска -
+// LocalBlobStore; S3-совместимое хранилище можно подключить, реализовав этот интерфейс.
+type BlobStore interface {
+	// Put сохраняет содержимое под ключом key, перезаписывая существующее
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get открывает содержимое для чтения; вызывающий обязан закрыть его
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete удаляет содержимое; отсутствие объекта ошибкой не считается
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalBlobStore хранит вложения в каталоге на локальном диске
+type LocalBlobStore struct {
+	root string
+}
+
+// NewLocalBlobStore создает хранилище в каталоге root (каталог создается при необходимости)
+func NewLocalBlobStore(root string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{root: root}, nil
+}
+
+// path переводит ключ в путь внутри корневого каталога и не дает выйти за его пределы
+func (s *LocalBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if key == "" || strings.Contains(key, "..") || clean == "/" {
+		return "", fmt.Errorf("недопустимый ключ объекта: %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+// Put записывает содержимое во временный файл и атомарно переименовывает его
+func (s *LocalBlobStore) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get открывает файл вложения
+func (s *LocalBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+// Delete удаляет файл вложения
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,509 @@
+// attachments/service.go
+package attachments
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/LilVoxy/coursework_chat/config"
+)
+
+const (
+	// Максимальный размер вложения по умолчанию
+	defaultMaxSize = 10 << 20 // 10 МБ
+
+	// Вложение, не прикрепленное к сообщению за это время, удаляется
+	unclaimedTTL = 24 * time.Hour
+
+	// Период очистки удаленных и неиспользованных вложений
+	cleanupInterval = time.Hour
+)
+
+// Разрешенные по умолчанию типы: фотографии товаров и счета
+var defaultAllowedTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "application/pdf"}
+
+// Ошибки работы с вложениями
+var (
+	ErrTooLarge        = errors.New("файл превышает допустимый размер")
+	ErrTypeNotAllowed  = errors.New("недопустимый тип файла")
+	ErrNotFound        = errors.New("вложение не найдено")
+	ErrForbidden       = errors.New("нет доступа к вложению")
+	ErrNotClaimable    = errors.New("вложение нельзя прикрепить к сообщению")
+	ErrNoThumbnail     = errors.New("у вложения нет миниатюры")
+	ErrEmptyAttachment = errors.New("пустой файл")
+	ErrE2EChat         = errors.New("в чате включено сквозное шифрование: сервер не принимает вложения, которые может прочитать")
+)
+
+// Attachment - метаданные вложения; ссылка на него передается в сообщениях
+type Attachment struct {
+	ID           int    `json:"id"`
+	ChatID       int    `json:"chatId"`
+	UploaderID   int    `json:"uploaderId"`
+	FileName     string `json:"fileName"`
+	MimeType     string `json:"mimeType"`
+	Size         int64  `json:"size"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	SHA256       string `json:"sha256"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+
+	storageKey   string
+	thumbnailKey string
+	messageID    int // 0, пока вложение не прикреплено к сообщению
+}
+
+// setURLs заполняет адреса скачивания вложения
+func (a *Attachment) setURLs() {
+	a.URL = fmt.Sprintf("/api/attachments/%d", a.ID)
+	if a.thumbnailKey != "" {
+		a.ThumbnailURL = a.URL + "/thumbnail"
+	}
+}
+
+// Service принимает, хранит и отдает вложения
+type Service struct {
+	db           *sql.DB
+	store        BlobStore
+	maxSize      int64
+	allowedTypes map[string]bool
+}
+
+// NewService создает сервис вложений. Пустой allowedTypes означает типы по умолчанию.
+func NewService(db *sql.DB, store BlobStore, maxSize int64, allowedTypes []string) *Service {
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	if len(allowedTypes) == 0 {
+		allowedTypes = defaultAllowedTypes
+	}
+
+	allowed := make(map[string]bool, len(allowedTypes))
+	for _, t := range allowedTypes {
+		allowed[strings.TrimSpace(strings.ToLower(t))] = true
+	}
+	return &Service{db: db, store: store, maxSize: maxSize, allowedTypes: allowed}
+}
+
+// NewServiceFromConfig создает сервис вложений по секции attachments конфигурации:
+//
+//	dir      - каталог для файлов (по умолчанию uploads)
+//	max_size - максимальный размер файла в байтах (по умолчанию 10 МБ)
+//	types    - разрешенные MIME-типы (пусто - типы по умолчанию)
+func NewServiceFromConfig(db *sql.DB, cfg config.AttachmentsConfig) (*Service, error) {
+	store, err := NewLocalBlobStore(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := NewService(db, store, cfg.MaxSize, cfg.Types)
+	abs, _ := filepath.Abs(cfg.Dir)
+	log.Printf("✅ Вложения хранятся в %s (до %d байт)", abs, svc.maxSize)
+	return svc, nil
+}
+
+// MaxSize возвращает максимальный размер вложения
+func (s *Service) MaxSize() int64 {
+	return s.maxSize
+}
+
+// isE2EChat сообщает, включено ли в чате сквозное шифрование
+func (s *Service) isE2EChat(chatID int) (bool, error) {
+	var e2e bool
+	err := s.db.QueryRow(`SELECT e2e FROM chats WHERE id = ?`, chatID).Scan(&e2e)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return e2e, err
+}
+
+// isParticipant проверяет, что пользователь участвует в чате
+func (s *Service) isParticipant(chatID, userID int) (bool, error) {
+	var ok bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM chat_participants WHERE chat_id = ? AND user_id = ?)
+	`, chatID, userID).Scan(&ok)
+	return ok, err
+}
+
+// newKey генерирует ключ объекта в хранилище
+func newKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+	return id[:2] + "/" + id, nil
+}
+
+// countingWriter считает записанные байты
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// Upload сохраняет файл, загруженный участником чата. Тип файла определяется по содержимому,
+// а не по имени или заголовкам клиента. Для изображений строится миниатюра.
+// В чатах со сквозным шифрованием файлы не принимаются (ErrE2EChat): сервер хранил бы их
+// и миниатюры в открытом виде.
+func (s *Service) Upload(ctx context.Context, chatID, uploaderID int, fileName string, r io.Reader) (*Attachment, error) {
+	ok, err := s.isParticipant(chatID, uploaderID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+	e2e, err := s.isE2EChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if e2e {
+		return nil, ErrE2EChat
+	}
+
+	// Определяем тип по первым байтам файла
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	if len(head) == 0 {
+		return nil, ErrEmptyAttachment
+	}
+	mimeType := strings.SplitN(http.DetectContentType(head), ";", 2)[0]
+	if !s.allowedTypes[mimeType] {
+		return nil, fmt.Errorf("%w: %s", ErrTypeNotAllowed, mimeType)
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// Читаем на байт больше лимита, чтобы отличить файл ровно допустимого размера от слишком большого
+	hash := sha256.New()
+	counter := &countingWriter{}
+	body := io.TeeReader(io.LimitReader(br, s.maxSize+1), io.MultiWriter(hash, counter))
+	if err := s.store.Put(ctx, key, body); err != nil {
+		return nil, err
+	}
+	if counter.n > s.maxSize {
+		s.deleteBlob(key)
+		return nil, ErrTooLarge
+	}
+
+	att := &Attachment{
+		ChatID:     chatID,
+		UploaderID: uploaderID,
+		FileName:   sanitizeFileName(fileName),
+		MimeType:   mimeType,
+		Size:       counter.n,
+		SHA256:     hex.EncodeToString(hash.Sum(nil)),
+		storageKey: key,
+	}
+
+	if thumbnailTypes[mimeType] {
+		s.attachThumbnail(ctx, att)
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO attachments (chat_id, uploader_id, storage_key, thumbnail_key, file_name,
+		                         mime_type, size, width, height, sha256)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?)
+	`, att.ChatID, att.UploaderID, att.storageKey, att.thumbnailKey, att.FileName,
+		att.MimeType, att.Size, att.Width, att.Height, att.SHA256)
+	if err != nil {
+		s.deleteBlob(att.storageKey)
+		s.deleteBlob(att.thumbnailKey)
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	att.ID = int(id)
+	att.setURLs()
+
+	log.Printf("📎 Пользователь %d загрузил вложение %d (%s, %d байт) в чат %d",
+		uploaderID, att.ID, att.MimeType, att.Size, chatID)
+	return att, nil
+}
+
+// attachThumbnail строит миниатюру изображения. Ошибка построения не мешает загрузке:
+// вложение просто останется без миниатюры.
+func (s *Service) attachThumbnail(ctx context.Context, att *Attachment) {
+	src, err := s.store.Get(ctx, att.storageKey)
+	if err != nil {
+		log.Printf("⚠️ Не удалось открыть изображение для миниатюры: %v", err)
+		return
+	}
+	defer src.Close()
+
+	thumb, width, height, err := makeThumbnail(src)
+	att.Width, att.Height = width, height
+	if err != nil {
+		log.Printf("⚠️ Не удалось построить миниатюру: %v", err)
+		return
+	}
+
+	key := att.storageKey + "_thumb"
+	if err := s.store.Put(ctx, key, bytes.NewReader(thumb)); err != nil {
+		log.Printf("⚠️ Не удалось сохранить миниатюру: %v", err)
+		return
+	}
+	att.thumbnailKey = key
+}
+
+// sanitizeFileName оставляет только имя файла без пути и управляющих символов
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == "" {
+		name = "file"
+	}
+	// Обрезаем до 255 байт по границе символа: половина многобайтового символа (кириллица)
+	// дала бы строку, которую столбец utf8mb4 не примет
+	if len(name) > 255 {
+		cut := 255
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut]
+	}
+	return name
+}
+
+// Load загружает метаданные вложения (удаленные вложения не возвращаются)
+func Load(db *sql.DB, attachmentID int) (*Attachment, error) {
+	var att Attachment
+	var thumbnailKey sql.NullString
+	var width, height, messageID sql.NullInt64
+	err := db.QueryRow(`
+		SELECT id, chat_id, uploader_id, message_id, storage_key, thumbnail_key, file_name,
+		       mime_type, size, width, height, sha256
+		FROM attachments
+		WHERE id = ? AND deleted_at IS NULL
+	`, attachmentID).Scan(&att.ID, &att.ChatID, &att.UploaderID, &messageID, &att.storageKey, &thumbnailKey,
+		&att.FileName, &att.MimeType, &att.Size, &width, &height, &att.SHA256)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	att.thumbnailKey = thumbnailKey.String
+	att.messageID = int(messageID.Int64)
+	att.Width, att.Height = int(width.Int64), int(height.Int64)
+	att.setURLs()
+	return &att, nil
+}
+
+// Execer - *sql.DB или *sql.Tx
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Claim прикрепляет загруженное вложение к сообщению. Прикрепить можно только собственное,
+// еще не использованное вложение того же чата, если в чате не включено сквозное шифрование.
+func Claim(db Execer, attachmentID, chatID, uploaderID, messageID int) error {
+	result, err := db.Exec(`
+		UPDATE attachments SET message_id = ?
+		WHERE id = ? AND chat_id = ? AND uploader_id = ? AND message_id IS NULL AND deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM chats WHERE id = ? AND e2e = TRUE)
+	`, messageID, attachmentID, chatID, uploaderID, chatID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotClaimable
+	}
+	return nil
+}
+
+// DiscardUnclaimed помечает удаленными вложения чата, еще не прикрепленные к сообщениям
+// (при включении сквозного шифрования); файлы удаляются при очистке
+func DiscardUnclaimed(db Execer, chatID int) error {
+	_, err := db.Exec(`
+		UPDATE attachments SET deleted_at = NOW() WHERE chat_id = ? AND message_id IS NULL AND deleted_at IS NULL
+	`, chatID)
+	return err
+}
+
+// MarkDeleted помечает удаленными вложения сообщения; файлы удаляются при очистке
+func MarkDeleted(db Execer, messageID int) error {
+	_, err := db.Exec(`
+		UPDATE attachments SET deleted_at = NOW() WHERE message_id = ? AND deleted_at IS NULL
+	`, messageID)
+	return err
+}
+
+// authorize загружает вложение и проверяет, что пользователь может его скачать:
+// он должен участвовать в чате и не скрывать у себя сообщение с этим вложением.
+// Вложение, еще не прикрепленное к сообщению, доступно только загрузившему его.
+func (s *Service) authorize(attachmentID, userID int) (*Attachment, error) {
+	att, err := Load(s.db, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if att.messageID == 0 && att.UploaderID != userID {
+		return nil, ErrNotFound
+	}
+
+	ok, err := s.isParticipant(att.ChatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrForbidden
+	}
+
+	var hidden bool
+	err = s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM attachments a
+			JOIN message_hidden h ON h.message_id = a.message_id
+			WHERE a.id = ? AND h.user_id = ?
+		)
+	`, attachmentID, userID).Scan(&hidden)
+	if err != nil {
+		return nil, err
+	}
+	if hidden {
+		return nil, ErrNotFound
+	}
+	return att, nil
+}
+
+// Open открывает содержимое вложения для участника чата
+func (s *Service) Open(ctx context.Context, attachmentID, userID int) (*Attachment, io.ReadCloser, error) {
+	att, err := s.authorize(attachmentID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := s.store.Get(ctx, att.storageKey)
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	return att, body, err
+}
+
+// OpenThumbnail открывает миниатюру изображения для участника чата
+func (s *Service) OpenThumbnail(ctx context.Context, attachmentID, userID int) (*Attachment, io.ReadCloser, error) {
+	att, err := s.authorize(attachmentID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if att.thumbnailKey == "" {
+		return nil, nil, ErrNoThumbnail
+	}
+	body, err := s.store.Get(ctx, att.thumbnailKey)
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	return att, body, err
+}
+
+// deleteBlob удаляет объект из хранилища, если ключ задан
+func (s *Service) deleteBlob(key string) {
+	if key == "" {
+		return
+	}
+	if err := s.store.Delete(context.Background(), key); err != nil {
+		log.Printf("⚠️ Не удалось удалить объект %s из хранилища: %v", key, err)
+	}
+}
+
+// RunCleanup периодически удаляет файлы вложений, удаленных вместе с сообщением,
+// и вложения, которые так и не были прикреплены к сообщению. Работает до отмены ctx.
+func (s *Service) RunCleanup(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.cleanup(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("⚠️ Ошибка при очистке вложений: %v", err)
+			}
+		}
+	}
+}
+
+// cleanup выполняет один проход очистки (прерывается отменой ctx между вложениями)
+func (s *Service) cleanup(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, storage_key, thumbnail_key FROM attachments
+		WHERE storage_key != ''
+		AND (deleted_at IS NOT NULL OR (message_id IS NULL AND created_at < ?))
+	`, time.Now().Add(-unclaimedTTL))
+	if err != nil {
+		return err
+	}
+
+	type staleBlob struct {
+		id                       int
+		storageKey, thumbnailKey string
+	}
+	var stale []staleBlob
+	for rows.Next() {
+		var b staleBlob
+		var thumb sql.NullString
+		if err := rows.Scan(&b.id, &b.storageKey, &thumb); err != nil {
+			rows.Close()
+			return err
+		}
+		b.thumbnailKey = thumb.String
+		stale = append(stale, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, b := range stale {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.deleteBlob(b.storageKey)
+		s.deleteBlob(b.thumbnailKey)
+		// Строку удаленного вложения оставляем (на нее ссылается сообщение), очищаем только ключи
+		if _, err := s.db.ExecContext(ctx, `
+			UPDATE attachments SET deleted_at = COALESCE(deleted_at, NOW()), storage_key = '', thumbnail_key = NULL
+			WHERE id = ?
+		`, b.id); err != nil {
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		log.Printf("🧹 Удалено %d неиспользуемых вложений", len(stale))
+	}
+	return nil
+}
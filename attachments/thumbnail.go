@@ -0,0 +1,112 @@
+// attachments/thumbnail.go
+package attachments
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+
+	// Декодеры поддерживаемых форматов изображений
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// Максимальная сторона миниатюры в пикселях
+	thumbnailSize = 320
+
+	// Качество JPEG для миниатюр
+	thumbnailQuality = 80
+
+	// Изображения больше этого числа пикселей не декодируются (защита от «бомб» распаковки)
+	maxImagePixels = 40_000_000
+)
+
+// thumbnailTypes - MIME-типы изображений, для которых строятся миниатюры
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// errImageTooLarge - размеры изображения превышают maxImagePixels
+var errImageTooLarge = errors.New("изображение слишком большое для построения миниатюры")
+
+// makeThumbnail декодирует изображение и возвращает уменьшенную копию в формате JPEG
+// вместе с размерами исходного изображения
+func makeThumbnail(r io.Reader) (thumb []byte, width, height int, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, cfg.Width, cfg.Height, errImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, cfg.Width, cfg.Height, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(src, thumbnailSize), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, cfg.Width, cfg.Height, err
+	}
+	return buf.Bytes(), cfg.Width, cfg.Height, nil
+}
+
+// scaleDown уменьшает изображение так, чтобы большая сторона не превышала maxSide.
+// Каждый пиксель результата - среднее по соответствующей области исходного изображения.
+func scaleDown(src image.Image, maxSide int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSide && h <= maxSide {
+		maxSide = max(w, h)
+	}
+
+	dw, dh := maxSide, maxSide
+	if w > h {
+		dh = max(1, h*maxSide/w)
+	} else {
+		dw = max(1, w*maxSide/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0 := b.Min.Y + y*h/dh
+		sy1 := max(sy0+1, b.Min.Y+(y+1)*h/dh)
+		for x := 0; x < dw; x++ {
+			sx0 := b.Min.X + x*w/dw
+			sx1 := max(sx0+1, b.Min.X+(x+1)*w/dw)
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			// Прозрачные области накладываем на белый фон: в JPEG нет альфа-канала
+			// (значения RGBA уже умножены на альфу)
+			bg := 0xffff - a/n
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8((r/n + bg) >> 8)
+			dst.Pix[i+1] = uint8((g/n + bg) >> 8)
+			dst.Pix[i+2] = uint8((bl/n + bg) >> 8)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
@@ -0,0 +1,81 @@
+// auth/authenticator.go
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"log"
+	"os"
+	"time"
+
+	"github.com/LilVoxy/coursework_chat/config"
+)
+
+// Время жизни токена по умолчанию
+const defaultTokenTTL = 24 * time.Hour
+
+// Authenticator выпускает и проверяет токены доступа к REST API и WebSocket
+type Authenticator struct {
+	secret            []byte           // Секрет HMAC для токенов HS256, выпускаемых через /api/auth
+	publicKey         crypto.PublicKey // Публичный ключ для токенов RS256/EdDSA от внешнего сервиса
+	issuer            string           // Ожидаемый издатель токенов (поле iss)
+	tokenTTL          time.Duration    // Время жизни выпускаемых токенов
+	allowPasswordless bool             // Разрешить выдачу токенов пользователям без пароля (только для разработки)
+}
+
+// NewAuthenticator создает проверяющий токены объект.
+// Должен быть задан хотя бы один из параметров: secret или publicKey.
+func NewAuthenticator(secret []byte, publicKey crypto.PublicKey, issuer string, tokenTTL time.Duration) *Authenticator {
+	if tokenTTL <= 0 {
+		tokenTTL = defaultTokenTTL
+	}
+	return &Authenticator{
+		secret:    secret,
+		publicKey: publicKey,
+		issuer:    issuer,
+		tokenTTL:  tokenTTL,
+	}
+}
+
+// NewAuthenticatorFromConfig создает Authenticator по секции auth конфигурации:
+//
+//	secret / secret_file  - секрет HMAC для выпуска и проверки токенов HS256 (CHAT_AUTH_SECRET)
+//	public_key_file       - путь к PEM-файлу публичного ключа (RS256/EdDSA)
+//	issuer                - ожидаемый издатель токенов
+//	token_ttl             - время жизни токена (например, 12h)
+//	allow_passwordless    - выдавать токены пользователям без пароля
+//
+// Если не задан ни секрет, ни публичный ключ, генерируется случайный секрет:
+// токены станут недействительными после перезапуска сервера.
+func NewAuthenticatorFromConfig(cfg config.AuthConfig) (*Authenticator, error) {
+	secret := []byte(cfg.Secret)
+
+	var publicKey crypto.PublicKey
+	if cfg.PublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, err = ParsePublicKeyPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("✅ Загружен публичный ключ для проверки токенов из %s", cfg.PublicKeyFile)
+	}
+
+	if len(secret) == 0 && publicKey == nil {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		log.Println("⚠️ auth.secret не задан, используется случайный секрет (токены не переживут перезапуск)")
+	}
+
+	a := NewAuthenticator(secret, publicKey, cfg.Issuer, cfg.TokenTTL)
+	a.allowPasswordless = cfg.AllowPasswordless
+	if a.allowPasswordless {
+		log.Println("⚠️ Разрешена выдача токенов пользователям без пароля - не используйте этот режим в продакшене")
+	}
+
+	return a, nil
+}
@@ -0,0 +1,77 @@
+// auth/handler.go
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// LoginRequest - тело запроса на получение токена
+type LoginRequest struct {
+	UserID   int    `json:"userId"`
+	Password string `json:"password"`
+}
+
+// LoginResponse - ответ с выпущенным токеном
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	UserID    int       `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// LoginHandler обрабатывает POST /api/auth: проверяет пароль пользователя
+// по users.password_hash и выпускает подписанный токен
+func (a *Authenticator) LoginHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req LoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID <= 0 {
+			http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+			return
+		}
+
+		var passwordHash sql.NullString
+		err := db.QueryRow("SELECT password_hash FROM users WHERE id = ?", req.UserID).Scan(&passwordHash)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Неверный ID пользователя или пароль", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Ошибка при получении пароля пользователя %d: %v", req.UserID, err)
+			http.Error(w, "Ошибка при проверке пароля", http.StatusInternalServerError)
+			return
+		}
+
+		if passwordHash.Valid && passwordHash.String != "" {
+			ok, err := VerifyPassword(req.Password, passwordHash.String)
+			if err != nil {
+				log.Printf("❌ Ошибка при проверке пароля пользователя %d: %v", req.UserID, err)
+			}
+			if !ok {
+				http.Error(w, "Неверный ID пользователя или пароль", http.StatusUnauthorized)
+				return
+			}
+		} else if !a.allowPasswordless {
+			http.Error(w, "Для пользователя не задан пароль", http.StatusUnauthorized)
+			return
+		}
+
+		token, expiresAt, err := a.IssueToken(req.UserID)
+		if err != nil {
+			log.Printf("❌ Ошибка при выпуске токена: %v", err)
+			http.Error(w, "Выпуск токенов недоступен", http.StatusServiceUnavailable)
+			return
+		}
+
+		log.Printf("✅ Выпущен токен для пользователя %d", req.UserID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LoginResponse{
+			Token:     token,
+			UserID:    req.UserID,
+			ExpiresAt: expiresAt,
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// auth/middleware.go
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// contextKey - тип ключей контекста запроса, чтобы избежать коллизий с другими пакетами
+type contextKey int
+
+const userIDKey contextKey = iota
+
+// Middleware пропускает только запросы с действительным токеном
+// и сохраняет ID аутентифицированного пользователя в контексте запроса
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := TokenFromRequest(r)
+		if token == "" {
+			http.Error(w, "Требуется токен авторизации", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := a.ParseToken(token)
+		if err != nil {
+			log.Printf("⚠️ Отклонен запрос %s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, "Недействительный токен авторизации", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), claims.UserID)))
+	})
+}
+
+// TokenFromRequest извлекает токен из заголовка Authorization ("Bearer <token>")
+// или из параметра token (браузеры не позволяют задать заголовки для WebSocket)
+func TokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get("token")
+}
+
+// WithUserID возвращает контекст с ID аутентифицированного пользователя
+func WithUserID(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// UserIDFromContext возвращает ID аутентифицированного пользователя из контекста запроса
+func UserIDFromContext(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(userIDKey).(int)
+	return userID, ok && userID > 0
+}
@@ -0,0 +1,202 @@
+// auth/token.go
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ошибки проверки токена
+var (
+	ErrInvalidToken = errors.New("недействительный токен")
+	ErrTokenExpired = errors.New("срок действия токена истек")
+)
+
+// Claims содержит данные, подписанные в токене (формат JWT)
+type Claims struct {
+	Subject   string `json:"sub"`
+	UserID    int    `json:"uid"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// tokenHeader - заголовок JWT
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// Поддерживаемые алгоритмы подписи
+const (
+	algHS256 = "HS256" // HMAC-SHA256, токены выпускает сам сервер через /api/auth
+	algRS256 = "RS256" // RSA PKCS#1 v1.5 + SHA-256, токены выпускает внешний сервис
+	algEdDSA = "EdDSA" // Ed25519, токены выпускает внешний сервис
+)
+
+var b64 = base64.RawURLEncoding
+
+// IssueToken выпускает токен для пользователя, подписанный HMAC-секретом сервера
+func (a *Authenticator) IssueToken(userID int) (string, time.Time, error) {
+	if len(a.secret) == 0 {
+		return "", time.Time{}, errors.New("выпуск токенов отключен: не задан секрет HMAC")
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(a.tokenTTL)
+	claims := Claims{
+		Subject:   strconv.Itoa(userID),
+		UserID:    userID,
+		Issuer:    a.issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	}
+
+	header, err := json.Marshal(tokenHeader{Alg: algHS256, Typ: "JWT"})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingInput := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	signature := signHMAC(a.secret, signingInput)
+
+	return signingInput + "." + b64.EncodeToString(signature), expiresAt, nil
+}
+
+// ParseToken проверяет подпись и срок действия токена и возвращает его данные
+func (a *Authenticator) ParseToken(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerJSON, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var header tokenHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err := a.verifySignature(header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Внешние сервисы могут передавать ID пользователя только в поле sub
+	if claims.UserID == 0 && claims.Subject != "" {
+		if id, err := strconv.Atoi(claims.Subject); err == nil {
+			claims.UserID = id
+		}
+	}
+	if claims.UserID <= 0 {
+		return nil, ErrInvalidToken
+	}
+
+	// Если издатель настроен, токен без поля iss тоже отклоняется
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, ErrInvalidToken
+	}
+	if claims.ExpiresAt == 0 || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	return &claims, nil
+}
+
+// verifySignature проверяет подпись в соответствии с алгоритмом из заголовка.
+// Алгоритм должен соответствовать настроенному ключу, иначе токен отклоняется.
+func (a *Authenticator) verifySignature(alg, signingInput string, signature []byte) error {
+	switch alg {
+	case algHS256:
+		if len(a.secret) == 0 {
+			return ErrInvalidToken
+		}
+		if !hmac.Equal(signature, signHMAC(a.secret, signingInput)) {
+			return ErrInvalidToken
+		}
+		return nil
+
+	case algRS256:
+		pub, ok := a.publicKey.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidToken
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidToken
+		}
+		return nil
+
+	case algEdDSA:
+		pub, ok := a.publicKey.(ed25519.PublicKey)
+		if !ok {
+			return ErrInvalidToken
+		}
+		if !ed25519.Verify(pub, []byte(signingInput), signature) {
+			return ErrInvalidToken
+		}
+		return nil
+
+	default:
+		// В том числе "none" - неподписанные токены не принимаются
+		return ErrInvalidToken
+	}
+}
+
+// signHMAC вычисляет подпись HMAC-SHA256
+func signHMAC(secret []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// ParsePublicKeyPEM разбирает публичный ключ RSA или Ed25519 в формате PEM (PKIX)
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("не найден PEM-блок публичного ключа")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора публичного ключа: %v", err)
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("неподдерживаемый тип публичного ключа %T", key)
+	}
+}
@@ -0,0 +1,164 @@
+// cmd/dbkeys/main.go
+// Утилита управления ключами шифрования сообщений в БД.
+//
+//	dbkeys kek -out kek.key                           создать KEK для локального провайдера
+//	dbkeys add -keyring keyring.json -id 2026-10      добавить ключ данных (без -primary - только для чтения)
+//	dbkeys promote -keyring keyring.json -id 2026-10  сделать ключ основным
+//	dbkeys list -keyring keyring.json                 показать ключи связки
+//
+// Ротация без простоя: новый ключ добавляется и раскатывается на все экземпляры, затем
+// становится основным, после чего сервер перешифровывает старые записи в фоне.
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/LilVoxy/coursework_chat/dbcrypt"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "kek":
+		err = runKEK(os.Args[2:])
+	case "add":
+		err = runAdd(os.Args[2:])
+	case "promote":
+		err = runPromote(os.Args[2:])
+	case "list":
+		err = runList(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "использование: dbkeys kek|add|promote|list [флаги]")
+	os.Exit(2)
+}
+
+// runKEK создает файл KEK для локального провайдера
+func runKEK(args []string) error {
+	fs := flag.NewFlagSet("kek", flag.ExitOnError)
+	out := fs.String("out", "", "файл для нового KEK")
+	fs.Parse(args)
+	if *out == "" {
+		return errors.New("укажите -out")
+	}
+
+	kek, err := dbcrypt.GenerateKey()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, base64.StdEncoding.EncodeToString(kek)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("✅ KEK записан в %s\n", *out)
+	return nil
+}
+
+// runAdd добавляет в связку новый ключ данных (создает связку, если файла нет)
+func runAdd(args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	path := fs.String("keyring", "", "файл связки ключей")
+	id := fs.String("id", "", "ID нового ключа")
+	primary := fs.Bool("primary", false, "сразу сделать ключ основным")
+	fs.Parse(args)
+	if *path == "" || *id == "" {
+		return errors.New("укажите -keyring и -id")
+	}
+
+	provider, err := dbcrypt.OpenKeyProvider(os.Getenv("CHAT_DB_KEY_PROVIDER"))
+	if err != nil {
+		return err
+	}
+	keyring, err := dbcrypt.LoadKeyring(*path)
+	if errors.Is(err, os.ErrNotExist) {
+		keyring, err = &dbcrypt.Keyring{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	// Проверяем, что KEK подходит к уже сохраненным ключам
+	if len(keyring.Keys) > 0 {
+		if _, err := keyring.Unwrap(provider); err != nil {
+			return err
+		}
+	}
+	if err := keyring.AddKey(provider, *id, *primary); err != nil {
+		return err
+	}
+	if err := keyring.Save(*path); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Ключ %s добавлен, основной ключ: %s\n", *id, keyring.Primary)
+	return nil
+}
+
+// runPromote делает ключ основным
+func runPromote(args []string) error {
+	fs := flag.NewFlagSet("promote", flag.ExitOnError)
+	path := fs.String("keyring", "", "файл связки ключей")
+	id := fs.String("id", "", "ID ключа")
+	fs.Parse(args)
+	if *path == "" || *id == "" {
+		return errors.New("укажите -keyring и -id")
+	}
+
+	keyring, err := dbcrypt.LoadKeyring(*path)
+	if err != nil {
+		return err
+	}
+	if err := keyring.SetPrimary(*id); err != nil {
+		return err
+	}
+	if err := keyring.Save(*path); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Основной ключ: %s. После перезапуска серверы перешифруют старые записи\n", *id)
+	return nil
+}
+
+// runList выводит ключи связки
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	path := fs.String("keyring", "", "файл связки ключей")
+	fs.Parse(args)
+	if *path == "" {
+		return errors.New("укажите -keyring")
+	}
+
+	keyring, err := dbcrypt.LoadKeyring(*path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Провайдер: %s\n", keyring.Provider)
+	for _, k := range keyring.Keys {
+		mark := " "
+		if k.ID == keyring.Primary {
+			mark = "*"
+		}
+		fmt.Printf("%s %-32s %s\n", mark, k.ID, k.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// cmd/migrate/main.go
+// Утилита миграций схемы базы чата (OLTP) и аналитической базы (OLAP).
+//
+//	migrate up [-schema oltp|olap] [-to 3]        применить миграции (до версии -to включительно)
+//	migrate down [-schema oltp|olap] [-steps 1]   откатить последние примененные миграции
+//	migrate status [-schema oltp|olap]            показать примененные и ожидающие миграции
+//
+// Параметры подключения берутся из общей конфигурации (--config, CHAT_DB_*, CHAT_OLAP_DB_*,
+// --db-*, --olap-db-*). Сервер и ETL применяют миграции при запуске сами, если не выключен
+// параметр auto_migrate; утилита нужна, чтобы обновить схему заранее или откатить ее.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+
+	"github.com/LilVoxy/coursework_chat/config"
+	"github.com/LilVoxy/coursework_chat/migrate"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	command := os.Args[1]
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	set := fs.String("schema", migrate.OLTP, "схема: oltp (база чата) или olap (аналитическая база)")
+	target := fs.Int("to", 0, "up: последняя применяемая версия (0 - все)")
+	steps := fs.Int("steps", 1, "down: сколько миграций откатить")
+
+	switch command {
+	case "up", "down", "status":
+	default:
+		usage()
+	}
+	cfg, err := config.Load(fs, os.Args[2:])
+	if err != nil {
+		fail(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, cfg, command, *set, *target, *steps); err != nil {
+		fail(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "использование: migrate up|down|status [-schema oltp|olap] [флаги]")
+	os.Exit(2)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+	os.Exit(1)
+}
+
+func run(ctx context.Context, cfg *config.Config, command, set string, target, steps int) error {
+	var dbConfig config.DatabaseConfig
+	switch set {
+	case migrate.OLTP:
+		dbConfig = cfg.Database
+	case migrate.OLAP:
+		dbConfig = cfg.Analytics
+	default:
+		return fmt.Errorf("неизвестная схема %q (ожидается oltp или olap)", set)
+	}
+
+	db, err := sql.Open("mysql", dbConfig.DSN("parseTime=true"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ошибка подключения к %s на %s:%d: %w", dbConfig.Name, dbConfig.Host, dbConfig.Port, err)
+	}
+
+	migrator, err := migrate.New(db, set)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		n, err := migrator.Up(ctx, target)
+		if err != nil {
+			return err
+		}
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ %s: применено миграций: %d, версия схемы %d\n", dbConfig.Name, n, version)
+	case "down":
+		if steps < 1 {
+			return errors.New("-steps должен быть больше нуля")
+		}
+		n, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ %s: откачено миграций: %d, версия схемы %d\n", dbConfig.Name, n, version)
+	case "status":
+		return printStatus(ctx, migrator, dbConfig.Name)
+	}
+	return nil
+}
+
+// printStatus выводит таблицу миграций; изменение примененной миграции - ошибка
+func printStatus(ctx context.Context, migrator *migrate.Migrator, name string) error {
+	states, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("База %s:\n", name)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ВЕРСИЯ\tМИГРАЦИЯ\tСОСТОЯНИЕ\tПРИМЕНЕНА")
+	modified := 0
+	for _, state := range states {
+		status, appliedAt := "ожидает", "-"
+		if state.Applied {
+			status = "применена"
+			appliedAt = state.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		switch {
+		case state.Missing:
+			status = "неизвестна программе"
+		case state.Modified:
+			status = "изменена после применения"
+			modified++
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", state.Version, state.Name, status, appliedAt)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if modified > 0 {
+		return fmt.Errorf("изменено примененных миграций: %d: %w", modified, migrate.ErrChecksum)
+	}
+	return nil
+}
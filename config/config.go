@@ -0,0 +1,304 @@
+// config/config.go
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Config - конфигурация сервера чата и ETL. Значения загружаются по порядку (каждый следующий
+// источник переопределяет предыдущий): значения по умолчанию, файл YAML или TOML, переменные
+// окружения, флаги командной строки (см. Load).
+//
+// Теги полей: yaml/toml - ключ в файле, env - имя переменной окружения, flag - имя флага,
+// help - описание флага, secret - значение скрывается при выводе (--print-config).
+// У вложенных секций env и flag задают префикс имен. Для каждого секрета X есть поле XFile:
+// если оно задано, значение читается из файла (например, из Docker или Kubernetes secret).
+// secret:"url" скрывает при выводе только пароль в адресе.
+//
+// Префиксы секций delivery, cluster и encryption сохраняют имена переменных окружения,
+// которые сервер читал до появления файла конфигурации (CHAT_BUS, CHAT_DB_ENCRYPTION_KEY и т.д.).
+type Config struct {
+	Server      ServerConfig      `yaml:"server" toml:"server" env:"CHAT_HTTP_" flag:"http-"`
+	WebSocket   WebSocketConfig   `yaml:"websocket" toml:"websocket" env:"CHAT_WS_" flag:"ws-"`
+	Delivery    DeliveryConfig    `yaml:"delivery" toml:"delivery" env:"CHAT_" flag:""`
+	Cluster     ClusterConfig     `yaml:"cluster" toml:"cluster" env:"CHAT_" flag:""`
+	Auth        AuthConfig        `yaml:"auth" toml:"auth" env:"CHAT_AUTH_" flag:"auth-"`
+	Database    DatabaseConfig    `yaml:"database" toml:"database" env:"CHAT_DB_" flag:"db-"`
+	Encryption  EncryptionConfig  `yaml:"encryption" toml:"encryption" env:"CHAT_DB_" flag:"db-"`
+	Search      SearchConfig      `yaml:"search" toml:"search" env:"CHAT_SEARCH_" flag:"search-"`
+	Attachments AttachmentsConfig `yaml:"attachments" toml:"attachments" env:"CHAT_ATTACHMENTS_" flag:"attachments-"`
+	Analytics   DatabaseConfig    `yaml:"analytics" toml:"analytics" env:"CHAT_OLAP_DB_" flag:"olap-db-"`
+	ETL         ETLConfig         `yaml:"etl" toml:"etl" env:"CHAT_ETL_" flag:"etl-"`
+
+	// Файл, из которого загружена конфигурация, и запрошен ли вывод конфигурации
+	File        string `yaml:"-" toml:"-"`
+	PrintConfig bool   `yaml:"-" toml:"-"`
+}
+
+// ServerConfig - HTTP-сервер
+type ServerConfig struct {
+	Addr            string        `yaml:"addr" toml:"addr" env:"ADDR" flag:"addr" help:"адрес HTTP-сервера"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"READ_TIMEOUT" flag:"read-timeout" help:"таймаут чтения запроса"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"WRITE_TIMEOUT" flag:"write-timeout" help:"таймаут записи ответа"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"IDLE_TIMEOUT" flag:"idle-timeout" help:"таймаут простоя keep-alive соединения"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" help:"сколько ждать завершения запросов и отключения клиентов при остановке"`
+	CORSOrigins     []string      `yaml:"cors_origins" toml:"cors_origins" env:"CORS_ORIGINS" flag:"cors-origins" help:"источники, которым разрешены запросы (через запятую, * - любые)"`
+}
+
+// WebSocketConfig - параметры WebSocket-соединений
+type WebSocketConfig struct {
+	MaxMessageSize    int64         `yaml:"max_message_size" toml:"max_message_size" env:"MAX_MESSAGE_SIZE" flag:"max-message-size" help:"наибольший размер кадра от клиента, байт"`
+	WriteWait         time.Duration `yaml:"write_wait" toml:"write_wait" env:"WRITE_WAIT" flag:"write-wait" help:"таймаут записи кадра клиенту"`
+	PongWait          time.Duration `yaml:"pong_wait" toml:"pong_wait" env:"PONG_WAIT" flag:"pong-wait" help:"сколько ждать кадра или pong от клиента"`
+	PingPeriod        time.Duration `yaml:"ping_period" toml:"ping_period" env:"PING_PERIOD" flag:"ping-period" help:"период отправки ping (меньше pong_wait)"`
+	InactivityTimeout time.Duration `yaml:"inactivity_timeout" toml:"inactivity_timeout" env:"INACTIVITY_TIMEOUT" flag:"inactivity-timeout" help:"через сколько без ping пользователь становится away (offline - через вдвое больший срок)"`
+}
+
+// DeliveryConfig - доставка кадров клиентам: очередь отправки соединения и сжатие
+type DeliveryConfig struct {
+	SlowClientPolicy string   `yaml:"slow_client_policy" toml:"slow_client_policy" env:"SLOW_CLIENT_POLICY" flag:"slow-client-policy" help:"политика медленного клиента: disconnect, drop-oldest или spill"`
+	SendQueueSize    int      `yaml:"send_queue_size" toml:"send_queue_size" env:"SEND_QUEUE_SIZE" flag:"send-queue-size" help:"размер очереди отправки соединения, кадров"`
+	Codecs           []string `yaml:"codecs" toml:"codecs" env:"CODECS" flag:"codecs" help:"разрешенные кодеки сжатия в порядке предпочтения (пусто - все)"`
+}
+
+// ClusterConfig - несколько экземпляров сервера: шина событий и адрес для переподключения
+type ClusterConfig struct {
+	Bus               string        `yaml:"bus" toml:"bus" env:"BUS" flag:"bus" help:"шина событий: memory (один экземпляр) или redis"`
+	RedisURL          string        `yaml:"redis_url" toml:"redis_url" env:"REDIS_URL" flag:"redis-url" help:"адрес Redis для шины событий" secret:"url"`
+	RedisPassword     string        `yaml:"redis_password" toml:"redis_password" env:"REDIS_PASSWORD" secret:"true"`
+	RedisPasswordFile string        `yaml:"redis_password_file" toml:"redis_password_file" env:"REDIS_PASSWORD_FILE" flag:"redis-password-file" help:"файл с паролем Redis"`
+	RedisChannel      string        `yaml:"redis_channel" toml:"redis_channel" env:"REDIS_CHANNEL" flag:"redis-channel" help:"канал Pub/Sub шины событий"`
+	ReconnectURL      string        `yaml:"reconnect_url" toml:"reconnect_url" env:"RECONNECT_URL" flag:"reconnect-url" help:"адрес, на который клиенты переподключаются при остановке сервера"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" toml:"heartbeat_interval" env:"HEARTBEAT_INTERVAL" flag:"heartbeat-interval" help:"период, с которым узел объявляет другим узлам свои соединения"`
+}
+
+// AuthConfig - выпуск и проверка токенов доступа
+type AuthConfig struct {
+	Secret            string        `yaml:"secret" toml:"secret" env:"SECRET" secret:"true"`
+	SecretFile        string        `yaml:"secret_file" toml:"secret_file" env:"SECRET_FILE" flag:"secret-file" help:"файл с секретом HMAC для токенов HS256"`
+	PublicKeyFile     string        `yaml:"public_key_file" toml:"public_key_file" env:"PUBLIC_KEY_FILE" flag:"public-key-file" help:"PEM-файл публичного ключа для токенов RS256/EdDSA"`
+	Issuer            string        `yaml:"issuer" toml:"issuer" env:"ISSUER" flag:"issuer" help:"ожидаемый издатель токенов"`
+	TokenTTL          time.Duration `yaml:"token_ttl" toml:"token_ttl" env:"TOKEN_TTL" flag:"token-ttl" help:"время жизни выпускаемых токенов"`
+	AllowPasswordless bool          `yaml:"allow_passwordless" toml:"allow_passwordless" env:"ALLOW_PASSWORDLESS" flag:"allow-passwordless" help:"выдавать токены пользователям без пароля (только для разработки)"`
+}
+
+// EncryptionConfig - шифрование текста сообщений в БД (общее для сервера и ETL).
+// KEK связки ключей читает провайдер ключей (для local - CHAT_DB_KEK_FILE или CHAT_DB_KEK).
+type EncryptionConfig struct {
+	Key            string `yaml:"key" toml:"key" env:"ENCRYPTION_KEY" secret:"true"`
+	KeyFile        string `yaml:"key_file" toml:"key_file" env:"ENCRYPTION_KEY_FILE" flag:"encryption-key-file" help:"файл с ключом шифрования сообщений (32 байта в base64)"`
+	Keyring        string `yaml:"keyring" toml:"keyring" env:"KEYRING" secret:"true"`
+	KeyringFile    string `yaml:"keyring_file" toml:"keyring_file" env:"KEYRING_FILE" flag:"keyring-file" help:"файл связки ключей шифрования (JSON)"`
+	KeyProvider    string `yaml:"key_provider" toml:"key_provider" env:"KEY_PROVIDER" flag:"key-provider" help:"провайдер KEK связки ключей"`
+	InsecureDevKey bool   `yaml:"insecure_dev_key" toml:"insecure_dev_key" env:"INSECURE_DEV_KEY" flag:"insecure-dev-key" help:"разрешить общеизвестный ключ разработки (только для разработки)"`
+}
+
+// SearchConfig - поисковый индекс сообщений
+type SearchConfig struct {
+	Key     string `yaml:"key" toml:"key" env:"KEY" secret:"true"`
+	KeyFile string `yaml:"key_file" toml:"key_file" env:"KEY_FILE" flag:"key-file" help:"файл с ключом слепых токенов (не менее 32 байт в base64)"`
+}
+
+// AttachmentsConfig - хранилище вложений
+type AttachmentsConfig struct {
+	Dir     string   `yaml:"dir" toml:"dir" env:"DIR" flag:"dir" help:"каталог файлов вложений"`
+	MaxSize int64    `yaml:"max_size" toml:"max_size" env:"MAX_SIZE" flag:"max-size" help:"наибольший размер вложения, байт"`
+	Types   []string `yaml:"types" toml:"types" env:"TYPES" flag:"types" help:"разрешенные MIME-типы (пусто - типы по умолчанию)"`
+}
+
+// DatabaseConfig - подключение к MySQL
+type DatabaseConfig struct {
+	Host            string        `yaml:"host" toml:"host" env:"HOST" flag:"host" help:"хост MySQL"`
+	Port            int           `yaml:"port" toml:"port" env:"PORT" flag:"port" help:"порт MySQL"`
+	User            string        `yaml:"user" toml:"user" env:"USER" flag:"user" help:"пользователь MySQL"`
+	Password        string        `yaml:"password" toml:"password" env:"PASSWORD" secret:"true"`
+	PasswordFile    string        `yaml:"password_file" toml:"password_file" env:"PASSWORD_FILE" flag:"password-file" help:"файл с паролем MySQL"`
+	Name            string        `yaml:"name" toml:"name" env:"NAME" flag:"name" help:"имя базы данных"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"MAX_OPEN_CONNS" flag:"max-open-conns" help:"наибольшее число открытых соединений"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"MAX_IDLE_CONNS" flag:"max-idle-conns" help:"наибольшее число простаивающих соединений"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME" flag:"conn-max-lifetime" help:"время жизни соединения"`
+	AutoMigrate     bool          `yaml:"auto_migrate" toml:"auto_migrate" env:"AUTO_MIGRATE" flag:"auto-migrate" help:"применять миграции схемы при запуске (иначе - командой migrate up)"`
+}
+
+// ETLConfig - запуск ETL
+type ETLConfig struct {
+	RunInterval     time.Duration `yaml:"run_interval" toml:"run_interval" env:"RUN_INTERVAL" flag:"run-interval" help:"интервал запуска ETL по расписанию"`
+	BatchSize       int           `yaml:"batch_size" toml:"batch_size" env:"BATCH_SIZE" flag:"batch-size" help:"наибольшее число записей за один запуск"`
+	DetailedLogging bool          `yaml:"detailed_logging" toml:"detailed_logging" env:"DETAILED_LOGGING" flag:"detailed-logging" help:"подробный журнал ETL"`
+}
+
+// Default возвращает конфигурацию по умолчанию. Пароли БД и ключи по умолчанию не заданы.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+			CORSOrigins:     []string{"*"},
+		},
+		WebSocket: WebSocketConfig{
+			MaxMessageSize:    512 * 1024,
+			WriteWait:         10 * time.Second,
+			PongWait:          60 * time.Second,
+			PingPeriod:        54 * time.Second,
+			InactivityTimeout: 65 * time.Second,
+		},
+		Delivery: DeliveryConfig{
+			SlowClientPolicy: "disconnect",
+			SendQueueSize:    256,
+		},
+		Cluster: ClusterConfig{
+			Bus:               "memory",
+			RedisURL:          "redis://localhost:6379/0",
+			RedisChannel:      "chat:events",
+			HeartbeatInterval: 10 * time.Second,
+		},
+		Auth: AuthConfig{
+			TokenTTL: 24 * time.Hour,
+		},
+		Database: defaultDatabase("chatdb", 25, 25),
+		Encryption: EncryptionConfig{
+			KeyProvider: "local",
+		},
+		Attachments: AttachmentsConfig{
+			Dir:     "uploads",
+			MaxSize: 10 << 20,
+		},
+		Analytics: defaultDatabase("chat_analytics", 10, 5),
+		ETL: ETLConfig{
+			RunInterval:     time.Hour,
+			BatchSize:       10000,
+			DetailedLogging: true,
+		},
+	}
+}
+
+func defaultDatabase(name string, maxOpen, maxIdle int) DatabaseConfig {
+	return DatabaseConfig{
+		Host:            "localhost",
+		Port:            3306,
+		User:            "root",
+		Name:            name,
+		MaxOpenConns:    maxOpen,
+		MaxIdleConns:    maxIdle,
+		ConnMaxLifetime: 5 * time.Minute,
+		AutoMigrate:     true,
+	}
+}
+
+// DSN возвращает строку подключения драйвера MySQL с параметрами params (например, parseTime=true)
+func (d DatabaseConfig) DSN(params string) string {
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?%s",
+		d.User, d.Password, net.JoinHostPort(d.Host, fmt.Sprint(d.Port)), d.Name, params)
+}
+
+// Validate проверяет значения и возвращает все найденные ошибки сразу
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	if _, port, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		check(false, "server.addr", "ожидается [хост]:порт, получено %q", c.Server.Addr)
+	} else {
+		check(port != "", "server.addr", "не указан порт")
+	}
+	check(c.Server.ReadTimeout > 0, "server.read_timeout", "должен быть больше нуля")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout", "должен быть больше нуля")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout", "должен быть больше нуля")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "должен быть больше нуля")
+	check(len(c.Server.CORSOrigins) > 0, "server.cors_origins", "укажите хотя бы один источник или *")
+	for _, origin := range c.Server.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "",
+			"server.cors_origins", "источник %q должен иметь вид http(s)://хост[:порт]", origin)
+	}
+
+	ws := c.WebSocket
+	check(ws.MaxMessageSize >= 1024 && ws.MaxMessageSize <= 64<<20, "websocket.max_message_size",
+		"ожидается от 1 КБ до 64 МБ, получено %d", ws.MaxMessageSize)
+	check(ws.WriteWait > 0, "websocket.write_wait", "должен быть больше нуля")
+	check(ws.PongWait > 0, "websocket.pong_wait", "должен быть больше нуля")
+	check(ws.PingPeriod > 0 && ws.PingPeriod < ws.PongWait, "websocket.ping_period",
+		"должен быть больше нуля и меньше pong_wait (%v)", ws.PongWait)
+	check(ws.InactivityTimeout > 0, "websocket.inactivity_timeout", "должен быть больше нуля")
+
+	switch c.Delivery.SlowClientPolicy {
+	case "disconnect", "drop-oldest", "spill":
+	default:
+		check(false, "delivery.slow_client_policy", "ожидается disconnect, drop-oldest или spill, получено %q", c.Delivery.SlowClientPolicy)
+	}
+	check(c.Delivery.SendQueueSize > 0, "delivery.send_queue_size", "должен быть больше нуля")
+
+	switch c.Cluster.Bus {
+	case "memory":
+	case "redis":
+		u, err := url.Parse(c.Cluster.RedisURL)
+		check(err == nil && (u.Scheme == "redis" || u.Scheme == "rediss"), "cluster.redis_url", "ожидается redis://хост:порт/база")
+	default:
+		check(false, "cluster.bus", "ожидается memory или redis, получено %q", c.Cluster.Bus)
+	}
+	check(c.Cluster.HeartbeatInterval > 0, "cluster.heartbeat_interval", "должен быть больше нуля")
+	if c.Cluster.ReconnectURL != "" {
+		u, err := url.Parse(c.Cluster.ReconnectURL)
+		check(err == nil && u.Scheme != "" && u.Host != "", "cluster.reconnect_url", "ожидается абсолютный адрес, получено %q", c.Cluster.ReconnectURL)
+	}
+
+	check(c.Auth.TokenTTL > 0, "auth.token_ttl", "должен быть больше нуля")
+
+	if c.Encryption.Key != "" {
+		key, err := base64.StdEncoding.DecodeString(c.Encryption.Key)
+		check(err == nil && len(key) == 32, "encryption.key", "ожидается 32 байта в base64")
+	}
+	if c.Search.Key != "" {
+		key, err := base64.StdEncoding.DecodeString(c.Search.Key)
+		check(err == nil && len(key) >= 32, "search.key", "ожидается не менее 32 байт в base64")
+	}
+
+	check(c.Attachments.Dir != "", "attachments.dir", "не задан")
+	check(c.Attachments.MaxSize > 0, "attachments.max_size", "должен быть больше нуля")
+
+	errs = append(errs, c.Database.validate("database")...)
+	errs = append(errs, c.Analytics.validate("analytics")...)
+
+	check(c.ETL.RunInterval >= time.Minute, "etl.run_interval", "не меньше минуты, получено %v", c.ETL.RunInterval)
+	check(c.ETL.BatchSize > 0, "etl.batch_size", "должен быть больше нуля")
+
+	return errors.Join(errs...)
+}
+
+func (d DatabaseConfig) validate(section string) []error {
+	var errs []error
+	if d.Host == "" {
+		errs = append(errs, fmt.Errorf("%s.host: не задан", section))
+	}
+	if d.Port < 1 || d.Port > 65535 {
+		errs = append(errs, fmt.Errorf("%s.port: ожидается число от 1 до 65535, получено %d", section, d.Port))
+	}
+	if d.User == "" {
+		errs = append(errs, fmt.Errorf("%s.user: не задан", section))
+	}
+	if d.Name == "" {
+		errs = append(errs, fmt.Errorf("%s.name: не задано", section))
+	}
+	if d.MaxOpenConns < 1 || d.MaxIdleConns < 0 || d.MaxIdleConns > d.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("%s: ожидается max_open_conns >= 1 и 0 <= max_idle_conns <= max_open_conns", section))
+	}
+	if d.ConnMaxLifetime < 0 {
+		errs = append(errs, fmt.Errorf("%s.conn_max_lifetime: не может быть отрицательным", section))
+	}
+	return errs
+}
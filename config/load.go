@@ -0,0 +1,275 @@
+// config/load.go
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Значение скрытого секрета в выводе --print-config
+const redacted = "[REDACTED]"
+
+// Load загружает конфигурацию: значения по умолчанию, затем файл (флаг --config или переменная
+// CHAT_CONFIG; формат определяется расширением .yaml, .yml или .toml), затем переменные окружения,
+// затем флаги. Флаги конфигурации, --config и --print-config регистрируются в fs, после чего fs
+// разбирается из args, поэтому программа может зарегистрировать в fs и собственные флаги.
+// Возвращает ошибку, если файл не читается, содержит неизвестные ключи или значения не проходят проверку.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+	fields := collect(&cfg)
+
+	// Флаги сначала только запоминаются: они применяются последними, поверх файла и окружения
+	set := make(map[string]string)
+	for _, f := range fields {
+		if f.flag != "" {
+			fs.Var(&flagValue{field: f, set: set}, f.flag, f.help)
+		}
+	}
+	file := fs.String("config", os.Getenv("CHAT_CONFIG"), "файл конфигурации YAML или TOML (CHAT_CONFIG)")
+	printConfig := fs.Bool("print-config", false, "вывести итоговую конфигурацию со скрытыми секретами и выйти")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		if err := loadFile(&cfg, *file); err != nil {
+			return nil, err
+		}
+		cfg.File = *file
+	}
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(f.env); ok && value != "" {
+			if err := setValue(f.value, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+	}
+	for _, f := range fields {
+		if value, ok := set[f.flag]; ok {
+			if err := setValue(f.value, value); err != nil {
+				return nil, fmt.Errorf("--%s: %w", f.flag, err)
+			}
+		}
+	}
+
+	if err := readSecretFiles(fields); err != nil {
+		return nil, err
+	}
+	cfg.PrintConfig = *printConfig
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("некорректная конфигурация:\n%w", err)
+	}
+	return &cfg, nil
+}
+
+// Print выводит конфигурацию в YAML со скрытыми секретами. Вывод можно сохранить как файл конфигурации.
+func (c *Config) Print(w io.Writer) error {
+	copied := *c
+	for _, f := range collect(&copied) {
+		switch {
+		case f.secret && f.value.String() != "":
+			f.value.SetString(redacted)
+		case f.secretURL:
+			if u, err := url.Parse(f.value.String()); err == nil {
+				f.value.SetString(u.Redacted())
+			}
+		}
+	}
+	if c.File != "" {
+		fmt.Fprintf(w, "# Загружено из %s\n", c.File)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(copied); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// field - поле конфигурации с полными именами ключа, переменной окружения и флага
+type field struct {
+	key    string // Ключ в файле, например database.port
+	env    string
+	flag   string
+	help   string
+	secret bool
+	// Значение - адрес, пароль в котором скрывается при выводе
+	secretURL bool
+	value     reflect.Value
+	parent    reflect.Value // Секция, в которой находится поле (для XFile секретов)
+	name      string
+}
+
+// collect перечисляет поля конфигурации, спускаясь во вложенные секции
+func collect(cfg *Config) []field {
+	var fields []field
+	var walk func(v reflect.Value, key, env, flag string)
+	walk = func(v reflect.Value, key, env, flag string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+			if name == "-" {
+				continue
+			}
+			if sf.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), key+name+".", env+sf.Tag.Get("env"), flag+sf.Tag.Get("flag"))
+				continue
+			}
+			f := field{
+				key:       key + name,
+				help:      sf.Tag.Get("help"),
+				secret:    sf.Tag.Get("secret") == "true",
+				secretURL: sf.Tag.Get("secret") == "url",
+				value:     v.Field(i),
+				parent:    v,
+				name:      sf.Name,
+			}
+			if tag := sf.Tag.Get("env"); tag != "" {
+				f.env = env + tag
+			}
+			if tag := sf.Tag.Get("flag"); tag != "" {
+				f.flag = flag + tag
+			}
+			if f.help != "" && f.env != "" {
+				f.help += " (" + f.env + ")"
+			}
+			fields = append(fields, f)
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "", "", "")
+	return fields
+}
+
+// loadFile читает файл конфигурации поверх значений по умолчанию. Неизвестные ключи - ошибка,
+// чтобы опечатка в имени параметра не проходила незамеченной.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения файла конфигурации: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: неизвестные ключи %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("%s: неизвестный формат файла конфигурации %q (ожидается .yaml, .yml или .toml)", path, ext)
+	}
+	return nil
+}
+
+// readSecretFiles читает секреты из файлов, указанных в полях XFile. Файл имеет приоритет
+// над значением, заданным напрямую; завершающий перевод строки отбрасывается.
+func readSecretFiles(fields []field) error {
+	for _, f := range fields {
+		if !f.secret {
+			continue
+		}
+		path := f.parent.FieldByName(f.name + "File")
+		if !path.IsValid() || path.String() == "" {
+			continue
+		}
+		data, err := os.ReadFile(path.String())
+		if err != nil {
+			return fmt.Errorf("%s_file: %w", f.key, err)
+		}
+		f.value.SetString(strings.TrimRight(string(data), "\r\n"))
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue разбирает строковое значение переменной окружения или флага в поле
+func setValue(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("ожидается длительность (например, 15s или 5m), получено %q", s)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("ожидается целое число, получено %q", s)
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("ожидается true или false, получено %q", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("неподдерживаемый тип %s", v.Type())
+	}
+	return nil
+}
+
+// flagValue запоминает значение флага; значение проверяется сразу, а применяется в Load
+type flagValue struct {
+	field field
+	set   map[string]string
+}
+
+func (f *flagValue) String() string {
+	if f.set == nil {
+		return ""
+	}
+	v := f.field.value
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func (f *flagValue) Set(s string) error {
+	if err := setValue(reflect.New(f.field.value.Type()).Elem(), s); err != nil {
+		return err
+	}
+	f.set[f.field.flag] = s
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.set != nil && f.field.value.Kind() == reflect.Bool
+}
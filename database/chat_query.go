@@ -8,11 +8,12 @@ import (
 // GetUserChats возвращает все чаты, в которых участвует пользователь
 func GetUserChats(userID int) ([]Chat, error) {
 	rows, err := DB.Query(`
-		SELECT id, buyer_id, seller_id, product_id, created_at 
-		FROM chats 
-		WHERE buyer_id = ? OR seller_id = ? 
-		ORDER BY created_at DESC
-	`, userID, userID)
+		SELECT c.id, c.buyer_id, c.seller_id, c.product_id, c.created_at 
+		FROM chat_participants p
+		JOIN chats c ON c.id = p.chat_id
+		WHERE p.user_id = ? 
+		ORDER BY c.created_at DESC
+	`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -5,11 +5,15 @@ import (
 	"database/sql"
 	"log"
 
+	"github.com/LilVoxy/coursework_chat/dbcrypt"
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var DB *sql.DB
 
+// Шифр, которым сервис сообщений шифрует текст сообщений в БД
+var MessageCipher *dbcrypt.Cipher
+
 // InitDB инициализирует соединение с базой данных
 // В этой функции больше нет прямого подключения, так как оно производится в main.go
 func InitDB() {
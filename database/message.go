@@ -3,6 +3,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"time"
 )
@@ -17,43 +18,15 @@ type Message struct {
 	ReadStatus bool
 }
 
-// SaveMessage сохраняет сообщение в базе данных.
-// Перед сохранением сообщения, функция получает или создает чат между
-// отправителем и получателем, относящийся к указанному товару.
-// Сообщение шифруется перед сохранением в БД.
-func SaveMessage(senderID, recipientID, productID int, message string) (int, error) {
-	// Получаем или создаем чат между пользователями для указанного товара
-	chatID, err := GetOrCreateChat(senderID, recipientID, productID)
-	if err != nil {
-		log.Printf("Ошибка получения/создания чата: %v", err)
-		return 0, err
-	}
-
-	// Шифруем сообщение перед сохранением в БД
-	encryptedMessage, err := encryptForDB(message)
-	if err != nil {
-		log.Printf("Ошибка шифрования сообщения: %v", err)
-		return 0, err
-	}
+// Сообщения сохраняются сервисом сообщений (пакет messages), который шифрует текст
+// для хранения; здесь остались функции чтения.
 
-	// Вставляем зашифрованное сообщение в базу данных
-	result, err := DB.Exec(
-		"INSERT INTO messages (chat_id, sender_id, message) VALUES (?, ?, ?)",
-		chatID, senderID, encryptedMessage,
-	)
-	if err != nil {
-		log.Printf("Ошибка сохранения сообщения: %v", err)
-		return 0, err
+// decryptMessage расшифровывает текст сообщения, прочитанный из БД
+func decryptMessage(stored string) (string, error) {
+	if MessageCipher == nil {
+		return "", errors.New("шифр сообщений не инициализирован")
 	}
-
-	// Получаем ID вставленного сообщения
-	messageID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("Ошибка получения ID сообщения: %v", err)
-		return 0, err
-	}
-
-	return int(messageID), nil
+	return MessageCipher.Decrypt(stored)
 }
 
 // MarkMessagesAsRead отмечает все сообщения в чате как прочитанные для указанного пользователя
@@ -86,7 +59,7 @@ func GetChatLastMessage(chatID int) (*Message, error) {
 	}
 
 	// Расшифровываем сообщение
-	decryptedMessage, err := decryptFromDB(encryptedMessage)
+	decryptedMessage, err := decryptMessage(encryptedMessage)
 	if err != nil {
 		log.Printf("Ошибка расшифровки сообщения %d: %v", msg.ID, err)
 		msg.Message = "[Ошибка расшифровки]" // Помечаем сообщение с ошибкой
@@ -31,7 +31,7 @@ func GetMessagesByChat(chatID int, limit, offset int) ([]Message, error) {
 		}
 
 		// Расшифровываем сообщение
-		decryptedMessage, err := decryptFromDB(encryptedMessage)
+		decryptedMessage, err := decryptMessage(encryptedMessage)
 		if err != nil {
 			log.Printf("Ошибка расшифровки сообщения %d: %v", msg.ID, err)
 			msg.Message = "[Ошибка расшифровки]" // Помечаем сообщение с ошибкой
@@ -49,59 +49,16 @@ func GetMessagesByChat(chatID int, limit, offset int) ([]Message, error) {
 	return messages, nil
 }
 
-// GetUnreadMessageCount возвращает количество непрочитанных сообщений для пользователя
+// GetUnreadMessageCount возвращает количество непрочитанных сообщений для пользователя.
+// Непрочитанными считаются сообщения других участников после курсора прочтения пользователя в каждом чате.
 func GetUnreadMessageCount(userID int) (int, error) {
-	// Найдем все чаты, в которых участвует пользователь
-	rows, err := DB.Query(`
-		SELECT c.id 
-		FROM chats c 
-		WHERE c.buyer_id = ? OR c.seller_id = ?
-	`, userID, userID)
-	if err != nil {
-		return 0, err
-	}
-	defer rows.Close()
-
-	var chatIDs []int
-	for rows.Next() {
-		var chatID int
-		if err := rows.Scan(&chatID); err != nil {
-			return 0, err
-		}
-		chatIDs = append(chatIDs, chatID)
-	}
-
-	if err = rows.Err(); err != nil {
-		return 0, err
-	}
-
-	// Если чатов нет, возвращаем 0
-	if len(chatIDs) == 0 {
-		return 0, nil
-	}
-
-	// Создаем запрос для подсчета непрочитанных сообщений
-	query := "SELECT COUNT(*) FROM messages WHERE chat_id IN (?) AND sender_id != ? AND read_status = FALSE"
-
-	// Преобразуем массив ID чатов в строку для SQL запроса
-	// Это простейшая реализация для примера. В реальности лучше использовать
-	// специальные библиотеки для работы с IN-запросами или кастомные функции
-	var args []interface{}
-	inClause := "("
-	for i, id := range chatIDs {
-		if i > 0 {
-			inClause += ","
-		}
-		inClause += "?"
-		args = append(args, id)
-	}
-	inClause += ")"
-
-	query = "SELECT COUNT(*) FROM messages WHERE chat_id IN " + inClause + " AND sender_id != ? AND read_status = FALSE"
-	args = append(args, userID)
-
 	var count int
-	err = DB.QueryRow(query, args...).Scan(&count)
+	err := DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM chat_participants p
+		JOIN messages m ON m.chat_id = p.chat_id
+		WHERE p.user_id = ? AND m.sender_id != p.user_id AND m.id > p.last_read_message_id
+	`, userID).Scan(&count)
 	if err != nil {
 		return 0, err
 	}
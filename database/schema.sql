@@ -1,45 +0,0 @@
--- Создание таблицы пользователей
-CREATE TABLE IF NOT EXISTS users (
-    id INT AUTO_INCREMENT PRIMARY KEY,
-    username VARCHAR(100) NOT NULL,
-    avatar_path VARCHAR(255) NOT NULL,
-    email VARCHAR(255) NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    UNIQUE (email)
-);
-
--- Создание таблицы товаров
-CREATE TABLE IF NOT EXISTS products (
-    id INT AUTO_INCREMENT PRIMARY KEY,
-    name VARCHAR(255) NOT NULL,
-    description TEXT,
-    price DECIMAL(10, 2) NOT NULL,
-    seller_id INT NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (seller_id) REFERENCES users(id)
-);
-
--- Создание таблицы чатов
-CREATE TABLE IF NOT EXISTS chats (
-    id INT AUTO_INCREMENT PRIMARY KEY,
-    buyer_id INT NOT NULL,   -- Покупатель
-    seller_id INT NOT NULL,  -- Продавец
-    product_id INT NOT NULL, -- Какой товар обсуждают
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    UNIQUE (buyer_id, seller_id, product_id), -- Запрещаем дублирование чатов по одному товару
-    FOREIGN KEY (buyer_id) REFERENCES users(id),
-    FOREIGN KEY (seller_id) REFERENCES users(id),
-    FOREIGN KEY (product_id) REFERENCES products(id)
-);
-
--- Создание таблицы сообщений
-CREATE TABLE IF NOT EXISTS messages (
-    id INT AUTO_INCREMENT PRIMARY KEY,
-    chat_id INT NOT NULL,      -- Какому чату принадлежит сообщение
-    sender_id INT NOT NULL,    -- Кто отправил сообщение
-    message TEXT NOT NULL,     -- Текст сообщения
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    read_status BOOLEAN DEFAULT FALSE, -- Флаг: прочитано / не прочитано
-    FOREIGN KEY (chat_id) REFERENCES chats(id),
-    FOREIGN KEY (sender_id) REFERENCES users(id)
-); 
\ No newline at end of file
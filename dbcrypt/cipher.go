@@ -0,0 +1,248 @@
+// dbcrypt/cipher.go
+package dbcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/LilVoxy/coursework_chat/config"
+)
+
+// Ключ для разработки. Совпадает с ключом, которым шифровались сообщения в первых версиях
+// сервера, поэтому ранее сохраненные сообщения остаются читаемыми. Используется только при
+// явном insecure_dev_key (CHAT_DB_INSECURE_DEV_KEY=true): ключ опубликован в исходниках и секретом не является.
+var developmentKey = []byte("this-is-32-byte-key-for-AES-GCM!") // Ровно 32 байта
+
+// Префикс версионированного формата шифротекста
+const versionPrefix = "v1:"
+
+// LegacyKeyID - ID ключа encryption.key (CHAT_DB_ENCRYPTION_KEY). Им расшифровываются записи
+// в старом формате без версии и шифруются новые, если связка ключей не настроена.
+const LegacyKeyID = "legacy"
+
+// Ошибки шифрования
+var (
+	ErrInvalidKey       = errors.New("ключ шифрования должен быть длиной 32 байта")
+	ErrNoKey            = errors.New("не задан ключ шифрования: укажите encryption.key (CHAT_DB_ENCRYPTION_KEY) или связку ключей encryption.keyring_file (CHAT_DB_KEYRING_FILE), для разработки - encryption.insecure_dev_key")
+	ErrInvalidKeyID     = errors.New("ID ключа должен состоять из латинских букв, цифр, точек и дефисов (до 32 символов)")
+	ErrUnknownKey       = errors.New("ключ шифрования с таким ID отсутствует в связке")
+	ErrCiphertextShort  = errors.New("шифротекст слишком короткий")
+	ErrInvalidEncoding  = errors.New("шифротекст должен быть в base64")
+	ErrDecryptionFailed = errors.New("не удалось расшифровать данные")
+)
+
+// Cipher шифрует текст сообщений для хранения в БД (AES-256-GCM) набором ключей данных.
+// Новые записи шифруются основным ключом, старые расшифровываются ключом, ID которого
+// записан в шифротексте. Формат хранения: "v1:<ID ключа>:" + base64(nonce || шифротекст || тег);
+// ID ключа и версия защищены тегом как дополнительные данные.
+// Записи старого формата - base64 без префикса - расшифровываются ключом LegacyKeyID.
+type Cipher struct {
+	keys    map[string]cipher.AEAD
+	primary string
+}
+
+// NewCipher создает шифр с одним 32-байтовым ключом (ID LegacyKeyID)
+func NewCipher(key []byte) (*Cipher, error) {
+	return NewKeyringCipher(map[string][]byte{LegacyKeyID: key}, LegacyKeyID)
+}
+
+// NewKeyringCipher создает шифр со связкой ключей данных; primary - ID ключа для новых записей
+func NewKeyringCipher(keys map[string][]byte, primary string) (*Cipher, error) {
+	c := &Cipher{keys: make(map[string]cipher.AEAD, len(keys)), primary: primary}
+	for id, key := range keys {
+		if !validKeyID(id) {
+			return nil, fmt.Errorf("%q: %w", id, ErrInvalidKeyID)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("ключ %s: %w", id, err)
+		}
+		c.keys[id] = aead
+	}
+	if _, ok := c.keys[primary]; !ok {
+		return nil, fmt.Errorf("основной ключ %q: %w", primary, ErrUnknownKey)
+	}
+	return c, nil
+}
+
+// NewCipherFromConfig создает шифр по секции encryption конфигурации. Используется и сервером, и ETL,
+// чтобы оба читали сообщения в одном формате:
+//   - key - ключ старого формата (32 байта в base64, CHAT_DB_ENCRYPTION_KEY);
+//   - keyring - связка завернутых ключей данных (JSON, обычно из keyring_file / CHAT_DB_KEYRING_FILE);
+//   - key_provider - провайдер KEK для связки (по умолчанию local, см. OpenKeyProvider);
+//   - insecure_dev_key - разрешить ключ разработки вместо key (CHAT_DB_INSECURE_DEV_KEY).
+//
+// Без связки новые записи шифруются ключом key; если не задан ни он, ни связка,
+// ни insecure_dev_key, возвращается ErrNoKey.
+func NewCipherFromConfig(cfg config.EncryptionConfig) (*Cipher, error) {
+	var legacy []byte
+	if cfg.InsecureDevKey {
+		legacy = developmentKey
+	}
+	if cfg.Key != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.Key)
+		if err != nil {
+			return nil, errors.New("encryption.key должен быть в base64")
+		}
+		legacy = key
+	}
+
+	if cfg.Keyring == "" {
+		if legacy == nil {
+			return nil, ErrNoKey
+		}
+		if cfg.Key == "" {
+			log.Println("⚠️ insecure_dev_key: сообщения шифруются общеизвестным ключом разработки")
+		}
+		return NewCipher(legacy)
+	}
+
+	keyring, err := ParseKeyring([]byte(cfg.Keyring))
+	if err != nil {
+		return nil, fmt.Errorf("encryption.keyring: %w", err)
+	}
+	provider, err := OpenKeyProvider(cfg.KeyProvider)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := keyring.Unwrap(provider)
+	if err != nil {
+		return nil, err
+	}
+	// Записи старого формата читаются ключом key, если он задан
+	if _, ok := keys[LegacyKeyID]; !ok && legacy != nil {
+		keys[LegacyKeyID] = legacy
+	}
+	log.Printf("✅ Связка ключей шифрования: %d ключей, основной %s", len(keys), keyring.Primary)
+	return NewKeyringCipher(keys, keyring.Primary)
+}
+
+// PrimaryKeyID возвращает ID ключа, которым шифруются новые записи
+func (c *Cipher) PrimaryKeyID() string {
+	return c.primary
+}
+
+// KeyIDs возвращает ID всех ключей связки
+func (c *Cipher) KeyIDs() []string {
+	ids := make([]string, 0, len(c.keys))
+	for id := range c.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// PrimaryPrefix возвращает префикс записей, зашифрованных основным ключом
+// (для поиска записей, которые нужно перешифровать)
+func (c *Cipher) PrimaryPrefix() string {
+	return versionPrefix + c.primary + ":"
+}
+
+// Encrypt шифрует текст основным ключом. Пустой текст (например, у сообщения только
+// с вложением или у удаленного сообщения) хранится как есть.
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	aead := c.keys[c.primary]
+	prefix := c.PrimaryPrefix()
+
+	// Nonce должен быть уникальным для каждого сообщения
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(prefix))
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt расшифровывает текст, сохраненный Encrypt любым ключом связки
+func (c *Cipher) Decrypt(stored string) (string, error) {
+	if stored == "" {
+		return "", nil
+	}
+
+	keyID, payload, aad := LegacyKeyID, stored, []byte(nil)
+	if strings.HasPrefix(stored, versionPrefix) {
+		rest := stored[len(versionPrefix):]
+		sep := strings.IndexByte(rest, ':')
+		if sep < 0 {
+			return "", ErrInvalidEncoding
+		}
+		keyID, payload = rest[:sep], rest[sep+1:]
+		aad = []byte(stored[:len(versionPrefix)+sep+1])
+	}
+
+	aead, ok := c.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("%q: %w", keyID, ErrUnknownKey)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrInvalidEncoding
+	}
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize+aead.Overhead() {
+		return "", ErrCiphertextShort
+	}
+
+	plaintext, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], aad)
+	if err != nil {
+		return "", ErrDecryptionFailed
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation сообщает, что запись зашифрована не основным ключом
+func (c *Cipher) NeedsRotation(stored string) bool {
+	return stored != "" && !strings.HasPrefix(stored, c.PrimaryPrefix())
+}
+
+// Reencrypt перешифровывает запись основным ключом. Записи, уже зашифрованные им, возвращаются как есть.
+func (c *Cipher) Reencrypt(stored string) (string, error) {
+	if !c.NeedsRotation(stored) {
+		return stored, nil
+	}
+	plaintext, err := c.Decrypt(stored)
+	if err != nil {
+		return "", err
+	}
+	return c.Encrypt(plaintext)
+}
+
+// newAEAD создает AES-256-GCM для 32-байтового ключа
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// validKeyID проверяет ID ключа: он входит в шифротекст и в шаблон LIKE при перешифровании,
+// поэтому двоеточия, знаки % и _ запрещены
+func validKeyID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+// dbcrypt/keyring.go
+package dbcrypt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Keyring - связка ключей данных, завернутых ключом провайдера (KEK).
+// Хранится в JSON-файле (CHAT_DB_KEYRING_FILE) или в переменной окружения (CHAT_DB_KEYRING):
+//
+//	{"version": 2, "provider": "local", "primary": "2026-10", "keys": [{"id": "2026-10", "wrappedKey": "...", "createdAt": "..."}]}
+//
+// Ключи не удаляются из связки, пока ими зашифрована хотя бы одна запись.
+type Keyring struct {
+	Version  int          `json:"version,omitempty"` // Формат заворачивания ключей (см. keyringVersion)
+	Provider string       `json:"provider"`
+	Primary  string       `json:"primary"`
+	Keys     []WrappedKey `json:"keys"`
+}
+
+// WrappedKey - ключ данных, зашифрованный KEK
+type WrappedKey struct {
+	ID         string    `json:"id"`
+	WrappedKey string    `json:"wrappedKey"` // base64
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Текущий формат связки: ключ заворачивается с ID ключа в качестве дополнительных данных,
+// поэтому завернутый ключ нельзя подставить в связку под другим ID. В связках версии 0
+// (без поля version) у всех ключей одинаковые дополнительные данные "dek"; такие связки
+// читаются, а при добавлении ключа (AddKey) переворачиваются в текущий формат.
+const keyringVersion = 2
+
+// LoadKeyring читает связку из файла
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k, err := ParseKeyring(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
+
+// ParseKeyring разбирает связку из JSON
+func ParseKeyring(data []byte) (*Keyring, error) {
+	var k Keyring
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("некорректная связка ключей: %w", err)
+	}
+	if len(k.Keys) == 0 {
+		return nil, errors.New("связка ключей пуста")
+	}
+	if k.Provider == "" {
+		k.Provider = "local"
+	}
+	if k.Primary == "" {
+		k.Primary = k.Keys[len(k.Keys)-1].ID
+	}
+	return &k, nil
+}
+
+// Save атомарно записывает связку в файл (через временный файл и переименование)
+func (k *Keyring) Save(path string) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyring-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// AddKey создает новый ключ данных, заворачивает его провайдером и добавляет в связку.
+// Если primary, новый ключ становится основным.
+func (k *Keyring) AddKey(provider KeyProvider, id string, primary bool) error {
+	if !validKeyID(id) || id == LegacyKeyID {
+		return fmt.Errorf("%q: %w", id, ErrInvalidKeyID)
+	}
+	if k.find(id) != nil {
+		return fmt.Errorf("ключ %s уже есть в связке", id)
+	}
+	if k.Provider == "" {
+		k.Provider = provider.Name()
+	}
+	if k.Provider != provider.Name() {
+		return fmt.Errorf("связка завернута провайдером %s, а не %s", k.Provider, provider.Name())
+	}
+
+	if err := k.upgrade(provider); err != nil {
+		return err
+	}
+
+	dek, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+	wrapped, err := provider.WrapKey(dek, k.aad(id))
+	if err != nil {
+		return err
+	}
+	k.Keys = append(k.Keys, WrappedKey{
+		ID:         id,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		CreatedAt:  time.Now().UTC(),
+	})
+	if primary || k.Primary == "" {
+		k.Primary = id
+	}
+	return nil
+}
+
+// SetPrimary делает ключ основным
+func (k *Keyring) SetPrimary(id string) error {
+	if k.find(id) == nil {
+		return fmt.Errorf("%q: %w", id, ErrUnknownKey)
+	}
+	k.Primary = id
+	return nil
+}
+
+// Unwrap разворачивает все ключи связки провайдером
+func (k *Keyring) Unwrap(provider KeyProvider) (map[string][]byte, error) {
+	if k.Provider != provider.Name() {
+		return nil, fmt.Errorf("связка завернута провайдером %s, а не %s", k.Provider, provider.Name())
+	}
+	keys := make(map[string][]byte, len(k.Keys))
+	for _, wk := range k.Keys {
+		wrapped, err := base64.StdEncoding.DecodeString(wk.WrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("ключ %s: %w", wk.ID, ErrInvalidEncoding)
+		}
+		dek, err := provider.UnwrapKey(wrapped, k.aad(wk.ID))
+		if err != nil {
+			return nil, fmt.Errorf("ключ %s: %w", wk.ID, err)
+		}
+		keys[wk.ID] = dek
+	}
+	if _, ok := keys[k.Primary]; !ok {
+		return nil, fmt.Errorf("основной ключ %q: %w", k.Primary, ErrUnknownKey)
+	}
+	return keys, nil
+}
+
+// aad возвращает дополнительные данные, с которыми завернут ключ id
+func (k *Keyring) aad(id string) []byte {
+	if k.Version < keyringVersion {
+		return []byte("dek")
+	}
+	return []byte("dek:" + id)
+}
+
+// upgrade переворачивает ключи связки старого формата с их ID в дополнительных данных
+func (k *Keyring) upgrade(provider KeyProvider) error {
+	if k.Version >= keyringVersion {
+		return nil
+	}
+	if len(k.Keys) == 0 {
+		k.Version = keyringVersion
+		return nil
+	}
+	keys, err := k.Unwrap(provider)
+	if err != nil {
+		return err
+	}
+	k.Version = keyringVersion
+	for i := range k.Keys {
+		wrapped, err := provider.WrapKey(keys[k.Keys[i].ID], k.aad(k.Keys[i].ID))
+		if err != nil {
+			return err
+		}
+		k.Keys[i].WrappedKey = base64.StdEncoding.EncodeToString(wrapped)
+	}
+	return nil
+}
+
+// find возвращает ключ связки по ID
+func (k *Keyring) find(id string) *WrappedKey {
+	for i := range k.Keys {
+		if k.Keys[i].ID == id {
+			return &k.Keys[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// dbcrypt/provider.go
+package dbcrypt
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeyProvider хранит ключ шифрования ключей (KEK) и заворачивает им ключи данных (DEK).
+// Ключи данных лежат в связке только в завернутом виде, поэтому утечка файла связки
+// без доступа к KEK не раскрывает сообщения. Реализацией может быть локальный файл,
+// облачный KMS или HSM.
+type KeyProvider interface {
+	// Name возвращает имя провайдера (записывается в связку ключей)
+	Name() string
+	// WrapKey шифрует ключ данных; aad (ID ключа) привязывает завернутый ключ к его ID
+	WrapKey(dek, aad []byte) ([]byte, error)
+	// UnwrapKey расшифровывает ключ данных с теми же aad, что при заворачивании
+	UnwrapKey(wrapped, aad []byte) ([]byte, error)
+}
+
+// KeyProviderFactory создает провайдер по настройкам из окружения
+type KeyProviderFactory func() (KeyProvider, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]KeyProviderFactory{
+		"local": func() (KeyProvider, error) { return LocalKeyProviderFromEnv() },
+	}
+)
+
+// RegisterKeyProvider регистрирует провайдер KEK (например, облачный KMS) под именем,
+// которое выбирается переменной CHAT_DB_KEY_PROVIDER
+func RegisterKeyProvider(name string, factory KeyProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = factory
+}
+
+// OpenKeyProvider создает зарегистрированный провайдер; пустое имя означает local
+func OpenKeyProvider(name string) (KeyProvider, error) {
+	if name == "" {
+		name = "local"
+	}
+	providersMu.RLock()
+	factory, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("неизвестный провайдер ключей %q", name)
+	}
+	return factory()
+}
+
+// LocalKeyProvider - KEK из локального файла (32 байта в base64), ключи заворачиваются AES-256-GCM
+type LocalKeyProvider struct {
+	aead cipher.AEAD
+}
+
+// NewLocalKeyProvider создает провайдер с 32-байтовым KEK
+func NewLocalKeyProvider(kek []byte) (*LocalKeyProvider, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalKeyProvider{aead: aead}, nil
+}
+
+// LoadLocalKeyProvider читает KEK из файла
+func LoadLocalKeyProvider(path string) (*LocalKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("файл KEK %s должен содержать ключ в base64", path)
+	}
+	return NewLocalKeyProvider(kek)
+}
+
+// LocalKeyProviderFromEnv читает KEK из файла CHAT_DB_KEK_FILE или из переменной CHAT_DB_KEK (base64)
+func LocalKeyProviderFromEnv() (*LocalKeyProvider, error) {
+	if path := os.Getenv("CHAT_DB_KEK_FILE"); path != "" {
+		return LoadLocalKeyProvider(path)
+	}
+	v := os.Getenv("CHAT_DB_KEK")
+	if v == "" {
+		return nil, errors.New("для связки ключей нужен KEK: задайте CHAT_DB_KEK_FILE или CHAT_DB_KEK")
+	}
+	kek, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, errors.New("CHAT_DB_KEK должен быть в base64")
+	}
+	return NewLocalKeyProvider(kek)
+}
+
+// GenerateKey создает случайный 32-байтовый ключ (KEK или DEK)
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Name возвращает имя провайдера
+func (p *LocalKeyProvider) Name() string {
+	return "local"
+}
+
+// WrapKey шифрует ключ данных: nonce || шифротекст
+func (p *LocalKeyProvider) WrapKey(dek, aad []byte) ([]byte, error) {
+	nonce := make([]byte, p.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return p.aead.Seal(nonce, nonce, dek, aad), nil
+}
+
+// UnwrapKey расшифровывает ключ данных
+func (p *LocalKeyProvider) UnwrapKey(wrapped, aad []byte) ([]byte, error) {
+	nonceSize := p.aead.NonceSize()
+	if len(wrapped) < nonceSize+p.aead.Overhead() {
+		return nil, ErrCiphertextShort
+	}
+	dek, err := p.aead.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], aad)
+	if err != nil {
+		return nil, errors.New("не удалось развернуть ключ данных: неверный KEK или ключ завернут для другого ID")
+	}
+	return dek, nil
+}
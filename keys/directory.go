@@ -0,0 +1,325 @@
+// keys/directory.go
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Алгоритмы ключей, которые принимает каталог.
+// Клиент шифрует сообщение AES-256-GCM, а ключ сообщения - для каждого устройства получателей
+// и подписывает сообщение ключом подписи своего устройства.
+const (
+	AlgRSAOAEP = "RSA-OAEP-256" // RSA-OAEP с SHA-256, ключ не короче 2048 бит (устаревший режим)
+	AlgX25519  = "X25519"       // Ключ устройства для сессий X3DH + Double Ratchet (см. prekeys.go)
+	AlgEd25519 = "Ed25519"      // Ключ подписи сообщений устройства
+)
+
+const (
+	// Минимальная длина ключа RSA в битах
+	minRSABits = 2048
+
+	// Количество действующих ключей одного пользователя (ключ шифрования и ключ подписи на устройство)
+	maxActiveKeys = 20
+
+	// Максимальная длина подписи ключа (название устройства)
+	maxLabelLength = 64
+)
+
+// Ошибки каталога ключей
+var (
+	ErrInvalidKey           = errors.New("некорректный публичный ключ: ожидается PEM или base64 (PKIX)")
+	ErrUnsupportedAlgorithm = errors.New("неподдерживаемый алгоритм ключа")
+	ErrWeakKey              = errors.New("ключ RSA должен быть не короче 2048 бит")
+	ErrKeyNotFound          = errors.New("ключ не найден")
+	ErrKeyTaken             = errors.New("ключ уже зарегистрирован другим пользователем")
+	ErrTooManyKeys          = errors.New("слишком много действующих ключей, отзовите неиспользуемые")
+	ErrNotSigningKey        = errors.New("ключ не является ключом подписи " + AlgEd25519)
+)
+
+// Querier - *sql.DB или *sql.Tx
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// PublicKey - публичный ключ устройства пользователя. Приватные ключи создаются и хранятся
+// только на устройствах; сервер публикует открытые ключи и пересылает зашифрованные сообщения,
+// не имея возможности их прочитать.
+type PublicKey struct {
+	Fingerprint string     `json:"fingerprint"` // SHA-256 от ключа в DER (hex)
+	UserID      int        `json:"userId"`
+	Algorithm   string     `json:"algorithm"`
+	PublicKey   string     `json:"publicKey"` // PEM
+	Label       string     `json:"label,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Directory - каталог публичных ключей пользователей
+type Directory struct {
+	db *sql.DB
+}
+
+// NewDirectory создает каталог ключей
+func NewDirectory(db *sql.DB) *Directory {
+	return &Directory{db: db}
+}
+
+// Fingerprint вычисляет отпечаток ключа: SHA-256 от DER (SubjectPublicKeyInfo) в hex
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// parsePublicKey разбирает ключ в PEM или base64 и проверяет, что он подходит алгоритму.
+// Возвращает DER ключа.
+func parsePublicKey(algorithm, text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	var der []byte
+	if block, _ := pem.Decode([]byte(text)); block != nil {
+		if block.Type != "PUBLIC KEY" {
+			return nil, ErrInvalidKey
+		}
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		der = decoded
+		// Ключи X25519 и Ed25519 можно передать и как 32 байта без обертки PKIX
+		if len(decoded) == 32 {
+			var raw interface{}
+			switch algorithm {
+			case AlgX25519:
+				if raw, err = ecdh.X25519().NewPublicKey(decoded); err != nil {
+					return nil, ErrInvalidKey
+				}
+			case AlgEd25519:
+				raw = ed25519.PublicKey(decoded)
+			}
+			if raw != nil {
+				if der, err = x509.MarshalPKIXPublicKey(raw); err != nil {
+					return nil, ErrInvalidKey
+				}
+			}
+		}
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	switch algorithm {
+	case AlgRSAOAEP:
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: для %s нужен ключ RSA", ErrInvalidKey, algorithm)
+		}
+		if rsaKey.N.BitLen() < minRSABits {
+			return nil, ErrWeakKey
+		}
+	case AlgX25519:
+		if key, ok := pub.(*ecdh.PublicKey); !ok || key.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("%w: для %s нужен ключ X25519", ErrInvalidKey, algorithm)
+		}
+	case AlgEd25519:
+		if _, ok := pub.(ed25519.PublicKey); !ok {
+			return nil, fmt.Errorf("%w: для %s нужен ключ Ed25519", ErrInvalidKey, algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+	return der, nil
+}
+
+// SigningKey возвращает ключ подписи из записи каталога
+func (k *PublicKey) SigningKey() (ed25519.PublicKey, error) {
+	if k.Algorithm != AlgEd25519 {
+		return nil, ErrNotSigningKey
+	}
+	block, _ := pem.Decode([]byte(k.PublicKey))
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	key, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrNotSigningKey
+	}
+	return key, nil
+}
+
+// Register публикует ключ устройства пользователя. Повторная регистрация того же ключа
+// возвращает уже сохраненную запись (отозванный ключ повторно не публикуется).
+func (d *Directory) Register(userID int, algorithm, publicKey, label string) (*PublicKey, bool, error) {
+	der, err := parsePublicKey(algorithm, publicKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(label) > maxLabelLength {
+		label = label[:maxLabelLength]
+	}
+	fingerprint := Fingerprint(der)
+
+	existing, err := d.Get(fingerprint)
+	if err == nil {
+		if existing.UserID != userID {
+			return nil, false, ErrKeyTaken
+		}
+		return existing, false, nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return nil, false, err
+	}
+
+	var active int
+	if err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM user_public_keys WHERE user_id = ? AND revoked_at IS NULL
+	`, userID).Scan(&active); err != nil {
+		return nil, false, err
+	}
+	if active >= maxActiveKeys {
+		return nil, false, ErrTooManyKeys
+	}
+
+	key := &PublicKey{
+		Fingerprint: fingerprint,
+		UserID:      userID,
+		Algorithm:   algorithm,
+		PublicKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Label:       label,
+		CreatedAt:   time.Now(),
+	}
+	if _, err := d.db.Exec(`
+		INSERT INTO user_public_keys (fingerprint, user_id, algorithm, public_key, label, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, key.Fingerprint, key.UserID, key.Algorithm, key.PublicKey, key.Label, key.CreatedAt); err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// Get возвращает ключ по отпечатку (в том числе отозванный)
+func (d *Directory) Get(fingerprint string) (*PublicKey, error) {
+	var key PublicKey
+	var revokedAt sql.NullTime
+	err := d.db.QueryRow(`
+		SELECT fingerprint, user_id, algorithm, public_key, label, created_at, revoked_at
+		FROM user_public_keys WHERE fingerprint = ?
+	`, strings.ToLower(fingerprint)).Scan(&key.Fingerprint, &key.UserID, &key.Algorithm, &key.PublicKey,
+		&key.Label, &key.CreatedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+// List возвращает действующие ключи пользователя (по одному на устройство)
+func (d *Directory) List(userID int) ([]PublicKey, error) {
+	rows, err := d.db.Query(`
+		SELECT fingerprint, user_id, algorithm, public_key, label, created_at
+		FROM user_public_keys
+		WHERE user_id = ? AND revoked_at IS NULL
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []PublicKey{}
+	for rows.Next() {
+		var key PublicKey
+		if err := rows.Scan(&key.Fingerprint, &key.UserID, &key.Algorithm, &key.PublicKey,
+			&key.Label, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, key)
+	}
+	return list, rows.Err()
+}
+
+// Revoke отзывает ключ пользователя (например, при потере устройства).
+// Отозванный ключ больше не выдается собеседникам, и новые сообщения для него не принимаются;
+// предварительные ключи устройства удаляются.
+func (d *Directory) Revoke(userID int, fingerprint string) error {
+	fingerprint = strings.ToLower(fingerprint)
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE user_public_keys SET revoked_at = NOW()
+		WHERE fingerprint = ? AND user_id = ? AND revoked_at IS NULL
+	`, fingerprint, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrKeyNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM one_time_prekeys WHERE fingerprint = ?`, fingerprint); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM prekey_bundles WHERE fingerprint = ?`, fingerprint); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Owners возвращает владельцев действующих ключей по отпечаткам.
+// Отпечатков, которых нет в каталоге или которые отозваны, в результате нет.
+func Owners(db Querier, fingerprints []string) (map[string]int, error) {
+	owners := make(map[string]int, len(fingerprints))
+	if len(fingerprints) == 0 {
+		return owners, nil
+	}
+
+	placeholders := make([]string, len(fingerprints))
+	args := make([]interface{}, len(fingerprints))
+	for i, fp := range fingerprints {
+		placeholders[i] = "?"
+		args[i] = strings.ToLower(fp)
+	}
+	rows, err := db.Query(`
+		SELECT fingerprint, user_id FROM user_public_keys
+		WHERE revoked_at IS NULL AND fingerprint IN (`+strings.Join(placeholders, ", ")+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fp string
+		var userID int
+		if err := rows.Scan(&fp, &userID); err != nil {
+			return nil, err
+		}
+		owners[fp] = userID
+	}
+	return owners, rows.Err()
+}
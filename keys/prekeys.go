@@ -0,0 +1,214 @@
+// keys/prekeys.go
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+
+	"github.com/LilVoxy/coursework_chat/processor"
+)
+
+const (
+	// Максимальное количество неиспользованных одноразовых ключей одного устройства
+	maxOneTimePreKeys = 100
+)
+
+// Ошибки предварительных ключей
+var (
+	ErrNotRatchetKey  = errors.New("предварительные ключи загружаются только для ключей " + AlgX25519)
+	ErrInvalidPreKey  = errors.New("некорректный предварительный ключ")
+	ErrTooManyPreKeys = errors.New("слишком много одноразовых ключей, дождитесь их расхода")
+)
+
+// OneTimePreKey - публичный одноразовый ключ устройства
+type OneTimePreKey struct {
+	ID        uint32 `json:"id"`
+	PublicKey []byte `json:"publicKey"` // X25519, 32 байта (base64 в JSON)
+}
+
+// PreKeyUpload - предварительные ключи, которые устройство загружает на сервер.
+// Подписанный ключ заменяет предыдущий, одноразовые добавляются к еще не выданным.
+type PreKeyUpload struct {
+	SigningKey            []byte          `json:"signingKey"` // Ed25519
+	SignedPreKeyID        uint32          `json:"signedPreKeyId"`
+	SignedPreKey          []byte          `json:"signedPreKey"`
+	SignedPreKeySignature []byte          `json:"signedPreKeySignature"`
+	OneTimePreKeys        []OneTimePreKey `json:"oneTimePreKeys"`
+}
+
+// DeviceBundle - набор ключей одного устройства для установки сессии X3DH
+type DeviceBundle struct {
+	Fingerprint string `json:"fingerprint"`
+	Label       string `json:"label,omitempty"`
+	processor.PreKeyBundle
+}
+
+// identityBytes возвращает 32 байта ключа X25519 из записи каталога
+func identityBytes(key *PublicKey) ([]byte, error) {
+	block, _ := pem.Decode([]byte(key.PublicKey))
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	dh, ok := pub.(*ecdh.PublicKey)
+	if !ok {
+		return nil, ErrNotRatchetKey
+	}
+	return dh.Bytes(), nil
+}
+
+// UploadPreKeys сохраняет предварительные ключи собственного устройства пользователя.
+// Подпись подписанного ключа проверяется ключом подписи из загрузки, поэтому клиент,
+// получивший набор, может убедиться, что его не подменили. Возвращает количество
+// одноразовых ключей устройства, которые еще не выданы.
+func (d *Directory) UploadPreKeys(userID int, fingerprint string, upload *PreKeyUpload) (int, error) {
+	key, err := d.Get(fingerprint)
+	if err != nil {
+		return 0, err
+	}
+	if key.UserID != userID || key.RevokedAt != nil {
+		return 0, ErrKeyNotFound
+	}
+	if key.Algorithm != AlgX25519 {
+		return 0, ErrNotRatchetKey
+	}
+	identity, err := identityBytes(key)
+	if err != nil {
+		return 0, err
+	}
+
+	bundle := &processor.PreKeyBundle{
+		IdentityKey:           identity,
+		SigningKey:            upload.SigningKey,
+		SignedPreKeyID:        upload.SignedPreKeyID,
+		SignedPreKey:          upload.SignedPreKey,
+		SignedPreKeySignature: upload.SignedPreKeySignature,
+	}
+	if err := processor.VerifyBundle(bundle); err != nil {
+		return 0, err
+	}
+	for _, opk := range upload.OneTimePreKeys {
+		if _, err := ecdh.X25519().NewPublicKey(opk.PublicKey); err != nil {
+			return 0, ErrInvalidPreKey
+		}
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO prekey_bundles (fingerprint, user_id, signing_key, signed_prekey_id, signed_prekey, signed_prekey_signature)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE signing_key = VALUES(signing_key), signed_prekey_id = VALUES(signed_prekey_id),
+			signed_prekey = VALUES(signed_prekey), signed_prekey_signature = VALUES(signed_prekey_signature),
+			updated_at = CURRENT_TIMESTAMP
+	`, key.Fingerprint, userID, upload.SigningKey, upload.SignedPreKeyID, upload.SignedPreKey,
+		upload.SignedPreKeySignature); err != nil {
+		return 0, err
+	}
+
+	// Ключи с уже загруженными ID пропускаются: повтор запроса после обрыва связи безопасен
+	for _, opk := range upload.OneTimePreKeys {
+		if _, err := tx.Exec(`
+			INSERT IGNORE INTO one_time_prekeys (fingerprint, prekey_id, public_key) VALUES (?, ?, ?)
+		`, key.Fingerprint, opk.ID, opk.PublicKey); err != nil {
+			return 0, err
+		}
+	}
+
+	var remaining int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM one_time_prekeys WHERE fingerprint = ?
+	`, key.Fingerprint).Scan(&remaining); err != nil {
+		return 0, err
+	}
+	if remaining > maxOneTimePreKeys {
+		return 0, ErrTooManyPreKeys
+	}
+	return remaining, tx.Commit()
+}
+
+// FetchBundles возвращает наборы ключей всех устройств пользователя, которые поддерживают сессии.
+// Каждому вызову выдается свой одноразовый ключ устройства, и он сразу удаляется с сервера;
+// когда одноразовые ключи заканчиваются, набор выдается без него.
+func (d *Directory) FetchBundles(userID int) ([]DeviceBundle, error) {
+	list, err := d.List(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := []DeviceBundle{}
+	for i := range list {
+		key := &list[i]
+		if key.Algorithm != AlgX25519 {
+			continue
+		}
+		identity, err := identityBytes(key)
+		if err != nil {
+			return nil, err
+		}
+		bundle, err := d.takeBundle(key.Fingerprint)
+		if errors.Is(err, ErrKeyNotFound) {
+			// Устройство еще не загрузило предварительные ключи
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		bundle.IdentityKey = identity
+		bundles = append(bundles, DeviceBundle{Fingerprint: key.Fingerprint, Label: key.Label, PreKeyBundle: *bundle})
+	}
+	return bundles, nil
+}
+
+// takeBundle читает подписанный ключ устройства и забирает один одноразовый ключ
+func (d *Directory) takeBundle(fingerprint string) (*processor.PreKeyBundle, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var bundle processor.PreKeyBundle
+	err = tx.QueryRow(`
+		SELECT signing_key, signed_prekey_id, signed_prekey, signed_prekey_signature
+		FROM prekey_bundles WHERE fingerprint = ?
+	`, fingerprint).Scan(&bundle.SigningKey, &bundle.SignedPreKeyID, &bundle.SignedPreKey, &bundle.SignedPreKeySignature)
+	if err == sql.ErrNoRows {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// FOR UPDATE не дает двум инициаторам получить один и тот же одноразовый ключ
+	var id uint32
+	var public []byte
+	err = tx.QueryRow(`
+		SELECT prekey_id, public_key FROM one_time_prekeys
+		WHERE fingerprint = ? ORDER BY prekey_id LIMIT 1 FOR UPDATE
+	`, fingerprint).Scan(&id, &public)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, err
+	default:
+		if _, err := tx.Exec(`
+			DELETE FROM one_time_prekeys WHERE fingerprint = ? AND prekey_id = ?
+		`, fingerprint, id); err != nil {
+			return nil, err
+		}
+		bundle.OneTimePreKeyID = &id
+		bundle.OneTimePreKey = public
+	}
+	return &bundle, tx.Commit()
+}
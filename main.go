@@ -2,50 +2,140 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
-	"time"
 
+	"github.com/LilVoxy/coursework_chat/attachments"
+	"github.com/LilVoxy/coursework_chat/auth"
+	"github.com/LilVoxy/coursework_chat/config"
+	"github.com/LilVoxy/coursework_chat/dbcrypt"
+	"github.com/LilVoxy/coursework_chat/messages"
 	"github.com/LilVoxy/coursework_chat/routes"
+	"github.com/LilVoxy/coursework_chat/search"
 	"github.com/LilVoxy/coursework_chat/websocket"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 )
 
 func main() {
+	// Конфигурация: файл (--config), переменные окружения CHAT_*, флаги
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("❌ Ошибка конфигурации: %v", err)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("❌ Ошибка вывода конфигурации: %v", err)
+		}
+		return
+	}
+
 	fmt.Println("Запуск сервера...")
 
+	// Контекст отменяется сигналом завершения; он же останавливает фоновые задачи
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
 	// Инициализация базы данных
-	db, err := websocket.InitDB()
+	db, err := websocket.InitDB(cfg.Database)
 	if err != nil {
 		log.Fatalf("❌ Не удалось инициализировать базу данных: %v", err)
 	}
-	defer db.Close()
+
+	// Шина событий между экземплярами сервера (в памяти или Redis)
+	bus, err := websocket.NewBus(cfg.Cluster)
+	if err != nil {
+		log.Fatalf("❌ Не удалось подключить шину событий: %v", err)
+	}
 
 	// Создаем новый менеджер WebSocket с подключением к БД
-	wsManager := websocket.NewManager(db)
+	wsManager := websocket.NewManager(db, bus, *cfg)
 	websocket.SetManager(wsManager)
 
+	// Шифрование текста сообщений в БД (тот же ключ использует ETL)
+	messageCipher, err := dbcrypt.NewCipherFromConfig(cfg.Encryption)
+	if err != nil {
+		log.Fatalf("❌ Не удалось настроить шифрование сообщений: %v", err)
+	}
+
+	// Поисковый индекс сообщений
+	searchIndex, err := search.NewIndexFromConfig(db, cfg.Search, cfg.Encryption.InsecureDevKey, messageCipher)
+	if err != nil {
+		log.Fatalf("❌ Не удалось настроить поисковый индекс: %v", err)
+	}
+
+	// Единый сервис записи сообщений для WebSocket и REST API
+	messageService := messages.NewService(db, messageCipher, searchIndex, wsManager)
+	wsManager.Messages = messageService
+
+	// Приводим сохраненные ранее данные к единому формату до приема соединений
+	if err := messageService.EncryptExisting(ctx); err != nil {
+		log.Fatalf("❌ Не удалось зашифровать сохраненные сообщения: %v", err)
+	}
+	if err := messageService.NormalizeChatRoles(ctx); err != nil {
+		log.Fatalf("❌ Не удалось исправить роли в чатах: %v", err)
+	}
+
+	// Фоновые задачи работают с БД до отмены ctx; при остановке БД закрывается после их завершения
+	var background sync.WaitGroup
+	runBackground := func(fn func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			fn()
+		}()
+	}
+
+	// Старые сообщения индексируются в фоне
+	runBackground(func() {
+		if err := searchIndex.Backfill(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("❌ Ошибка индексации сообщений: %v", err)
+		}
+	})
+
+	// Записи, зашифрованные прежними ключами, перешифровываются основным ключом в фоне
+	runBackground(func() {
+		if err := messageService.RotateKeys(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("❌ Ошибка перешифрования сообщений: %v", err)
+		}
+	})
+
 	// Запускаем менеджер WebSocket
 	go wsManager.Run()
 
+	// Настраиваем проверку токенов доступа
+	authenticator, err := auth.NewAuthenticatorFromConfig(cfg.Auth)
+	if err != nil {
+		log.Fatalf("❌ Не удалось настроить авторизацию: %v", err)
+	}
+
+	// Хранилище вложений (файлы и изображения в сообщениях)
+	attachmentService, err := attachments.NewServiceFromConfig(db, cfg.Attachments)
+	if err != nil {
+		log.Fatalf("❌ Не удалось настроить хранилище вложений: %v", err)
+	}
+	runBackground(func() { attachmentService.RunCleanup(ctx) })
+
 	// Создаем маршрутизатор
 	router := mux.NewRouter()
 
 	// Настройка всех маршрутов
-	routes.SetupRoutes(router, db, wsManager)
+	routes.SetupRoutes(router, db, wsManager, authenticator, attachmentService, searchIndex, cfg.Server.CORSOrigins)
 
 	// Настраиваем сервер
 	server := &http.Server{
-		Addr:         ":8080",
+		Addr:         cfg.Server.Addr,
 		Handler:      router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 
 	// Запускаем сервер в отдельной горутине
@@ -56,15 +146,45 @@ func main() {
 		}
 	}()
 
-	// Канал для сигналов завершения
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-
 	// Ожидаем сигнал завершения
-	<-stop
+	<-ctx.Done()
+	stopSignals()
 	log.Println("⚠️ Получен сигнал завершения, закрываем соединения...")
 
-	// Закрываем соединение с базой данных
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Перестаем принимать HTTP-запросы и дожидаемся текущих (в том числе отправки сообщений через REST)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("❌ Ошибка остановки HTTP-сервера: %v", err)
+	} else {
+		log.Println("✅ HTTP-сервер остановлен")
+	}
+
+	// Отключаем WebSocket-клиентов: дожидаемся сохранения принятых сообщений, отправляем
+	// очереди клиентов и кадр закрытия с адресом для переподключения
+	if err := wsManager.Shutdown(shutdownCtx); err != nil {
+		log.Printf("❌ Ошибка остановки менеджера WebSocket: %v", err)
+	}
+
+	// Индексация, перешифрование и очистка вложений остановлены отменой ctx; дожидаемся запросов,
+	// которые они еще выполняют
+	backgroundDone := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(backgroundDone)
+	}()
+	select {
+	case <-backgroundDone:
+		log.Println("✅ Фоновые задачи остановлены")
+	case <-shutdownCtx.Done():
+		log.Printf("❌ Фоновые задачи не завершились: %v", shutdownCtx.Err())
+	}
+
+	// Шину и БД закрываем последними, когда к ним больше никто не обращается
+	if err := bus.Close(); err != nil {
+		log.Printf("❌ Ошибка закрытия шины событий: %v", err)
+	}
 	if err := db.Close(); err != nil {
 		log.Printf("❌ Ошибка закрытия соединения с БД: %v", err)
 	} else {
@@ -0,0 +1,229 @@
+// messages/e2e.go
+package messages
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/LilVoxy/coursework_chat/attachments"
+	"github.com/LilVoxy/coursework_chat/keys"
+	"github.com/LilVoxy/coursework_chat/processor"
+)
+
+const (
+	// Максимальный размер зашифрованного текста (base64)
+	maxCiphertextSize = 96 << 10
+
+	// Максимальное количество завернутых ключей сообщения (устройств участников)
+	maxPayloadKeys = 64
+
+	// Максимальная длина ID сообщения, созданного отправителем
+	maxPayloadMessageID = 64
+
+	// Допустимое расхождение времени подписи с часами сервера
+	signatureClockSkew = 5 * time.Minute
+)
+
+// Ошибки сквозного шифрования
+var (
+	ErrInvalidPayload      = errors.New("некорректное зашифрованное сообщение")
+	ErrUnknownRecipientKey = errors.New("ключ получателя не найден в каталоге или отозван")
+	ErrE2ERequired         = errors.New("в чате включено сквозное шифрование: принимаются только зашифрованные сообщения без вложений")
+	ErrMissingSignature    = errors.New("зашифрованное сообщение должно быть подписано ключом отправителя")
+	ErrInvalidSignature    = errors.New("подпись сообщения не подтверждает отправителя")
+)
+
+// EncryptedPayload - сообщение, зашифрованное на устройстве отправителя.
+// Сервер не может его расшифровать: он проверяет только, что ключи принадлежат участникам чата,
+// и пересылает payload без изменений. Текст шифруется AES-256-GCM одноразовым ключом,
+// который заворачивается для каждого устройства участников (см. пакет keys).
+// Шифротекст и метаданные подписываются ключом Ed25519 отправителя (processor.SignMessage):
+// сервер проверяет подпись при получении, получатели - при расшифровке.
+type EncryptedPayload struct {
+	Algorithm  string         `json:"alg"`                 // Например, X25519-DR+A256GCM
+	Ciphertext string         `json:"ciphertext"`          // base64(nonce || шифротекст || тег)
+	SenderKey  string         `json:"senderKey,omitempty"` // Отпечаток ключа устройства отправителя
+	Keys       []RecipientKey `json:"keys"`
+
+	MessageID  string `json:"messageId"`  // ID сообщения, созданный отправителем
+	Timestamp  int64  `json:"timestamp"`  // Время подписи, Unix в миллисекундах
+	SigningKey string `json:"signingKey"` // Отпечаток ключа подписи отправителя (Ed25519)
+	Signature  string `json:"signature"`  // base64(Ed25519)
+}
+
+// RecipientKey - ключ сообщения, завернутый для одного устройства
+type RecipientKey struct {
+	Fingerprint  string `json:"fingerprint"`
+	EncryptedKey string `json:"encryptedKey"` // base64
+}
+
+// validate проверяет структуру payload (содержимое сервер проверить не может)
+func (p *EncryptedPayload) validate() error {
+	if p.Algorithm == "" || p.Ciphertext == "" || len(p.Keys) == 0 {
+		return ErrInvalidPayload
+	}
+	if len(p.Ciphertext) > maxCiphertextSize || len(p.Keys) > maxPayloadKeys {
+		return fmt.Errorf("%w: слишком большое сообщение", ErrInvalidPayload)
+	}
+	if _, err := base64.StdEncoding.DecodeString(p.Ciphertext); err != nil {
+		return fmt.Errorf("%w: ciphertext должен быть в base64", ErrInvalidPayload)
+	}
+	for i := range p.Keys {
+		p.Keys[i].Fingerprint = strings.ToLower(p.Keys[i].Fingerprint)
+		if p.Keys[i].Fingerprint == "" || p.Keys[i].EncryptedKey == "" {
+			return ErrInvalidPayload
+		}
+		if _, err := base64.StdEncoding.DecodeString(p.Keys[i].EncryptedKey); err != nil {
+			return fmt.Errorf("%w: encryptedKey должен быть в base64", ErrInvalidPayload)
+		}
+	}
+	p.SenderKey = strings.ToLower(p.SenderKey)
+	p.SigningKey = strings.ToLower(p.SigningKey)
+	return nil
+}
+
+// SignedMetadata возвращает подписанные метаданные payload для отправителя и чата
+func (p *EncryptedPayload) SignedMetadata(senderID, chatID int) *processor.SignedMetadata {
+	recipients := make([]string, len(p.Keys))
+	for i, k := range p.Keys {
+		recipients[i] = k.Fingerprint
+	}
+	return &processor.SignedMetadata{
+		SenderID:   senderID,
+		ChatID:     chatID,
+		MessageID:  p.MessageID,
+		Timestamp:  p.Timestamp,
+		Recipients: recipients,
+	}
+}
+
+// verifySignature проверяет, что payload подписан опубликованным ключом подписи отправителя,
+// подпись относится именно к этому чату и получателям и создана недавно
+func (s *Service) verifySignature(p *EncryptedPayload, senderID, chatID int) error {
+	if p.SigningKey == "" || p.Signature == "" || p.MessageID == "" || p.Timestamp == 0 {
+		return ErrMissingSignature
+	}
+	if len(p.MessageID) > maxPayloadMessageID {
+		return fmt.Errorf("%w: слишком длинный messageId", ErrInvalidPayload)
+	}
+	signedAt := time.UnixMilli(p.Timestamp)
+	if skew := time.Since(signedAt); skew > signatureClockSkew || skew < -signatureClockSkew {
+		return fmt.Errorf("%w: время подписи расходится с часами сервера", ErrInvalidSignature)
+	}
+
+	key, err := keys.NewDirectory(s.db).Get(p.SigningKey)
+	if errors.Is(err, keys.ErrKeyNotFound) {
+		return fmt.Errorf("%w: ключ подписи %s не найден", ErrInvalidSignature, p.SigningKey)
+	}
+	if err != nil {
+		return err
+	}
+	if key.UserID != senderID || key.RevokedAt != nil {
+		return fmt.Errorf("%w: ключ подписи %s не принадлежит отправителю", ErrInvalidSignature, p.SigningKey)
+	}
+	public, err := key.SigningKey()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(p.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: signature должна быть в base64", ErrInvalidPayload)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(p.Ciphertext)
+	if err != nil {
+		return ErrInvalidPayload
+	}
+	if err := processor.VerifyMessage(public, p.SignedMetadata(senderID, chatID), ciphertext, signature); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// checkPayloadKeys проверяет, что все ключи payload - действующие ключи участников чата,
+// а ключ отправителя принадлежит ему
+func (s *Service) checkPayloadKeys(p *EncryptedPayload, senderID int, recipients []int) error {
+	members := map[int]bool{senderID: true}
+	for _, id := range recipients {
+		members[id] = true
+	}
+
+	fingerprints := make([]string, 0, len(p.Keys)+1)
+	for _, k := range p.Keys {
+		fingerprints = append(fingerprints, k.Fingerprint)
+	}
+	if p.SenderKey != "" {
+		fingerprints = append(fingerprints, p.SenderKey)
+	}
+	owners, err := keys.Owners(s.db, fingerprints)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range p.Keys {
+		owner, ok := owners[k.Fingerprint]
+		if !ok || !members[owner] {
+			return fmt.Errorf("%w: %s", ErrUnknownRecipientKey, k.Fingerprint)
+		}
+	}
+	if p.SenderKey != "" && owners[p.SenderKey] != senderID {
+		return fmt.Errorf("%w: ключ отправителя %s", ErrUnknownRecipientKey, p.SenderKey)
+	}
+	return nil
+}
+
+// ParsePayload разбирает payload, сохраненный вместе с сообщением (LEFT JOIN message_e2e_payloads)
+func ParsePayload(stored sql.NullString) *EncryptedPayload {
+	if !stored.Valid || stored.String == "" {
+		return nil
+	}
+	var p EncryptedPayload
+	if err := json.Unmarshal([]byte(stored.String), &p); err != nil {
+		log.Printf("❌ Ошибка разбора зашифрованного сообщения: %v", err)
+		return nil
+	}
+	return &p
+}
+
+// IsE2EChat сообщает, включено ли в чате сквозное шифрование
+func (s *Service) IsE2EChat(chatID int) (bool, error) {
+	var e2e bool
+	err := s.db.QueryRow(`SELECT e2e FROM chats WHERE id = ?`, chatID).Scan(&e2e)
+	if err == sql.ErrNoRows {
+		return false, ErrChatNotFound
+	}
+	return e2e, err
+}
+
+// EnableE2E включает в чате сквозное шифрование. После этого сервер принимает в чате только
+// зашифрованные на клиенте сообщения и хранит только шифротекст. Выключить режим нельзя,
+// чтобы участник не мог незаметно для собеседников понизить защиту переписки.
+func (s *Service) EnableE2E(chatID, userID int) (bool, error) {
+	if _, err := ChatRecipients(s.db, chatID, userID); err != nil {
+		return false, err
+	}
+	result, err := s.db.Exec(`UPDATE chats SET e2e = TRUE WHERE id = ? AND e2e = FALSE`, chatID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	// Загруженные, но еще не отправленные файлы хранятся в открытом виде; прикрепить их теперь нельзя
+	if err := attachments.DiscardUnclaimed(s.db, chatID); err != nil {
+		log.Printf("⚠️ Не удалось удалить неотправленные вложения чата %d: %v", chatID, err)
+	}
+
+	log.Printf("✅ Пользователь %d включил сквозное шифрование в чате %d", userID, chatID)
+	if s.notifier != nil {
+		s.notifier.ChatUpdated(chatID)
+	}
+	return true, nil
+}
@@ -0,0 +1,177 @@
+// messages/migrate.go
+package messages
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Размер пакета при шифровании ранее сохраненных сообщений
+const migrateBatchSize = 500
+
+// EncryptExisting шифрует сообщения и историю правок, сохраненные в открытом виде
+// до появления единого сервиса. Текст, который уже расшифровывается текущим ключом
+// (сообщения старого пути записи), только помечается зашифрованным.
+// Выполняется при запуске до приема соединений и может быть прервана и повторена.
+func (s *Service) EncryptExisting(ctx context.Context) error {
+	for _, table := range []struct{ name, column string }{
+		{"messages", "message"},
+		{"message_edits", "content"},
+	} {
+		total := 0
+		for {
+			n, err := s.encryptBatch(ctx, table.name, table.column)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table.name, err)
+			}
+			if n == 0 {
+				break
+			}
+			total += n
+		}
+		if total > 0 {
+			log.Printf("✅ Зашифровано %d записей в таблице %s", total, table.name)
+		}
+	}
+	return nil
+}
+
+// encryptBatch шифрует очередной пакет незашифрованных записей таблицы
+func (s *Service) encryptBatch(ctx context.Context, table, column string) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, `+column+` FROM `+table+`
+		WHERE encrypted = FALSE
+		ORDER BY id
+		LIMIT ?
+	`, migrateBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	type record struct {
+		id      int
+		content string
+	}
+	var batch []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, r := range batch {
+		stored := r.content
+		if _, err := s.cipher.Decrypt(stored); err != nil {
+			if stored, err = s.cipher.Encrypt(r.content); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE `+table+` SET `+column+` = ?, encrypted = TRUE WHERE id = ?
+		`, stored, r.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(batch), tx.Commit()
+}
+
+// NormalizeChatRoles исправляет роли в чатах, созданных до единого сервиса, где продавцом
+// мог оказаться не владелец товара: покупатель и продавец меняются местами в chats
+// и chat_participants. Чаты, для которых уже есть чат с правильными ролями (та же пара
+// по тому же товару), пропускаются: перестановка нарушила бы уникальность uniq_chat, а
+// объединять два разговора автоматически нельзя.
+func (s *Service) NormalizeChatRoles(ctx context.Context) error {
+	var duplicates int
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM chats c
+		JOIN products p ON p.id = c.product_id
+		WHERE c.buyer_id = p.seller_id
+		AND EXISTS (SELECT 1 FROM chats d
+		            WHERE d.buyer_id = c.seller_id AND d.seller_id = c.buyer_id AND d.product_id = c.product_id)
+	`).Scan(&duplicates); err != nil {
+		return err
+	}
+	if duplicates > 0 {
+		log.Printf("⚠️ Роли не исправлены в %d чатах: для них уже есть чат с правильными ролями", duplicates)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.id, c.buyer_id, c.seller_id
+		FROM chats c
+		JOIN products p ON p.id = c.product_id
+		WHERE c.buyer_id = p.seller_id
+		AND NOT EXISTS (SELECT 1 FROM chats d
+		                WHERE d.buyer_id = c.seller_id AND d.seller_id = c.buyer_id AND d.product_id = c.product_id)
+	`)
+	if err != nil {
+		return err
+	}
+	type swap struct{ chatID, buyerID, sellerID int }
+	var swaps []swap
+	for rows.Next() {
+		var sw swap
+		if err := rows.Scan(&sw.chatID, &sw.buyerID, &sw.sellerID); err != nil {
+			rows.Close()
+			return err
+		}
+		swaps = append(swaps, sw)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	fixed := 0
+	for _, sw := range swaps {
+		if err := s.swapChatRoles(ctx, sw.chatID, sw.sellerID, sw.buyerID); err != nil {
+			log.Printf("⚠️ Не удалось исправить роли в чате %d: %v", sw.chatID, err)
+			continue
+		}
+		fixed++
+	}
+	if fixed > 0 {
+		log.Printf("✅ Исправлены роли покупателя и продавца в %d чатах", fixed)
+	}
+	return nil
+}
+
+// swapChatRoles назначает чату нового покупателя и продавца. Если такой чат успели создать
+// после проверки, UPDATE нарушит uniq_chat и транзакция откатится.
+func (s *Service) swapChatRoles(ctx context.Context, chatID, buyerID, sellerID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE chats SET buyer_id = ?, seller_id = ? WHERE id = ?
+	`, buyerID, sellerID, chatID); err != nil {
+		return err
+	}
+	for _, p := range []struct {
+		userID int
+		role   string
+	}{{buyerID, RoleBuyer}, {sellerID, RoleSeller}} {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE chat_participants SET role = ? WHERE chat_id = ? AND user_id = ?
+		`, p.role, chatID, p.userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
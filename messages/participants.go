@@ -0,0 +1,83 @@
+// messages/participants.go
+package messages
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Роли участников чата
+const (
+	RoleBuyer    = "buyer"     // Покупатель
+	RoleSeller   = "seller"    // Продавец
+	RoleCoSeller = "co_seller" // Дополнительный продавец (сотрудник магазина)
+	RoleSupport  = "support"   // Агент поддержки маркетплейса
+)
+
+// Execer - *sql.DB или *sql.Tx
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Querier - *sql.DB или *sql.Tx
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// InsertParticipant добавляет участника в чат. Сообщения, отправленные до его
+// присоединения, не считаются для него непрочитанными. Роль участника, который уже
+// есть в чате, не меняется: возвращается ErrAlreadyMember.
+func InsertParticipant(db Execer, chatID, userID int, role string) error {
+	result, err := db.Exec(`
+		INSERT IGNORE INTO chat_participants (chat_id, user_id, role, last_read_message_id)
+		SELECT ?, ?, ?, COALESCE(MAX(id), 0) FROM messages WHERE chat_id = ?
+	`, chatID, userID, role, chatID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAlreadyMember
+	}
+	return nil
+}
+
+// ChatRecipients возвращает участников чата, кроме userID.
+// Возвращает ErrNotParticipant, если userID не участвует в чате.
+func ChatRecipients(db Querier, chatID, userID int) ([]int, error) {
+	rows, err := db.Query(`
+		SELECT user_id FROM chat_participants
+		WHERE chat_id = ?
+		ORDER BY joined_at, user_id
+	`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []int
+	found, isMember := false, false
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		found = true
+		if id == userID {
+			isMember = true
+			continue
+		}
+		recipients = append(recipients, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("чат %d: %w", chatID, ErrChatNotFound)
+	}
+	if !isMember {
+		return nil, ErrNotParticipant
+	}
+	return recipients, nil
+}
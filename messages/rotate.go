@@ -0,0 +1,106 @@
+// messages/rotate.go
+package messages
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Пауза между пакетами перешифрования, чтобы фоновая задача не мешала основной нагрузке
+const rotatePause = 200 * time.Millisecond
+
+// RotateKeys перешифровывает основным ключом сообщения и историю правок, зашифрованные
+// прежними ключами связки. Выполняется в фоне без остановки сервера: пока задача идет,
+// старые записи читаются прежними ключами, а запись обновляется, только если ее
+// не изменили параллельно (например, правкой сообщения).
+func (s *Service) RotateKeys(ctx context.Context) error {
+	prefix := s.cipher.PrimaryPrefix()
+	for _, table := range []struct{ name, column string }{
+		{"messages", "message"},
+		{"message_edits", "content"},
+	} {
+		cursor, total, failed := 0, 0, 0
+		for {
+			n, skipped, next, err := s.rotateBatch(ctx, table.name, table.column, prefix, cursor)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table.name, err)
+			}
+			if next == cursor {
+				break
+			}
+			total += n
+			failed += skipped
+			cursor = next
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(rotatePause):
+			}
+		}
+		if total > 0 {
+			log.Printf("✅ Перешифровано ключом %s: %d записей в таблице %s", s.cipher.PrimaryKeyID(), total, table.name)
+		}
+		if failed > 0 {
+			log.Printf("⚠️ Не удалось перешифровать %d записей в таблице %s", failed, table.name)
+		}
+	}
+	return nil
+}
+
+// rotateBatch перешифровывает очередной пакет записей после cursor.
+// Возвращает число перешифрованных и пропущенных записей и новый курсор.
+func (s *Service) rotateBatch(ctx context.Context, table, column, prefix string, cursor int) (int, int, int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, `+column+` FROM `+table+`
+		WHERE id > ? AND encrypted = TRUE AND `+column+` <> '' AND `+column+` NOT LIKE ?
+		ORDER BY id
+		LIMIT ?
+	`, cursor, prefix+"%", migrateBatchSize)
+	if err != nil {
+		return 0, 0, cursor, err
+	}
+	type record struct {
+		id     int
+		stored string
+	}
+	var batch []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.stored); err != nil {
+			rows.Close()
+			return 0, 0, cursor, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, cursor, err
+	}
+	if len(batch) == 0 {
+		return 0, 0, cursor, nil
+	}
+
+	rotated, skipped := 0, 0
+	for _, r := range batch {
+		reencrypted, err := s.cipher.Reencrypt(r.stored)
+		if err != nil {
+			log.Printf("⚠️ Запись %d таблицы %s не перешифрована: %v", r.id, table, err)
+			skipped++
+			continue
+		}
+		// Условие на прежнее значение не дает затереть параллельную правку
+		result, err := s.db.ExecContext(ctx, `
+			UPDATE `+table+` SET `+column+` = ? WHERE id = ? AND `+column+` = ?
+		`, reencrypted, r.id, r.stored)
+		if err != nil {
+			return rotated, skipped, cursor, err
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			rotated++
+		}
+	}
+	return rotated, skipped, batch[len(batch)-1].id, nil
+}
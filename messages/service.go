@@ -0,0 +1,415 @@
+// messages/service.go
+package messages
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/LilVoxy/coursework_chat/attachments"
+	"github.com/LilVoxy/coursework_chat/dbcrypt"
+	"github.com/LilVoxy/coursework_chat/search"
+	"github.com/go-sql-driver/mysql"
+)
+
+// Текст, который показывается вместо сообщения, которое не удалось расшифровать
+const undecryptable = "[Ошибка расшифровки]"
+
+// Ошибки отправки сообщений
+var (
+	ErrInvalidMessage  = errors.New("отсутствуют обязательные поля сообщения")
+	ErrNotParticipant  = errors.New("пользователь не является участником чата")
+	ErrChatNotFound    = errors.New("чат не найден")
+	ErrProductNotFound = errors.New("товар не найден")
+	ErrNoSeller        = errors.New("ни один из собеседников не продает этот товар")
+	ErrSelfChat        = errors.New("нельзя начать чат с самим собой")
+	ErrAlreadyMember   = errors.New("пользователь уже участвует в чате")
+)
+
+// Message - сохраненное сообщение (текст расшифрован)
+type Message struct {
+	ID           int                     `json:"id"`
+	ChatID       int                     `json:"chatId"`
+	FromID       int                     `json:"fromId"`
+	ProductID    int                     `json:"productId"`
+	Content      string                  `json:"content"`
+	AttachmentID int                     `json:"attachmentId,omitempty"`
+	Attachment   *attachments.Attachment `json:"attachment,omitempty"`
+	Encrypted    *EncryptedPayload       `json:"encrypted,omitempty"`   // Сообщение, зашифрованное на клиенте
+	ClientMsgID  string                  `json:"clientMsgId,omitempty"` // ID, созданный клиентом при отправке
+	CreatedAt    time.Time               `json:"createdAt"`
+
+	// Duplicate - сообщение с этим clientMsgId уже было сохранено, повторная отправка ничего не изменила
+	Duplicate bool `json:"-"`
+}
+
+// SendRequest - запрос на отправку сообщения. Для нового диалога нужны получатель и товар,
+// для существующего (в том числе группового) чата достаточно ChatID.
+// Сообщение с вложением может быть без текста. Сообщение, зашифрованное на клиенте,
+// передается в Encrypted без текста и вложения.
+type SendRequest struct {
+	FromID       int               `json:"-"` // Отправитель определяется аутентификацией
+	ToID         int               `json:"toId"`
+	ChatID       int               `json:"chatId"`
+	ProductID    int               `json:"productId"`
+	Content      string            `json:"content"`
+	AttachmentID int               `json:"attachmentId"`
+	Encrypted    *EncryptedPayload `json:"encrypted"`
+	ClientMsgID  string            `json:"clientMsgId"` // Ключ идемпотентности: повтор с тем же ID не создает дубликат
+}
+
+// Максимальная длина clientMsgId
+const maxClientMsgIDLength = 64
+
+// idempotencyKey возвращает ключ, по которому повторная отправка распознается как дубликат.
+// Для зашифрованного сообщения без clientMsgId используется подписанный messageId.
+func (req *SendRequest) idempotencyKey() string {
+	if req.ClientMsgID != "" {
+		return req.ClientMsgID
+	}
+	if req.Encrypted != nil {
+		return req.Encrypted.MessageID
+	}
+	return ""
+}
+
+// Notifier доставляет события участникам (реализуется менеджером WebSocket)
+type Notifier interface {
+	// ChatCreated вызывается после создания чата
+	ChatCreated(chatID int)
+	// ChatUpdated вызывается после изменения настроек чата (например, включения сквозного шифрования)
+	ChatUpdated(chatID int)
+	// MessageSent вызывается после сохранения сообщения
+	MessageSent(msg *Message, recipients []int)
+}
+
+// Service - единая точка записи сообщений: определяет чат и роли собеседников,
+// шифрует текст для хранения, сохраняет сообщение и рассылает его участникам.
+// Через него отправляют сообщения и WebSocket, и REST API.
+type Service struct {
+	db       *sql.DB
+	cipher   *dbcrypt.Cipher
+	index    *search.Index
+	notifier Notifier
+}
+
+// NewService создает сервис сообщений. index может быть nil - тогда сообщения не индексируются.
+func NewService(db *sql.DB, cipher *dbcrypt.Cipher, index *search.Index, notifier Notifier) *Service {
+	return &Service{db: db, cipher: cipher, index: index, notifier: notifier}
+}
+
+// Encrypt шифрует текст для хранения в БД
+func (s *Service) Encrypt(plaintext string) (string, error) {
+	return s.cipher.Encrypt(plaintext)
+}
+
+// Decrypt расшифровывает текст, прочитанный из БД. Если расшифровать не удалось,
+// возвращается пометка об ошибке, чтобы одно поврежденное сообщение не ломало всю историю.
+func (s *Service) Decrypt(stored string) string {
+	plaintext, err := s.cipher.Decrypt(stored)
+	if err != nil {
+		log.Printf("❌ Ошибка расшифровки сообщения: %v", err)
+		return undecryptable
+	}
+	return plaintext
+}
+
+// ResolveChat находит чат двух пользователей по товару или создает новый.
+// Продавцом чата всегда становится владелец товара (products.seller_id), покупателем - его собеседник.
+func (s *Service) ResolveChat(fromID, toID, productID int) (int, error) {
+	if fromID <= 0 || toID <= 0 || productID <= 0 {
+		return 0, ErrInvalidMessage
+	}
+	if fromID == toID {
+		return 0, ErrSelfChat
+	}
+
+	var sellerID int
+	err := s.db.QueryRow(`SELECT seller_id FROM products WHERE id = ?`, productID).Scan(&sellerID)
+	if err == sql.ErrNoRows {
+		return 0, ErrProductNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var buyerID int
+	switch sellerID {
+	case fromID:
+		buyerID = toID
+	case toID:
+		buyerID = fromID
+	default:
+		return 0, ErrNoSeller
+	}
+
+	// Ищем существующий чат. Чаты, созданные до исправления ролей, могут хранить
+	// покупателя и продавца в обратном порядке - предпочитаем чат с правильными ролями.
+	var chatID int
+	err = s.db.QueryRow(`
+		SELECT id FROM chats
+		WHERE product_id = ? AND ((buyer_id = ? AND seller_id = ?) OR (buyer_id = ? AND seller_id = ?))
+		ORDER BY seller_id = ? DESC
+		LIMIT 1
+	`, productID, buyerID, sellerID, sellerID, buyerID, sellerID).Scan(&chatID)
+	if err == nil {
+		return chatID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// При одновременном создании второй запрос получит ID уже созданного чата
+	result, err := tx.Exec(`
+		INSERT INTO chats (buyer_id, seller_id, product_id, created_at)
+		VALUES (?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)
+	`, buyerID, sellerID, productID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	chatID = int(id)
+
+	created, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if created != 1 {
+		return chatID, nil
+	}
+
+	if err := InsertParticipant(tx, chatID, buyerID, RoleBuyer); err != nil {
+		return 0, err
+	}
+	if err := InsertParticipant(tx, chatID, sellerID, RoleSeller); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("✅ Создан новый чат ID: %d между покупателем %d и продавцом %d для товара %d",
+		chatID, buyerID, sellerID, productID)
+
+	if s.notifier != nil {
+		s.notifier.ChatCreated(chatID)
+	}
+	return chatID, nil
+}
+
+// Send сохраняет сообщение и рассылает его участникам чата.
+// Писать в чат могут только его участники.
+func (s *Service) Send(req SendRequest) (*Message, error) {
+	if req.FromID == 0 || (req.ToID == 0 && req.ChatID == 0) ||
+		(req.Content == "" && req.AttachmentID == 0 && req.Encrypted == nil) {
+		return nil, ErrInvalidMessage
+	}
+	if len(req.ClientMsgID) > maxClientMsgIDLength {
+		return nil, fmt.Errorf("%w: слишком длинный clientMsgId", ErrInvalidMessage)
+	}
+	if req.Encrypted != nil {
+		if req.Content != "" || req.AttachmentID != 0 {
+			return nil, fmt.Errorf("%w: зашифрованное сообщение передается без текста и вложения", ErrInvalidPayload)
+		}
+		if err := req.Encrypted.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Повторная отправка (например, после обрыва связи до подтверждения) возвращает уже сохраненное сообщение
+	key := req.idempotencyKey()
+	if key != "" {
+		if existing, err := s.findByClientMsgID(req.FromID, key); err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	chatID := req.ChatID
+	if chatID == 0 {
+		var err error
+		if chatID, err = s.ResolveChat(req.FromID, req.ToID, req.ProductID); err != nil {
+			return nil, err
+		}
+	}
+
+	var productID int
+	var e2e bool
+	err := s.db.QueryRow(`SELECT product_id, e2e FROM chats WHERE id = ?`, chatID).Scan(&productID, &e2e)
+	if err == sql.ErrNoRows {
+		return nil, ErrChatNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e2e && req.Encrypted == nil {
+		return nil, ErrE2ERequired
+	}
+
+	recipients, err := ChatRecipients(s.db, chatID, req.FromID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Encrypted != nil {
+		if err := s.checkPayloadKeys(req.Encrypted, req.FromID, recipients); err != nil {
+			return nil, err
+		}
+		if err := s.verifySignature(req.Encrypted, req.FromID, chatID); err != nil {
+			return nil, err
+		}
+	}
+
+	msg := &Message{
+		ChatID:       chatID,
+		FromID:       req.FromID,
+		ProductID:    productID,
+		Content:      req.Content,
+		AttachmentID: req.AttachmentID,
+		Encrypted:    req.Encrypted,
+		ClientMsgID:  key,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.save(msg); err != nil {
+		// Параллельная отправка с тем же clientMsgId успела сохранить сообщение раньше
+		if key != "" && isDuplicateEntry(err) {
+			if existing, findErr := s.findByClientMsgID(req.FromID, key); findErr == nil && existing != nil {
+				return existing, nil
+			}
+		}
+		return nil, err
+	}
+	log.Printf("✅ Сохранено сообщение ID: %d в чат ID: %d от пользователя %d", msg.ID, chatID, msg.FromID)
+
+	// Добавляем метаданные вложения для получателей
+	if msg.AttachmentID != 0 {
+		if msg.Attachment, err = attachments.Load(s.db, msg.AttachmentID); err != nil {
+			log.Printf("⚠️ Не удалось загрузить вложение %d: %v", msg.AttachmentID, err)
+		}
+	}
+
+	if s.notifier != nil {
+		s.notifier.MessageSent(msg, recipients)
+	}
+	return msg, nil
+}
+
+// save сохраняет сообщение в зашифрованном виде. Вложение прикрепляется к сообщению,
+// а текст попадает в поисковый индекс в той же транзакции: чужое, уже использованное или
+// загруженное в другой чат вложение отклоняется вместе с сообщением.
+// У сообщения, зашифрованного на клиенте, текста нет: сохраняется только payload.
+func (s *Service) save(msg *Message) error {
+	encrypted, err := s.cipher.Encrypt(msg.Content)
+	if err != nil {
+		return fmt.Errorf("шифрование сообщения: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var attachment sql.NullInt64
+	if msg.AttachmentID != 0 {
+		attachment = sql.NullInt64{Int64: int64(msg.AttachmentID), Valid: true}
+	}
+
+	var clientMsgID sql.NullString
+	if msg.ClientMsgID != "" {
+		clientMsgID = sql.NullString{String: msg.ClientMsgID, Valid: true}
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO messages (chat_id, sender_id, message, encrypted, created_at, read_status, attachment_id, client_msg_id)
+		VALUES (?, ?, ?, TRUE, ?, FALSE, ?, ?)
+	`, msg.ChatID, msg.FromID, encrypted, msg.CreatedAt, attachment, clientMsgID)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	msg.ID = int(id)
+
+	if msg.AttachmentID != 0 {
+		if err := attachments.Claim(tx, msg.AttachmentID, msg.ChatID, msg.FromID, msg.ID); err != nil {
+			return fmt.Errorf("вложение %d: %w", msg.AttachmentID, err)
+		}
+	}
+	if msg.Encrypted != nil {
+		payload, err := json.Marshal(msg.Encrypted)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO message_e2e_payloads (message_id, payload) VALUES (?, ?)
+		`, msg.ID, string(payload)); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if err := s.IndexMessage(tx, msg.ID, msg.ChatID, msg.Content); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// findByClientMsgID ищет сообщение отправителя с данным clientMsgId. Возвращает nil, если его нет.
+// Текст и вложение не загружаются: повторная отправка только подтверждается клиенту.
+func (s *Service) findByClientMsgID(senderID int, clientMsgID string) (*Message, error) {
+	msg := &Message{FromID: senderID, ClientMsgID: clientMsgID, Duplicate: true}
+	err := s.db.QueryRow(`
+		SELECT m.id, m.chat_id, c.product_id, m.created_at
+		FROM messages m
+		JOIN chats c ON c.id = m.chat_id
+		WHERE m.sender_id = ? AND m.client_msg_id = ?
+	`, senderID, clientMsgID).Scan(&msg.ID, &msg.ChatID, &msg.ProductID, &msg.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// isDuplicateEntry сообщает, что вставка нарушила уникальный индекс
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// IndexMessage заменяет текст сообщения в поисковом индексе (при сохранении и правке)
+func (s *Service) IndexMessage(db search.Execer, messageID, chatID int, content string) error {
+	if s.index == nil {
+		return nil
+	}
+	if err := s.index.ReindexMessage(db, messageID, chatID, content); err != nil {
+		return fmt.Errorf("поисковый индекс: %w", err)
+	}
+	return nil
+}
+
+// UnindexMessage удаляет сообщение из поискового индекса (при удалении для всех)
+func (s *Service) UnindexMessage(db search.Execer, messageID int) error {
+	if s.index == nil {
+		return nil
+	}
+	if err := s.index.RemoveMessage(db, messageID); err != nil {
+		return fmt.Errorf("поисковый индекс: %w", err)
+	}
+	return nil
+}
@@ -5,16 +5,42 @@ import (
 	"net/http"
 )
 
-// CORSMiddleware добавляет заголовки CORS для поддержки кросс-доменных запросов
+// CORSMiddleware добавляет заголовки CORS для поддержки кросс-доменных запросов с любого источника
 func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+	return CORS([]string{"*"})(next)
+}
+
+// CORS возвращает middleware, разрешающее запросы с перечисленных источников
+// (например, https://shop.example.com); "*" разрешает любые источники.
+// Запросам с других источников заголовок Access-Control-Allow-Origin не выставляется.
+func CORS(origins []string) func(http.Handler) http.Handler {
+	anyOrigin := false
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		if origin == "*" {
+			anyOrigin = true
 		}
-		next.ServeHTTP(w, r)
-	})
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				// Ответ зависит от источника запроса, кэши должны это учитывать
+				w.Header().Add("Vary", "Origin")
+				if origin := r.Header.Get("Origin"); allowed[origin] {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
@@ -0,0 +1,378 @@
+// migrate/migrate.go
+// Пакет migrate применяет версионированные миграции схемы к базе чата (OLTP) и аналитической
+// базе (OLAP). Миграции - SQL-файлы, встроенные в бинарный файл:
+//
+//	oltp/0003_add_chats_e2e.up.sql    применение
+//	oltp/0003_add_chats_e2e.down.sql  откат (необязателен)
+//
+// Номер версии задает порядок применения. Примененные миграции записываются в таблицу
+// schema_migrations вместе с контрольной суммой файла: если примененную миграцию изменили,
+// Up и Down возвращают ErrChecksum - изменения схемы оформляются новой миграцией.
+//
+// DDL в MySQL не транзакционен, поэтому каждая миграция должна выдерживать повторный запуск
+// после сбоя (CREATE TABLE IF NOT EXISTS и т.п.) или состоять из одной DDL-инструкции.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed oltp/*.sql olap/*.sql
+var files embed.FS
+
+// Наборы миграций
+const (
+	OLTP = "oltp" // База чата (секция database конфигурации)
+	OLAP = "olap" // Аналитическая база ETL (секция analytics)
+)
+
+const (
+	versionTable = "schema_migrations"
+	lockTimeout  = 60 // Сколько секунд ждать блокировки, пока миграции применяет другой экземпляр
+)
+
+var (
+	// ErrChecksum - примененная миграция была изменена после применения
+	ErrChecksum = errors.New("контрольная сумма примененной миграции не совпадает с файлом")
+	// ErrNoDown - у миграции нет файла отката
+	ErrNoDown = errors.New("у миграции нет файла отката")
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration - одна миграция набора
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string // Пустой, если откат не предусмотрен
+	Checksum string // SHA-256 файла применения (hex)
+}
+
+// State - состояние миграции в базе
+type State struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool // Файл изменен после применения (контрольные суммы не совпадают)
+	Missing   bool // Миграция применена, но в наборе ее нет (база новее программы)
+}
+
+// Load читает встроенный набор миграций (OLTP или OLAP), упорядоченный по версии
+func Load(set string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, set)
+	if err != nil {
+		return nil, fmt.Errorf("неизвестный набор миграций %q", set)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s/%s: ожидается имя вида 0001_name.up.sql", set, entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := files.ReadFile(path.Join(set, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("%s: у версии %d разные имена файлов (%s и %s)", set, version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			sum := sha256.Sum256(data)
+			m.Up = string(data)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("%s: у версии %d нет файла .up.sql", set, m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator применяет набор миграций к базе
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New создает Migrator для встроенного набора set (OLTP или OLAP)
+func New(db *sql.DB, set string) (*Migrator, error) {
+	migrations, err := Load(set)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up применяет миграции, которые еще не применены, до версии target включительно
+// (0 - до последней). Возвращает число примененных миграций.
+func (m *Migrator) Up(ctx context.Context, target int) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		states, err := m.states(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			if state.Missing {
+				log.Printf("⚠️ Миграция %d применена, но программе неизвестна: схема базы новее программы", state.Version)
+			}
+		}
+		for _, state := range states {
+			if state.Applied || state.Missing || (target > 0 && state.Version > target) {
+				continue
+			}
+			if err := m.apply(ctx, conn, state.Migration); err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down откатывает steps последних примененных миграций. Возвращает число откаченных миграций.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		states, err := m.states(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(states) - 1; i >= 0 && reverted < steps; i-- {
+			state := states[i]
+			if !state.Applied {
+				continue
+			}
+			if state.Missing {
+				return fmt.Errorf("миграция %d программе неизвестна, откатить ее нельзя", state.Version)
+			}
+			if err := m.revert(ctx, conn, state.Migration); err != nil {
+				return err
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status возвращает состояние всех миграций набора и примененных миграций, которых нет в наборе
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := ensureVersionTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	return m.readStates(ctx, conn)
+}
+
+// Version возвращает последнюю примененную версию (0, если миграции не применялись)
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	states, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for _, state := range states {
+		if state.Applied && state.Version > version {
+			version = state.Version
+		}
+	}
+	return version, nil
+}
+
+// locked выполняет fn на отдельном соединении под именованной блокировкой MySQL, чтобы
+// экземпляры сервера, запущенные одновременно, не применяли миграции параллельно
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(CONCAT(DATABASE(), '.', ?), ?)", versionTable, lockTimeout).Scan(&acquired); err != nil {
+		return fmt.Errorf("ошибка получения блокировки миграций: %w", err)
+	}
+	if acquired.Int64 != 1 {
+		return fmt.Errorf("не удалось получить блокировку миграций за %d с: миграции применяет другой процесс", lockTimeout)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(CONCAT(DATABASE(), '.', ?))", versionTable)
+
+	if err := ensureVersionTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// states читает состояние миграций и проверяет контрольные суммы примененных
+func (m *Migrator) states(ctx context.Context, conn *sql.Conn) ([]State, error) {
+	states, err := m.readStates(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		if state.Modified {
+			return nil, fmt.Errorf("миграция %d_%s: %w", state.Version, state.Name, ErrChecksum)
+		}
+	}
+	return states, nil
+}
+
+func (m *Migrator) readStates(ctx context.Context, conn *sql.Conn) ([]State, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM "+versionTable)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения %s: %w", versionTable, err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]State)
+	for rows.Next() {
+		var state State
+		var appliedAt interface{}
+		if err := rows.Scan(&state.Version, &state.Name, &state.Checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		state.Applied = true
+		// Без parseTime в строке подключения драйвер возвращает время строкой
+		switch v := appliedAt.(type) {
+		case time.Time:
+			state.AppliedAt = v
+		case []byte:
+			state.AppliedAt, _ = time.Parse(time.DateTime, string(v))
+		}
+		applied[state.Version] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make([]State, 0, len(m.migrations)+len(applied))
+	for _, migration := range m.migrations {
+		state := State{Migration: migration}
+		if record, ok := applied[migration.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = record.AppliedAt
+			state.Modified = record.Checksum != migration.Checksum
+			delete(applied, migration.Version)
+		}
+		states = append(states, state)
+	}
+	for _, record := range applied {
+		record.Missing = true
+		states = append(states, record)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	start := time.Now()
+	if err := execScript(ctx, conn, migration.Up); err != nil {
+		return fmt.Errorf("миграция %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := conn.ExecContext(ctx,
+		"INSERT INTO "+versionTable+" (version, name, checksum) VALUES (?, ?, ?)",
+		migration.Version, migration.Name, migration.Checksum,
+	); err != nil {
+		return fmt.Errorf("миграция %d_%s применена, но не записана в %s: %w", migration.Version, migration.Name, versionTable, err)
+	}
+	log.Printf("✅ Применена миграция %d_%s (%v)", migration.Version, migration.Name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("миграция %d_%s: %w", migration.Version, migration.Name, ErrNoDown)
+	}
+	if err := execScript(ctx, conn, migration.Down); err != nil {
+		return fmt.Errorf("откат миграции %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := conn.ExecContext(ctx, "DELETE FROM "+versionTable+" WHERE version = ?", migration.Version); err != nil {
+		return fmt.Errorf("миграция %d_%s откачена, но осталась в %s: %w", migration.Version, migration.Name, versionTable, err)
+	}
+	log.Printf("✅ Откачена миграция %d_%s", migration.Version, migration.Name)
+	return nil
+}
+
+func ensureVersionTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS `+versionTable+` (
+			version INT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы %s: %w", versionTable, err)
+	}
+	return nil
+}
+
+// execScript выполняет инструкции файла миграции по одной: драйвер MySQL по умолчанию
+// не принимает несколько инструкций в одном запросе
+func execScript(ctx context.Context, conn *sql.Conn, script string) error {
+	for _, statement := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("%w\n%s", err, statement)
+		}
+	}
+	return nil
+}
+
+// splitStatements делит скрипт на инструкции по ";" в конце строки и отбрасывает строки
+// комментариев "--". Точка с запятой внутри строки или комментария в конце строки не допускается.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
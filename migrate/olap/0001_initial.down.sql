@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS communication_weights;
+DROP TABLE IF EXISTS user_influence_rank;
+DROP TABLE IF EXISTS activity_trend_predictions;
+DROP TABLE IF EXISTS etl_run_log;
+DROP TABLE IF EXISTS daily_activity_facts;
+DROP TABLE IF EXISTS chat_facts;
+DROP TABLE IF EXISTS message_facts;
+DROP TABLE IF EXISTS user_dimension;
+DROP TABLE IF EXISTS time_dimension;
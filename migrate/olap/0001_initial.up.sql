@@ -0,0 +1,147 @@
+-- Начальная схема аналитической базы (звезда: измерения, факты и результаты алгоритмов).
+-- Таблицы создаются в базе из секции analytics конфигурации; запросы ETL обращаются к chat_analytics.
+
+-- Измерения
+-- Временное измерение: одна запись на дату
+CREATE TABLE IF NOT EXISTS time_dimension (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    full_date DATE,                   -- Полная дата (например, 2023-05-15)
+    year SMALLINT,
+    quarter TINYINT,                  -- Квартал (1-4)
+    month TINYINT,                    -- Месяц (1-12)
+    month_name VARCHAR(10),           -- January, February...
+    week_of_year TINYINT,             -- Неделя года (1-53)
+    day_of_month TINYINT,             -- День месяца (1-31)
+    day_of_week TINYINT,              -- День недели (1-7, где 1 - воскресенье)
+    day_name VARCHAR(10),             -- Monday, Tuesday...
+    is_weekend BOOLEAN,
+    UNIQUE KEY uniq_full_date (full_date)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Измерение пользователей
+CREATE TABLE IF NOT EXISTS user_dimension (
+    id INT PRIMARY KEY,                          -- ID пользователя из базы чата
+    registration_date DATE,
+    days_active INT,                             -- Дней с момента регистрации
+    total_chats INT,
+    total_messages INT,
+    avg_response_time_minutes FLOAT,
+    activity_level ENUM('high', 'medium', 'low'),
+    last_updated TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Факты
+-- Факты сообщений
+CREATE TABLE IF NOT EXISTS message_facts (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    message_id INT UNIQUE,                       -- ID сообщения из базы чата
+    time_id INT,
+    sender_id INT,
+    recipient_id INT,
+    chat_id INT,
+    message_length INT,                          -- Длина сообщения в символах
+    response_time_minutes FLOAT,
+    is_first_in_chat BOOLEAN,
+    FOREIGN KEY (time_id) REFERENCES time_dimension(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Факты чатов
+CREATE TABLE IF NOT EXISTS chat_facts (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    chat_id INT UNIQUE,                          -- ID чата из базы чата
+    start_time_id INT,                           -- Время первого сообщения
+    end_time_id INT,                             -- Время последнего сообщения
+    buyer_id INT,
+    seller_id INT,
+    total_messages INT,
+    buyer_messages INT,
+    seller_messages INT,
+    avg_message_length FLOAT,
+    avg_response_time_minutes FLOAT,
+    chat_duration_hours FLOAT,
+    FOREIGN KEY (start_time_id) REFERENCES time_dimension(id),
+    FOREIGN KEY (end_time_id) REFERENCES time_dimension(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Факты ежедневной активности: одна запись на дату (ETL обновляет ее при повторном запуске)
+CREATE TABLE IF NOT EXISTS daily_activity_facts (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    date_id INT,
+    total_messages INT,
+    total_new_chats INT,
+    active_users INT,
+    new_users INT,
+    avg_messages_per_chat FLOAT,
+    avg_response_time_minutes FLOAT,
+    peak_hour TINYINT,                           -- Час пиковой активности
+    peak_hour_messages INT,
+    UNIQUE KEY uniq_date (date_id),
+    FOREIGN KEY (date_id) REFERENCES time_dimension(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Журнал запусков ETL (последнее обработанное сообщение - точка продолжения инкрементальной загрузки)
+CREATE TABLE IF NOT EXISTS etl_run_log (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    start_time TIMESTAMP NOT NULL,
+    end_time TIMESTAMP NULL,
+    status ENUM('success', 'failed', 'in_progress') NOT NULL DEFAULT 'in_progress',
+    users_processed INT DEFAULT 0,
+    chats_processed INT DEFAULT 0,
+    messages_processed INT DEFAULT 0,
+    last_processed_message_id INT DEFAULT 0,
+    error_message TEXT,
+    execution_time_seconds FLOAT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Прогнозы активности (линейная регрессия)
+CREATE TABLE IF NOT EXISTS activity_trend_predictions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    period_start DATE NOT NULL,                  -- Начало анализируемого периода
+    period_end DATE NOT NULL,                    -- Конец анализируемого периода
+    a DOUBLE NOT NULL,                           -- Коэффициент наклона (slope)
+    b DOUBLE NOT NULL,                           -- Сдвиг (intercept)
+    r DOUBLE NOT NULL,                           -- Коэффициент корреляции Пирсона
+    r2 DOUBLE NOT NULL,                          -- Коэффициент детерминации (R^2)
+    forecast_date DATE NOT NULL,
+    forecast_value DOUBLE NOT NULL,
+    ci_lower DOUBLE NOT NULL,                    -- Границы доверительного интервала
+    ci_upper DOUBLE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_forecast_date (forecast_date),
+    INDEX idx_period (period_start, period_end)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- ChatRank (модификация PageRank): ранги влиятельности пользователей
+CREATE TABLE IF NOT EXISTS user_influence_rank (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    chat_rank DOUBLE NOT NULL,                   -- Итоговый ранг пользователя
+    rank_percentile DOUBLE NOT NULL,             -- Процентиль ранга (например, 0.95 для топ-5%)
+    category ENUM('high', 'medium', 'low') NOT NULL,
+    calculation_date DATE NOT NULL,
+    iteration_count INT NOT NULL,                -- Количество итераций до сходимости
+    convergence_delta DOUBLE NOT NULL,           -- Наибольшее изменение ранга на последней итерации
+    FOREIGN KEY (user_id) REFERENCES user_dimension(id),
+    UNIQUE KEY uniq_user (user_id),
+    INDEX idx_date (calculation_date),
+    INDEX idx_rank (chat_rank)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- ChatRank: веса коммуникационных связей между пользователями
+CREATE TABLE IF NOT EXISTS communication_weights (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    sender_id INT NOT NULL,
+    recipient_id INT NOT NULL,
+    weight DOUBLE NOT NULL,                      -- Итоговый вес связи
+    time_factor DOUBLE NOT NULL,                 -- Скорость ответа
+    response_factor DOUBLE NOT NULL,             -- Частота ответов
+    length_factor DOUBLE NOT NULL,               -- Длина сообщений
+    continuation_factor DOUBLE NOT NULL,         -- Количество сообщений
+    calculation_date DATE NOT NULL,
+    FOREIGN KEY (sender_id) REFERENCES user_dimension(id),
+    FOREIGN KEY (recipient_id) REFERENCES user_dimension(id),
+    UNIQUE KEY uniq_pair_date (sender_id, recipient_id, calculation_date),
+    INDEX idx_sender (sender_id),
+    INDEX idx_recipient (recipient_id),
+    INDEX idx_date (calculation_date)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
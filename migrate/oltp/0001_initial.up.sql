@@ -0,0 +1,46 @@
+-- Начальная схема базы чата - та, которую создавали версии сервера до появления миграций
+-- (database/schema.sql и createTablesIfNotExist). На такой базе таблицы уже есть и IF NOT EXISTS
+-- их пропускает; поля, индексы и таблицы, появившиеся позже, добавляют следующие миграции.
+-- Файла отката нет: откат удалил бы чаты и сообщения.
+
+-- Пользователи и товары ведет маркетплейс; таблицы создаются для новой установки
+CREATE TABLE IF NOT EXISTS users (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    username VARCHAR(100) NOT NULL,
+    avatar_path VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_email (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS products (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    price DECIMAL(10, 2) NOT NULL,
+    seller_id INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (seller_id) REFERENCES users(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Чаты покупателя и продавца по товару
+CREATE TABLE IF NOT EXISTS chats (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    buyer_id INT NOT NULL,
+    seller_id INT NOT NULL,
+    product_id INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_participants (buyer_id, seller_id, product_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Сообщения. Последнее сообщение чата для списка чатов выбирается отсюда же.
+CREATE TABLE IF NOT EXISTS messages (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    chat_id INT NOT NULL,
+    sender_id INT NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    read_status BOOLEAN DEFAULT FALSE,
+    FOREIGN KEY (chat_id) REFERENCES chats(id),
+    INDEX idx_chat_id (chat_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
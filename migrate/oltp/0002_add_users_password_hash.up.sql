@@ -0,0 +1,2 @@
+-- Хеш пароля (pbkdf2-sha256) для получения токена через /api/auth
+ALTER TABLE users ADD COLUMN password_hash VARCHAR(255) NULL DEFAULT NULL;
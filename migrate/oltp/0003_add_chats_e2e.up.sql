@@ -0,0 +1,2 @@
+-- Сквозное шифрование: в чате принимаются только сообщения, зашифрованные на клиенте
+ALTER TABLE chats ADD COLUMN e2e BOOLEAN NOT NULL DEFAULT FALSE;
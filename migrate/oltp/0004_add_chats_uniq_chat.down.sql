@@ -0,0 +1 @@
+ALTER TABLE chats DROP INDEX uniq_chat;
@@ -0,0 +1,3 @@
+-- Один чат на пару покупатель-продавец по товару (в первых версиях индекс был неуникальным).
+-- Если в базе есть повторяющиеся чаты, миграция завершится ошибкой: объедините их и запустите снова.
+ALTER TABLE chats ADD UNIQUE INDEX uniq_chat (buyer_id, seller_id, product_id);
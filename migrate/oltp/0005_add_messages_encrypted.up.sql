@@ -0,0 +1,2 @@
+-- Текст зашифрован (AES-256-GCM, "v1:<ID ключа>:base64"; старые записи шифруются при запуске сервера)
+ALTER TABLE messages ADD COLUMN encrypted BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- Время доставки сообщения получателю
+ALTER TABLE messages ADD COLUMN delivered_at TIMESTAMP NULL DEFAULT NULL;
@@ -0,0 +1,2 @@
+-- Время прочтения сообщения
+ALTER TABLE messages ADD COLUMN read_at TIMESTAMP NULL DEFAULT NULL;
@@ -0,0 +1,2 @@
+-- Время последней правки сообщения
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP NULL DEFAULT NULL;
@@ -0,0 +1,2 @@
+-- «Надгробие»: время удаления сообщения для всех
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL;
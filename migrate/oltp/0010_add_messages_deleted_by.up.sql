@@ -0,0 +1,2 @@
+-- Кто удалил сообщение для всех
+ALTER TABLE messages ADD COLUMN deleted_by INT NULL DEFAULT NULL;
@@ -0,0 +1 @@
+ALTER TABLE messages DROP COLUMN attachment_id;
@@ -0,0 +1,2 @@
+-- Вложение сообщения (таблица attachments)
+ALTER TABLE messages ADD COLUMN attachment_id INT NULL DEFAULT NULL;
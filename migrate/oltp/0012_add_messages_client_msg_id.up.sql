@@ -0,0 +1,2 @@
+-- ID, созданный клиентом: повторная отправка не создает дубликат
+ALTER TABLE messages ADD COLUMN client_msg_id VARCHAR(64) NULL DEFAULT NULL;
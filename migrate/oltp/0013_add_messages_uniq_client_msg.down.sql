@@ -0,0 +1 @@
+ALTER TABLE messages DROP INDEX uniq_client_msg;
@@ -0,0 +1,2 @@
+-- Уникальность client_msg_id в пределах отправителя
+ALTER TABLE messages ADD UNIQUE INDEX uniq_client_msg (sender_id, client_msg_id);
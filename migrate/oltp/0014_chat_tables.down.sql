@@ -0,0 +1,12 @@
+-- Удаляет таблицы, созданные миграцией, в порядке, обратном зависимостям
+DROP TABLE IF EXISTS one_time_prekeys;
+DROP TABLE IF EXISTS prekey_bundles;
+DROP TABLE IF EXISTS message_e2e_payloads;
+DROP TABLE IF EXISTS user_public_keys;
+DROP TABLE IF EXISTS search_index_state;
+DROP TABLE IF EXISTS message_search_index;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS message_hidden;
+DROP TABLE IF EXISTS message_edits;
+DROP TABLE IF EXISTS chat_participants;
+DROP TABLE IF EXISTS user_events;
@@ -0,0 +1,127 @@
+-- Таблицы, появившиеся после начальной схемы. IF NOT EXISTS позволяет повторить миграцию после сбоя.
+
+-- Журнал событий пользователей (для синхронизации после переподключения)
+CREATE TABLE IF NOT EXISTS user_events (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    chat_id INT NOT NULL,
+    message_id INT NULL,
+    event_type VARCHAR(32) NOT NULL,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_user_events (user_id, id),
+    INDEX idx_user_event_messages (user_id, event_type, message_id),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Участники чатов (покупатель, продавец, дополнительные продавцы, поддержка)
+CREATE TABLE IF NOT EXISTS chat_participants (
+    chat_id INT NOT NULL,
+    user_id INT NOT NULL,
+    role VARCHAR(16) NOT NULL,                   -- buyer, seller, co_seller, support
+    joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_read_message_id INT NOT NULL DEFAULT 0, -- Курсор прочтения участника
+    PRIMARY KEY (chat_id, user_id),
+    FOREIGN KEY (chat_id) REFERENCES chats(id),
+    INDEX idx_participant_chats (user_id, chat_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- История правок: предыдущие версии текста сообщения
+CREATE TABLE IF NOT EXISTS message_edits (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    message_id INT NOT NULL,
+    content TEXT NOT NULL,                       -- Текст до правки (зашифрован так же, как messages.message)
+    encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    edited_by INT NOT NULL,
+    edited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (message_id) REFERENCES messages(id),
+    INDEX idx_message_edits (message_id, id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Сообщения, удаленные пользователем только у себя
+CREATE TABLE IF NOT EXISTS message_hidden (
+    message_id INT NOT NULL,
+    user_id INT NOT NULL,
+    hidden_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id),
+    FOREIGN KEY (message_id) REFERENCES messages(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Вложения: метаданные файлов, содержимое хранится в BlobStore (по умолчанию локальный диск)
+CREATE TABLE IF NOT EXISTS attachments (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    chat_id INT NOT NULL,                        -- Чат, участники которого могут скачать файл
+    uploader_id INT NOT NULL,
+    message_id INT NULL DEFAULT NULL,            -- Сообщение, к которому прикреплен файл
+    storage_key VARCHAR(128) NOT NULL,
+    thumbnail_key VARCHAR(128) NULL DEFAULT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(100) NOT NULL,             -- Определяется по содержимому файла
+    size BIGINT NOT NULL,
+    width INT NULL DEFAULT NULL,
+    height INT NULL DEFAULT NULL,
+    sha256 CHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (chat_id) REFERENCES chats(id),
+    INDEX idx_attachment_message (message_id),
+    INDEX idx_attachment_cleanup (deleted_at, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Поисковый индекс: слепые токены (HMAC-SHA256, 16 байт) слов и их префиксов.
+-- Текст сообщений в индексе не хранится.
+CREATE TABLE IF NOT EXISTS message_search_index (
+    token BINARY(16) NOT NULL,
+    message_id INT NOT NULL,
+    chat_id INT NOT NULL,
+    PRIMARY KEY (token, message_id),
+    INDEX idx_search_message (message_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Состояние поискового индекса: отпечаток ключа и прогресс индексации старых сообщений
+CREATE TABLE IF NOT EXISTS search_index_state (
+    id TINYINT PRIMARY KEY,
+    key_id CHAR(16) NOT NULL,
+    backfill_cursor INT NOT NULL DEFAULT 0
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Каталог публичных ключей устройств (приватные ключи хранятся только на устройствах)
+CREATE TABLE IF NOT EXISTS user_public_keys (
+    fingerprint CHAR(64) PRIMARY KEY,            -- SHA-256 от ключа в DER (hex)
+    user_id INT NOT NULL,
+    algorithm VARCHAR(32) NOT NULL,              -- X25519, Ed25519 или RSA-OAEP-256 (устаревший режим)
+    public_key TEXT NOT NULL,                    -- PEM (PKIX)
+    label VARCHAR(64) NOT NULL DEFAULT '',       -- Название устройства
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP NULL DEFAULT NULL,
+    INDEX idx_user_keys (user_id, revoked_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Сообщения, зашифрованные на клиенте: сервер хранит только шифротекст и завернутые ключи
+-- (messages.message у таких сообщений пустой)
+CREATE TABLE IF NOT EXISTS message_e2e_payloads (
+    message_id INT PRIMARY KEY,
+    payload MEDIUMTEXT NOT NULL,                 -- JSON: alg, ciphertext, senderKey, keys[{fingerprint, encryptedKey}]
+    FOREIGN KEY (message_id) REFERENCES messages(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Подписанные предварительные ключи устройств для установки сессий X3DH
+CREATE TABLE IF NOT EXISTS prekey_bundles (
+    fingerprint CHAR(64) PRIMARY KEY,            -- Ключ устройства X25519 из user_public_keys
+    user_id INT NOT NULL,
+    signing_key VARBINARY(32) NOT NULL,          -- Ed25519, которым подписан предварительный ключ
+    signed_prekey_id INT UNSIGNED NOT NULL,
+    signed_prekey VARBINARY(32) NOT NULL,
+    signed_prekey_signature VARBINARY(64) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (fingerprint) REFERENCES user_public_keys(fingerprint)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Одноразовые предварительные ключи: каждый выдается одному инициатору и сразу удаляется
+CREATE TABLE IF NOT EXISTS one_time_prekeys (
+    fingerprint CHAR(64) NOT NULL,
+    prekey_id INT UNSIGNED NOT NULL,
+    public_key VARBINARY(32) NOT NULL,
+    PRIMARY KEY (fingerprint, prekey_id),
+    FOREIGN KEY (fingerprint) REFERENCES user_public_keys(fingerprint)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1 @@
+-- Перенесенные участники не удаляются: после миграции их уже не отличить от добавленных сервером.
@@ -0,0 +1,15 @@
+-- Переносит покупателя и продавца чатов, созданных до появления chat_participants.
+-- Курсор прочтения выставляется по последнему прочитанному сообщению собеседника.
+INSERT IGNORE INTO chat_participants (chat_id, user_id, role, joined_at, last_read_message_id)
+SELECT c.id, c.buyer_id, 'buyer', c.created_at,
+       COALESCE((SELECT MAX(m.id) FROM messages m
+                 WHERE m.chat_id = c.id AND m.sender_id != c.buyer_id AND m.read_status = TRUE), 0)
+FROM chats c
+WHERE NOT EXISTS (SELECT 1 FROM chat_participants p WHERE p.chat_id = c.id AND p.user_id = c.buyer_id);
+
+INSERT IGNORE INTO chat_participants (chat_id, user_id, role, joined_at, last_read_message_id)
+SELECT c.id, c.seller_id, 'seller', c.created_at,
+       COALESCE((SELECT MAX(m.id) FROM messages m
+                 WHERE m.chat_id = c.id AND m.sender_id != c.seller_id AND m.read_status = TRUE), 0)
+FROM chats c
+WHERE NOT EXISTS (SELECT 1 FROM chat_participants p WHERE p.chat_id = c.id AND p.user_id = c.seller_id);
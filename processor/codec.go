@@ -0,0 +1,231 @@
+// processor/codec.go
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Идентификаторы кодеков в формате сообщения (первый байт сжатых данных)
+const (
+	CodecIDNone   byte = 0
+	CodecIDSnappy byte = 1
+	CodecIDZstd   byte = 2
+)
+
+// Ошибки кодеков
+var (
+	ErrUnknownCodec  = errors.New("неизвестный кодек сжатия")
+	ErrEmptyFrame    = errors.New("пустые сжатые данные")
+	ErrFrameTooLarge = errors.New("распакованные данные превышают допустимый размер")
+)
+
+// Максимальный размер распакованного сообщения для всех кодеков (защита от «бомб» сжатия)
+const maxDecodedSize = 16 << 20
+
+// Codec - алгоритм сжатия. ID записывается в сообщение, поэтому получатель
+// декодирует его независимо от того, какой кодек выбрал отправитель.
+type Codec interface {
+	ID() byte
+	Name() string
+	Encode(src []byte) ([]byte, error)
+	Decode(src []byte) ([]byte, error)
+}
+
+// Реестр кодеков
+var (
+	codecsMu     sync.RWMutex
+	codecsByID   = map[byte]Codec{}
+	codecsByName = map[string]Codec{}
+	codecOrder   []string
+)
+
+// RegisterCodec добавляет кодек в реестр. Порядок регистрации - порядок предпочтения при согласовании.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, exists := codecsByName[c.Name()]; !exists {
+		codecOrder = append(codecOrder, c.Name())
+	}
+	codecsByID[c.ID()] = c
+	codecsByName[c.Name()] = c
+}
+
+// CodecByID возвращает кодек по идентификатору из сообщения
+func CodecByID(id byte) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecsByID[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownCodec, id)
+	}
+	return c, nil
+}
+
+// CodecByName возвращает кодек по имени
+func CodecByName(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecsByName[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, name)
+	}
+	return c, nil
+}
+
+// Codecs возвращает зарегистрированные кодеки в порядке предпочтения
+func Codecs() []Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	list := make([]Codec, 0, len(codecOrder))
+	for _, name := range codecOrder {
+		list = append(list, codecsByName[name])
+	}
+	return list
+}
+
+// CodecsByName возвращает кодеки с именами names (в порядке предпочтения).
+// Пустой список - все зарегистрированные кодеки.
+func CodecsByName(names []string) ([]Codec, error) {
+	if len(names) == 0 {
+		return Codecs(), nil
+	}
+	list := make([]Codec, 0, len(names))
+	for _, name := range names {
+		c, err := CodecByName(name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, nil
+}
+
+// CodecNames возвращает имена кодеков
+func CodecNames(codecs []Codec) []string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+// NegotiateCodecs выбирает из кодеков supported (в их порядке предпочтения) те, что названы в offered.
+// Кодек none поддерживается всегда, поэтому результат не бывает пустым.
+func NegotiateCodecs(offered []string, supported []Codec) []Codec {
+	accepted := make(map[string]bool, len(offered))
+	for _, name := range offered {
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	var result []Codec
+	hasNone := false
+	for _, c := range supported {
+		if accepted[c.Name()] || c.ID() == CodecIDNone {
+			result = append(result, c)
+			hasNone = hasNone || c.ID() == CodecIDNone
+		}
+	}
+	if !hasNone {
+		result = append(result, NoneCodec{})
+	}
+	return result
+}
+
+// EncodeFrame сжимает данные кодеком и добавляет его идентификатор
+func EncodeFrame(c Codec, data []byte) ([]byte, error) {
+	encoded, err := c.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{c.ID()}, encoded...), nil
+}
+
+// DecodeFrame распаковывает данные кодеком, указанным в первом байте
+func DecodeFrame(frame []byte) ([]byte, error) {
+	if len(frame) == 0 {
+		return nil, ErrEmptyFrame
+	}
+	c, err := CodecByID(frame[0])
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(frame[1:])
+}
+
+// NoneCodec передает данные без сжатия (короткие и уже сжатые сообщения)
+type NoneCodec struct{}
+
+func (NoneCodec) ID() byte                          { return CodecIDNone }
+func (NoneCodec) Name() string                      { return "none" }
+func (NoneCodec) Encode(src []byte) ([]byte, error) { return append([]byte{}, src...), nil }
+func (NoneCodec) Decode(src []byte) ([]byte, error) { return append([]byte{}, src...), nil }
+
+// SnappyCodec - быстрое сжатие с умеренной степенью (сообщения среднего размера)
+type SnappyCodec struct{}
+
+func (SnappyCodec) ID() byte                          { return CodecIDSnappy }
+func (SnappyCodec) Name() string                      { return "snappy" }
+func (SnappyCodec) Encode(src []byte) ([]byte, error) { return CompressMessage(src), nil }
+
+// Decode проверяет размер, записанный в заголовке snappy, до выделения памяти под результат
+func (SnappyCodec) Decode(src []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxDecodedSize {
+		return nil, fmt.Errorf("%w: %d байт", ErrFrameTooLarge, n)
+	}
+	return DecompressMessage(src)
+}
+
+// ZstdCodec - более сильное сжатие для длинных сообщений
+type ZstdCodec struct{}
+
+// Кодировщик и декодировщик zstd потокобезопасны для EncodeAll/DecodeAll и создаются один раз
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func zstdCoders() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault)); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecodedSize))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+func (ZstdCodec) ID() byte     { return CodecIDZstd }
+func (ZstdCodec) Name() string { return "zstd" }
+
+func (ZstdCodec) Encode(src []byte) ([]byte, error) {
+	enc, _, err := zstdCoders()
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncodeAll(src, nil), nil
+}
+
+func (ZstdCodec) Decode(src []byte) ([]byte, error) {
+	_, dec, err := zstdCoders()
+	if err != nil {
+		return nil, err
+	}
+	return dec.DecodeAll(src, nil)
+}
+
+func init() {
+	RegisterCodec(ZstdCodec{})
+	RegisterCodec(SnappyCodec{})
+	RegisterCodec(NoneCodec{})
+}
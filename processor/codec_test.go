@@ -0,0 +1,71 @@
+// processor/codec_test.go
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// Сообщения разного размера проходят сжатие и распаковку; выбранный кодек записан первым байтом
+func TestCompressStageRoundTrip(t *testing.T) {
+	stage := NewCompressStage(nil)
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		codec byte
+	}{
+		{"короткое", []byte("привет"), CodecIDNone},
+		{"среднее", bytes.Repeat([]byte("сообщение "), 50), CodecIDSnappy},
+		{"длинное", bytes.Repeat([]byte("длинное сообщение "), 500), CodecIDZstd},
+		{"уже сжатое", append([]byte{0x1f, 0x8b}, bytes.Repeat([]byte{7}, 500)...), CodecIDNone},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Packet{Data: tc.data}
+			if err := stage.Outbound(p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Data[0] != tc.codec {
+				t.Errorf("кодек %d, ожидался %d", p.Data[0], tc.codec)
+			}
+			if err := stage.Inbound(p); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(p.Data, tc.data) {
+				t.Error("распакованные данные не совпадают с исходными")
+			}
+		})
+	}
+}
+
+// Данные, сжатые snappy до появления идентификатора кодека, распаковываются при LegacySnappy
+func TestCompressStageLegacySnappy(t *testing.T) {
+	legacy := &CompressStage{LegacySnappy: true}
+	for n := 0; n <= 300; n++ {
+		data := bytes.Repeat([]byte{'a' + byte(n%26)}, n)
+		p := &Packet{Data: CompressMessage(data)}
+		if err := legacy.Inbound(p); err != nil {
+			t.Fatalf("длина %d: %v", n, err)
+		}
+		if !bytes.Equal(p.Data, data) {
+			t.Fatalf("длина %d: распакованные данные не совпадают с исходными", n)
+		}
+	}
+
+	if err := NewCompressStage(nil).Inbound(&Packet{Data: CompressMessage(bytes.Repeat([]byte("x"), 200))}); err == nil {
+		t.Error("без LegacySnappy данные старого формата не должны приниматься")
+	}
+}
+
+// Snappy не выделяет память под результат больше допустимого размера
+func TestSnappyDecodedLenLimit(t *testing.T) {
+	frame := binary.AppendUvarint(nil, maxDecodedSize+1)
+	frame = append(frame, 0)
+	if _, err := (SnappyCodec{}).Decode(frame); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("ожидалась ErrFrameTooLarge, получено %v", err)
+	}
+	if _, err := DecodeFrame(append([]byte{CodecIDSnappy}, frame...)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("DecodeFrame: ожидалась ErrFrameTooLarge, получено %v", err)
+	}
+}
@@ -58,3 +58,8 @@ func DecryptMessage(encryptedAESKey, encryptedMessage []byte, recipientPrivateKe
 
 	return plaintext, nil
 }
+
+// OpenContent расшифровывает текст сообщения, зашифрованный SealContent
+func OpenContent(contentKey, ciphertext []byte) ([]byte, error) {
+	return aesGCMDecrypt(contentKey, ciphertext)
+}
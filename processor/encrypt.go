@@ -40,7 +40,8 @@ func rsaEncrypt(pub *rsa.PublicKey, data []byte) ([]byte, error) {
 	return encrypted, nil
 }
 
-// EncryptMessage выполняет гибридное шифрование:
+// EncryptMessage выполняет гибридное шифрование (устаревший режим AlgLegacyRSA,
+// без прямой секретности - для новых сессий используйте InitiateSession):
 // 1. Генерирует случайный AES-ключ (например, 32 байта для AES-256).
 // 2. Шифрует исходное сообщение с использованием AES-GCM.
 // 3. Шифрует AES-ключ с использованием RSA-OAEP и публичного ключа получателя.
@@ -63,3 +64,18 @@ func EncryptMessage(plaintext []byte, recipientPublicKey *rsa.PublicKey) (encryp
 
 	return encryptedAESKey, encryptedMessage, nil
 }
+
+// SealContent шифрует текст сообщения одноразовым AES-ключом (см. EncryptedPayload на сервере).
+// Ключ затем заворачивается для каждого устройства получателей: сессией Double Ratchet
+// (Session.Encrypt, алгоритм AlgRatchet) или, в устаревшем режиме, ключом RSA (AlgLegacyRSA).
+func SealContent(plaintext []byte) (contentKey, ciphertext []byte, err error) {
+	contentKey, err = GenerateRandomAESKey(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err = aesGCMEncrypt(contentKey, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return contentKey, ciphertext, nil
+}
@@ -0,0 +1,558 @@
+// processor/keystore.go
+package processor
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Параметры scrypt для ключа из парольной фразы (около 100 мс на современном процессоре)
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	scryptSalt   = 16
+)
+
+// Типы PEM-блоков
+const (
+	pemPrivateKey          = "PRIVATE KEY"                  // PKCS#8 без шифрования
+	pemEncryptedPrivateKey = "MYCHAT ENCRYPTED PRIVATE KEY" // PKCS#8, зашифрованный ключом из парольной фразы
+)
+
+// Ошибки хранилища ключей
+var (
+	ErrKeyNotFound      = errors.New("ключ не найден в хранилище")
+	ErrKeyRevoked       = errors.New("ключ отозван")
+	ErrKeyExists        = errors.New("ключ с таким ID уже есть в хранилище")
+	ErrWrongPassphrase  = errors.New("неверная парольная фраза")
+	ErrUnsupportedKey   = errors.New("неподдерживаемый тип ключа: ожидается RSA, X25519 или Ed25519")
+	ErrInvalidKeyID     = errors.New("недопустимый ID ключа")
+	ErrKeyStoreClosed   = errors.New("хранилище ключей закрыто")
+	ErrInvalidKeyFormat = errors.New("некорректный формат ключа")
+)
+
+// KeyInfo - сведения о ключе без секретной части
+type KeyInfo struct {
+	ID        string     `json:"id"`
+	Type      string     `json:"type"` // RSA, X25519 или Ed25519
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// KeyStore - хранилище приватных ключей устройства. Реализации хранят ключи зашифрованными
+// и безопасны для одновременного использования из нескольких горутин.
+type KeyStore interface {
+	// Put сохраняет новый ключ; существующий или отозванный ID повторно не используется
+	Put(id string, key crypto.PrivateKey) error
+	// Get возвращает ключ; для отозванного ключа - ErrKeyRevoked
+	Get(id string) (crypto.PrivateKey, error)
+	// List возвращает сведения обо всех ключах, включая отозванные
+	List() ([]KeyInfo, error)
+	// Revoke отзывает ключ: секретная часть удаляется, запись остается, чтобы ID не был использован снова
+	Revoke(id string) error
+}
+
+// keyType возвращает тип ключа для KeyInfo
+func keyType(key crypto.PrivateKey) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return "RSA", nil
+	case ed25519.PrivateKey:
+		return "Ed25519", nil
+	case *ecdh.PrivateKey:
+		if k.Curve() == ecdh.X25519() {
+			return "X25519", nil
+		}
+	}
+	return "", ErrUnsupportedKey
+}
+
+// MarshalPKCS8PEM кодирует приватный ключ в PEM (PKCS#8) без шифрования
+func MarshalPKCS8PEM(key crypto.PrivateKey) ([]byte, error) {
+	if _, err := keyType(key); err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: der}), nil
+}
+
+// ParsePKCS8PEM разбирает приватный ключ из PEM (PKCS#8) без шифрования
+func ParsePKCS8PEM(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemPrivateKey {
+		return nil, ErrInvalidKeyFormat
+	}
+	return parsePKCS8(block.Bytes)
+}
+
+func parsePKCS8(der []byte) (crypto.PrivateKey, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyFormat, err)
+	}
+	if _, err := keyType(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// passphraseKey выводит ключ AES-256 из парольной фразы (scrypt)
+func passphraseKey(passphrase, salt []byte, n, r, p int) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, n, r, p, scryptKeyLen)
+}
+
+// sealAEAD и openAEAD шифруют данные AES-256-GCM с дополнительными данными (nonce в начале)
+func sealAEAD(key, plaintext, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func openAEAD(key, ciphertext, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrInvalidKeyFormat
+	}
+	nonce, ct := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ct, ad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptPKCS8PEM экспортирует ключ в PEM, зашифрованный ключом из парольной фразы
+// (scrypt + AES-256-GCM). Параметры scrypt записываются в заголовки блока.
+func EncryptPKCS8PEM(key crypto.PrivateKey, passphrase []byte) ([]byte, error) {
+	if _, err := keyType(key); err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, scryptSalt)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	kek, err := passphraseKey(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealAEAD(kek, der, []byte(pemEncryptedPrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type: pemEncryptedPrivateKey,
+		Headers: map[string]string{
+			"KDF":  fmt.Sprintf("scrypt,N=%d,r=%d,p=%d", scryptN, scryptR, scryptP),
+			"Salt": base64.StdEncoding.EncodeToString(salt),
+		},
+		Bytes: sealed,
+	}), nil
+}
+
+// DecryptPKCS8PEM импортирует ключ из PEM: зашифрованного EncryptPKCS8PEM
+// или обычного PKCS#8 (тогда парольная фраза не нужна)
+func DecryptPKCS8PEM(data, passphrase []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidKeyFormat
+	}
+	if block.Type == pemPrivateKey {
+		return parsePKCS8(block.Bytes)
+	}
+	if block.Type != pemEncryptedPrivateKey {
+		return nil, ErrInvalidKeyFormat
+	}
+
+	n, r, p, err := parseScryptParams(block.Headers["KDF"])
+	if err != nil {
+		return nil, err
+	}
+	salt, err := base64.StdEncoding.DecodeString(block.Headers["Salt"])
+	if err != nil || len(salt) == 0 {
+		return nil, ErrInvalidKeyFormat
+	}
+	kek, err := passphraseKey(passphrase, salt, n, r, p)
+	if err != nil {
+		return nil, err
+	}
+	der, err := openAEAD(kek, block.Bytes, []byte(pemEncryptedPrivateKey))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return parsePKCS8(der)
+}
+
+// parseScryptParams разбирает заголовок KDF вида scrypt,N=32768,r=8,p=1
+func parseScryptParams(header string) (n, r, p int, err error) {
+	parts := strings.Split(header, ",")
+	if len(parts) != 4 || parts[0] != "scrypt" {
+		return 0, 0, 0, fmt.Errorf("%w: неизвестный KDF %q", ErrInvalidKeyFormat, header)
+	}
+	values := map[string]int{}
+	for _, part := range parts[1:] {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return 0, 0, 0, ErrInvalidKeyFormat
+		}
+		if values[name], err = strconv.Atoi(value); err != nil {
+			return 0, 0, 0, ErrInvalidKeyFormat
+		}
+	}
+	n, r, p = values["N"], values["r"], values["p"]
+	if !validScryptParams(n, r, p) {
+		return 0, 0, 0, fmt.Errorf("%w: недопустимые параметры scrypt", ErrInvalidKeyFormat)
+	}
+	return n, r, p, nil
+}
+
+// validScryptParams ограничивает параметры scrypt, чтобы чужой файл не заставил выделить гигабайты памяти
+func validScryptParams(n, r, p int) bool {
+	return n >= 2 && n <= 1<<20 && r >= 1 && r <= 32 && p >= 1 && p <= 16
+}
+
+// Допустимые ID ключей (используются как имена файлов)
+var keyIDPattern = regexp.MustCompile(`^[0-9A-Za-z._-]{1,128}$`)
+
+// keyStoreMeta - параметры хранилища: соль scrypt и контрольное значение для проверки парольной фразы
+type keyStoreMeta struct {
+	KDF   string `json:"kdf"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  []byte `json:"salt"`
+	Check []byte `json:"check"`
+}
+
+// storedKey - запись ключа в файле: PKCS#8, зашифрованный главным ключом хранилища
+type storedKey struct {
+	KeyInfo
+	Data []byte `json:"data,omitempty"` // base64(nonce || AES-256-GCM(PKCS#8)); у отозванного ключа пустое
+}
+
+// Метка контрольного значения хранилища
+var keyStoreCheck = []byte("MyChat key store")
+
+// FileKeyStore хранит ключи в каталоге на диске: по файлу на ключ (права 0600).
+// Главный ключ хранилища выводится из парольной фразы при открытии и хранится только в памяти,
+// каждый ключ зашифрован им с ID ключа в дополнительных данных (файлы нельзя подменить местами).
+type FileKeyStore struct {
+	dir    string
+	mu     sync.RWMutex
+	master []byte
+}
+
+// OpenFileKeyStore открывает хранилище в каталоге dir или создает новое.
+// Для существующего хранилища неверная парольная фраза дает ErrWrongPassphrase.
+func OpenFileKeyStore(dir string, passphrase []byte) (*FileKeyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	metaPath := filepath.Join(dir, "keystore.json")
+
+	data, err := os.ReadFile(metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return createFileKeyStore(dir, metaPath, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var meta keyStoreMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("keystore.json: %w", err)
+	}
+	if meta.KDF != "scrypt" || !validScryptParams(meta.N, meta.R, meta.P) {
+		return nil, fmt.Errorf("%w: неподдерживаемые параметры KDF хранилища", ErrInvalidKeyFormat)
+	}
+	master, err := passphraseKey(passphrase, meta.Salt, meta.N, meta.R, meta.P)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := openAEAD(master, meta.Check, keyStoreCheck); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return &FileKeyStore{dir: dir, master: master}, nil
+}
+
+// createFileKeyStore создает новое хранилище с новой солью
+func createFileKeyStore(dir, metaPath string, passphrase []byte) (*FileKeyStore, error) {
+	meta := keyStoreMeta{KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, scryptSalt)}
+	if _, err := io.ReadFull(rand.Reader, meta.Salt); err != nil {
+		return nil, err
+	}
+	master, err := passphraseKey(passphrase, meta.Salt, meta.N, meta.R, meta.P)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Check, err = sealAEAD(master, keyStoreCheck, keyStoreCheck); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(dir, metaPath, data); err != nil {
+		return nil, err
+	}
+	return &FileKeyStore{dir: dir, master: master}, nil
+}
+
+// writeFileAtomic записывает файл через временный файл и переименование (права 0600)
+func writeFileAtomic(dir, path string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (ks *FileKeyStore) path(id string) (string, error) {
+	if !keyIDPattern.MatchString(id) {
+		return "", ErrInvalidKeyID
+	}
+	return filepath.Join(ks.dir, id+".key"), nil
+}
+
+// load читает запись ключа (вызывается под блокировкой)
+func (ks *FileKeyStore) load(id string) (*storedKey, error) {
+	path, err := ks.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored storedKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyFormat, err)
+	}
+	return &stored, nil
+}
+
+// store записывает запись ключа (вызывается под блокировкой)
+func (ks *FileKeyStore) store(stored *storedKey) error {
+	path, err := ks.path(stored.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ks.dir, path, data)
+}
+
+// Put сохраняет новый ключ
+func (ks *FileKeyStore) Put(id string, key crypto.PrivateKey) error {
+	typ, err := keyType(key)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.master == nil {
+		return ErrKeyStoreClosed
+	}
+	existing, err := ks.load(id)
+	switch {
+	case err == nil && existing.RevokedAt != nil:
+		return ErrKeyRevoked
+	case err == nil:
+		return ErrKeyExists
+	case !errors.Is(err, ErrKeyNotFound):
+		return err
+	}
+
+	sealed, err := sealAEAD(ks.master, der, []byte(id))
+	if err != nil {
+		return err
+	}
+	return ks.store(&storedKey{KeyInfo: KeyInfo{ID: id, Type: typ, CreatedAt: time.Now().UTC()}, Data: sealed})
+}
+
+// Get возвращает ключ по ID
+func (ks *FileKeyStore) Get(id string) (crypto.PrivateKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.master == nil {
+		return nil, ErrKeyStoreClosed
+	}
+	stored, err := ks.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if stored.RevokedAt != nil {
+		return nil, ErrKeyRevoked
+	}
+	der, err := openAEAD(ks.master, stored.Data, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("%w: ключ %s поврежден или подменен", ErrInvalidKeyFormat, id)
+	}
+	return parsePKCS8(der)
+}
+
+// List возвращает сведения о ключах, отсортированные по ID
+func (ks *FileKeyStore) List() ([]KeyInfo, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.master == nil {
+		return nil, ErrKeyStoreClosed
+	}
+	paths, err := filepath.Glob(filepath.Join(ks.dir, "*.key"))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]KeyInfo, 0, len(paths))
+	for _, path := range paths {
+		stored, err := ks.load(strings.TrimSuffix(filepath.Base(path), ".key"))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, stored.KeyInfo)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// Revoke отзывает ключ: зашифрованная секретная часть удаляется из файла
+func (ks *FileKeyStore) Revoke(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.master == nil {
+		return ErrKeyStoreClosed
+	}
+	stored, err := ks.load(id)
+	if err != nil {
+		return err
+	}
+	if stored.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	stored.RevokedAt = &now
+	stored.Data = nil
+	return ks.store(stored)
+}
+
+// Export возвращает ключ в PEM, зашифрованном парольной фразой (для переноса на другое устройство).
+// Пустая парольная фраза дает обычный PKCS#8 без шифрования.
+func (ks *FileKeyStore) Export(id string, passphrase []byte) ([]byte, error) {
+	key, err := ks.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return MarshalPKCS8PEM(key)
+	}
+	return EncryptPKCS8PEM(key, passphrase)
+}
+
+// Import сохраняет ключ из PEM (зашифрованного EncryptPKCS8PEM или обычного PKCS#8)
+func (ks *FileKeyStore) Import(id string, data, passphrase []byte) error {
+	key, err := DecryptPKCS8PEM(data, passphrase)
+	if err != nil {
+		return err
+	}
+	return ks.Put(id, key)
+}
+
+// Close стирает главный ключ из памяти; после этого хранилище недоступно
+func (ks *FileKeyStore) Close() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for i := range ks.master {
+		ks.master[i] = 0
+	}
+	ks.master = nil
+}
+
+// SaveIdentity сохраняет ключи устройства под ID prefix.dh и prefix.sign
+func SaveIdentity(ks KeyStore, prefix string, k *IdentityKey) error {
+	if err := ks.Put(prefix+".dh", k.DH); err != nil {
+		return err
+	}
+	return ks.Put(prefix+".sign", k.Signing)
+}
+
+// LoadIdentity загружает ключи устройства, сохраненные SaveIdentity
+func LoadIdentity(ks KeyStore, prefix string) (*IdentityKey, error) {
+	dh, err := ks.Get(prefix + ".dh")
+	if err != nil {
+		return nil, err
+	}
+	signing, err := ks.Get(prefix + ".sign")
+	if err != nil {
+		return nil, err
+	}
+	dhKey, ok1 := dh.(*ecdh.PrivateKey)
+	signingKey, ok2 := signing.(ed25519.PrivateKey)
+	if !ok1 || !ok2 {
+		return nil, ErrUnsupportedKey
+	}
+	return &IdentityKey{DH: dhKey, Signing: signingKey}, nil
+}
@@ -0,0 +1,69 @@
+// processor/keystore_test.go
+package processor
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+// Хранилище, созданное с одной парольной фразой, не открывается с другой
+func TestFileKeyStoreWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := OpenFileKeyStore(dir, []byte("верная фраза"))
+	if err != nil {
+		t.Fatalf("создание хранилища: %v", err)
+	}
+	if err := SaveIdentity(ks, "device", identity); err != nil {
+		t.Fatalf("SaveIdentity: %v", err)
+	}
+	ks.Close()
+	if _, err := ks.Get("device.sign"); !errors.Is(err, ErrKeyStoreClosed) {
+		t.Errorf("закрытое хранилище: ожидалась ErrKeyStoreClosed, получено %v", err)
+	}
+
+	if _, err := OpenFileKeyStore(dir, []byte("неверная фраза")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("ожидалась ErrWrongPassphrase, получено %v", err)
+	}
+
+	ks, err = OpenFileKeyStore(dir, []byte("верная фраза"))
+	if err != nil {
+		t.Fatalf("повторное открытие: %v", err)
+	}
+	defer ks.Close()
+	loaded, err := LoadIdentity(ks, "device")
+	if err != nil {
+		t.Fatalf("LoadIdentity: %v", err)
+	}
+	if !loaded.DH.Equal(identity.DH) || !loaded.Signing.Equal(identity.Signing) {
+		t.Error("загруженные ключи не совпадают с сохраненными")
+	}
+}
+
+// Экспортированный ключ импортируется только с той же парольной фразой
+func TestEncryptedPEMWrongPassphrase(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncryptPKCS8PEM(key, []byte("фраза экспорта"))
+	if err != nil {
+		t.Fatalf("EncryptPKCS8PEM: %v", err)
+	}
+
+	if _, err := DecryptPKCS8PEM(data, []byte("другая фраза")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("ожидалась ErrWrongPassphrase, получено %v", err)
+	}
+	decrypted, err := DecryptPKCS8PEM(data, []byte("фраза экспорта"))
+	if err != nil {
+		t.Fatalf("DecryptPKCS8PEM: %v", err)
+	}
+	if !key.Equal(decrypted) {
+		t.Error("импортированный ключ не совпадает с экспортированным")
+	}
+}
@@ -1,40 +1,266 @@
 package processor
 
 import (
+	"bytes"
 	"crypto/rsa"
+	"errors"
+	"fmt"
 )
 
-// ProcessOutboundMessage объединяет два этапа обработки:
-// 1. Сжатие исходного сообщения с использованием Snappy (функция CompressMessage из compress.go)
+// Пороги выбора кодека по умолчанию
+const (
+	// Сообщения короче не сжимаются: заголовок кодека съедает весь выигрыш
+	defaultMinCompressSize = 64
+
+	// Начиная с этого размера предпочитается zstd, короче - snappy
+	defaultLargeMessageSize = 4 << 10
+)
+
+// ErrNoContentKey возвращается, если на этапе расшифровки нет ключа сообщения
+var ErrNoContentKey = errors.New("нет ключа сообщения для расшифровки")
+
+// Packet - сообщение на пути через конвейер. Исходящее сообщение проходит этапы по порядку,
+// входящее - в обратном порядке.
+type Packet struct {
+	Data       []byte // Текущее содержимое: текст, сжатые или зашифрованные данные
+	ContentKey []byte // Одноразовый ключ AES-256 сообщения (SealStage)
+	WrappedKey []byte // Ключ сообщения, завернутый для получателя (RSAKeyStage)
+}
+
+// Stage - этап обработки сообщения. Inbound отменяет действие Outbound.
+type Stage interface {
+	Name() string
+	Outbound(p *Packet) error
+	Inbound(p *Packet) error
+}
+
+// Pipeline - последовательность этапов обработки
+type Pipeline struct {
+	stages []Stage
+}
+
+// NewPipeline создает конвейер из этапов в порядке обработки исходящего сообщения
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Outbound обрабатывает исходящее сообщение
+func (pl *Pipeline) Outbound(p *Packet) error {
+	for _, stage := range pl.stages {
+		if err := stage.Outbound(p); err != nil {
+			return fmt.Errorf("этап %s: %w", stage.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Inbound обрабатывает входящее сообщение (этапы в обратном порядке)
+func (pl *Pipeline) Inbound(p *Packet) error {
+	for i := len(pl.stages) - 1; i >= 0; i-- {
+		if err := pl.stages[i].Inbound(p); err != nil {
+			return fmt.Errorf("этап %s: %w", pl.stages[i].Name(), err)
+		}
+	}
+	return nil
+}
+
+// CompressStage сжимает сообщение кодеком, выбранным по размеру и содержимому,
+// и записывает идентификатор кодека первым байтом. При распаковке годится любой
+// зарегистрированный кодек, а не только согласованные.
+type CompressStage struct {
+	Codecs    []Codec // Согласованные кодеки (NegotiateCodecs); пустой список - все зарегистрированные
+	MinSize   int     // Минимальный размер для сжатия (0 - по умолчанию)
+	LargeSize int     // Размер, начиная с которого предпочитается zstd (0 - по умолчанию)
+
+	// LegacySnappy - принимать данные без идентификатора кодека, сжатые snappy
+	// до появления кодеков (сообщения устаревшего режима, сохраненные на устройствах)
+	LegacySnappy bool
+}
+
+// NewCompressStage создает этап сжатия с порогами по умолчанию
+func NewCompressStage(codecs []Codec) *CompressStage {
+	return &CompressStage{Codecs: codecs}
+}
+
+func (s *CompressStage) Name() string { return "compress" }
+
+// ChooseCodec выбирает кодек для сообщения: короткие и уже сжатые данные не сжимаются,
+// сообщения среднего размера сжимаются быстрым snappy, длинные - zstd
+func (s *CompressStage) ChooseCodec(data []byte) Codec {
+	minSize, largeSize := s.MinSize, s.LargeSize
+	if minSize == 0 {
+		minSize = defaultMinCompressSize
+	}
+	if largeSize == 0 {
+		largeSize = defaultLargeMessageSize
+	}
+	if len(data) < minSize || looksCompressed(data) {
+		return NoneCodec{}
+	}
+
+	preferred := CodecIDSnappy
+	if len(data) >= largeSize {
+		preferred = CodecIDZstd
+	}
+	codecs := s.Codecs
+	if len(codecs) == 0 {
+		codecs = Codecs()
+	}
+	var fallback Codec
+	for _, c := range codecs {
+		if c.ID() == preferred {
+			return c
+		}
+		if fallback == nil && c.ID() != CodecIDNone {
+			fallback = c
+		}
+	}
+	if fallback == nil {
+		return NoneCodec{}
+	}
+	return fallback
+}
+
+func (s *CompressStage) Outbound(p *Packet) error {
+	codec := s.ChooseCodec(p.Data)
+	frame, err := EncodeFrame(codec, p.Data)
+	if err != nil {
+		return err
+	}
+	// Если сжатие не дало выигрыша, данные передаются как есть
+	if codec.ID() != CodecIDNone && len(frame) > len(p.Data) {
+		if frame, err = EncodeFrame(NoneCodec{}, p.Data); err != nil {
+			return err
+		}
+	}
+	p.Data = frame
+	return nil
+}
+
+func (s *CompressStage) Inbound(p *Packet) error {
+	data, err := DecodeFrame(p.Data)
+	if err != nil && s.LegacySnappy {
+		// Старый формат начинается с длины в varint: первый байт совпадает с идентификатором
+		// кодека только у сообщений короче 3 байт, и такие кадры не распаковываются как новые
+		if legacy, legacyErr := (SnappyCodec{}).Decode(p.Data); legacyErr == nil {
+			data, err = legacy, nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	p.Data = data
+	return nil
+}
+
+// Сигнатуры форматов, которые уже сжаты (вложения, пересланные архивы и т.п.)
+var compressedMagic = [][]byte{
+	{0x1f, 0x8b},             // gzip
+	{0x28, 0xb5, 0x2f, 0xfd}, // zstd
+	{0x50, 0x4b, 0x03, 0x04}, // zip
+	{0x89, 'P', 'N', 'G'},    // png
+	{0xff, 0xd8, 0xff},       // jpeg
+	[]byte("GIF8"),           // gif
+	[]byte("%PDF"),           // pdf (обычно со сжатыми потоками)
+}
+
+// looksCompressed определяет по сигнатуре, что данные уже сжаты
+func looksCompressed(data []byte) bool {
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(data, magic) {
+			return true
+		}
+	}
+	return len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP"))
+}
+
+// SealStage шифрует сообщение AES-256-GCM ключом сообщения. Если ключа нет, создается новый
+// (его затем заворачивает для получателей следующий этап или сессия Double Ratchet).
+type SealStage struct{}
+
+func (SealStage) Name() string { return "seal" }
+
+func (SealStage) Outbound(p *Packet) error {
+	if p.ContentKey == nil {
+		key, err := GenerateRandomAESKey(32)
+		if err != nil {
+			return err
+		}
+		p.ContentKey = key
+	}
+	ciphertext, err := aesGCMEncrypt(p.ContentKey, p.Data)
+	if err != nil {
+		return err
+	}
+	p.Data = ciphertext
+	return nil
+}
+
+func (SealStage) Inbound(p *Packet) error {
+	if p.ContentKey == nil {
+		return ErrNoContentKey
+	}
+	plaintext, err := aesGCMDecrypt(p.ContentKey, p.Data)
+	if err != nil {
+		return err
+	}
+	p.Data = plaintext
+	return nil
+}
+
+// RSAKeyStage заворачивает ключ сообщения ключом RSA-OAEP получателя (устаревший режим AlgLegacyRSA).
+// Для исходящих сообщений нужен PublicKey, для входящих - PrivateKey.
+type RSAKeyStage struct {
+	PublicKey  *rsa.PublicKey
+	PrivateKey *rsa.PrivateKey
+}
+
+func (RSAKeyStage) Name() string { return "rsa-oaep" }
+
+func (s RSAKeyStage) Outbound(p *Packet) error {
+	wrapped, err := rsaEncrypt(s.PublicKey, p.ContentKey)
+	if err != nil {
+		return err
+	}
+	p.WrappedKey = wrapped
+	return nil
+}
+
+func (s RSAKeyStage) Inbound(p *Packet) error {
+	key, err := rsaDecrypt(s.PrivateKey, p.WrappedKey)
+	if err != nil {
+		return err
+	}
+	p.ContentKey = key
+	return nil
+}
+
+// ProcessOutboundMessage объединяет этапы обработки устаревшего режима:
+// 1. Сжатие кодеком, выбранным по размеру и содержимому сообщения (идентификатор кодека - первый байт),
 // 2. Гибридное шифрование с использованием AES-GCM для шифрования сжатого сообщения и RSA-OAEP для защиты AES-ключа.
 // При успешном выполнении возвращаются зашифрованный AES-ключ и зашифрованное сообщение.
-func ProcessOutboundMessage(plaintext []byte, recipientPublicKey *rsa.PublicKey) (encryptedAESKey, encryptedMessage []byte, err error) {
-	// Сжатие сообщения
-	compressed := CompressMessage(plaintext)
-	
-	// Гибридное шифрование: шифруем сжатое сообщение
-	encryptedAESKey, encryptedMessage, err = EncryptMessage(compressed, recipientPublicKey)
-	if err != nil {
+// codecs - кодеки, согласованные с собеседником (nil - все зарегистрированные).
+func ProcessOutboundMessage(plaintext []byte, recipientPublicKey *rsa.PublicKey, codecs ...Codec) (encryptedAESKey, encryptedMessage []byte, err error) {
+	p := &Packet{Data: plaintext}
+	pipeline := NewPipeline(NewCompressStage(codecs), SealStage{}, RSAKeyStage{PublicKey: recipientPublicKey})
+	if err := pipeline.Outbound(p); err != nil {
 		return nil, nil, err
 	}
-	return encryptedAESKey, encryptedMessage, nil
+	return p.WrappedKey, p.Data, nil
 }
 
 // ProcessInboundMessage выполняет обратный процесс обработки входящего сообщения:
 // 1. Расшифровывает зашифрованный AES-ключ с помощью RSA-OAEP и приватного ключа получателя,
 // 2. Дешифрует сообщение с использованием AES-GCM с полученным AES-ключом,
-// 3. Распаковывает расшифрованное (сжатое) сообщение с использованием Snappy (функция DecompressMessage из compress.go).
+// 3. Распаковывает сообщение кодеком, идентификатор которого записан в первом байте
+// (сообщения, сжатые snappy до появления кодеков, распаковываются как раньше).
 // Возвращается исходное сообщение (plaintext).
 func ProcessInboundMessage(encryptedAESKey, encryptedMessage []byte, recipientPrivateKey *rsa.PrivateKey) (plaintext []byte, err error) {
-	// Расшифровываем сообщение гибридным способом: сначала получаем сжатый текст
-	compressed, err := DecryptMessage(encryptedAESKey, encryptedMessage, recipientPrivateKey)
-	if err != nil {
-		return nil, err
-	}
-	// Распаковка сжатого сообщения
-	plaintext, err = DecompressMessage(compressed)
-	if err != nil {
+	p := &Packet{Data: encryptedMessage, WrappedKey: encryptedAESKey}
+	compress := &CompressStage{LegacySnappy: true}
+	pipeline := NewPipeline(compress, SealStage{}, RSAKeyStage{PrivateKey: recipientPrivateKey})
+	if err := pipeline.Inbound(p); err != nil {
 		return nil, err
 	}
-	return plaintext, nil
+	return p.Data, nil
 }
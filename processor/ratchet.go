@@ -0,0 +1,326 @@
+// processor/ratchet.go
+package processor
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Максимальное количество пропущенных ключей сообщений, которые хранит сессия
+// (сообщения, пришедшие не по порядку)
+const maxSkippedKeys = 1000
+
+var (
+	ratchetInfo = []byte("MyChat DR ratchet")
+	messageInfo = []byte("MyChat DR message")
+)
+
+// Ошибки сессии
+var (
+	ErrTooManySkipped = errors.New("слишком много пропущенных сообщений в цепочке")
+	ErrNoSendingChain = errors.New("сессия еще не может отправлять сообщения")
+	ErrRatchetDecrypt = errors.New("не удалось расшифровать сообщение сессии")
+)
+
+// Header - заголовок сообщения сессии: текущий ключ DH отправителя и номер сообщения
+type Header struct {
+	DH []byte `json:"dh"`
+	PN uint32 `json:"pn"` // Количество сообщений в предыдущей цепочке отправки
+	N  uint32 `json:"n"`  // Номер сообщения в текущей цепочке
+}
+
+// bytes кодирует заголовок для дополнительных данных AEAD
+func (h Header) bytes() []byte {
+	out := make([]byte, 0, len(h.DH)+8)
+	out = append(out, h.DH...)
+	out = binary.BigEndian.AppendUint32(out, h.PN)
+	return binary.BigEndian.AppendUint32(out, h.N)
+}
+
+// RatchetMessage - зашифрованное сообщение сессии
+type RatchetMessage struct {
+	Initial    *InitialHeader `json:"x3dh,omitempty"` // Только до первого ответа собеседника
+	Header     Header         `json:"header"`
+	Ciphertext []byte         `json:"ct"`
+}
+
+// Session - состояние Double Ratchet с одним устройством собеседника.
+// Состояние нужно сохранять после каждого Encrypt и Decrypt (см. SessionStore):
+// ключи использованных сообщений в нем уже не содержатся.
+type Session struct {
+	rootKey []byte
+	dhs     *ecdh.PrivateKey // Наша текущая пара DH
+	dhr     *ecdh.PublicKey  // Текущий ключ DH собеседника
+	cks     []byte           // Цепочка отправки
+	ckr     []byte           // Цепочка получения
+	ns, nr  uint32
+	pn      uint32
+	skipped map[string][]byte // Ключи пропущенных сообщений: hex(DH) + ":" + N
+	ad      []byte            // Ключи устройств обеих сторон (инициатор первым)
+
+	// Initial прикладывается к исходящим сообщениям, пока собеседник не ответил
+	Initial *InitialHeader
+}
+
+// newInitiatorSession создает сессию инициатора: первая цепочка отправки выводится сразу
+func newInitiatorSession(secret []byte, theirSPK *ecdh.PublicKey, ad []byte) (*Session, error) {
+	dhs, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	dhOut, err := dhs.ECDH(theirSPK)
+	if err != nil {
+		return nil, err
+	}
+	rootKey, cks, err := kdfRoot(secret, dhOut)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{rootKey: rootKey, dhs: dhs, dhr: theirSPK, cks: cks, skipped: map[string][]byte{}, ad: ad}, nil
+}
+
+// newResponderSession создает сессию получателя: цепочки появятся после первого сообщения
+func newResponderSession(secret []byte, spk *ecdh.PrivateKey, ad []byte) *Session {
+	return &Session{rootKey: secret, dhs: spk, skipped: map[string][]byte{}, ad: ad}
+}
+
+// kdfRoot обновляет корневой ключ результатом DH и выводит новую цепочку
+func kdfRoot(rootKey, dhOut []byte) ([]byte, []byte, error) {
+	out, err := hkdf.Key(sha256.New, dhOut, rootKey, string(ratchetInfo), 64)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out[:32], out[32:], nil
+}
+
+// kdfChain сдвигает цепочку: возвращает следующий ключ цепочки и ключ сообщения
+func kdfChain(chainKey []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, chainKey)
+	mac.Write([]byte{0x02})
+	next := mac.Sum(nil)
+	mac = hmac.New(sha256.New, chainKey)
+	mac.Write([]byte{0x01})
+	return next, mac.Sum(nil)
+}
+
+// sealWithMessageKey шифрует текст одноразовым ключом сообщения (ключ и nonce выводятся из него)
+func sealWithMessageKey(mk, plaintext, ad []byte) ([]byte, error) {
+	aead, nonce, err := messageAEAD(mk)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plaintext, ad), nil
+}
+
+// openWithMessageKey расшифровывает текст ключом сообщения
+func openWithMessageKey(mk, ciphertext, ad []byte) ([]byte, error) {
+	aead, nonce, err := messageAEAD(mk)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, ErrRatchetDecrypt
+	}
+	return plaintext, nil
+}
+
+// messageAEAD выводит из ключа сообщения ключ AES-256-GCM и nonce
+func messageAEAD(mk []byte) (cipher.AEAD, []byte, error) {
+	out, err := hkdf.Key(sha256.New, mk, make([]byte, 32), string(messageInfo), 32+12)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(out[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, out[32:], nil
+}
+
+// associatedData - дополнительные данные AEAD: ключи устройств сторон и заголовок
+func (s *Session) associatedData(h Header) []byte {
+	return append(append([]byte{}, s.ad...), h.bytes()...)
+}
+
+// Encrypt шифрует сообщение следующим ключом цепочки отправки
+func (s *Session) Encrypt(plaintext []byte) (*RatchetMessage, error) {
+	if s.cks == nil {
+		return nil, ErrNoSendingChain
+	}
+	var mk []byte
+	s.cks, mk = kdfChain(s.cks)
+	h := Header{DH: s.dhs.PublicKey().Bytes(), PN: s.pn, N: s.ns}
+	s.ns++
+
+	ct, err := sealWithMessageKey(mk, plaintext, s.associatedData(h))
+	if err != nil {
+		return nil, err
+	}
+	return &RatchetMessage{Initial: s.Initial, Header: h, Ciphertext: ct}, nil
+}
+
+// Decrypt расшифровывает сообщение собеседника. При ошибке состояние сессии не меняется.
+func (s *Session) Decrypt(msg *RatchetMessage) ([]byte, error) {
+	h := msg.Header
+	key := skippedKey(h.DH, h.N)
+	if mk, ok := s.skipped[key]; ok {
+		plaintext, err := openWithMessageKey(mk, msg.Ciphertext, s.associatedData(h))
+		if err != nil {
+			return nil, err
+		}
+		delete(s.skipped, key)
+		return plaintext, nil
+	}
+
+	// Изменения применяются к копии и сохраняются только после успешной расшифровки
+	next := s.clone()
+	if next.dhr == nil || !bytes.Equal(h.DH, next.dhr.Bytes()) {
+		if err := next.skipMessageKeys(h.PN); err != nil {
+			return nil, err
+		}
+		if err := next.dhRatchet(h); err != nil {
+			return nil, err
+		}
+	}
+	if err := next.skipMessageKeys(h.N); err != nil {
+		return nil, err
+	}
+	var mk []byte
+	next.ckr, mk = kdfChain(next.ckr)
+	next.nr++
+
+	plaintext, err := openWithMessageKey(mk, msg.Ciphertext, next.associatedData(h))
+	if err != nil {
+		return nil, err
+	}
+	// Собеседник ответил - данные X3DH ему больше не нужны
+	next.Initial = nil
+	*s = *next
+	return plaintext, nil
+}
+
+// skipMessageKeys сохраняет ключи сообщений текущей цепочки получения до номера until
+func (s *Session) skipMessageKeys(until uint32) error {
+	if s.ckr == nil {
+		return nil
+	}
+	if until > s.nr && (until-s.nr > maxSkippedKeys || len(s.skipped)+int(until-s.nr) > maxSkippedKeys) {
+		return ErrTooManySkipped
+	}
+	for s.nr < until {
+		var mk []byte
+		s.ckr, mk = kdfChain(s.ckr)
+		s.skipped[skippedKey(s.dhr.Bytes(), s.nr)] = mk
+		s.nr++
+	}
+	return nil
+}
+
+// dhRatchet выполняет шаг обмена DH по новому ключу собеседника
+func (s *Session) dhRatchet(h Header) error {
+	dhr, err := ecdh.X25519().NewPublicKey(h.DH)
+	if err != nil {
+		return ErrRatchetDecrypt
+	}
+	s.pn, s.ns, s.nr = s.ns, 0, 0
+	s.dhr = dhr
+
+	dhOut, err := s.dhs.ECDH(dhr)
+	if err != nil {
+		return err
+	}
+	if s.rootKey, s.ckr, err = kdfRoot(s.rootKey, dhOut); err != nil {
+		return err
+	}
+	if s.dhs, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+		return err
+	}
+	if dhOut, err = s.dhs.ECDH(dhr); err != nil {
+		return err
+	}
+	s.rootKey, s.cks, err = kdfRoot(s.rootKey, dhOut)
+	return err
+}
+
+// clone копирует состояние сессии
+func (s *Session) clone() *Session {
+	c := *s
+	c.skipped = make(map[string][]byte, len(s.skipped))
+	for k, v := range s.skipped {
+		c.skipped[k] = v
+	}
+	return &c
+}
+
+func skippedKey(dh []byte, n uint32) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(dh), n)
+}
+
+// sessionState - сериализуемое состояние сессии
+type sessionState struct {
+	RootKey []byte            `json:"rootKey"`
+	DHs     []byte            `json:"dhs"`
+	DHr     []byte            `json:"dhr,omitempty"`
+	CKs     []byte            `json:"cks,omitempty"`
+	CKr     []byte            `json:"ckr,omitempty"`
+	Ns      uint32            `json:"ns"`
+	Nr      uint32            `json:"nr"`
+	PN      uint32            `json:"pn"`
+	Skipped map[string][]byte `json:"skipped,omitempty"`
+	AD      []byte            `json:"ad"`
+	Initial *InitialHeader    `json:"initial,omitempty"`
+}
+
+// MarshalJSON сериализует состояние сессии (содержит секретные ключи - хранить только на устройстве)
+func (s *Session) MarshalJSON() ([]byte, error) {
+	st := sessionState{
+		RootKey: s.rootKey, DHs: s.dhs.Bytes(), CKs: s.cks, CKr: s.ckr,
+		Ns: s.ns, Nr: s.nr, PN: s.pn, Skipped: s.skipped, AD: s.ad, Initial: s.Initial,
+	}
+	if s.dhr != nil {
+		st.DHr = s.dhr.Bytes()
+	}
+	return json.Marshal(st)
+}
+
+// UnmarshalJSON восстанавливает состояние сессии
+func (s *Session) UnmarshalJSON(data []byte) error {
+	var st sessionState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	curve := ecdh.X25519()
+	dhs, err := curve.NewPrivateKey(st.DHs)
+	if err != nil {
+		return fmt.Errorf("состояние сессии: %w", err)
+	}
+	*s = Session{
+		rootKey: st.RootKey, dhs: dhs, cks: st.CKs, ckr: st.CKr,
+		ns: st.Ns, nr: st.Nr, pn: st.PN, skipped: st.Skipped, ad: st.AD, Initial: st.Initial,
+	}
+	if s.skipped == nil {
+		s.skipped = map[string][]byte{}
+	}
+	if len(st.DHr) > 0 {
+		if s.dhr, err = curve.NewPublicKey(st.DHr); err != nil {
+			return fmt.Errorf("состояние сессии: %w", err)
+		}
+	}
+	return nil
+}
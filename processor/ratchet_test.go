@@ -0,0 +1,124 @@
+// processor/ratchet_test.go
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func encryptAll(t *testing.T, s *Session, texts ...string) []*RatchetMessage {
+	t.Helper()
+	msgs := make([]*RatchetMessage, len(texts))
+	for i, text := range texts {
+		msg, err := s.Encrypt([]byte(text))
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", text, err)
+		}
+		msgs[i] = msg
+	}
+	return msgs
+}
+
+func expectDecrypt(t *testing.T, s *Session, msg *RatchetMessage, want string) {
+	t.Helper()
+	plaintext, err := s.Decrypt(msg)
+	if err != nil {
+		t.Fatalf("Decrypt(%q): %v", want, err)
+	}
+	if string(plaintext) != want {
+		t.Fatalf("Decrypt: %q, ожидалось %q", plaintext, want)
+	}
+}
+
+// Сообщения одной цепочки расшифровываются в любом порядке, но только один раз
+func TestRatchetOutOfOrder(t *testing.T) {
+	alice, bob := establish(t, true)
+	msgs := encryptAll(t, alice, "m1", "m2", "m3", "m4")
+
+	for _, i := range []int{3, 0, 2, 1} {
+		expectDecrypt(t, bob, msgs[i], fmt.Sprintf("m%d", i+1))
+	}
+	if len(bob.skipped) != 0 {
+		t.Errorf("после расшифровки всех сообщений осталось пропущенных ключей: %d", len(bob.skipped))
+	}
+	if _, err := bob.Decrypt(msgs[2]); err == nil {
+		t.Error("повторное сообщение расшифровалось")
+	}
+}
+
+// Первые сообщения инициатора (с данными X3DH) тоже могут прийти не по порядку
+func TestRatchetInitialOutOfOrder(t *testing.T) {
+	aliceID, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobDevice := newDevice(t)
+	alice, err := InitiateSession(aliceID, bobDevice.identity.Bundle(bobDevice.spk, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := encryptAll(t, alice, "первое", "второе")
+
+	bob, plaintext, err := AcceptSession(bobDevice.identity, bobDevice.spk, nil, msgs[1])
+	if err != nil || string(plaintext) != "второе" {
+		t.Fatalf("AcceptSession: %q, %v", plaintext, err)
+	}
+	expectDecrypt(t, bob, msgs[0], "первое")
+}
+
+// Пропущенные сообщения старой цепочки расшифровываются после шага DH,
+// в том числе после сохранения и восстановления состояния сессии
+func TestRatchetSkippedAcrossDHStep(t *testing.T) {
+	alice, bob := establish(t, true)
+	old := encryptAll(t, alice, "a1", "a2", "a3")
+	expectDecrypt(t, bob, old[0], "a1")
+
+	// Ответ Боба переводит Алису на новую цепочку, a2 и a3 остаются в старой
+	expectDecrypt(t, alice, encryptAll(t, bob, "b1")[0], "b1")
+	next := encryptAll(t, alice, "a4")[0]
+	if next.Header.PN != 4 {
+		t.Fatalf("PN = %d, ожидалось 4", next.Header.PN)
+	}
+	expectDecrypt(t, bob, next, "a4")
+	if len(bob.skipped) != 2 {
+		t.Fatalf("пропущенных ключей %d, ожидалось 2", len(bob.skipped))
+	}
+
+	data, err := json.Marshal(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &Session{}
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	expectDecrypt(t, restored, old[2], "a3")
+	expectDecrypt(t, restored, old[1], "a2")
+	expectDecrypt(t, alice, encryptAll(t, restored, "b2")[0], "b2")
+}
+
+// Неудачная расшифровка не меняет состояние сессии
+func TestRatchetTamperedMessage(t *testing.T) {
+	alice, bob := establish(t, true)
+	msg := encryptAll(t, alice, "m1")[0]
+
+	tampered := *msg
+	tampered.Ciphertext = append([]byte{}, msg.Ciphertext...)
+	tampered.Ciphertext[len(tampered.Ciphertext)-1] ^= 1
+	if _, err := bob.Decrypt(&tampered); err == nil {
+		t.Fatal("измененное сообщение расшифровалось")
+	}
+	expectDecrypt(t, bob, msg, "m1")
+}
+
+// Сессия не хранит больше maxSkippedKeys пропущенных ключей
+func TestRatchetTooManySkipped(t *testing.T) {
+	alice, bob := establish(t, true)
+	msg := encryptAll(t, alice, "m1")[0]
+	msg.Header.N = maxSkippedKeys + 2
+	if _, err := bob.Decrypt(msg); !errors.Is(err, ErrTooManySkipped) {
+		t.Errorf("ожидалась ErrTooManySkipped, получено %v", err)
+	}
+}
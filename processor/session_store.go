@@ -0,0 +1,98 @@
+// processor/session_store.go
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// ErrNoSession - сессии с устройством еще нет (нужно установить ее через InitiateSession)
+var ErrNoSession = errors.New("сессия с устройством не найдена")
+
+// SessionStore хранит состояние сессий на устройстве. Ключ - отпечаток ключа устройства собеседника
+// из каталога /api/keys. Состояние содержит секретные ключи и никогда не передается на сервер.
+type SessionStore interface {
+	Load(peer string) (*Session, error)
+	Save(peer string, s *Session) error
+	Delete(peer string) error
+}
+
+// Допустимые имена сессий: отпечатки ключей (hex) и другие простые идентификаторы
+var peerNamePattern = regexp.MustCompile(`^[0-9A-Za-z._-]{1,128}$`)
+
+// FileSessionStore хранит каждую сессию в отдельном JSON-файле каталога (права 0600)
+type FileSessionStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileSessionStore создает хранилище сессий в каталоге dir
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+func (fs *FileSessionStore) path(peer string) (string, error) {
+	if !peerNamePattern.MatchString(peer) {
+		return "", errors.New("недопустимый идентификатор сессии")
+	}
+	return filepath.Join(fs.dir, peer+".json"), nil
+}
+
+// Load загружает сессию с устройством
+func (fs *FileSessionStore) Load(peer string) (*Session, error) {
+	path, err := fs.path(peer)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Save атомарно сохраняет сессию (через временный файл и переименование)
+func (fs *FileSessionStore) Save(peer string, s *Session) error {
+	path, err := fs.path(peer)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return writeFileAtomic(fs.dir, path, data)
+}
+
+// Delete удаляет сессию (например, после отзыва ключа собеседника)
+func (fs *FileSessionStore) Delete(peer string) error {
+	path, err := fs.path(peer)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
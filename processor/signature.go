@@ -0,0 +1,65 @@
+// processor/signature.go
+package processor
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Метка подписи сообщения (отделяет ее от подписи предварительных ключей)
+var messageSignatureInfo = []byte("MyChat message signature v1")
+
+// Ошибки подписи
+var (
+	ErrBadSignature = errors.New("подпись сообщения не сходится")
+)
+
+// SignedMetadata - данные сообщения, которые подписываются вместе с шифротекстом.
+// Подпись связывает шифротекст с автором, чатом и получателями, поэтому его нельзя
+// выдать за сообщение другого пользователя или переслать в другой чат.
+type SignedMetadata struct {
+	SenderID   int      // ID отправителя
+	ChatID     int      // ID чата
+	MessageID  string   // ID сообщения, созданный отправителем (например, UUID)
+	Timestamp  int64    // Время отправки по часам отправителя, Unix в миллисекундах
+	Recipients []string // Отпечатки ключей устройств, для которых зашифровано сообщение
+}
+
+// signedMessage кодирует подписываемые данные: поля переменной длины предваряются длиной,
+// чтобы разные наборы полей не давали одинаковую последовательность байт
+func signedMessage(meta *SignedMetadata, ciphertext []byte) []byte {
+	recipients := append([]string{}, meta.Recipients...)
+	sort.Strings(recipients)
+
+	out := append([]byte{}, messageSignatureInfo...)
+	out = binary.BigEndian.AppendUint64(out, uint64(meta.SenderID))
+	out = binary.BigEndian.AppendUint64(out, uint64(meta.ChatID))
+	out = appendField(out, []byte(meta.MessageID))
+	out = binary.BigEndian.AppendUint64(out, uint64(meta.Timestamp))
+	out = binary.BigEndian.AppendUint32(out, uint32(len(recipients)))
+	for _, fp := range recipients {
+		out = appendField(out, []byte(fp))
+	}
+	return appendField(out, ciphertext)
+}
+
+func appendField(out, field []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(field)))
+	return append(out, field...)
+}
+
+// SignMessage подписывает шифротекст и метаданные сообщения ключом подписи устройства
+func SignMessage(key ed25519.PrivateKey, meta *SignedMetadata, ciphertext []byte) []byte {
+	return ed25519.Sign(key, signedMessage(meta, ciphertext))
+}
+
+// VerifyMessage проверяет подпись сообщения. Получатель должен также убедиться,
+// что ключ подписи опубликован в каталоге отправителем сообщения.
+func VerifyMessage(key ed25519.PublicKey, meta *SignedMetadata, ciphertext, signature []byte) error {
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, signedMessage(meta, ciphertext), signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+// processor/x3dh.go
+package processor
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// Алгоритмы клиентского шифрования (поле alg зашифрованного сообщения)
+const (
+	// AlgLegacyRSA - устаревший режим: ключ сообщения заворачивается долговременным ключом RSA
+	// (EncryptMessage). Утечка приватного ключа раскрывает всю прошлую переписку.
+	AlgLegacyRSA = "RSA-OAEP-256+A256GCM"
+
+	// AlgRatchet - сессия X3DH + Double Ratchet: ключ каждого сообщения выводится из цепочки,
+	// которая обновляется обменом X25519, поэтому утечка текущего состояния не раскрывает прошлые сообщения
+	AlgRatchet = "X25519-DR+A256GCM"
+)
+
+// Метки для вывода ключей и подписи (разделяют контексты использования)
+var (
+	x3dhInfo         = []byte("MyChat X3DH")
+	signedPreKeyInfo = []byte("MyChat signed prekey")
+)
+
+// Ошибки установки сессии
+var (
+	ErrInvalidBundle      = errors.New("некорректный набор предварительных ключей")
+	ErrBadPreKeySignature = errors.New("подпись предварительного ключа не сходится")
+	ErrPreKeyMismatch     = errors.New("предварительный ключ не соответствует сообщению")
+)
+
+// IdentityKey - долговременные ключи устройства. Публичный X25519-ключ публикуется в каталоге
+// (его отпечаток - идентификатор устройства), Ed25519-ключом подписываются предварительные ключи.
+type IdentityKey struct {
+	DH      *ecdh.PrivateKey
+	Signing ed25519.PrivateKey
+}
+
+// SignedPreKey - среднесрочный предварительный ключ, подписанный ключом устройства.
+// Его стоит заменять раз в несколько недель.
+type SignedPreKey struct {
+	ID        uint32
+	Key       *ecdh.PrivateKey
+	Signature []byte
+}
+
+// OneTimePreKey - одноразовый предварительный ключ. Сервер выдает каждый из них только одному инициатору.
+type OneTimePreKey struct {
+	ID  uint32
+	Key *ecdh.PrivateKey
+}
+
+// PreKeyBundle - публичные ключи устройства, которые сервер выдает инициатору сессии
+type PreKeyBundle struct {
+	IdentityKey           []byte  `json:"identityKey"` // X25519
+	SigningKey            []byte  `json:"signingKey"`  // Ed25519
+	SignedPreKeyID        uint32  `json:"signedPreKeyId"`
+	SignedPreKey          []byte  `json:"signedPreKey"`
+	SignedPreKeySignature []byte  `json:"signedPreKeySignature"`
+	OneTimePreKeyID       *uint32 `json:"oneTimePreKeyId,omitempty"`
+	OneTimePreKey         []byte  `json:"oneTimePreKey,omitempty"` // Может отсутствовать, если ключи закончились
+}
+
+// InitialHeader - данные X3DH, которые инициатор прикладывает к сообщениям,
+// пока не получит первый ответ: по ним получатель выводит тот же общий секрет
+type InitialHeader struct {
+	IdentityKey     []byte  `json:"ik"`
+	EphemeralKey    []byte  `json:"ek"`
+	SignedPreKeyID  uint32  `json:"spk"`
+	OneTimePreKeyID *uint32 `json:"opk,omitempty"`
+}
+
+// GenerateIdentity создает долговременные ключи устройства
+func GenerateIdentity() (*IdentityKey, error) {
+	dh, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	_, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &IdentityKey{DH: dh, Signing: signing}, nil
+}
+
+// signedPreKeyMessage - подписываемые данные: подпись связывает предварительный ключ с ключом устройства
+func signedPreKeyMessage(identityKey, preKey []byte) []byte {
+	msg := make([]byte, 0, len(signedPreKeyInfo)+len(identityKey)+len(preKey))
+	msg = append(msg, signedPreKeyInfo...)
+	msg = append(msg, identityKey...)
+	return append(msg, preKey...)
+}
+
+// GenerateSignedPreKey создает и подписывает предварительный ключ
+func (k *IdentityKey) GenerateSignedPreKey(id uint32) (*SignedPreKey, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(k.Signing, signedPreKeyMessage(k.DH.PublicKey().Bytes(), key.PublicKey().Bytes()))
+	return &SignedPreKey{ID: id, Key: key, Signature: sig}, nil
+}
+
+// GenerateOneTimePreKeys создает n одноразовых ключей с ID начиная с startID
+func GenerateOneTimePreKeys(startID uint32, n int) ([]OneTimePreKey, error) {
+	keys := make([]OneTimePreKey, n)
+	for i := range keys {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = OneTimePreKey{ID: startID + uint32(i), Key: key}
+	}
+	return keys, nil
+}
+
+// Bundle возвращает публичный набор ключей для загрузки на сервер
+func (k *IdentityKey) Bundle(spk *SignedPreKey, opk *OneTimePreKey) *PreKeyBundle {
+	b := &PreKeyBundle{
+		IdentityKey:           k.DH.PublicKey().Bytes(),
+		SigningKey:            k.Signing.Public().(ed25519.PublicKey),
+		SignedPreKeyID:        spk.ID,
+		SignedPreKey:          spk.Key.PublicKey().Bytes(),
+		SignedPreKeySignature: spk.Signature,
+	}
+	if opk != nil {
+		id := opk.ID
+		b.OneTimePreKeyID = &id
+		b.OneTimePreKey = opk.Key.PublicKey().Bytes()
+	}
+	return b
+}
+
+// VerifyBundle проверяет размеры ключей и подпись предварительного ключа
+func VerifyBundle(b *PreKeyBundle) error {
+	if len(b.IdentityKey) != 32 || len(b.SignedPreKey) != 32 || len(b.SigningKey) != ed25519.PublicKeySize {
+		return ErrInvalidBundle
+	}
+	if (b.OneTimePreKeyID == nil) != (len(b.OneTimePreKey) == 0) ||
+		(b.OneTimePreKeyID != nil && len(b.OneTimePreKey) != 32) {
+		return ErrInvalidBundle
+	}
+	if !ed25519.Verify(b.SigningKey, signedPreKeyMessage(b.IdentityKey, b.SignedPreKey), b.SignedPreKeySignature) {
+		return ErrBadPreKeySignature
+	}
+	return nil
+}
+
+// x3dhSecret выводит общий секрет из результатов обменов DH
+func x3dhSecret(dhs ...[]byte) ([]byte, error) {
+	ikm := bytes.Repeat([]byte{0xFF}, 32)
+	for _, dh := range dhs {
+		ikm = append(ikm, dh...)
+	}
+	return hkdf.Key(sha256.New, ikm, make([]byte, 32), string(x3dhInfo), 32)
+}
+
+// InitiateSession устанавливает сессию с устройством по его набору ключей (X3DH).
+// Проверенный ключ устройства собеседника (bundle.IdentityKey) нужно сверить с отпечатком из каталога.
+func InitiateSession(ours *IdentityKey, bundle *PreKeyBundle) (*Session, error) {
+	if err := VerifyBundle(bundle); err != nil {
+		return nil, err
+	}
+	curve := ecdh.X25519()
+	theirIdentity, err := curve.NewPublicKey(bundle.IdentityKey)
+	if err != nil {
+		return nil, ErrInvalidBundle
+	}
+	theirSPK, err := curve.NewPublicKey(bundle.SignedPreKey)
+	if err != nil {
+		return nil, ErrInvalidBundle
+	}
+	ephemeral, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	dh1, err := ours.DH.ECDH(theirSPK)
+	if err != nil {
+		return nil, err
+	}
+	dh2, err := ephemeral.ECDH(theirIdentity)
+	if err != nil {
+		return nil, err
+	}
+	dh3, err := ephemeral.ECDH(theirSPK)
+	if err != nil {
+		return nil, err
+	}
+	dhs := [][]byte{dh1, dh2, dh3}
+	if bundle.OneTimePreKeyID != nil {
+		theirOPK, err := curve.NewPublicKey(bundle.OneTimePreKey)
+		if err != nil {
+			return nil, ErrInvalidBundle
+		}
+		dh4, err := ephemeral.ECDH(theirOPK)
+		if err != nil {
+			return nil, err
+		}
+		dhs = append(dhs, dh4)
+	}
+	secret, err := x3dhSecret(dhs...)
+	if err != nil {
+		return nil, err
+	}
+
+	ad := append(append([]byte{}, ours.DH.PublicKey().Bytes()...), bundle.IdentityKey...)
+	s, err := newInitiatorSession(secret, theirSPK, ad)
+	if err != nil {
+		return nil, err
+	}
+	s.Initial = &InitialHeader{
+		IdentityKey:     ours.DH.PublicKey().Bytes(),
+		EphemeralKey:    ephemeral.PublicKey().Bytes(),
+		SignedPreKeyID:  bundle.SignedPreKeyID,
+		OneTimePreKeyID: bundle.OneTimePreKeyID,
+	}
+	return s, nil
+}
+
+// AcceptSession устанавливает сессию по первому сообщению инициатора и расшифровывает его.
+// spk и opk - приватные предварительные ключи с ID из msg.Initial (opk равен nil, если инициатор
+// не использовал одноразовый ключ). После успешной расшифровки одноразовый ключ нужно удалить.
+func AcceptSession(ours *IdentityKey, spk *SignedPreKey, opk *OneTimePreKey, msg *RatchetMessage) (*Session, []byte, error) {
+	init := msg.Initial
+	if init == nil || init.SignedPreKeyID != spk.ID ||
+		(init.OneTimePreKeyID == nil) != (opk == nil) ||
+		(opk != nil && *init.OneTimePreKeyID != opk.ID) {
+		return nil, nil, ErrPreKeyMismatch
+	}
+	curve := ecdh.X25519()
+	theirIdentity, err := curve.NewPublicKey(init.IdentityKey)
+	if err != nil {
+		return nil, nil, ErrInvalidBundle
+	}
+	theirEphemeral, err := curve.NewPublicKey(init.EphemeralKey)
+	if err != nil {
+		return nil, nil, ErrInvalidBundle
+	}
+
+	dh1, err := spk.Key.ECDH(theirIdentity)
+	if err != nil {
+		return nil, nil, err
+	}
+	dh2, err := ours.DH.ECDH(theirEphemeral)
+	if err != nil {
+		return nil, nil, err
+	}
+	dh3, err := spk.Key.ECDH(theirEphemeral)
+	if err != nil {
+		return nil, nil, err
+	}
+	dhs := [][]byte{dh1, dh2, dh3}
+	if opk != nil {
+		dh4, err := opk.Key.ECDH(theirEphemeral)
+		if err != nil {
+			return nil, nil, err
+		}
+		dhs = append(dhs, dh4)
+	}
+	secret, err := x3dhSecret(dhs...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ad := append(append([]byte{}, init.IdentityKey...), ours.DH.PublicKey().Bytes()...)
+	s := newResponderSession(secret, spk.Key, ad)
+	plaintext, err := s.Decrypt(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, plaintext, nil
+}
@@ -0,0 +1,139 @@
+// processor/x3dh_test.go
+package processor
+
+import (
+	"errors"
+	"testing"
+)
+
+// device - ключи устройства получателя с опубликованным набором
+type device struct {
+	identity *IdentityKey
+	spk      *SignedPreKey
+	opks     []OneTimePreKey
+}
+
+func newDevice(t *testing.T) *device {
+	t.Helper()
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spk, err := identity.GenerateSignedPreKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opks, err := GenerateOneTimePreKeys(100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &device{identity: identity, spk: spk, opks: opks}
+}
+
+// establish устанавливает сессию alice -> bob и возвращает обе стороны после первого сообщения
+func establish(t *testing.T, useOPK bool) (alice, bob *Session) {
+	t.Helper()
+	aliceID, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobDevice := newDevice(t)
+	var opk *OneTimePreKey
+	if useOPK {
+		opk = &bobDevice.opks[0]
+	}
+
+	alice, err = InitiateSession(aliceID, bobDevice.identity.Bundle(bobDevice.spk, opk))
+	if err != nil {
+		t.Fatalf("InitiateSession: %v", err)
+	}
+	msg, err := alice.Encrypt([]byte("привет"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	bob, plaintext, err := AcceptSession(bobDevice.identity, bobDevice.spk, opk, msg)
+	if err != nil {
+		t.Fatalf("AcceptSession: %v", err)
+	}
+	if string(plaintext) != "привет" {
+		t.Fatalf("первое сообщение: %q", plaintext)
+	}
+	return alice, bob
+}
+
+// Обе стороны X3DH выводят один общий секрет: сообщения проходят в обе стороны
+func TestX3DHSharedSecret(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		useOPK bool
+	}{
+		{"с одноразовым ключом", true},
+		{"без одноразового ключа", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			alice, bob := establish(t, tc.useOPK)
+
+			reply, err := bob.Encrypt([]byte("ответ"))
+			if err != nil {
+				t.Fatalf("ответ: %v", err)
+			}
+			if reply.Initial != nil {
+				t.Error("ответ получателя не должен содержать данные X3DH")
+			}
+			plaintext, err := alice.Decrypt(reply)
+			if err != nil || string(plaintext) != "ответ" {
+				t.Fatalf("расшифровка ответа: %q, %v", plaintext, err)
+			}
+			if alice.Initial != nil {
+				t.Error("после ответа инициатор не должен прикладывать данные X3DH")
+			}
+		})
+	}
+}
+
+// Получатель с другими предварительными ключами не выводит тот же секрет
+func TestX3DHWrongPreKey(t *testing.T) {
+	aliceID, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobDevice := newDevice(t)
+	alice, err := InitiateSession(aliceID, bobDevice.identity.Bundle(bobDevice.spk, &bobDevice.opks[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := alice.Encrypt([]byte("привет"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := AcceptSession(bobDevice.identity, bobDevice.spk, &bobDevice.opks[1], msg); !errors.Is(err, ErrPreKeyMismatch) {
+		t.Errorf("другой одноразовый ключ: ожидалась ErrPreKeyMismatch, получено %v", err)
+	}
+	wrongOPK := OneTimePreKey{ID: bobDevice.opks[0].ID, Key: bobDevice.opks[1].Key}
+	if _, _, err := AcceptSession(bobDevice.identity, bobDevice.spk, &wrongOPK, msg); err == nil {
+		t.Error("подмененный одноразовый ключ: сообщение расшифровалось")
+	}
+}
+
+// Набор с чужой подписью предварительного ключа отклоняется
+func TestVerifyBundleSignature(t *testing.T) {
+	bobDevice := newDevice(t)
+	other, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := bobDevice.identity.Bundle(bobDevice.spk, nil)
+	if err := VerifyBundle(bundle); err != nil {
+		t.Fatalf("корректный набор: %v", err)
+	}
+
+	forged, err := other.GenerateSignedPreKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle.SignedPreKey = forged.Key.PublicKey().Bytes()
+	if _, err := InitiateSession(other, bundle); !errors.Is(err, ErrBadPreKeySignature) {
+		t.Errorf("ожидалась ErrBadPreKeySignature, получено %v", err)
+	}
+}
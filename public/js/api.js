@@ -1,9 +1,14 @@
 // API и WebSocket коммуникация
 class API {
+    // Заголовки авторизации для запросов к API
+    static authHeaders(extra = {}) {
+        return Object.assign({ 'Authorization': `Bearer ${CONFIG.AUTH_TOKEN}` }, extra);
+    }
+
     // Получение списка чатов для пользователя
     static async getChats() {
         try {
-            const response = await fetch(`${CONFIG.API_URL}/api/chats?userId=${CONFIG.CURRENT_USER_ID}`);
+            const response = await fetch(`${CONFIG.API_URL}/api/chats?userId=${CONFIG.CURRENT_USER_ID}`, { headers: API.authHeaders() });
             if (!response.ok) {
                 throw new Error(`Ошибка HTTP: ${response.status}`);
             }
@@ -18,7 +23,7 @@ class API {
     // Получение сообщений для конкретного чата
     static async getMessages(chatWithId) {
         try {
-            const response = await fetch(`${CONFIG.API_URL}/api/messages?userId=${CONFIG.CURRENT_USER_ID}&chatWith=${chatWithId}`);
+            const response = await fetch(`${CONFIG.API_URL}/api/messages?userId=${CONFIG.CURRENT_USER_ID}&chatWith=${chatWithId}`, { headers: API.authHeaders() });
             if (!response.ok) {
                 throw new Error(`Ошибка HTTP: ${response.status}`);
             }
@@ -30,6 +35,24 @@ class API {
         }
     }
 
+    // Отметка сообщений чата прочитанными (до messageId включительно, без него - все)
+    static async markRead(chatId, messageId = 0) {
+        try {
+            const response = await fetch(`${CONFIG.API_URL}/api/chats/${chatId}/read?userId=${CONFIG.CURRENT_USER_ID}`, {
+                method: 'POST',
+                headers: API.authHeaders({ 'Content-Type': 'application/json' }),
+                body: JSON.stringify({ messageId })
+            });
+            if (!response.ok) {
+                throw new Error(`Ошибка HTTP: ${response.status}`);
+            }
+            return await response.json();
+        } catch (error) {
+            log('Ошибка при отметке сообщений прочитанными:', error);
+            return null;
+        }
+    }
+
     // Получение информации о пользователе по ID
     static async getUserInfo(userId) {
         try {
@@ -63,9 +86,9 @@ class API {
         try {
             const response = await fetch(`${CONFIG.API_URL}/api/status`, {
                 method: 'POST',
-                headers: {
+                headers: API.authHeaders({
                     'Content-Type': 'application/json',
-                },
+                }),
                 body: JSON.stringify({
                     userId: CONFIG.CURRENT_USER_ID,
                     status
@@ -98,7 +121,7 @@ class ChatSocket {
         }
 
         try {
-            this.socket = new WebSocket(`${CONFIG.WS_URL}/${CONFIG.CURRENT_USER_ID}`);
+            this.socket = new WebSocket(`${CONFIG.WS_URL}?token=${encodeURIComponent(CONFIG.AUTH_TOKEN)}`);
             
             this.socket.onopen = () => {
                 log('WebSocket соединение установлено');
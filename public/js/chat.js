@@ -363,6 +363,12 @@ class ChatApp {
             // Вставляем все сообщения в DOM
             this.chatMessages.appendChild(fragment);
             
+            // Загрузка истории не отмечает сообщения прочитанными - сообщаем о прочтении отдельно
+            const lastIncoming = filteredMessages.filter(msg => msg.fromId !== this.currentUser).pop();
+            if (lastIncoming && lastIncoming.status !== 'read') {
+                API.markRead(activeChatId, lastIncoming.id);
+            }
+            
             // Прокручиваем чат вниз
             setTimeout(() => {
                 this.chatMessages.scrollTop = this.chatMessages.scrollHeight;
@@ -21,7 +21,10 @@ const CONFIG = {
     
     // ID текущего пользователя (может быть изменен через интерфейс)
     // По умолчанию используем пользователя 1, но можно изменить через localStorage
-    CURRENT_USER_ID: parseInt(localStorage.getItem('currentUserId') || '1')
+    CURRENT_USER_ID: parseInt(localStorage.getItem('currentUserId') || '1'),
+
+    // Токен доступа, полученный через POST /api/auth (хранится в localStorage)
+    AUTH_TOKEN: localStorage.getItem('authToken') || ''
 };
 
 // Режим отладки
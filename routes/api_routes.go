@@ -5,27 +5,71 @@ import (
 	"database/sql"
 	"net/http"
 
+	"github.com/LilVoxy/coursework_chat/attachments"
+	"github.com/LilVoxy/coursework_chat/auth"
+	"github.com/LilVoxy/coursework_chat/keys"
 	"github.com/LilVoxy/coursework_chat/middleware"
+	"github.com/LilVoxy/coursework_chat/search"
 	"github.com/LilVoxy/coursework_chat/websocket"
 	"github.com/gorilla/mux"
 )
 
-// SetupRoutes настраивает все маршруты API и WebSocket
-func SetupRoutes(router *mux.Router, db *sql.DB, wsManager *websocket.Manager) {
+// SetupRoutes настраивает все маршруты API и WebSocket.
+// corsOrigins - источники, которым разрешены кросс-доменные запросы ("*" - любые).
+func SetupRoutes(router *mux.Router, db *sql.DB, wsManager *websocket.Manager, authenticator *auth.Authenticator,
+	attachmentService *attachments.Service, searchIndex *search.Index, corsOrigins []string) {
 	// Применяем CORS middleware
-	router.Use(middleware.CORSMiddleware)
+	router.Use(middleware.CORS(corsOrigins))
 
-	// WebSocket соединения
-	router.HandleFunc("/ws/{userId}", wsManager.HandleConnections)
+	// Все маршруты, кроме получения токена и статических файлов, требуют авторизации
+	protected := authenticator.Middleware
+
+	// Выпуск токенов
+	router.HandleFunc("/api/auth", authenticator.LoginHandler(db)).Methods("POST", "OPTIONS")
+
+	// WebSocket соединения (токен передается в параметре token или заголовке Authorization)
+	router.Handle("/ws", protected(http.HandlerFunc(wsManager.HandleConnections)))
+	router.Handle("/ws/{userId}", protected(http.HandlerFunc(wsManager.HandleConnections)))
 
 	// API статусов
-	router.HandleFunc("/api/status", wsManager.HandleStatus).Methods("POST", "OPTIONS")
+	router.Handle("/api/status", protected(http.HandlerFunc(wsManager.HandleStatus))).Methods("POST", "OPTIONS")
+
+	// Очереди отправки WebSocket-соединений узла
+	router.Handle("/api/ws/queues", protected(http.HandlerFunc(wsManager.HandleQueueMetrics))).Methods("GET", "OPTIONS")
 
 	// API чатов
-	router.HandleFunc("/api/chats", GetChatsHandler(db)).Methods("GET", "OPTIONS")
+	router.Handle("/api/chats", protected(GetChatsHandler(db, wsManager))).Methods("GET", "OPTIONS")
+
+	// API участников чатов
+	router.Handle("/api/chats/{chatId}/participants", protected(GetParticipantsHandler(wsManager))).Methods("GET", "OPTIONS")
+	router.Handle("/api/chats/{chatId}/participants", protected(AddParticipantHandler(wsManager))).Methods("POST")
+	router.Handle("/api/chats/{chatId}/participants/{userId}", protected(RemoveParticipantHandler(wsManager))).Methods("DELETE", "OPTIONS")
 
 	// API сообщений
-	router.HandleFunc("/api/messages", GetMessagesHandler(db)).Methods("GET", "OPTIONS")
+	router.Handle("/api/messages", protected(GetMessagesHandler(db, wsManager.Messages))).Methods("GET", "OPTIONS")
+	router.Handle("/api/messages", protected(SendMessageHandler(wsManager.Messages))).Methods("POST")
+	router.Handle("/api/chats/{chatId}/messages", protected(GetChatMessagesHandler(db, wsManager.Messages))).Methods("GET", "OPTIONS")
+	router.Handle("/api/chats/{chatId}/read", protected(MarkChatReadHandler(db, wsManager))).Methods("POST", "OPTIONS")
+	router.Handle("/api/messages/{messageId}", protected(EditMessageHandler(wsManager))).Methods("PATCH", "OPTIONS")
+	router.Handle("/api/messages/{messageId}", protected(DeleteMessageHandler(wsManager))).Methods("DELETE")
+	router.Handle("/api/messages/{messageId}/history", protected(GetMessageHistoryHandler(wsManager))).Methods("GET", "OPTIONS")
+
+	// Каталог публичных ключей устройств и сквозное шифрование чатов
+	keyDirectory := keys.NewDirectory(db)
+	router.Handle("/api/keys", protected(RegisterKeyHandler(keyDirectory))).Methods("POST", "OPTIONS")
+	router.Handle("/api/keys/{userId:[0-9]+}", protected(GetUserKeysHandler(keyDirectory))).Methods("GET", "OPTIONS")
+	router.Handle("/api/keys/{fingerprint:[0-9a-fA-F]{64}}", protected(RevokeKeyHandler(keyDirectory))).Methods("DELETE", "OPTIONS")
+	router.Handle("/api/keys/{fingerprint:[0-9a-fA-F]{64}}/prekeys", protected(UploadPreKeysHandler(keyDirectory))).Methods("PUT", "OPTIONS")
+	router.Handle("/api/keys/{userId:[0-9]+}/bundles", protected(GetBundlesHandler(keyDirectory))).Methods("GET", "OPTIONS")
+	router.Handle("/api/chats/{chatId}/e2e", protected(EnableE2EHandler(wsManager.Messages))).Methods("POST", "OPTIONS")
+
+	// Поиск по сообщениям
+	router.Handle("/api/search", protected(SearchMessagesHandler(searchIndex))).Methods("GET", "OPTIONS")
+
+	// API вложений
+	router.Handle("/api/chats/{chatId}/attachments", protected(UploadAttachmentHandler(attachmentService))).Methods("POST", "OPTIONS")
+	router.Handle("/api/attachments/{attachmentId}", protected(DownloadAttachmentHandler(attachmentService, false))).Methods("GET", "OPTIONS")
+	router.Handle("/api/attachments/{attachmentId}/thumbnail", protected(DownloadAttachmentHandler(attachmentService, true))).Methods("GET", "OPTIONS")
 
 	// Статические файлы
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("public")))
@@ -0,0 +1,150 @@
+// routes/attachment_handlers.go
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/LilVoxy/coursework_chat/attachments"
+	"github.com/gorilla/mux"
+)
+
+// Запас на заголовки multipart сверх максимального размера файла
+const multipartOverhead = 64 << 10
+
+// writeAttachmentError переводит ошибку работы с вложением в HTTP-ответ
+func writeAttachmentError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, attachments.ErrTooLarge), errors.As(err, &maxBytesErr):
+		http.Error(w, attachments.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, attachments.ErrTypeNotAllowed):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, attachments.ErrEmptyAttachment):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, attachments.ErrE2EChat):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, attachments.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, attachments.ErrNotFound), errors.Is(err, attachments.ErrNoThumbnail):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Printf("❌ Ошибка при работе с вложением: %v", err)
+		http.Error(w, "Ошибка при работе с вложением", http.StatusInternalServerError)
+	}
+}
+
+// UploadAttachmentHandler принимает файл (multipart/form-data, поле file) от участника чата.
+// В ответ возвращаются метаданные вложения; его id передается в поле attachmentId сообщения.
+func UploadAttachmentHandler(service *attachments.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		chatID, ok := chatIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, service.MaxSize()+multipartOverhead)
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "Ожидается multipart/form-data", http.StatusBadRequest)
+			return
+		}
+
+		// Читаем части запроса потоком, не сохраняя файл во временный каталог
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				http.Error(w, "Отсутствует поле file", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				writeAttachmentError(w, err)
+				return
+			}
+			if part.FormName() != "file" {
+				part.Close()
+				continue
+			}
+
+			att, err := service.Upload(r.Context(), chatID, userId, part.FileName(), part)
+			part.Close()
+			if err != nil {
+				writeAttachmentError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			if err := json.NewEncoder(w).Encode(att); err != nil {
+				log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// attachmentIDFromPath разбирает ID вложения из пути запроса
+func attachmentIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["attachmentId"])
+	if err != nil || id <= 0 {
+		http.Error(w, "Неверный формат ID вложения", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// DownloadAttachmentHandler отдает файл вложения участнику чата, в который он загружен.
+// При thumbnail=true отдается миниатюра изображения.
+func DownloadAttachmentHandler(service *attachments.Service, thumbnail bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		attachmentID, ok := attachmentIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		open := service.Open
+		if thumbnail {
+			open = service.OpenThumbnail
+		}
+		att, body, err := open(r.Context(), attachmentID, userId)
+		if err != nil {
+			writeAttachmentError(w, err)
+			return
+		}
+		defer body.Close()
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		if thumbnail {
+			w.Header().Set("Content-Type", "image/jpeg")
+		} else {
+			w.Header().Set("Content-Type", att.MimeType)
+			w.Header().Set("Content-Length", fmt.Sprint(att.Size))
+			w.Header().Set("ETag", `"`+att.SHA256+`"`)
+			// Изображения показываются в браузере, остальные файлы (например, PDF-счета) скачиваются
+			disposition := "attachment"
+			if att.Width > 0 {
+				disposition = "inline"
+			}
+			w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": att.FileName}))
+		}
+
+		if _, err := io.Copy(w, body); err != nil {
+			log.Printf("⚠️ Передача вложения %d прервана: %v", attachmentID, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+// routes/auth_helpers.go
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/LilVoxy/coursework_chat/auth"
+)
+
+// authenticatedUserID возвращает ID пользователя из токена запроса.
+// Если в запросе явно передан ID пользователя (для обратной совместимости),
+// он должен совпадать с аутентифицированным, иначе запрос отклоняется.
+func authenticatedUserID(w http.ResponseWriter, r *http.Request, requestedID string) (int, bool) {
+	userId, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Требуется авторизация", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	if requestedID != "" {
+		id, err := strconv.Atoi(requestedID)
+		if err != nil {
+			http.Error(w, "Неверный формат ID пользователя", http.StatusBadRequest)
+			return 0, false
+		}
+		if id != userId {
+			http.Error(w, "Доступ к данным другого пользователя запрещен", http.StatusForbidden)
+			return 0, false
+		}
+	}
+
+	return userId, true
+}
@@ -6,20 +6,24 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"strconv"
 	"time"
+
+	"github.com/LilVoxy/coursework_chat/websocket"
 )
 
 // ChatInfo структура для информации о чате
 type ChatInfo struct {
-	ID              int       `json:"id"`
-	BuyerID         int       `json:"buyerId"`
-	SellerID        int       `json:"sellerId"`
-	ProductID       int       `json:"productId"`
-	LastMessage     string    `json:"lastMessage"`
-	LastMessageTime string    `json:"lastMessageTime"`
-	UnreadCount     int       `json:"unreadCount"`
-	CreatedAt       time.Time `json:"createdAt"`
+	ID              int                     `json:"id"`
+	BuyerID         int                     `json:"buyerId"`
+	SellerID        int                     `json:"sellerId"`
+	ProductID       int                     `json:"productId"`
+	Role            string                  `json:"role"` // Роль пользователя в чате
+	Participants    []websocket.Participant `json:"participants"`
+	E2E             bool                    `json:"e2e"`         // Сквозное шифрование: в чате хранятся только шифротексты
+	LastMessage     string                  `json:"lastMessage"` // Пустой, если последнее сообщение зашифровано на клиенте
+	LastMessageTime string                  `json:"lastMessageTime"`
+	UnreadCount     int                     `json:"unreadCount"` // Сообщения других участников после курсора прочтения пользователя
+	CreatedAt       time.Time               `json:"createdAt"`
 }
 
 // ChatsResponse структура ответа API для списка чатов
@@ -28,7 +32,7 @@ type ChatsResponse struct {
 }
 
 // GetChatsHandler обрабатывает запросы на получение списка чатов
-func GetChatsHandler(db *sql.DB) http.HandlerFunc {
+func GetChatsHandler(db *sql.DB, wsManager *websocket.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Получаем параметры запроса
 		query := r.URL.Query()
@@ -39,16 +43,9 @@ func GetChatsHandler(db *sql.DB) http.HandlerFunc {
 			userIdStr = query.Get("user_id")
 		}
 
-		// Проверяем параметры
-		if userIdStr == "" {
-			http.Error(w, "Отсутствует обязательный параметр userId или user_id", http.StatusBadRequest)
-			return
-		}
-
-		// Преобразуем ID в число
-		userId, err := strconv.Atoi(userIdStr)
-		if err != nil {
-			http.Error(w, "Неверный формат ID пользователя", http.StatusBadRequest)
+		// ID пользователя берется из токена; параметр userId, если передан, должен с ним совпадать
+		userId, ok := authenticatedUserID(w, r, userIdStr)
+		if !ok {
 			return
 		}
 
@@ -60,6 +57,11 @@ func GetChatsHandler(db *sql.DB) http.HandlerFunc {
 				c.seller_id, 
 				c.product_id, 
 				c.created_at,
+				c.e2e,
+				p.role,
+				(SELECT COUNT(*) FROM messages 
+				 WHERE chat_id = c.id AND sender_id != p.user_id 
+				   AND id > p.last_read_message_id) as unread_count,
 				IFNULL(
 					(SELECT message FROM messages 
 					 WHERE chat_id = c.id 
@@ -72,10 +74,11 @@ func GetChatsHandler(db *sql.DB) http.HandlerFunc {
 					 ORDER BY created_at DESC LIMIT 1),
 					c.created_at
 				) as last_message_time
-			FROM chats c
-			WHERE c.buyer_id = ? OR c.seller_id = ?
+			FROM chat_participants p
+			JOIN chats c ON c.id = p.chat_id
+			WHERE p.user_id = ?
 			ORDER BY last_message_time DESC
-		`, userId, userId)
+		`, userId)
 
 		if err != nil {
 			log.Printf("❌ Ошибка при запросе чатов: %v", err)
@@ -99,6 +102,9 @@ func GetChatsHandler(db *sql.DB) http.HandlerFunc {
 				&chat.SellerID,
 				&chat.ProductID,
 				&chat.CreatedAt,
+				&chat.E2E,
+				&chat.Role,
+				&chat.UnreadCount,
 				&chat.LastMessage,
 				&lastMessageTime,
 			)
@@ -107,6 +113,8 @@ func GetChatsHandler(db *sql.DB) http.HandlerFunc {
 				continue
 			}
 
+			chat.LastMessage = wsManager.Messages.Decrypt(chat.LastMessage)
+
 			// Форматируем время последнего сообщения
 			chat.LastMessageTime = lastMessageTime.Format("15:04")
 
@@ -127,6 +135,17 @@ func GetChatsHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Ошибка при обработке чатов", http.StatusInternalServerError)
 			return
 		}
+		rows.Close()
+
+		// Добавляем состав участников каждого чата
+		for i := range chats {
+			participants, err := wsManager.GetChatParticipants(chats[i].ID)
+			if err != nil {
+				log.Printf("❌ Ошибка при получении участников чата %d: %v", chats[i].ID, err)
+				continue
+			}
+			chats[i].Participants = participants
+		}
 
 		// Подготавливаем ответ
 		response := ChatsResponse{
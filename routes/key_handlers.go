@@ -0,0 +1,217 @@
+// routes/key_handlers.go
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/LilVoxy/coursework_chat/keys"
+	"github.com/LilVoxy/coursework_chat/messages"
+	"github.com/LilVoxy/coursework_chat/processor"
+	"github.com/gorilla/mux"
+)
+
+// RegisterKeyRequest структура запроса на публикацию ключа устройства
+type RegisterKeyRequest struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"publicKey"` // PEM или base64 (PKIX)
+	Label     string `json:"label"`     // Название устройства
+}
+
+// KeysResponse структура ответа API для ключей пользователя
+type KeysResponse struct {
+	UserID int              `json:"userId"`
+	Keys   []keys.PublicKey `json:"keys"`
+}
+
+// PreKeysResponse структура ответа на загрузку предварительных ключей
+type PreKeysResponse struct {
+	Fingerprint    string `json:"fingerprint"`
+	OneTimePreKeys int    `json:"oneTimePreKeys"` // Сколько одноразовых ключей еще не выдано
+}
+
+// BundlesResponse структура ответа с наборами ключей устройств пользователя
+type BundlesResponse struct {
+	UserID  int                 `json:"userId"`
+	Bundles []keys.DeviceBundle `json:"bundles"`
+}
+
+// writeKeyError переводит ошибку каталога ключей в HTTP-ответ
+func writeKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, keys.ErrInvalidKey), errors.Is(err, keys.ErrUnsupportedAlgorithm),
+		errors.Is(err, keys.ErrWeakKey), errors.Is(err, keys.ErrNotRatchetKey), errors.Is(err, keys.ErrInvalidPreKey),
+		errors.Is(err, processor.ErrInvalidBundle), errors.Is(err, processor.ErrBadPreKeySignature):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, keys.ErrKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, keys.ErrKeyTaken), errors.Is(err, keys.ErrTooManyKeys):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("❌ Ошибка каталога ключей: %v", err)
+		http.Error(w, "Ошибка при работе с ключами", http.StatusInternalServerError)
+	}
+}
+
+// RegisterKeyHandler публикует публичный ключ устройства пользователя: POST /api/keys.
+// Возвращает 201 для нового ключа и 200, если ключ уже был опубликован.
+func RegisterKeyHandler(directory *keys.Directory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+
+		var req RegisterKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+			return
+		}
+
+		key, created, err := directory.Register(userId, req.Algorithm, req.PublicKey, req.Label)
+		if err != nil {
+			writeKeyError(w, err)
+			return
+		}
+
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+			log.Printf("✅ Пользователь %d опубликовал ключ %s", userId, key.Fingerprint)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(key); err != nil {
+			log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+		}
+	}
+}
+
+// GetUserKeysHandler возвращает действующие ключи пользователя: GET /api/keys/{userId}.
+// Клиент шифрует сообщение для каждого из этих ключей и сверяет отпечатки с ранее увиденными.
+func GetUserKeysHandler(directory *keys.Directory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticatedUserID(w, r, ""); !ok {
+			return
+		}
+		userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+		if err != nil || userID <= 0 {
+			http.Error(w, "Неверный формат ID пользователя", http.StatusBadRequest)
+			return
+		}
+
+		list, err := directory.List(userID)
+		if err != nil {
+			writeKeyError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(KeysResponse{UserID: userID, Keys: list}); err != nil {
+			log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+		}
+	}
+}
+
+// RevokeKeyHandler отзывает собственный ключ пользователя: DELETE /api/keys/{fingerprint}
+func RevokeKeyHandler(directory *keys.Directory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		fingerprint := mux.Vars(r)["fingerprint"]
+
+		if err := directory.Revoke(userId, fingerprint); err != nil {
+			writeKeyError(w, err)
+			return
+		}
+
+		log.Printf("✅ Пользователь %d отозвал ключ %s", userId, fingerprint)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// UploadPreKeysHandler загружает предварительные ключи собственного устройства:
+// PUT /api/keys/{fingerprint}/prekeys. Клиент повторяет загрузку, когда одноразовых ключей остается мало.
+func UploadPreKeysHandler(directory *keys.Directory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		fingerprint := mux.Vars(r)["fingerprint"]
+
+		var upload keys.PreKeyUpload
+		if err := json.NewDecoder(r.Body).Decode(&upload); err != nil {
+			http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+			return
+		}
+
+		remaining, err := directory.UploadPreKeys(userId, fingerprint, &upload)
+		if err != nil {
+			writeKeyError(w, err)
+			return
+		}
+
+		log.Printf("✅ Пользователь %d загрузил предварительные ключи для %s (одноразовых: %d)", userId, fingerprint, remaining)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(PreKeysResponse{Fingerprint: fingerprint, OneTimePreKeys: remaining}); err != nil {
+			log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+		}
+	}
+}
+
+// GetBundlesHandler выдает наборы предварительных ключей устройств пользователя: GET /api/keys/{userId}/bundles.
+// Каждый вызов расходует по одному одноразовому ключу каждого устройства.
+func GetBundlesHandler(directory *keys.Directory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticatedUserID(w, r, ""); !ok {
+			return
+		}
+		userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+		if err != nil || userID <= 0 {
+			http.Error(w, "Неверный формат ID пользователя", http.StatusBadRequest)
+			return
+		}
+
+		bundles, err := directory.FetchBundles(userID)
+		if err != nil {
+			writeKeyError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(BundlesResponse{UserID: userID, Bundles: bundles}); err != nil {
+			log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+		}
+	}
+}
+
+// EnableE2EHandler включает в чате сквозное шифрование: POST /api/chats/{chatId}/e2e.
+// После этого сервер принимает в чате только сообщения, зашифрованные на клиенте.
+func EnableE2EHandler(service *messages.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		chatID, ok := chatIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		if _, err := service.EnableE2E(chatID, userId); err != nil {
+			writeSendError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"chatId": chatID, "e2e": true}); err != nil {
+			log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+		}
+	}
+}
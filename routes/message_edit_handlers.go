@@ -0,0 +1,129 @@
+// routes/message_edit_handlers.go
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/LilVoxy/coursework_chat/websocket"
+	"github.com/gorilla/mux"
+)
+
+// EditMessageRequest структура запроса на редактирование сообщения
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// MessageHistoryResponse структура ответа API для истории правок
+type MessageHistoryResponse struct {
+	MessageID int                     `json:"messageId"`
+	History   []websocket.MessageEdit `json:"history"`
+}
+
+// messageIDFromPath разбирает ID сообщения из пути запроса
+func messageIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	messageID, err := strconv.Atoi(mux.Vars(r)["messageId"])
+	if err != nil || messageID <= 0 {
+		http.Error(w, "Неверный формат ID сообщения", http.StatusBadRequest)
+		return 0, false
+	}
+	return messageID, true
+}
+
+// writeMessageChangeError переводит ошибку изменения сообщения в HTTP-ответ
+func writeMessageChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, websocket.ErrMessageNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, websocket.ErrNotAuthor), errors.Is(err, websocket.ErrNotParticipant):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, websocket.ErrMessageDeleted), errors.Is(err, websocket.ErrEncryptedEdit):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, websocket.ErrEmptyContent), errors.Is(err, websocket.ErrInvalidScope):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("❌ Ошибка при изменении сообщения: %v", err)
+		http.Error(w, "Ошибка при изменении сообщения", http.StatusInternalServerError)
+	}
+}
+
+// EditMessageHandler редактирует собственное сообщение пользователя
+func EditMessageHandler(wsManager *websocket.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		messageID, ok := messageIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req EditMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+			return
+		}
+
+		if err := wsManager.EditMessage(messageID, userId, req.Content); err != nil {
+			writeMessageChangeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeleteMessageHandler удаляет сообщение: ?scope=me (по умолчанию) или ?scope=everyone
+func DeleteMessageHandler(wsManager *websocket.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		messageID, ok := messageIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		scope := r.URL.Query().Get("scope")
+		if scope == "" {
+			scope = websocket.DeleteScopeMe
+		}
+
+		if err := wsManager.DeleteMessage(messageID, userId, scope); err != nil {
+			writeMessageChangeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetMessageHistoryHandler возвращает предыдущие версии сообщения
+func GetMessageHistoryHandler(wsManager *websocket.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		messageID, ok := messageIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		history, err := wsManager.GetMessageHistory(messageID, userId)
+		if err != nil {
+			writeMessageChangeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(MessageHistoryResponse{MessageID: messageID, History: history}); err != nil {
+			log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+		}
+	}
+}
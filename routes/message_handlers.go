@@ -4,12 +4,21 @@ package routes
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
-	"github.com/LilVoxy/coursework_chat/websocket"
+	"github.com/LilVoxy/coursework_chat/attachments"
+	"github.com/LilVoxy/coursework_chat/messages"
+)
+
+const (
+	// Размер страницы истории по умолчанию и максимальный
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 200
 )
 
 // Message структура для сообщений
@@ -23,20 +32,256 @@ type Message struct {
 	Timestamp  string    `json:"timestamp"`
 	CreatedAt  time.Time `json:"createdAt"`
 	ReadStatus bool      `json:"readStatus"`
+
+	// Состояние доставки: sent, delivered или read
+	State       string     `json:"state"`
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+	ReadAt      *time.Time `json:"readAt,omitempty"`
+
+	// Правка и удаление: у удаленного для всех сообщения текст пустой
+	Edited    bool       `json:"edited"`
+	EditedAt  *time.Time `json:"editedAt,omitempty"`
+	Deleted   bool       `json:"deleted"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+
+	// Вложение (файл или изображение)
+	Attachment *attachments.Attachment `json:"attachment,omitempty"`
+
+	// Сообщение, зашифрованное на клиенте (content при этом пустой)
+	Encrypted *messages.EncryptedPayload `json:"encrypted,omitempty"`
 }
 
-// MessagesResponse структура ответа API для сообщений
+// MessagesResponse структура ответа API для сообщений.
+// Сообщения страницы всегда упорядочены от старых к новым.
 type MessagesResponse struct {
 	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"hasMore"`
+	// Курсор следующей страницы: значение для before (при листании назад)
+	// или для after (при запросе с after); null, если страниц больше нет
+	NextCursor *int `json:"nextCursor"`
+}
+
+// messagePage - параметры страницы истории по ключу (ID сообщения)
+type messagePage struct {
+	Before int // Сообщения с ID меньше before
+	After  int // Сообщения с ID больше after
+	Limit  int
+}
+
+// forward сообщает, что страница листается вперед (от after к новым сообщениям)
+func (p messagePage) forward() bool {
+	return p.After > 0
+}
+
+// parseMessagePage разбирает параметры before, after и limit
+func parseMessagePage(query url.Values) (messagePage, error) {
+	page := messagePage{Limit: defaultMessagesLimit}
+
+	parse := func(name string, dst *int) error {
+		v := query.Get(name)
+		if v == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errors.New("неверное значение параметра " + name)
+		}
+		*dst = n
+		return nil
+	}
+	if err := parse("before", &page.Before); err != nil {
+		return page, err
+	}
+	if err := parse("after", &page.After); err != nil {
+		return page, err
+	}
+	if err := parse("limit", &page.Limit); err != nil {
+		return page, err
+	}
+
+	if page.Limit <= 0 {
+		page.Limit = defaultMessagesLimit
+	}
+	if page.Limit > maxMessagesLimit {
+		page.Limit = maxMessagesLimit
+	}
+	if page.Before > 0 && page.After > 0 && page.After >= page.Before {
+		return page, errors.New("параметр after должен быть меньше before")
+	}
+	return page, nil
+}
+
+// loadMessagesPage загружает страницу сообщений из чатов, выбранных chatFilter (подзапрос,
+// возвращающий chat_id), без сообщений, скрытых пользователем. Чтение истории не меняет
+// состояние прочтения - для этого есть отдельный запрос.
+// toID подставляется получателем собственных сообщений пользователя (0 для групповых чатов).
+func loadMessagesPage(db *sql.DB, service *messages.Service, userId, toID int, chatFilter string, filterArgs []interface{}, page messagePage) (MessagesResponse, error) {
+	args := append([]interface{}{userId, toID}, filterArgs...)
+	args = append(args, userId)
+
+	keyset := ""
+	if page.Before > 0 {
+		keyset += " AND m.id < ?"
+		args = append(args, page.Before)
+	}
+	if page.After > 0 {
+		keyset += " AND m.id > ?"
+		args = append(args, page.After)
+	}
+
+	// Без after берем самые новые сообщения (перед before), с after - самые старые после него
+	order := "DESC"
+	if page.forward() {
+		order = "ASC"
+	}
+	// Запрашиваем на одно сообщение больше, чтобы узнать, есть ли следующая страница
+	args = append(args, page.Limit+1)
+
+	rows, err := db.Query(`
+		SELECT m.id, m.chat_id, m.sender_id as from_id,
+			   CASE
+				   WHEN m.sender_id = ? THEN ?
+				   ELSE m.sender_id
+			   END as to_id,
+			   c.product_id, m.message as content, m.created_at, m.read_status,
+			   m.delivered_at, m.read_at, m.edited_at, m.deleted_at,
+			   m.attachment_id, e.payload
+		FROM messages m
+		JOIN chats c ON m.chat_id = c.id
+		LEFT JOIN message_e2e_payloads e ON e.message_id = m.id
+		WHERE m.chat_id IN (`+chatFilter+`
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id = ?
+		)`+keyset+`
+		ORDER BY m.id `+order+`
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return MessagesResponse{}, err
+	}
+	defer rows.Close()
+
+	// Создаем слайс для хранения сообщений
+	list := []Message{}
+	attachmentIDs := make(map[int]int) // Индекс сообщения -> ID вложения
+
+	// Обрабатываем результаты запроса
+	for rows.Next() {
+		var msg Message
+		var createdAt time.Time
+		var readStatus sql.NullBool
+		var deliveredAt, readAt, editedAt, deletedAt sql.NullTime
+		var attachmentID sql.NullInt64
+		var payload sql.NullString
+
+		// Сканируем данные строки
+		err := rows.Scan(&msg.ID, &msg.ChatID, &msg.FromID, &msg.ToID, &msg.ProductID, &msg.Content, &createdAt, &readStatus,
+			&deliveredAt, &readAt, &editedAt, &deletedAt, &attachmentID, &payload)
+		if err != nil {
+			log.Printf("❌ Ошибка при сканировании сообщения: %v", err)
+			continue
+		}
+
+		// Форматируем время
+		msg.CreatedAt = createdAt
+		msg.Timestamp = createdAt.Format("15:04")
+
+		// Обрабатываем статус прочтения (если NULL, то считаем непрочитанным)
+		msg.ReadStatus = readStatus.Valid && readStatus.Bool
+
+		// Определяем состояние доставки по сохраненным отметкам времени
+		msg.State = "sent"
+		if deliveredAt.Valid {
+			msg.State = "delivered"
+			msg.DeliveredAt = &deliveredAt.Time
+		}
+		if readAt.Valid || msg.ReadStatus {
+			msg.State = "read"
+		}
+		if readAt.Valid {
+			msg.ReadAt = &readAt.Time
+		}
+
+		// Отмечаем отредактированные и удаленные сообщения
+		if editedAt.Valid {
+			msg.Edited = true
+			msg.EditedAt = &editedAt.Time
+		}
+		if deletedAt.Valid {
+			msg.Deleted = true
+			msg.DeletedAt = &deletedAt.Time
+			msg.Content = ""
+		} else {
+			msg.Content = service.Decrypt(msg.Content)
+			msg.Encrypted = messages.ParsePayload(payload)
+			if attachmentID.Valid {
+				attachmentIDs[len(list)] = int(attachmentID.Int64)
+			}
+		}
+
+		// Добавляем сообщение в слайс
+		list = append(list, msg)
+	}
+
+	// Проверяем ошибки после итерации
+	if err := rows.Err(); err != nil {
+		return MessagesResponse{}, err
+	}
+	rows.Close()
+
+	// Метаданные вложений загружаем после чтения строк, чтобы не держать два соединения
+	for i, attachmentID := range attachmentIDs {
+		if list[i].Attachment, err = attachments.Load(db, attachmentID); err != nil {
+			log.Printf("⚠️ Не удалось загрузить вложение %d: %v", attachmentID, err)
+		}
+	}
+
+	response := MessagesResponse{}
+	if len(list) > page.Limit {
+		response.HasMore = true
+		list = list[:page.Limit]
+	}
+
+	// Страница отдается от старых сообщений к новым
+	if !page.forward() {
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+	}
+
+	if response.HasMore && len(list) > 0 {
+		cursor := list[0].ID
+		if page.forward() {
+			cursor = list[len(list)-1].ID
+		}
+		response.NextCursor = &cursor
+	}
+	response.Messages = list
+	return response, nil
+}
+
+// writeMessagesResponse отправляет страницу сообщений в формате JSON
+func writeMessagesResponse(w http.ResponseWriter, response MessagesResponse) {
+	// Устанавливаем заголовок для JSON
+	w.Header().Set("Content-Type", "application/json")
+
+	// Кодируем и отправляем ответ
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+		http.Error(w, "Ошибка при формировании ответа", http.StatusInternalServerError)
+	}
 }
 
 // GetMessagesHandler обрабатывает запросы на получение истории сообщений
-func GetMessagesHandler(db *sql.DB) http.HandlerFunc {
+// с собеседником (chatWith) или в указанном чате (chatId)
+func GetMessagesHandler(db *sql.DB, service *messages.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Получаем параметры запроса
 		query := r.URL.Query()
 		userIdStr := query.Get("userId")
 		chatWithStr := query.Get("chatWith")
+		chatIdStr := query.Get("chatId")
 
 		// Поддержка альтернативных форматов параметров (u_id и chat_with)
 		if userIdStr == "" {
@@ -47,126 +292,169 @@ func GetMessagesHandler(db *sql.DB) http.HandlerFunc {
 			chatWithStr = query.Get("chat_with")
 		}
 
-		// Проверяем параметры
-		if userIdStr == "" || chatWithStr == "" {
-			http.Error(w, "Отсутствуют обязательные параметры (userId/u_id и chatWith/chat_with)", http.StatusBadRequest)
+		// Проверяем параметры: история диалога с собеседником или история конкретного (группового) чата
+		if chatWithStr == "" && chatIdStr == "" {
+			http.Error(w, "Отсутствует обязательный параметр chatWith/chat_with или chatId", http.StatusBadRequest)
 			return
 		}
 
-		// Преобразуем ID в числа
-		userId, err := strconv.Atoi(userIdStr)
-		if err != nil {
-			http.Error(w, "Неверный формат ID пользователя", http.StatusBadRequest)
+		// ID пользователя берется из токена; параметр userId, если передан, должен с ним совпадать
+		userId, ok := authenticatedUserID(w, r, userIdStr)
+		if !ok {
 			return
 		}
 
-		chatWith, err := strconv.Atoi(chatWithStr)
+		page, err := parseMessagePage(query)
 		if err != nil {
-			http.Error(w, "Неверный формат ID собеседника", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// Получаем сообщения из базы данных
-		rows, err := db.Query(`
-			SELECT m.id, m.chat_id, m.sender_id as from_id, 
-				   CASE 
-					   WHEN m.sender_id = ? THEN ? 
-					   ELSE m.sender_id 
-				   END as to_id, 
-				   c.product_id, m.message as content, m.created_at, m.read_status
-			FROM messages m
-			JOIN chats c ON m.chat_id = c.id
-			WHERE m.chat_id IN (
-				SELECT id FROM chats
-				WHERE (buyer_id = ? AND seller_id = ?) 
-				   OR (buyer_id = ? AND seller_id = ?)
-			)
-			ORDER BY m.created_at ASC
-		`, userId, chatWith, userId, chatWith, chatWith, userId)
+		// Чаты, в которых пользователь участвует вместе с собеседником, либо один указанный чат
+		var chatWith, chatId int
+		var chatFilter string
+		var filterArgs []interface{}
+		if chatIdStr != "" {
+			chatId, err = strconv.Atoi(chatIdStr)
+			if err != nil {
+				http.Error(w, "Неверный формат ID чата", http.StatusBadRequest)
+				return
+			}
+			chatFilter = `
+				SELECT chat_id FROM chat_participants
+				WHERE user_id = ? AND chat_id = ?`
+			filterArgs = []interface{}{userId, chatId}
+		} else {
+			chatWith, err = strconv.Atoi(chatWithStr)
+			if err != nil {
+				http.Error(w, "Неверный формат ID собеседника", http.StatusBadRequest)
+				return
+			}
+			chatFilter = `
+				SELECT p1.chat_id FROM chat_participants p1
+				JOIN chat_participants p2 ON p2.chat_id = p1.chat_id
+				WHERE p1.user_id = ? AND p2.user_id = ?`
+			filterArgs = []interface{}{userId, chatWith}
+		}
 
+		// В групповом чате у собственных сообщений пользователя нет единственного получателя (toId = 0)
+		response, err := loadMessagesPage(db, service, userId, chatWith, chatFilter, filterArgs, page)
 		if err != nil {
 			log.Printf("❌ Ошибка при запросе сообщений: %v", err)
 			http.Error(w, "Ошибка при получении сообщений", http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
 
-		// Создаем слайс для хранения сообщений
-		var messages []Message
+		writeMessagesResponse(w, response)
 
-		// Обрабатываем результаты запроса
-		for rows.Next() {
-			var msg Message
-			var createdAt time.Time
-			var readStatus sql.NullBool
+		if chatId != 0 {
+			log.Printf("✅ Отправлено %d сообщений чата %d пользователю %d", len(response.Messages), chatId, userId)
+		} else {
+			log.Printf("✅ Отправлено %d сообщений для чата между пользователями %d и %d", len(response.Messages), userId, chatWith)
+		}
+	}
+}
 
-			// Сканируем данные строки
-			err := rows.Scan(&msg.ID, &msg.ChatID, &msg.FromID, &msg.ToID, &msg.ProductID, &msg.Content, &createdAt, &readStatus)
-			if err != nil {
-				log.Printf("❌ Ошибка при сканировании сообщения: %v", err)
-				continue
-			}
+// GetChatMessagesHandler возвращает страницу истории одного чата: GET /api/chats/{chatId}/messages
+func GetChatMessagesHandler(db *sql.DB, service *messages.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		chatID, ok := chatIDFromPath(w, r)
+		if !ok {
+			return
+		}
 
-			// Форматируем время
-			msg.CreatedAt = createdAt
-			msg.Timestamp = createdAt.Format("15:04")
+		page, err := parseMessagePage(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-			// Обрабатываем статус прочтения (если NULL, то считаем непрочитанным)
-			msg.ReadStatus = readStatus.Valid && readStatus.Bool
+		// Историю чата видят только его участники
+		var toID int
+		err = db.QueryRow(`
+			SELECT CASE WHEN (SELECT COUNT(*) FROM chat_participants WHERE chat_id = p.chat_id) = 2
+			            THEN (SELECT user_id FROM chat_participants WHERE chat_id = p.chat_id AND user_id != p.user_id)
+			            ELSE 0 END
+			FROM chat_participants p
+			WHERE p.chat_id = ? AND p.user_id = ?
+		`, chatID, userId).Scan(&toID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Пользователь не является участником чата", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Ошибка при проверке участия в чате %d: %v", chatID, err)
+			http.Error(w, "Ошибка при получении сообщений", http.StatusInternalServerError)
+			return
+		}
 
-			// Добавляем сообщение в слайс
-			messages = append(messages, msg)
+		response, err := loadMessagesPage(db, service, userId, toID, `?`, []interface{}{chatID}, page)
+		if err != nil {
+			log.Printf("❌ Ошибка при запросе сообщений чата %d: %v", chatID, err)
+			http.Error(w, "Ошибка при получении сообщений", http.StatusInternalServerError)
+			return
 		}
 
-		// Помечаем сообщения как прочитанные
-		if len(messages) > 0 {
-			_, err := db.Exec(`
-				UPDATE messages m
-				JOIN chats c ON m.chat_id = c.id
-				SET m.read_status = TRUE
-				WHERE m.sender_id != ?
-				AND m.read_status = FALSE
-				AND m.chat_id IN (
-					SELECT id FROM chats
-					WHERE (buyer_id = ? AND seller_id = ?) 
-					   OR (buyer_id = ? AND seller_id = ?)
-				)
-			`, userId, userId, chatWith, chatWith, userId)
+		writeMessagesResponse(w, response)
+		log.Printf("✅ Отправлено %d сообщений чата %d пользователю %d", len(response.Messages), chatID, userId)
+	}
+}
 
-			if err != nil {
-				log.Printf("❌ Ошибка при обновлении статуса прочтения: %v", err)
-			} else {
-				log.Printf("✅ Обновлен статус прочтения сообщений для чата между пользователями %d и %d", userId, chatWith)
-
-				// Отправляем уведомления об обновлении статуса
-				if manager := websocket.GetGlobalManager(); manager != nil {
-					go manager.SendReadStatusUpdates(userId, chatWith)
-				}
-			}
+// writeSendError переводит ошибку отправки сообщения в HTTP-ответ
+func writeSendError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, messages.ErrInvalidMessage), errors.Is(err, messages.ErrNoSeller),
+		errors.Is(err, messages.ErrSelfChat), errors.Is(err, messages.ErrInvalidPayload),
+		errors.Is(err, messages.ErrUnknownRecipientKey), errors.Is(err, messages.ErrMissingSignature):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, messages.ErrNotParticipant), errors.Is(err, messages.ErrInvalidSignature):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, messages.ErrChatNotFound), errors.Is(err, messages.ErrProductNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, attachments.ErrNotClaimable), errors.Is(err, messages.ErrE2ERequired):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("❌ Ошибка при отправке сообщения: %v", err)
+		http.Error(w, "Ошибка при отправке сообщения", http.StatusInternalServerError)
+	}
+}
+
+// SendMessageHandler отправляет сообщение: POST /api/messages.
+// Сообщение сохраняется и рассылается так же, как отправленное через WebSocket.
+// Повтор запроса с тем же clientMsgId не создает дубликат (ответ 200 вместо 201).
+func SendMessageHandler(service *messages.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
 		}
 
-		// Проверяем ошибки после итерации
-		if err = rows.Err(); err != nil {
-			log.Printf("❌ Ошибка при итерации по сообщениям: %v", err)
-			http.Error(w, "Ошибка при обработке сообщений", http.StatusInternalServerError)
+		var req messages.SendRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
 			return
 		}
+		req.FromID = userId
 
-		// Подготавливаем ответ
-		response := MessagesResponse{
-			Messages: messages,
+		msg, err := service.Send(req)
+		if err != nil {
+			writeSendError(w, err)
+			return
 		}
 
-		// Устанавливаем заголовок для JSON
+		// Повторная отправка с тем же clientMsgId возвращает ранее сохраненное сообщение
+		status := http.StatusCreated
+		if msg.Duplicate {
+			status = http.StatusOK
+		}
 		w.Header().Set("Content-Type", "application/json")
-
-		// Кодируем и отправляем ответ
-		if err := json.NewEncoder(w).Encode(response); err != nil {
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(msg); err != nil {
 			log.Printf("❌ Ошибка при кодировании JSON: %v", err)
-			http.Error(w, "Ошибка при формировании ответа", http.StatusInternalServerError)
-			return
 		}
-
-		log.Printf("✅ Отправлено %d сообщений для чата между пользователями %d и %d", len(messages), userId, chatWith)
 	}
 }
@@ -0,0 +1,142 @@
+// routes/participant_handlers.go
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/LilVoxy/coursework_chat/websocket"
+	"github.com/gorilla/mux"
+)
+
+// ParticipantsResponse структура ответа API для участников чата
+type ParticipantsResponse struct {
+	ChatID       int                     `json:"chatId"`
+	Participants []websocket.Participant `json:"participants"`
+}
+
+// AddParticipantRequest структура запроса на добавление участника
+type AddParticipantRequest struct {
+	UserID int    `json:"userId"`
+	Role   string `json:"role"`
+}
+
+// chatIDFromPath разбирает ID чата из пути запроса
+func chatIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	chatID, err := strconv.Atoi(mux.Vars(r)["chatId"])
+	if err != nil || chatID <= 0 {
+		http.Error(w, "Неверный формат ID чата", http.StatusBadRequest)
+		return 0, false
+	}
+	return chatID, true
+}
+
+// writeParticipantError переводит ошибку изменения состава чата в HTTP-ответ
+func writeParticipantError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, websocket.ErrNotParticipant), errors.Is(err, websocket.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, websocket.ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, websocket.ErrAlreadyMember):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("❌ Ошибка при изменении состава чата: %v", err)
+		http.Error(w, "Ошибка при изменении состава чата", http.StatusInternalServerError)
+	}
+}
+
+// GetParticipantsHandler возвращает участников чата. Доступно только участникам.
+func GetParticipantsHandler(wsManager *websocket.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		chatID, ok := chatIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		participants, err := wsManager.GetChatParticipants(chatID)
+		if err != nil {
+			log.Printf("❌ Ошибка при получении участников чата %d: %v", chatID, err)
+			http.Error(w, "Ошибка при получении участников чата", http.StatusInternalServerError)
+			return
+		}
+
+		isMember := false
+		for _, p := range participants {
+			if p.UserID == userId {
+				isMember = true
+				break
+			}
+		}
+		if !isMember {
+			http.Error(w, websocket.ErrNotParticipant.Error(), http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ParticipantsResponse{ChatID: chatID, Participants: participants}); err != nil {
+			log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+		}
+	}
+}
+
+// AddParticipantHandler добавляет участника в чат (продавец или агент поддержки)
+func AddParticipantHandler(wsManager *websocket.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		chatID, ok := chatIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req AddParticipantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID <= 0 {
+			http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+			return
+		}
+
+		if err := wsManager.AddChatParticipant(chatID, userId, req.UserID, req.Role); err != nil {
+			writeParticipantError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RemoveParticipantHandler удаляет участника из чата или позволяет пользователю покинуть чат
+func RemoveParticipantHandler(wsManager *websocket.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		chatID, ok := chatIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		memberID, err := strconv.Atoi(mux.Vars(r)["userId"])
+		if err != nil || memberID <= 0 {
+			http.Error(w, "Неверный формат ID пользователя", http.StatusBadRequest)
+			return
+		}
+
+		if err := wsManager.RemoveChatParticipant(chatID, userId, memberID); err != nil {
+			writeParticipantError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,86 @@
+// routes/read_handlers.go
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/LilVoxy/coursework_chat/websocket"
+)
+
+// MarkReadRequest структура запроса на отметку о прочтении
+type MarkReadRequest struct {
+	// ID последнего прочитанного сообщения; 0 - все сообщения чата
+	MessageID int `json:"messageId"`
+}
+
+// MarkReadResponse структура ответа на отметку о прочтении
+type MarkReadResponse struct {
+	ChatID     int   `json:"chatId"`
+	MessageIDs []int `json:"messageIds"` // Сообщения, прочитанные этим запросом
+}
+
+// MarkChatReadHandler сдвигает курсор прочтения пользователя в чате: POST /api/chats/{chatId}/read.
+// Это REST-аналог WebSocket-кадра read; получение истории состояние прочтения не меняет.
+func MarkChatReadHandler(db *sql.DB, wsManager *websocket.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := authenticatedUserID(w, r, "")
+		if !ok {
+			return
+		}
+		chatID, ok := chatIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		// Тело запроса необязательно
+		var req MarkReadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); (err != nil && err != io.EOF) || req.MessageID < 0 {
+			http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+			return
+		}
+
+		// Без messageId отмечаем прочитанным весь чат; иначе сообщение должно принадлежать этому чату
+		if req.MessageID == 0 {
+			if err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM messages WHERE chat_id = ?`, chatID).Scan(&req.MessageID); err != nil {
+				log.Printf("❌ Ошибка при получении последнего сообщения чата %d: %v", chatID, err)
+				http.Error(w, "Ошибка при сохранении отметки о прочтении", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			var exists bool
+			if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM messages WHERE id = ? AND chat_id = ?)`, req.MessageID, chatID).Scan(&exists); err != nil {
+				log.Printf("❌ Ошибка при проверке сообщения %d в чате %d: %v", req.MessageID, chatID, err)
+				http.Error(w, "Ошибка при сохранении отметки о прочтении", http.StatusInternalServerError)
+				return
+			}
+			if !exists {
+				http.Error(w, "Сообщение не найдено в этом чате", http.StatusNotFound)
+				return
+			}
+		}
+
+		ids, err := wsManager.MarkMessagesRead(chatID, userId, req.MessageID)
+		if errors.Is(err, websocket.ErrNotParticipant) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Ошибка при сохранении отметки о прочтении в чате %d: %v", chatID, err)
+			http.Error(w, "Ошибка при сохранении отметки о прочтении", http.StatusInternalServerError)
+			return
+		}
+		if ids == nil {
+			ids = []int{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(MarkReadResponse{ChatID: chatID, MessageIDs: ids}); err != nil {
+			log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// routes/search_handlers.go
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/LilVoxy/coursework_chat/search"
+)
+
+// SearchMessagesHandler ищет сообщения в чатах пользователя: GET /api/search?q=...
+//
+// Дополнительные параметры: chatId - искать в одном чате, limit - размер страницы,
+// before - курсор nextCursor предыдущей страницы.
+func SearchMessagesHandler(index *search.Index) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		userId, ok := authenticatedUserID(w, r, query.Get("userId"))
+		if !ok {
+			return
+		}
+
+		q := search.Query{UserID: userId, Text: query.Get("q")}
+		for name, dst := range map[string]*int{"chatId": &q.ChatID, "before": &q.Before, "limit": &q.Limit} {
+			v := query.Get(name)
+			if v == "" {
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "Неверное значение параметра "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+
+		results, err := index.Search(r.Context(), q)
+		if errors.Is(err, search.ErrEmptyQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Ошибка поиска сообщений для пользователя %d: %v", userId, err)
+			http.Error(w, "Ошибка при поиске сообщений", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+		}
+	}
+}
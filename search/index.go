@@ -0,0 +1,355 @@
+// search/index.go
+package search
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/LilVoxy/coursework_chat/config"
+	"github.com/LilVoxy/coursework_chat/dbcrypt"
+)
+
+const (
+	// Длина токена слепого индекса в байтах (усеченный HMAC-SHA256)
+	tokenSize = 16
+
+	// Количество результатов поиска по умолчанию и максимум
+	defaultResultsLimit = 20
+	maxResultsLimit     = 100
+
+	// Размер пакета при индексации существующих сообщений
+	backfillBatchSize = 500
+)
+
+// Ключ разработки: опубликован в исходниках, поэтому используется только при явном
+// encryption.insecure_dev_key. В рабочем окружении ключ задается параметром search.key (CHAT_SEARCH_KEY).
+var developmentKey = []byte("search-blind-index-development!!")
+
+// Ошибки поиска
+var (
+	ErrEmptyQuery = errors.New("поисковый запрос должен содержать хотя бы одно слово из двух и более символов")
+	ErrNoKey      = errors.New("не задан ключ поискового индекса: укажите search.key (CHAT_SEARCH_KEY), для разработки - encryption.insecure_dev_key")
+)
+
+// Execer - *sql.DB или *sql.Tx
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Index - инвертированный индекс сообщений на слепых токенах.
+// В базе хранятся только HMAC от слов и их префиксов, поэтому индекс не раскрывает текст переписки,
+// который сам хранится в зашифрованном виде.
+type Index struct {
+	db     *sql.DB
+	key    []byte
+	cipher *dbcrypt.Cipher // Расшифровка текста сообщений для индексации и фрагментов
+}
+
+// NewIndex создает индекс с ключом для слепых токенов
+func NewIndex(db *sql.DB, key []byte, cipher *dbcrypt.Cipher) *Index {
+	return &Index{db: db, key: key, cipher: cipher}
+}
+
+// NewIndexFromConfig создает индекс по секции search конфигурации
+// (ключ в base64, не менее 32 байт). При смене ключа индекс перестраивается.
+// Токены индекса - HMAC слов без соли: с известным ключом их восстанавливают перебором
+// по словарю, поэтому без search.key индекс создается только при insecureDevKey
+// (encryption.insecure_dev_key), иначе возвращается ErrNoKey.
+func NewIndexFromConfig(db *sql.DB, cfg config.SearchConfig, insecureDevKey bool, cipher *dbcrypt.Cipher) (*Index, error) {
+	var key []byte
+	switch {
+	case cfg.Key != "":
+		decoded, err := base64.StdEncoding.DecodeString(cfg.Key)
+		if err != nil || len(decoded) < 32 {
+			return nil, errors.New("search.key должен содержать не менее 32 байт в base64")
+		}
+		key = decoded
+	case insecureDevKey:
+		log.Println("⚠️ insecure_dev_key: поисковый индекс строится общеизвестным ключом разработки")
+		key = developmentKey
+	default:
+		return nil, ErrNoKey
+	}
+
+	idx := NewIndex(db, key, cipher)
+	if err := idx.checkKey(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// keyID возвращает отпечаток ключа, по которому можно обнаружить его смену
+func (idx *Index) keyID() string {
+	mac := hmac.New(sha256.New, idx.key)
+	mac.Write([]byte("search-key-id"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// checkKey сбрасывает индекс, если он был построен с другим ключом
+func (idx *Index) checkKey() error {
+	var stored string
+	err := idx.db.QueryRow(`SELECT key_id FROM search_index_state WHERE id = 1`).Scan(&stored)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if stored == idx.keyID() {
+		return nil
+	}
+
+	if stored != "" {
+		log.Println("⚠️ Ключ поискового индекса изменился, индекс будет построен заново")
+	}
+	if _, err := idx.db.Exec(`DELETE FROM message_search_index`); err != nil {
+		return err
+	}
+	_, err = idx.db.Exec(`
+		INSERT INTO search_index_state (id, key_id, backfill_cursor) VALUES (1, ?, 0)
+		ON DUPLICATE KEY UPDATE key_id = VALUES(key_id), backfill_cursor = 0
+	`, idx.keyID())
+	return err
+}
+
+// token вычисляет слепой токен терма
+func (idx *Index) token(term string) []byte {
+	mac := hmac.New(sha256.New, idx.key)
+	mac.Write([]byte(term))
+	return mac.Sum(nil)[:tokenSize]
+}
+
+// IndexMessage добавляет текст сообщения в индекс. Вызывается при сохранении сообщения,
+// до шифрования текста, в той же транзакции.
+func (idx *Index) IndexMessage(db Execer, messageID, chatID int, content string) error {
+	terms := indexTerms(content)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(terms))
+	args := make([]interface{}, 0, len(terms)*3)
+	for i, term := range terms {
+		placeholders[i] = "(?, ?, ?)"
+		args = append(args, idx.token(term), messageID, chatID)
+	}
+	_, err := db.Exec(`
+		INSERT IGNORE INTO message_search_index (token, message_id, chat_id) VALUES `+strings.Join(placeholders, ", "), args...)
+	return err
+}
+
+// RemoveMessage удаляет сообщение из индекса
+func (idx *Index) RemoveMessage(db Execer, messageID int) error {
+	_, err := db.Exec(`DELETE FROM message_search_index WHERE message_id = ?`, messageID)
+	return err
+}
+
+// ReindexMessage заменяет индекс сообщения после правки
+func (idx *Index) ReindexMessage(db Execer, messageID, chatID int, content string) error {
+	if err := idx.RemoveMessage(db, messageID); err != nil {
+		return err
+	}
+	return idx.IndexMessage(db, messageID, chatID, content)
+}
+
+// Backfill индексирует сообщения, сохраненные до появления индекса (или до смены ключа).
+// Прогресс сохраняется, поэтому после перезапуска индексация продолжается с места остановки.
+func (idx *Index) Backfill(ctx context.Context) error {
+	var cursor, last int
+	if err := idx.db.QueryRowContext(ctx, `SELECT backfill_cursor FROM search_index_state WHERE id = 1`).Scan(&cursor); err != nil {
+		return err
+	}
+	// Новые сообщения индексируются при сохранении, догонять нужно только существующие
+	if err := idx.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM messages`).Scan(&last); err != nil {
+		return err
+	}
+
+	indexed := 0
+	for cursor < last {
+		n, next, err := idx.backfillBatch(ctx, cursor, last)
+		if err != nil {
+			return err
+		}
+		indexed += n
+		cursor = next
+	}
+	if indexed > 0 {
+		log.Printf("✅ Поисковый индекс: проиндексировано %d сообщений", indexed)
+	}
+	return nil
+}
+
+// backfillBatch индексирует очередной пакет сообщений после cursor и сдвигает курсор
+func (idx *Index) backfillBatch(ctx context.Context, cursor, last int) (int, int, error) {
+	rows, err := idx.db.QueryContext(ctx, `
+		SELECT id, chat_id, message FROM messages
+		WHERE id > ? AND id <= ? AND deleted_at IS NULL
+		ORDER BY id
+		LIMIT ?
+	`, cursor, last, backfillBatchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	type pending struct {
+		id, chatID int
+		content    string
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.chatID, &p.content); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	next := last
+	if len(batch) == backfillBatchSize {
+		next = batch[len(batch)-1].id
+	}
+
+	tx, err := idx.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	for _, p := range batch {
+		content, err := idx.cipher.Decrypt(p.content)
+		if err != nil {
+			log.Printf("⚠️ Сообщение %d не проиндексировано: %v", p.id, err)
+			continue
+		}
+		if err := idx.IndexMessage(tx, p.id, p.chatID, content); err != nil {
+			return 0, 0, fmt.Errorf("сообщение %d: %w", p.id, err)
+		}
+	}
+	if _, err := tx.Exec(`UPDATE search_index_state SET backfill_cursor = ? WHERE id = 1`, next); err != nil {
+		return 0, 0, err
+	}
+	return len(batch), next, tx.Commit()
+}
+
+// Query - параметры поиска
+type Query struct {
+	UserID int    // Ищем только в чатах этого пользователя
+	Text   string // Текст запроса; сообщение должно содержать все слова (или слова, начинающиеся с них)
+	ChatID int    // 0 - во всех чатах пользователя
+	Before int    // Курсор: искать среди сообщений с ID меньше указанного
+	Limit  int
+}
+
+// Result - найденное сообщение с контекстом чата
+type Result struct {
+	MessageID  int         `json:"messageId"`
+	ChatID     int         `json:"chatId"`
+	ProductID  int         `json:"productId"`
+	BuyerID    int         `json:"buyerId"`
+	SellerID   int         `json:"sellerId"`
+	FromID     int         `json:"fromId"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	Snippet    string      `json:"snippet"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+// Results - страница результатов поиска (от новых сообщений к старым)
+type Results struct {
+	Results    []Result `json:"results"`
+	HasMore    bool     `json:"hasMore"`
+	NextCursor *int     `json:"nextCursor"` // Передается в before для следующей страницы
+}
+
+// Search ищет сообщения в чатах пользователя. Удаленные сообщения и сообщения,
+// скрытые пользователем, не возвращаются.
+func (idx *Index) Search(ctx context.Context, q Query) (Results, error) {
+	terms := queryTerms(q.Text)
+	if len(terms) == 0 {
+		return Results{}, ErrEmptyQuery
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultResultsLimit
+	}
+	if q.Limit > maxResultsLimit {
+		q.Limit = maxResultsLimit
+	}
+
+	placeholders := make([]string, len(terms))
+	args := make([]interface{}, 0, len(terms)+6)
+	args = append(args, q.UserID)
+	for i, term := range terms {
+		placeholders[i] = "?"
+		args = append(args, idx.token(term))
+	}
+
+	filter := ""
+	if q.ChatID > 0 {
+		filter += " AND s.chat_id = ?"
+		args = append(args, q.ChatID)
+	}
+	if q.Before > 0 {
+		filter += " AND s.message_id < ?"
+		args = append(args, q.Before)
+	}
+	args = append(args, len(terms), q.UserID, q.Limit+1)
+
+	// Сначала находим сообщения, содержащие все термы, затем отбрасываем удаленные и скрытые
+	rows, err := idx.db.QueryContext(ctx, `
+		SELECT m.id, m.chat_id, c.product_id, c.buyer_id, c.seller_id, m.sender_id, m.message, m.created_at
+		FROM (
+			SELECT s.message_id
+			FROM message_search_index s
+			JOIN chat_participants p ON p.chat_id = s.chat_id AND p.user_id = ?
+			WHERE s.token IN (`+strings.Join(placeholders, ", ")+`)`+filter+`
+			GROUP BY s.message_id
+			HAVING COUNT(*) = ?
+		) hits
+		JOIN messages m ON m.id = hits.message_id
+		JOIN chats c ON c.id = m.chat_id
+		WHERE m.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id = ?)
+		ORDER BY m.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return Results{}, err
+	}
+	defer rows.Close()
+
+	results := Results{Results: []Result{}}
+	for rows.Next() {
+		var r Result
+		var stored string
+		if err := rows.Scan(&r.MessageID, &r.ChatID, &r.ProductID, &r.BuyerID, &r.SellerID,
+			&r.FromID, &stored, &r.CreatedAt); err != nil {
+			return Results{}, err
+		}
+		content, err := idx.cipher.Decrypt(stored)
+		if err != nil {
+			log.Printf("❌ Ошибка расшифровки сообщения %d: %v", r.MessageID, err)
+			continue
+		}
+		r.Snippet, r.Highlights = buildSnippet(content, terms)
+		results.Results = append(results.Results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return Results{}, err
+	}
+
+	if len(results.Results) > q.Limit {
+		results.Results = results.Results[:q.Limit]
+		results.HasMore = true
+		cursor := results.Results[q.Limit-1].MessageID
+		results.NextCursor = &cursor
+	}
+	return results, nil
+}
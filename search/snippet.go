@@ -0,0 +1,94 @@
+// search/snippet.go
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// Длина фрагмента текста в результатах поиска (в символах)
+	snippetLength = 160
+
+	// Сколько символов контекста оставлять перед первым совпадением
+	snippetLead = 40
+
+	ellipsis = "…"
+)
+
+// Highlight - совпадение во фрагменте: [Start, End) в символах (рунах) строки Snippet
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// matchesTerm проверяет, начинается ли слово с одного из термов запроса
+func matchesTerm(w word, terms []string) bool {
+	norm := truncateTerm(w.norm)
+	for _, term := range terms {
+		if strings.HasPrefix(norm, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildSnippet вырезает из текста фрагмент вокруг первого совпадения и возвращает позиции совпадений в нем
+func buildSnippet(text string, terms []string) (string, []Highlight) {
+	runes := []rune(text)
+
+	var matches []word
+	for _, w := range splitWords(text) {
+		if matchesTerm(w, terms) {
+			matches = append(matches, w)
+		}
+	}
+
+	// Окно фрагмента: начинаем немного раньше первого совпадения, по границе слова
+	start := 0
+	if len(matches) > 0 && matches[0].start > snippetLead {
+		start = matches[0].start - snippetLead
+		for start < matches[0].start && !unicode.IsSpace(runes[start-1]) {
+			start++
+		}
+	}
+	end := start + snippetLength
+	if end >= len(runes) {
+		end = len(runes)
+	} else {
+		// Не обрываем слово посередине, если это возможно
+		for cut := end; cut > start+snippetLength/2; cut-- {
+			if unicode.IsSpace(runes[cut]) {
+				end = cut
+				break
+			}
+		}
+	}
+
+	var b strings.Builder
+	offset := 0
+	if start > 0 {
+		b.WriteString(ellipsis)
+		offset = len([]rune(ellipsis))
+	}
+	b.WriteString(strings.TrimSpace(string(runes[start:end])))
+	// Пробелы в начале окна срезаны, сдвигаем позиции соответственно
+	for start < end && unicode.IsSpace(runes[start]) {
+		start++
+	}
+	if end < len(runes) {
+		b.WriteString(ellipsis)
+	}
+
+	highlights := []Highlight{}
+	for _, m := range matches {
+		if m.start < start || m.end > end {
+			continue
+		}
+		highlights = append(highlights, Highlight{
+			Start: m.start - start + offset,
+			End:   m.end - start + offset,
+		})
+	}
+	return b.String(), highlights
+}
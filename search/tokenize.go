@@ -0,0 +1,114 @@
+// search/tokenize.go
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// Слова короче этого не индексируются и не ищутся
+	minTermLength = 2
+
+	// Для слов длиннее индексируются префиксы от minPrefixLength до maxTermLength символов,
+	// чтобы запрос «доставк» находил «доставка», «доставку» и т.д.
+	minPrefixLength = 3
+	maxTermLength   = 24
+
+	// Максимальное количество слов в запросе
+	maxQueryTerms = 8
+)
+
+// word - слово в исходном тексте: позиция в рунах и нормализованная форма
+type word struct {
+	start, end int
+	norm       string
+}
+
+// splitWords разбивает текст на слова (последовательности букв и цифр)
+func splitWords(text string) []word {
+	var words []word
+	var b strings.Builder
+	start, pos := -1, 0
+	flush := func() {
+		if start >= 0 {
+			words = append(words, word{start: start, end: pos, norm: b.String()})
+			b.Reset()
+			start = -1
+		}
+	}
+
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = pos
+			}
+			b.WriteRune(normalizeRune(r))
+		} else {
+			flush()
+		}
+		pos++
+	}
+	flush()
+	return words
+}
+
+// normalizeRune приводит букву к нижнему регистру; «ё» считается равной «е»
+func normalizeRune(r rune) rune {
+	r = unicode.ToLower(r)
+	if r == 'ё' {
+		r = 'е'
+	}
+	return r
+}
+
+// truncateTerm обрезает слово до maxTermLength символов
+func truncateTerm(term string) string {
+	runes := []rune(term)
+	if len(runes) > maxTermLength {
+		runes = runes[:maxTermLength]
+	}
+	return string(runes)
+}
+
+// indexTerms возвращает уникальные термы для индексации текста: слова целиком и их префиксы
+func indexTerms(text string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	add := func(term string) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	for _, w := range splitWords(text) {
+		runes := []rune(truncateTerm(w.norm))
+		if len(runes) < minTermLength {
+			continue
+		}
+		add(string(runes))
+		for n := minPrefixLength; n < len(runes); n++ {
+			add(string(runes[:n]))
+		}
+	}
+	return terms
+}
+
+// queryTerms разбирает поисковый запрос на уникальные термы
+func queryTerms(query string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, w := range splitWords(query) {
+		term := truncateTerm(w.norm)
+		if len([]rune(term)) < minTermLength || seen[term] {
+			continue
+		}
+		seen[term] = true
+		terms = append(terms, term)
+		if len(terms) == maxQueryTerms {
+			break
+		}
+	}
+	return terms
+}
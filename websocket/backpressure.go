@@ -0,0 +1,295 @@
+// websocket/backpressure.go
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/LilVoxy/coursework_chat/auth"
+	"github.com/LilVoxy/coursework_chat/config"
+	"github.com/gorilla/websocket"
+)
+
+// SlowClientPolicy - что делать, если очередь отправки соединения переполнена.
+// Некритичные кадры (набор текста) при переполнении пропускаются при любой политике.
+type SlowClientPolicy string
+
+const (
+	// PolicyDisconnect закрывает соединение с кодом 1013 (Try Again Later);
+	// после переподключения клиент получает пропущенные события синхронизацией
+	PolicyDisconnect SlowClientPolicy = "disconnect"
+
+	// PolicyDropOldest освобождает место, удаляя самые старые кадры очереди,
+	// и просит клиента выполнить синхронизацию (кадр sync_required)
+	PolicyDropOldest SlowClientPolicy = "drop-oldest"
+
+	// PolicySpill перестает отправлять кадры в очередь соединения: события остаются только
+	// в очереди пользователя в БД, а клиент получает sync_required и забирает их синхронизацией
+	PolicySpill SlowClientPolicy = "spill"
+)
+
+// Кадр, которым сервер просит клиента выполнить синхронизацию после пропуска кадров
+const frameTypeSyncRequired = "sync_required"
+
+// Сколько раз при политике drop-oldest освобождается место, прежде чем кадр считается потерянным
+const dropOldestAttempts = 4
+
+// ParseSlowClientPolicy разбирает название политики
+func ParseSlowClientPolicy(value string) (SlowClientPolicy, error) {
+	switch policy := SlowClientPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case PolicyDisconnect, PolicyDropOldest, PolicySpill:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("неизвестная политика медленного клиента %q (disconnect, drop-oldest, spill)", value)
+	}
+}
+
+// BackpressureConfig - настройки очереди отправки соединений
+type BackpressureConfig struct {
+	Policy    SlowClientPolicy // Политика по умолчанию (клиент может выбрать другую при подключении)
+	QueueSize int              // Размер очереди отправки соединения в кадрах
+}
+
+// NewBackpressureConfig читает настройки из секции delivery конфигурации:
+//
+//	slow_client_policy - disconnect (по умолчанию), drop-oldest или spill
+//	send_queue_size    - размер очереди отправки соединения (по умолчанию 256)
+func NewBackpressureConfig(delivery config.DeliveryConfig) (BackpressureConfig, error) {
+	cfg := BackpressureConfig{Policy: PolicyDisconnect, QueueSize: defaultSendQueueSize}
+	if delivery.SlowClientPolicy != "" {
+		policy, err := ParseSlowClientPolicy(delivery.SlowClientPolicy)
+		if err != nil {
+			return cfg, fmt.Errorf("delivery.slow_client_policy: %w", err)
+		}
+		cfg.Policy = policy
+	}
+	if delivery.SendQueueSize > 0 {
+		cfg.QueueSize = delivery.SendQueueSize
+	}
+	return cfg, nil
+}
+
+// connectionPolicy возвращает политику, выбранную клиентом параметром подключения backpressure
+func connectionPolicy(r *http.Request, fallback SlowClientPolicy) (SlowClientPolicy, error) {
+	value := r.URL.Query().Get("backpressure")
+	if value == "" {
+		return fallback, nil
+	}
+	return ParseSlowClientPolicy(value)
+}
+
+// queueStats - счетчики очереди отправки соединения
+type queueStats struct {
+	enqueued atomic.Int64 // Кадров поставлено в очередь
+	dropped  atomic.Int64 // Кадров пропущено (некритичные и вытесненные drop-oldest)
+	spilled  atomic.Int64 // Событий оставлено в очереди пользователя в БД (spill)
+	maxDepth atomic.Int64 // Наибольшая длина очереди
+}
+
+// offer ставит кадр в очередь соединения, не блокируясь, с учетом политики медленного клиента.
+// eventID != 0 означает, что событие сохранено в очереди пользователя и его можно получить
+// синхронизацией. Пока клиент синхронизируется, кадры откладываются и отправляются после
+// воспроизведения пропущенных. Возвращает false, если по политике соединение нужно закрыть.
+func (c *Client) offer(eventID int64, data []byte, droppable bool) bool {
+	c.syncMutex.Lock()
+	defer c.syncMutex.Unlock()
+
+	if c.syncing {
+		c.pending = append(c.pending, pendingFrame{EventID: eventID, Data: data})
+		return true
+	}
+	if c.isClosed() {
+		return true
+	}
+	if c.spilling {
+		// Клиент заберет события синхронизацией, остальные кадры устарели бы к ее окончанию
+		c.countSkipped(eventID)
+		return true
+	}
+	if c.enqueue(data) {
+		c.noteEnqueued()
+		return true
+	}
+	if droppable {
+		c.stats.dropped.Add(1)
+		return true
+	}
+
+	switch c.Policy {
+	case PolicyDropOldest:
+		defer c.requestSync()
+		for i := 0; i < dropOldestAttempts; i++ {
+			select {
+			case <-c.Send:
+				c.stats.dropped.Add(1)
+			default:
+			}
+			if c.enqueue(data) {
+				c.noteEnqueued()
+				return true
+			}
+		}
+		c.countSkipped(eventID)
+		return true
+
+	case PolicySpill:
+		c.spilling = true
+		c.countSkipped(eventID)
+		c.requestSync()
+		return true
+
+	default:
+		return false
+	}
+}
+
+// countSkipped учитывает кадр, который не попал в очередь соединения
+func (c *Client) countSkipped(eventID int64) {
+	if eventID != 0 {
+		c.stats.spilled.Add(1)
+	} else {
+		c.stats.dropped.Add(1)
+	}
+}
+
+func (c *Client) noteEnqueued() {
+	c.stats.enqueued.Add(1)
+	depth := int64(len(c.Send))
+	for {
+		max := c.stats.maxDepth.Load()
+		if depth <= max || c.stats.maxDepth.CompareAndSwap(max, depth) {
+			return
+		}
+	}
+}
+
+// requestSync просит writePump отправить клиенту кадр sync_required.
+// Кадр отправляется вне очереди, поэтому его нельзя вытеснить.
+func (c *Client) requestSync() {
+	c.syncRequired.Store(true)
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// syncRequiredFrame возвращает кадр sync_required, если он запрошен и еще не отправлен
+func (c *Client) syncRequiredFrame() []byte {
+	if !c.syncRequired.Swap(false) {
+		return nil
+	}
+	data, err := json.Marshal(Message{
+		Type:  frameTypeSyncRequired,
+		Error: "очередь отправки переполнена, часть событий не доставлена",
+	})
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// QueueMetrics - состояние очереди отправки одного соединения
+type QueueMetrics struct {
+	UserID    int              `json:"userId"`
+	SessionID string           `json:"sessionId"`
+	Policy    SlowClientPolicy `json:"policy"`
+	Depth     int              `json:"depth"`    // Кадров в очереди сейчас
+	Capacity  int              `json:"capacity"` // Размер очереди
+	MaxDepth  int64            `json:"maxDepth"` // Наибольшая длина очереди
+	Enqueued  int64            `json:"enqueued"`
+	Dropped   int64            `json:"dropped"`
+	Spilled   int64            `json:"spilled"`
+	Spilling  bool             `json:"spilling"` // События временно не отправляются (ждем синхронизацию)
+}
+
+// QueueTotals - сводка по очередям всех соединений узла
+type QueueTotals struct {
+	Connections      int   `json:"connections"`
+	Queued           int   `json:"queued"`           // Кадров во всех очередях
+	MaxDepth         int   `json:"maxDepth"`         // Самая длинная очередь сейчас
+	Spilling         int   `json:"spilling"`         // Соединений в режиме spill
+	SlowDisconnects  int64 `json:"slowDisconnects"`  // Соединений закрыто из-за переполнения очереди
+	DroppedTotal     int64 `json:"droppedTotal"`     // Пропущено кадров текущими соединениями
+	SpilledTotal     int64 `json:"spilledTotal"`     // Оставлено событий в БД текущими соединениями
+	DefaultQueueSize int   `json:"defaultQueueSize"` // Размер очереди новых соединений
+}
+
+func (c *Client) queueMetrics() QueueMetrics {
+	c.syncMutex.Lock()
+	spilling := c.spilling
+	c.syncMutex.Unlock()
+
+	return QueueMetrics{
+		UserID:    c.UserID,
+		SessionID: c.SessionID,
+		Policy:    c.Policy,
+		Depth:     len(c.Send),
+		Capacity:  cap(c.Send),
+		MaxDepth:  c.stats.maxDepth.Load(),
+		Enqueued:  c.stats.enqueued.Load(),
+		Dropped:   c.stats.dropped.Load(),
+		Spilled:   c.stats.spilled.Load(),
+		Spilling:  spilling,
+	}
+}
+
+// QueueMetrics возвращает сводку по очередям соединений узла и состояние очередей
+// соединений пользователя userID (0 - всех соединений)
+func (m *Manager) QueueMetrics(userID int) (QueueTotals, []QueueMetrics) {
+	totals := QueueTotals{
+		SlowDisconnects:  m.slowDisconnects.Load(),
+		DefaultQueueSize: m.Backpressure.QueueSize,
+	}
+	var list []QueueMetrics
+	m.call(func() {
+		for id, sessions := range m.Clients {
+			for _, client := range sessions {
+				metrics := client.queueMetrics()
+				totals.Connections++
+				totals.Queued += metrics.Depth
+				totals.DroppedTotal += metrics.Dropped
+				totals.SpilledTotal += metrics.Spilled
+				if metrics.Depth > totals.MaxDepth {
+					totals.MaxDepth = metrics.Depth
+				}
+				if metrics.Spilling {
+					totals.Spilling++
+				}
+				if userID == 0 || id == userID {
+					list = append(list, metrics)
+				}
+			}
+		}
+	})
+	return totals, list
+}
+
+// HandleQueueMetrics отдает сводку по очередям отправки узла и очереди соединений
+// текущего пользователя: GET /api/ws/queues
+func (m *Manager) HandleQueueMetrics(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Требуется авторизация", http.StatusUnauthorized)
+		return
+	}
+
+	totals, connections := m.QueueMetrics(userID)
+	if connections == nil {
+		connections = []QueueMetrics{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"node":        m.NodeID,
+		"totals":      totals,
+		"connections": connections,
+	}); err != nil {
+		log.Printf("❌ Ошибка при кодировании JSON: %v", err)
+	}
+}
+
+// Код закрытия соединения медленного клиента
+const closeSlowConsumer = websocket.CloseTryAgainLater
@@ -0,0 +1,171 @@
+// websocket/backpressure_test.go
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newQueueClient создает клиента без сокета с очередью заданного размера
+func newQueueClient(m *Manager, userID int, policy SlowClientPolicy, size int) *Client {
+	return &Client{
+		ID:        userID,
+		UserID:    userID,
+		SessionID: fmt.Sprintf("s%d", userID),
+		Send:      make(chan []byte, size),
+		Manager:   m,
+		Policy:    policy,
+		notify:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+func frame(i int) []byte {
+	return []byte(fmt.Sprintf(`{"type":"test","eventId":%d}`, i))
+}
+
+func drain(c *Client) []string {
+	var out []string
+	for {
+		select {
+		case data := <-c.Send:
+			out = append(out, string(data))
+		default:
+			return out
+		}
+	}
+}
+
+func TestDropOldestKeepsNewestFrames(t *testing.T) {
+	c := newQueueClient(nil, 1, PolicyDropOldest, 2)
+	for i := 1; i <= 5; i++ {
+		if !c.offer(int64(i), frame(i), false) {
+			t.Fatalf("кадр %d: drop-oldest не должен отключать клиента", i)
+		}
+	}
+
+	got := drain(c)
+	want := []string{string(frame(4)), string(frame(5))}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("в очереди %v, ожидалось %v", got, want)
+	}
+	if m := c.queueMetrics(); m.Dropped != 3 || m.Enqueued != 5 || m.MaxDepth != 2 {
+		t.Fatalf("счетчики: %+v", m)
+	}
+	if c.syncRequiredFrame() == nil {
+		t.Fatal("клиент не получил запрос синхронизации после вытеснения кадров")
+	}
+	if c.syncRequiredFrame() != nil {
+		t.Fatal("запрос синхронизации отправлен повторно")
+	}
+}
+
+func TestSpillStopsQueueUntilSync(t *testing.T) {
+	c := newQueueClient(nil, 1, PolicySpill, 1)
+	c.offer(1, frame(1), false)
+	c.offer(2, frame(2), false)
+	c.offer(3, frame(3), false)
+	c.offer(0, []byte(`{"type":"status"}`), false)
+
+	if got := drain(c); len(got) != 1 || got[0] != string(frame(1)) {
+		t.Fatalf("в очереди %v, ожидался только первый кадр", got)
+	}
+	m := c.queueMetrics()
+	if !m.Spilling || m.Spilled != 2 || m.Dropped != 1 {
+		t.Fatalf("счетчики: %+v", m)
+	}
+	if c.syncRequiredFrame() == nil {
+		t.Fatal("клиент не получил запрос синхронизации")
+	}
+
+	// Пока режим spill не снят синхронизацией, очередь не пополняется, даже если в ней есть место
+	c.offer(4, frame(4), false)
+	if got := drain(c); len(got) != 0 {
+		t.Fatalf("кадры отправлены в режиме spill: %v", got)
+	}
+
+	// Синхронизация снимает режим spill (так делает handleSync)
+	c.syncMutex.Lock()
+	c.spilling = false
+	c.syncMutex.Unlock()
+	c.offer(5, frame(5), false)
+	if got := drain(c); len(got) != 1 {
+		t.Fatalf("после синхронизации кадр не доставлен: %v", got)
+	}
+}
+
+func TestDroppableFramesNeverTriggerPolicy(t *testing.T) {
+	for _, policy := range []SlowClientPolicy{PolicyDisconnect, PolicyDropOldest, PolicySpill} {
+		c := newQueueClient(nil, 1, policy, 1)
+		c.offer(1, frame(1), false)
+		if !c.offer(0, []byte(`{"type":"typing"}`), true) {
+			t.Fatalf("%s: некритичный кадр отключил клиента", policy)
+		}
+		if got := drain(c); len(got) != 1 || got[0] != string(frame(1)) {
+			t.Fatalf("%s: некритичный кадр вытеснил событие: %v", policy, got)
+		}
+		if c.syncRequiredFrame() != nil {
+			t.Fatalf("%s: пропуск некритичного кадра не требует синхронизации", policy)
+		}
+	}
+}
+
+func TestDisconnectPolicyClosesWithCode(t *testing.T) {
+	m := startManager(t)
+	c := newQueueClient(m, 11, PolicyDisconnect, 1)
+	m.do(func() { m.registerClient(c) })
+
+	m.publishToUser(11, frame(1), false)
+	m.publishToUser(11, frame(2), false)
+	eventually(t, "клиент отключен", c.isClosed)
+
+	if len(c.closeFrame) < 2 || int(binary.BigEndian.Uint16(c.closeFrame)) != websocket.CloseTryAgainLater {
+		t.Fatalf("кадр закрытия %v, ожидался код %d", c.closeFrame, websocket.CloseTryAgainLater)
+	}
+	if totals, _ := m.QueueMetrics(0); totals.SlowDisconnects != 1 {
+		t.Fatalf("slowDisconnects = %d", totals.SlowDisconnects)
+	}
+}
+
+func TestQueueMetricsPerUser(t *testing.T) {
+	m := startManager(t)
+	a := newQueueClient(m, 21, PolicySpill, 4)
+	b := newQueueClient(m, 22, PolicyDropOldest, 4)
+	m.do(func() { m.registerClient(a) })
+	m.do(func() { m.registerClient(b) })
+
+	m.publishToUser(21, frame(1), false)
+	m.publishToUser(21, frame(2), false)
+	m.publishToUser(22, frame(3), false)
+
+	eventually(t, "кадры в очередях", func() bool {
+		totals, _ := m.QueueMetrics(0)
+		return totals.Queued >= 3
+	})
+	totals, own := m.QueueMetrics(21)
+	if totals.Connections != 2 || totals.MaxDepth < 2 {
+		t.Fatalf("сводка: %+v", totals)
+	}
+	if len(own) != 1 || own[0].UserID != 21 || own[0].Policy != PolicySpill || own[0].Capacity != 4 {
+		t.Fatalf("очереди пользователя: %+v", own)
+	}
+}
+
+func TestConnectionPolicyFromQuery(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ws?backpressure=Drop-Oldest", nil)
+	if policy, err := connectionPolicy(r, PolicyDisconnect); err != nil || policy != PolicyDropOldest {
+		t.Fatalf("получено %q, %v", policy, err)
+	}
+	r = httptest.NewRequest("GET", "/ws", nil)
+	if policy, _ := connectionPolicy(r, PolicySpill); policy != PolicySpill {
+		t.Fatalf("без параметра ожидалась политика сервера, получено %q", policy)
+	}
+	r = httptest.NewRequest("GET", "/ws?backpressure=ignore", nil)
+	if _, err := connectionPolicy(r, PolicyDisconnect); err == nil {
+		t.Fatal("неизвестная политика принята")
+	}
+}
@@ -0,0 +1,138 @@
+// websocket/bus.go
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/LilVoxy/coursework_chat/config"
+)
+
+// Виды событий шины
+const (
+	busDeliver   = "deliver"   // Доставка кадра всем устройствам пользователя
+	busBroadcast = "broadcast" // Рассылка кадра всем подключенным пользователям
+	busPresence  = "presence"  // Изменение статуса и количества устройств пользователя на узле
+	busHeartbeat = "heartbeat" // Периодический снимок всех пользователей, подключенных к узлу
+	busSync      = "sync"      // Запрос снимков у других узлов (узел только что подписался на шину)
+)
+
+// BusEvent - событие, которым обмениваются экземпляры сервера через шину.
+// Каждый узел доставляет событие только своим локальным соединениям.
+type BusEvent struct {
+	Kind   string `json:"kind"`
+	NodeID string `json:"nodeId"` // Узел, опубликовавший событие
+
+	// Доставка и рассылка
+	UserID        int    `json:"userId,omitempty"`        // Получатель (для deliver) или пользователь (для presence)
+	ExcludeUserID int    `json:"excludeUserId,omitempty"` // Пользователь, которому не нужно отправлять рассылку
+	EventID       int64  `json:"eventId,omitempty"`       // ID события в очереди пользователя
+	Payload       []byte `json:"payload,omitempty"`       // Готовый кадр для отправки клиенту
+	Droppable     bool   `json:"droppable,omitempty"`     // Кадр можно пропустить, если очередь клиента переполнена
+
+	// Подтверждение доставки нового сообщения: узел, доставивший его получателю,
+	// сохраняет отметку о доставке и уведомляет отправителя
+	MessageID int `json:"messageId,omitempty"`
+	ChatID    int `json:"chatId,omitempty"`
+	SenderID  int `json:"senderId,omitempty"`
+
+	// Присутствие
+	Status   string `json:"status,omitempty"`
+	IsActive bool   `json:"isActive,omitempty"`
+	Devices  int    `json:"devices"`           // Количество устройств пользователя на узле NodeID
+	Changed  bool   `json:"changed,omitempty"` // Статус изменился и его нужно разослать клиентам
+
+	// Снимок присутствия (heartbeat): все пользователи, подключенные к узлу NodeID
+	Presence []PresenceEntry `json:"presence,omitempty"`
+}
+
+// PresenceEntry - пользователь в снимке присутствия узла
+type PresenceEntry struct {
+	UserID   int    `json:"userId"`
+	Devices  int    `json:"devices"`
+	Status   string `json:"status,omitempty"`
+	IsActive bool   `json:"isActive,omitempty"`
+}
+
+// Bus - шина событий между экземплярами сервера. Через нее проходит вся доставка
+// сообщений, статусов и отметок о прочтении, поэтому пользователи, подключенные
+// к разным узлам, получают события друг друга.
+type Bus interface {
+	// Publish отправляет событие всем узлам, включая текущий
+	Publish(event BusEvent) error
+	// Subscribe регистрирует обработчик входящих событий
+	Subscribe(handler func(BusEvent)) error
+	// Close освобождает ресурсы шины
+	Close() error
+}
+
+// MemoryBus - шина внутри одного процесса (режим одного сервера).
+// Обработчики вызываются синхронно в порядке публикации.
+type MemoryBus struct {
+	mu       sync.RWMutex
+	handlers []func(BusEvent)
+}
+
+// NewMemoryBus создает шину внутри процесса
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Publish передает событие всем подписчикам
+func (b *MemoryBus) Publish(event BusEvent) error {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+// Subscribe регистрирует обработчик событий
+func (b *MemoryBus) Subscribe(handler func(BusEvent)) error {
+	b.mu.Lock()
+	b.handlers = append(b.handlers, handler)
+	b.mu.Unlock()
+	return nil
+}
+
+// Close ничего не делает для шины внутри процесса
+func (b *MemoryBus) Close() error {
+	return nil
+}
+
+// NewBus создает шину по секции cluster конфигурации:
+//
+//	bus            - "memory" (по умолчанию, один экземпляр сервера) или "redis"
+//	redis_url      - адрес Redis, например redis://localhost:6379/0
+//	redis_password - пароль Redis (заменяет пароль из адреса)
+//	redis_channel  - канал Pub/Sub (по умолчанию chat:events)
+func NewBus(cfg config.ClusterConfig) (Bus, error) {
+	switch cfg.Bus {
+	case "", "memory":
+		return NewMemoryBus(), nil
+	case "redis":
+		return NewRedisBus(cfg.RedisURL, cfg.RedisPassword, cfg.RedisChannel)
+	default:
+		return nil, fmt.Errorf("неизвестный тип шины событий: %s", cfg.Bus)
+	}
+}
+
+// NewNodeID генерирует идентификатор экземпляра сервера
+func NewNodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "node"
+	}
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("⚠️ Ошибка генерации ID узла: %v", err)
+	}
+	return fmt.Sprintf("%s-%s", host, hex.EncodeToString(buf))
+}
@@ -0,0 +1,252 @@
+// websocket/bus_handler.go
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	// Период снимков присутствия, если он не задан в конфигурации
+	defaultHeartbeatInterval = 10 * time.Second
+	// Через сколько пропущенных снимков узел считается остановленным
+	nodeExpiryBeats = 3
+)
+
+// publish публикует событие в шину от имени текущего узла
+func (m *Manager) publish(event BusEvent) {
+	event.NodeID = m.NodeID
+	if err := m.Bus.Publish(event); err != nil {
+		log.Printf("❌ Ошибка публикации события %s в шину: %v", event.Kind, err)
+	}
+}
+
+// publishToUser отправляет кадр на все устройства пользователя, к какому бы узлу они ни были подключены
+func (m *Manager) publishToUser(userID int, data []byte, droppable bool) {
+	m.publish(BusEvent{Kind: busDeliver, UserID: userID, Payload: data, Droppable: droppable})
+}
+
+// onBusEvent обрабатывает событие из шины: доставляет его локальным соединениям этого узла.
+// Событие передается циклу менеджера, который владеет списком соединений.
+func (m *Manager) onBusEvent(event BusEvent) {
+	switch event.Kind {
+	case busDeliver:
+		m.do(func() { m.deliverLocal(event) })
+	case busBroadcast:
+		m.do(func() { m.broadcastLocal(event.Payload, event.ExcludeUserID) })
+	case busPresence:
+		m.do(func() { m.applyPresence(event) })
+	case busHeartbeat:
+		m.do(func() { m.applyHeartbeat(event) })
+	case busSync:
+		if event.NodeID != m.NodeID {
+			m.do(m.announceSnapshot)
+		}
+	default:
+		log.Printf("⚠️ Неизвестный тип события шины: %s", event.Kind)
+	}
+}
+
+// deliverLocal отправляет кадр устройствам пользователя, подключенным к этому узлу (только в цикле Run).
+// Цикл не ждет медленных клиентов: при переполнении очереди устройства действует его политика
+// (см. SlowClientPolicy).
+func (m *Manager) deliverLocal(event BusEvent) {
+	clients := m.userClients(event.UserID)
+	for _, client := range clients {
+		if !client.offer(event.EventID, event.Payload, event.Droppable) {
+			m.evict(client)
+		}
+	}
+
+	// Новое сообщение попало в очередь устройства получателя - фиксируем доставку
+	if len(clients) > 0 && event.MessageID != 0 {
+		m.spawn(func() { m.confirmDelivery(Message{ID: event.MessageID, ChatID: event.ChatID, FromID: event.SenderID}) })
+	}
+}
+
+// broadcastLocal отправляет кадр всем локальным соединениям, кроме соединений excludeUserID (только в цикле Run)
+func (m *Manager) broadcastLocal(data []byte, excludeUserID int) {
+	for userID, sessions := range m.Clients {
+		if excludeUserID != 0 && userID == excludeUserID {
+			continue
+		}
+		for _, client := range sessions {
+			if !client.offer(0, data, false) {
+				m.evict(client)
+			}
+		}
+	}
+}
+
+// evict отключает клиента, очередь которого переполнена (политика disconnect), с кодом 1013.
+// Из списка соединений он удаляется обычным образом, когда readPump обнаружит закрытие сокета.
+func (m *Manager) evict(client *Client) {
+	if client.isClosed() {
+		return
+	}
+	log.Printf("⚠️ Клиент %d (сессия %s) не успевает принимать события и будет отключен", client.UserID, client.SessionID)
+	m.slowDisconnects.Add(1)
+	client.closeWith(closeSlowConsumer, "slow consumer")
+}
+
+// announcePresence сообщает другим узлам текущий статус и количество устройств пользователя на этом узле
+// (только в цикле Run). changed=true означает, что статус изменился и его нужно разослать клиентам.
+func (m *Manager) announcePresence(userID int, changed bool) {
+	event := BusEvent{
+		Kind:    busPresence,
+		UserID:  userID,
+		Devices: len(m.Clients[userID]),
+		Changed: changed,
+	}
+	if status, ok := m.UserStatuses[userID]; ok {
+		event.Status = status.Status
+		event.IsActive = status.IsActive
+	}
+
+	m.effects.push(func() { m.publish(event) })
+}
+
+// applyPresence учитывает статус пользователя, объявленный узлом, и рассылает его локальным клиентам
+// (только в цикле Run)
+func (m *Manager) applyPresence(event BusEvent) {
+	if event.NodeID != m.NodeID {
+		m.remoteNodes[event.NodeID] = time.Now()
+		nodes, ok := m.remoteDevices[event.UserID]
+		if !ok {
+			nodes = make(map[string]int)
+			m.remoteDevices[event.UserID] = nodes
+		}
+		if event.Devices > 0 {
+			nodes[event.NodeID] = event.Devices
+		} else {
+			delete(nodes, event.NodeID)
+		}
+		if len(nodes) == 0 {
+			delete(m.remoteDevices, event.UserID)
+		}
+
+		if event.Status != "" {
+			status := m.userStatus(event.UserID)
+			status.Status = event.Status
+			status.IsActive = event.IsActive
+		}
+	}
+
+	if event.Changed {
+		m.broadcastStatusLocal(event.UserID, event.Status)
+	}
+}
+
+// broadcastStatusLocal отправляет статус пользователя всем локальным клиентам, кроме самого
+// пользователя (только в цикле Run)
+func (m *Manager) broadcastStatusLocal(userID int, status string) {
+	data, err := json.Marshal(Message{
+		Type:   "status",
+		UserID: userID,
+		Status: status,
+	})
+	if err != nil {
+		log.Printf("❌ Ошибка при сериализации статуса: %v", err)
+		return
+	}
+	m.broadcastLocal(data, userID)
+}
+
+// announceSnapshot публикует снимок всех пользователей, подключенных к этому узлу (только в цикле Run).
+// Снимки подтверждают другим узлам, что этот узел работает, и исправляют пропущенные ими события.
+func (m *Manager) announceSnapshot() {
+	event := BusEvent{Kind: busHeartbeat, Presence: make([]PresenceEntry, 0, len(m.Clients))}
+	for userID, sessions := range m.Clients {
+		entry := PresenceEntry{UserID: userID, Devices: len(sessions)}
+		if status, ok := m.UserStatuses[userID]; ok {
+			entry.Status = status.Status
+			entry.IsActive = status.IsActive
+		}
+		event.Presence = append(event.Presence, entry)
+	}
+	m.effects.push(func() { m.publish(event) })
+}
+
+// applyHeartbeat заменяет устройства узла снимком из события (только в цикле Run).
+// Пользователи, которых другие узлы еще не видели в сети, получают статус из снимка.
+func (m *Manager) applyHeartbeat(event BusEvent) {
+	if event.NodeID == m.NodeID {
+		return
+	}
+	m.remoteNodes[event.NodeID] = time.Now()
+
+	present := make(map[int]bool, len(event.Presence))
+	for _, entry := range event.Presence {
+		if entry.Devices <= 0 {
+			continue
+		}
+		present[entry.UserID] = true
+		known := len(m.Clients[entry.UserID]) > 0 || m.remoteDeviceCount(entry.UserID) > 0
+
+		nodes, ok := m.remoteDevices[entry.UserID]
+		if !ok {
+			nodes = make(map[string]int)
+			m.remoteDevices[entry.UserID] = nodes
+		}
+		nodes[event.NodeID] = entry.Devices
+
+		if !known && entry.Status != "" {
+			status := m.userStatus(entry.UserID)
+			status.Status = entry.Status
+			status.IsActive = entry.IsActive
+			m.broadcastStatusLocal(entry.UserID, entry.Status)
+		}
+	}
+	m.forgetNode(event.NodeID, present)
+}
+
+// expireNodes забывает устройства узлов, от которых давно не было событий: узел остановлен
+// аварийно и не успел сообщить об отключении своих клиентов (только в цикле Run)
+func (m *Manager) expireNodes(now time.Time) {
+	for nodeID, lastSeen := range m.remoteNodes {
+		if now.Sub(lastSeen) <= nodeExpiryBeats*m.Heartbeat {
+			continue
+		}
+		log.Printf("⚠️ Узел %s не отвечает %v, его соединения считаются закрытыми", nodeID, now.Sub(lastSeen).Round(time.Second))
+		delete(m.remoteNodes, nodeID)
+		m.forgetNode(nodeID, nil)
+	}
+}
+
+// forgetNode удаляет устройства узла nodeID у всех пользователей, кроме keep. Пользователи,
+// у которых не осталось устройств ни на одном узле, уходят в offline (только в цикле Run).
+// Каждый узел выполняет это сам, поэтому статус рассылается только локальным клиентам.
+func (m *Manager) forgetNode(nodeID string, keep map[int]bool) {
+	for userID, nodes := range m.remoteDevices {
+		if _, ok := nodes[nodeID]; !ok || keep[userID] {
+			continue
+		}
+		delete(nodes, nodeID)
+		if len(nodes) > 0 {
+			continue
+		}
+		delete(m.remoteDevices, userID)
+		if len(m.Clients[userID]) > 0 {
+			continue
+		}
+
+		status := m.userStatus(userID)
+		status.Connected = false
+		if status.Status != "offline" {
+			status.Status = "offline"
+			status.IsActive = false
+			status.LastSeen = time.Now()
+			m.broadcastStatusLocal(userID, "offline")
+		}
+	}
+}
+
+// remoteDeviceCount возвращает количество устройств пользователя на других узлах (только в цикле Run)
+func (m *Manager) remoteDeviceCount(userID int) int {
+	total := 0
+	for _, devices := range m.remoteDevices[userID] {
+		total += devices
+	}
+	return total
+}
@@ -0,0 +1,84 @@
+// websocket/bus_redis.go
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Канал Redis по умолчанию
+const defaultRedisBusChannel = "chat:events"
+
+// RedisBus - шина событий поверх Redis Pub/Sub для запуска нескольких экземпляров сервера
+type RedisBus struct {
+	client  *redis.Client
+	channel string
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewRedisBus подключается к Redis по URL (например, redis://localhost:6379/0)
+func NewRedisBus(url, password, channel string) (*RedisBus, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		opts.Password = password
+	}
+	if channel == "" {
+		channel = defaultRedisBusChannel
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := client.Ping(ctx).Err(); err != nil {
+		cancel()
+		client.Close()
+		return nil, err
+	}
+
+	log.Printf("✅ Шина событий подключена к Redis (%s, канал %s)", opts.Addr, channel)
+	return &RedisBus{client: client, channel: channel, ctx: ctx, cancel: cancel}, nil
+}
+
+// Publish публикует событие в канал Redis
+func (b *RedisBus) Publish(event BusEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(b.ctx, b.channel, data).Err()
+}
+
+// Subscribe подписывается на канал Redis и вызывает обработчик для каждого события.
+// События обрабатываются по одному в порядке получения.
+func (b *RedisBus) Subscribe(handler func(BusEvent)) error {
+	pubsub := b.client.Subscribe(b.ctx, b.channel)
+	if _, err := pubsub.Receive(b.ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	go func() {
+		defer pubsub.Close()
+		for msg := range pubsub.Channel() {
+			var event BusEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				log.Printf("⚠️ Некорректное событие в шине Redis: %v", err)
+				continue
+			}
+			handler(event)
+		}
+	}()
+	return nil
+}
+
+// Close отключается от Redis
+func (b *RedisBus) Close() error {
+	b.cancel()
+	return b.client.Close()
+}
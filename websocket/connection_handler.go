@@ -6,23 +6,50 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/LilVoxy/coursework_chat/auth"
+	"github.com/LilVoxy/coursework_chat/processor"
 	"github.com/gorilla/mux"
 )
 
 // HandleConnections обрабатывает WebSocket-соединения
 func (manager *Manager) HandleConnections(w http.ResponseWriter, r *http.Request) {
-	// Получаем ID пользователя из URL
-	params := mux.Vars(r)
-	userIdStr := params["userId"]
-	log.Printf("Получен запрос на установку WebSocket с параметром userId=%s, полный URL: %s", userIdStr, r.URL.String())
+	// ID пользователя определяется только по проверенному токену
+	userId, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Требуется авторизация", http.StatusUnauthorized)
+		return
+	}
 
-	// Проверяем, что ID является числом
-	userId, err := strconv.Atoi(userIdStr)
+	// ID в URL поддерживается для обратной совместимости и должен совпадать с токеном
+	if userIdStr := mux.Vars(r)["userId"]; userIdStr != "" {
+		requestedID, err := strconv.Atoi(userIdStr)
+		if err != nil {
+			log.Printf("Невалидный ID пользователя: %s, ошибка: %v", userIdStr, err)
+			http.Error(w, "Невалидный ID пользователя", http.StatusBadRequest)
+			return
+		}
+		if requestedID != userId {
+			log.Printf("⚠️ Пользователь %d пытался подключиться как %d", userId, requestedID)
+			http.Error(w, "Доступ запрещен", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Политика медленного клиента может быть выбрана при подключении
+	policy, err := connectionPolicy(r, manager.Backpressure.Policy)
 	if err != nil {
-		log.Printf("Невалидный ID пользователя: %s, ошибка: %v", userIdStr, err)
-		http.Error(w, "Невалидный ID пользователя", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Во время остановки сервера новые соединения не принимаются.
+	// Соединение учитывается до завершения readPump, чтобы Shutdown дождался его отключения.
+	if !manager.acquire(&manager.conns) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
@@ -32,75 +59,66 @@ func (manager *Manager) HandleConnections(w http.ResponseWriter, r *http.Request
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Ошибка при установке WebSocket-соединения:", err)
+		manager.conns.Done()
 		return
 	}
 
-	// Создаем нового клиента
+	// Создаем нового клиента. У пользователя может быть несколько одновременных
+	// соединений (телефон, ноутбук), каждое получает собственный ID сессии
 	client := &Client{
 		ID:           userId,
 		UserID:       userId,
+		SessionID:    newSessionID(),
 		Socket:       conn,
 		Conn:         conn,
-		Send:         make(chan []byte, 256),
+		Send:         make(chan []byte, manager.Backpressure.QueueSize),
 		Manager:      manager,
 		LastActivity: time.Now(),
+		Encoding:     encodingBySubprotocol(conn.Subprotocol()),
+		RemoteAddr:   r.RemoteAddr,
+		Policy:       policy,
+		notify:       make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		finished:     make(chan struct{}),
 	}
 
-	// Если клиент с таким ID уже существует, отключаем его
-	if existingClient, ok := manager.Clients[userId]; ok {
-		log.Printf("Пользователь ID: %d уже подключен. Заменяем соединение.", userId)
-
-		// Безопасно закрываем канал существующего клиента
-		// Удаляем клиента из менеджера перед закрытием канала
-		delete(manager.Clients, userId)
-
-		// Закрываем соединение и канал
-		existingClient.Socket.Close()
-		// Закрываем канал только если он еще не закрыт
-		select {
-		case _, ok := <-existingClient.Send:
-			if ok {
-				close(existingClient.Send)
-			}
-		default:
-			close(existingClient.Send)
-		}
-	}
+	client.setCodecs(negotiateCodecs(r, manager.Codecs))
 
-	// Регистрируем клиента в менеджере
-	manager.Register <- client
+	// Очередь отправки обслуживается с самого начала, чтобы начальные кадры не ждали ее освобождения
+	go client.writePump()
+
+	// Сообщаем клиенту ID его сессии, согласованные кодеки и кодировку кадров
+	session := Message{Type: "session", UserID: userId, SessionID: client.SessionID, Codecs: client.Codecs, Encoding: client.Encoding.Name()}
+	if sessionData, err := json.Marshal(session); err == nil {
+		client.send(sessionData)
+	}
 
-	// Обновляем статус пользователя при подключении
-	manager.statusMutex.Lock()
-	if status, exists := manager.UserStatuses[userId]; exists {
-		status.Connected = true
-		status.ConnectionID = r.RemoteAddr
-		status.LastSeen = time.Now()
+	// Объявляем версии протокола и возможности сервера
+	if helloData, err := json.Marshal(manager.helloFrame(client)); err == nil {
+		client.send(helloData)
 	}
-	manager.statusMutex.Unlock()
 
-	// Вызываем обновление статуса через централизованную функцию
-	manager.updateUserStatus(userId, "online", true)
-	log.Printf("✅ Пользователь %d подключился с адреса %s", userId, r.RemoteAddr)
+	// Регистрируем клиента в менеджере: цикл менеджера отмечает пользователя в сети
+	manager.do(func() { manager.registerClient(client) })
+	log.Printf("✅ Пользователь %d подключился с адреса %s (сессия %s, %s)", userId, r.RemoteAddr, client.SessionID, client.Encoding.Name())
 
 	// Отправляем новому клиенту статусы всех пользователей (кроме него самого)
-	manager.statusMutex.RLock()
-	for userID, status := range manager.UserStatuses {
-		// Не отправляем пользователю его собственный статус
-		if userID != userId {
-			statusMsg := Message{
-				Type:   "status",
-				UserID: userID,
-				Status: status.Status,
-			}
-			if statusData, err := json.Marshal(statusMsg); err == nil {
-				client.Send <- statusData
-			}
+	for _, statusMsg := range manager.statusSnapshot(userId) {
+		if statusData, err := json.Marshal(statusMsg); err == nil {
+			client.send(statusData)
 		}
 	}
-	manager.statusMutex.RUnlock()
 
-	// Запускаем горутины для чтения и отправки сообщений
+	// Запускаем чтение сообщений клиента
 	go client.readPump()
-	go client.writePump()
+}
+
+// negotiateCodecs согласует кодеки сжатия по параметру подключения codecs (имена через запятую).
+// Без параметра доступен только none.
+func negotiateCodecs(r *http.Request, allowed []processor.Codec) []processor.Codec {
+	var offered []string
+	if value := r.URL.Query().Get("codecs"); value != "" {
+		offered = strings.Split(value, ",")
+	}
+	return processor.NegotiateCodecs(offered, allowed)
 }
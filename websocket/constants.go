@@ -5,20 +5,20 @@ import (
 	"time"
 )
 
-// Константы для WebSocket-соединения
+// Константы для WebSocket-соединения.
+// Таймауты соединения и размер кадра настраиваются (config.WebSocketConfig, поле Manager.Limits).
 const (
-	// Время ожидания записи сообщения клиенту
-	writeWait = 10 * time.Second
+	// Размер очереди отправки соединения по умолчанию (CHAT_SEND_QUEUE_SIZE)
+	defaultSendQueueSize = 256
 
-	// Время ожидания сообщения от клиента
-	pongWait = 60 * time.Second
-
-	// Период отправки пинг-сообщений
-	pingPeriod = (pongWait * 9) / 10
-
-	// Максимальный размер сообщения
-	maxMessageSize = 512 * 1024 // 512KB
+	// Время, через которое сервер сам снимает индикатор набора текста,
+	// если клиент не прислал typing_stopped
+	typingTimeout = 6 * time.Second
+)
 
-	// Добавляем таймаут для определения неактивности
-	inactivityTimeout = 65 * time.Second
+// Состояния сообщения: отправлено -> доставлено -> прочитано
+const (
+	messageStateSent      = "sent"
+	messageStateDelivered = "delivered"
+	messageStateRead      = "read"
 )
@@ -1,11 +0,0 @@
-// websocket/db_types.go
-package websocket
-
-// Настройки базы данных
-type DBInfo struct {
-	Username string
-	Password string
-	Host     string
-	Port     string
-	Database string
-}
@@ -0,0 +1,354 @@
+// websocket/edits.go
+package websocket
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/LilVoxy/coursework_chat/attachments"
+)
+
+// Области удаления сообщения
+const (
+	DeleteScopeMe       = "me"       // Сообщение скрывается только у пользователя, который его удалил
+	DeleteScopeEveryone = "everyone" // Сообщение заменяется «надгробием» у всех участников чата
+)
+
+// Ошибки редактирования и удаления сообщений
+var (
+	ErrMessageNotFound = errors.New("сообщение не найдено")
+	ErrNotAuthor       = errors.New("изменять сообщение может только его автор")
+	ErrMessageDeleted  = errors.New("сообщение удалено")
+	ErrEmptyContent    = errors.New("текст сообщения не может быть пустым")
+	ErrInvalidScope    = errors.New("неизвестная область удаления")
+	ErrEncryptedEdit   = errors.New("сообщения со сквозным шифрованием нельзя редактировать")
+)
+
+// MessageEdit - предыдущая версия отредактированного сообщения
+type MessageEdit struct {
+	Content  string    `json:"content"`
+	EditedBy int       `json:"editedBy"`
+	EditedAt time.Time `json:"editedAt"` // Момент, когда эта версия была заменена
+}
+
+// storedMessage - сообщение, заблокированное для изменения (текст зашифрован)
+type storedMessage struct {
+	ChatID    int
+	SenderID  int
+	Content   string
+	DeletedAt sql.NullTime
+	E2E       bool // Сообщение зашифровано на клиенте или отправлено в чат со сквозным шифрованием
+}
+
+// lockMessage загружает сообщение в транзакции с блокировкой строки
+func lockMessage(tx *sql.Tx, messageID int) (storedMessage, error) {
+	var msg storedMessage
+	err := tx.QueryRow(`
+		SELECT m.chat_id, m.sender_id, m.message, m.deleted_at,
+		       c.e2e OR EXISTS (SELECT 1 FROM message_e2e_payloads p WHERE p.message_id = m.id)
+		FROM messages m
+		JOIN chats c ON c.id = m.chat_id
+		WHERE m.id = ?
+		FOR UPDATE
+	`, messageID).Scan(&msg.ChatID, &msg.SenderID, &msg.Content, &msg.DeletedAt, &msg.E2E)
+	if err == sql.ErrNoRows {
+		return msg, ErrMessageNotFound
+	}
+	return msg, err
+}
+
+// EditMessage заменяет текст сообщения от имени userID и сохраняет предыдущую версию в истории.
+// Изменение рассылается всем участникам чата.
+func (m *Manager) EditMessage(messageID, userID int, content string) error {
+	if content == "" {
+		return ErrEmptyContent
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stored, err := lockMessage(tx, messageID)
+	if err != nil {
+		return err
+	}
+	if stored.SenderID != userID {
+		return ErrNotAuthor
+	}
+	// Автор, удаленный из чата, больше не может менять в нем сообщения
+	if _, err := m.participantRole(stored.ChatID, userID); err != nil {
+		return err
+	}
+	if stored.DeletedAt.Valid {
+		return ErrMessageDeleted
+	}
+	// Сервер не может изменить текст, который он не видит, а открытый текст в таком чате недопустим
+	if stored.E2E {
+		return ErrEncryptedEdit
+	}
+	if m.Messages.Decrypt(stored.Content) == content {
+		return nil
+	}
+
+	// Предыдущая версия уходит в историю в том же зашифрованном виде
+	encrypted, err := m.Messages.Encrypt(content)
+	if err != nil {
+		return err
+	}
+	editedAt := time.Now()
+	if _, err := tx.Exec(`
+		INSERT INTO message_edits (message_id, content, encrypted, edited_by, edited_at)
+		VALUES (?, ?, TRUE, ?, ?)
+	`, messageID, stored.Content, userID, editedAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE messages SET message = ?, edited_at = ? WHERE id = ?
+	`, encrypted, editedAt, messageID); err != nil {
+		return err
+	}
+	if err := m.Messages.IndexMessage(tx, messageID, stored.ChatID, content); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("✏️ Пользователь %d отредактировал сообщение %d в чате %d", userID, messageID, stored.ChatID)
+
+	m.deliverToParticipants(stored.ChatID, messageID, Message{
+		Type:      eventTypeEdit,
+		ChatID:    stored.ChatID,
+		MessageID: messageID,
+		FromID:    stored.SenderID,
+		Content:   content,
+		EditedAt:  editedAt.Format(time.RFC3339),
+	})
+	return nil
+}
+
+// DeleteMessage удаляет сообщение от имени userID.
+// Область "me" скрывает сообщение только для пользователя (на всех его устройствах),
+// область "everyone" стирает текст и историю правок, оставляя «надгробие», которое видят все участники.
+// Удалить сообщение для всех может его автор или агент поддержки чата.
+func (m *Manager) DeleteMessage(messageID, userID int, scope string) error {
+	switch scope {
+	case DeleteScopeMe:
+		return m.hideMessage(messageID, userID)
+	case DeleteScopeEveryone:
+		return m.tombstoneMessage(messageID, userID)
+	default:
+		return ErrInvalidScope
+	}
+}
+
+// hideMessage скрывает сообщение для одного пользователя
+func (m *Manager) hideMessage(messageID, userID int) error {
+	var chatID int
+	err := m.DB.QueryRow(`SELECT chat_id FROM messages WHERE id = ?`, messageID).Scan(&chatID)
+	if err == sql.ErrNoRows {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := m.participantRole(chatID, userID); err != nil {
+		return err
+	}
+
+	deletedAt := time.Now()
+	result, err := m.DB.Exec(`
+		INSERT IGNORE INTO message_hidden (message_id, user_id, hidden_at) VALUES (?, ?, ?)
+	`, messageID, userID, deletedAt)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		// Сообщение уже скрыто
+		return err
+	}
+
+	log.Printf("🗑️ Пользователь %d удалил у себя сообщение %d в чате %d", userID, messageID, chatID)
+
+	// Остальные устройства пользователя тоже должны скрыть сообщение
+	m.deliverEvent(userID, Message{
+		Type:      eventTypeDelete,
+		ChatID:    chatID,
+		MessageID: messageID,
+		Scope:     DeleteScopeMe,
+		Deleted:   true,
+		DeletedAt: deletedAt.Format(time.RFC3339),
+	})
+	return nil
+}
+
+// tombstoneMessage удаляет сообщение для всех участников чата
+func (m *Manager) tombstoneMessage(messageID, userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stored, err := lockMessage(tx, messageID)
+	if err != nil {
+		return err
+	}
+	if stored.DeletedAt.Valid {
+		return nil
+	}
+	if stored.SenderID != userID {
+		role, err := m.participantRole(stored.ChatID, userID)
+		if err != nil {
+			return err
+		}
+		if role != RoleSupport {
+			return ErrNotAuthor
+		}
+	}
+
+	deletedAt := time.Now()
+	if _, err := tx.Exec(`
+		UPDATE messages SET message = '', deleted_at = ?, deleted_by = ? WHERE id = ?
+	`, deletedAt, userID, messageID); err != nil {
+		return err
+	}
+	// Удаленный для всех текст не должен сохраниться ни в истории правок, ни в поисковом индексе, а вложение - в хранилище
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id = ?`, messageID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_e2e_payloads WHERE message_id = ?`, messageID); err != nil {
+		return err
+	}
+	if err := attachments.MarkDeleted(tx, messageID); err != nil {
+		return err
+	}
+	if err := m.Messages.UnindexMessage(tx, messageID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("🗑️ Пользователь %d удалил для всех сообщение %d в чате %d", userID, messageID, stored.ChatID)
+
+	m.deliverToParticipants(stored.ChatID, messageID, Message{
+		Type:      eventTypeDelete,
+		ChatID:    stored.ChatID,
+		MessageID: messageID,
+		FromID:    stored.SenderID,
+		Scope:     DeleteScopeEveryone,
+		Deleted:   true,
+		DeletedAt: deletedAt.Format(time.RFC3339),
+	})
+	return nil
+}
+
+// GetMessageHistory возвращает предыдущие версии сообщения (от старых к новым).
+// История доступна участникам чата, у которых сообщение не скрыто.
+func (m *Manager) GetMessageHistory(messageID, userID int) ([]MessageEdit, error) {
+	var chatID int
+	err := m.DB.QueryRow(`SELECT chat_id FROM messages WHERE id = ?`, messageID).Scan(&chatID)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.participantRole(chatID, userID); err != nil {
+		return nil, err
+	}
+	if hidden, err := m.isMessageHidden(messageID, userID); err != nil || hidden {
+		if err == nil {
+			err = ErrMessageNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := m.DB.Query(`
+		SELECT content, edited_by, edited_at FROM message_edits
+		WHERE message_id = ?
+		ORDER BY id
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []MessageEdit{}
+	for rows.Next() {
+		var edit MessageEdit
+		if err := rows.Scan(&edit.Content, &edit.EditedBy, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edit.Content = m.Messages.Decrypt(edit.Content)
+		history = append(history, edit)
+	}
+	return history, rows.Err()
+}
+
+// isMessageHidden сообщает, удалил ли пользователь сообщение у себя
+func (m *Manager) isMessageHidden(messageID, userID int) (bool, error) {
+	var hidden bool
+	err := m.DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM message_hidden WHERE message_id = ? AND user_id = ?)
+	`, messageID, userID).Scan(&hidden)
+	return hidden, err
+}
+
+// deliverToParticipants отправляет событие об изменении сообщения всем участникам чата,
+// кроме тех, кто удалил это сообщение у себя
+func (m *Manager) deliverToParticipants(chatID, messageID int, frame Message) {
+	participants, err := m.GetChatParticipants(chatID)
+	if err != nil {
+		log.Printf("⚠️ Не удалось получить участников чата %d: %v", chatID, err)
+		return
+	}
+
+	for _, p := range participants {
+		if hidden, err := m.isMessageHidden(messageID, p.UserID); err == nil && hidden {
+			continue
+		}
+		m.deliverEvent(p.UserID, frame)
+	}
+}
+
+// handleEdit обрабатывает кадр edit: {"type":"edit","messageId":1,"content":"..."}
+func (m *Manager) handleEdit(message []byte, client *Client) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, newFrameError(ErrCodeBadFrame, "некорректный запрос на редактирование: %v", err)
+	}
+	if msg.MessageID == 0 {
+		return nil, newFrameError(ErrCodeInvalidRequest, "запрос на редактирование без messageId")
+	}
+
+	if err := m.EditMessage(msg.MessageID, client.UserID, msg.Content); err != nil {
+		return nil, err
+	}
+	return &Message{ID: msg.MessageID}, nil
+}
+
+// handleDelete обрабатывает кадр delete: {"type":"delete","messageId":1,"scope":"me|everyone"}
+func (m *Manager) handleDelete(message []byte, client *Client) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, newFrameError(ErrCodeBadFrame, "некорректный запрос на удаление: %v", err)
+	}
+	if msg.MessageID == 0 {
+		return nil, newFrameError(ErrCodeInvalidRequest, "запрос на удаление без messageId")
+	}
+	if msg.Scope == "" {
+		msg.Scope = DeleteScopeMe
+	}
+
+	if err := m.DeleteMessage(msg.MessageID, client.UserID, msg.Scope); err != nil {
+		return nil, err
+	}
+	return &Message{ID: msg.MessageID}, nil
+}
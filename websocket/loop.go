@@ -0,0 +1,108 @@
+// websocket/loop.go
+package websocket
+
+import (
+	"sync"
+)
+
+// Размер очереди команд цикла менеджера
+const loopInboxSize = 1024
+
+// Состоянием соединений (Clients), статусами пользователей (UserStatuses) и количеством
+// устройств на других узлах (remoteDevices) владеет только горутина Run. Остальные горутины
+// (readPump, HTTP-обработчики, подписчик шины, таймеры) передают ей команды через do и call,
+// поэтому эти структуры не требуют блокировок.
+//
+// Команды цикла не должны блокироваться. Шина внутри процесса вызывает обработчики синхронно,
+// и публикация из цикла вернулась бы в него же командой do, поэтому все, что публикует события
+// или обращается к БД, цикл откладывает в очередь effects и выполняет вне своей горутины.
+
+// do передает команду циклу менеджера, не дожидаясь ее выполнения.
+// Нельзя вызывать из самого цикла: там команды выполняются напрямую.
+// После остановки цикла команды не выполняются.
+func (m *Manager) do(fn func()) {
+	select {
+	case m.inbox <- fn:
+	case <-m.stopped:
+	}
+}
+
+// call выполняет команду в цикле менеджера и дожидается ее завершения
+// (если цикл уже остановлен, команда не выполняется)
+func (m *Manager) call(fn func()) {
+	done := make(chan struct{})
+	m.do(func() {
+		defer close(done)
+		fn()
+	})
+	select {
+	case <-done:
+	case <-m.stopped:
+	}
+}
+
+// effectQueue - очередь действий, которые цикл менеджера выполняет вне своей горутины
+// (публикация в шину, снятие индикаторов набора текста). Очередь не ограничена, поэтому цикл
+// никогда не ждет ее освобождения, а действия выполняются строго в порядке добавления.
+type effectQueue struct {
+	mu    sync.Mutex
+	items []func()
+	wake  chan struct{}
+	quit  chan struct{}
+	done  chan struct{} // Закрывается, когда run выполнил оставшиеся действия и завершился
+}
+
+func newEffectQueue() *effectQueue {
+	return &effectQueue{
+		wake: make(chan struct{}, 1),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// push добавляет действие в очередь
+func (q *effectQueue) push(fn func()) {
+	q.mu.Lock()
+	q.items = append(q.items, fn)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run выполняет действия по мере поступления, а после stop - оставшиеся действия, и завершается
+func (q *effectQueue) run() {
+	defer close(q.done)
+	for {
+		select {
+		case <-q.wake:
+			q.drain()
+		case <-q.quit:
+			q.drain()
+			return
+		}
+	}
+}
+
+// stop завершает run после выполнения уже добавленных действий
+func (q *effectQueue) stop() {
+	close(q.quit)
+}
+
+func (q *effectQueue) drain() {
+	for {
+		q.mu.Lock()
+		items := q.items
+		q.items = nil
+		q.mu.Unlock()
+
+		if len(items) == 0 {
+			return
+		}
+		for _, fn := range items {
+			fn()
+		}
+	}
+}
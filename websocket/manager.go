@@ -2,10 +2,15 @@
 package websocket
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
 	"log"
 	"time"
+
+	"github.com/LilVoxy/coursework_chat/config"
+	"github.com/LilVoxy/coursework_chat/migrate"
+	"github.com/LilVoxy/coursework_chat/processor"
+	"github.com/gorilla/websocket"
 )
 
 // Установка глобального менеджера
@@ -23,80 +28,164 @@ func GetGlobalManager() *Manager {
 	return globalManager
 }
 
-// Создание нового менеджера WebSocket-соединений
-func NewManager(db *sql.DB) *Manager {
+// Создание нового менеджера WebSocket-соединений. Таймауты, очередь отправки, кодеки
+// и адрес для переподключения берутся из секций websocket, delivery и cluster конфигурации.
+// Если шина не передана, используется шина внутри процесса (один экземпляр сервера).
+func NewManager(db *sql.DB, bus Bus, cfg config.Config) *Manager {
+	if bus == nil {
+		bus = NewMemoryBus()
+	}
+	codecs, err := processor.CodecsByName(cfg.Delivery.Codecs)
+	if err != nil {
+		log.Printf("⚠️ delivery.codecs: %v, разрешены все кодеки", err)
+		codecs = processor.Codecs()
+	}
+	backpressure, err := NewBackpressureConfig(cfg.Delivery)
+	if err != nil {
+		log.Printf("⚠️ %v, используются значения по умолчанию", err)
+	}
 	return &Manager{
-		Broadcast:    make(chan []byte),
-		Register:     make(chan *Client),
-		Unregister:   make(chan *Client),
-		Clients:      make(map[int]*Client),
-		DB:           db,
-		UserStatuses: make(map[int]*UserStatus),
+		Broadcast:     make(chan []byte),
+		Register:      make(chan *Client),
+		Unregister:    make(chan *Client),
+		Clients:       make(map[int]map[string]*Client),
+		DB:            db,
+		UserStatuses:  make(map[int]*UserStatus),
+		Bus:           bus,
+		NodeID:        NewNodeID(),
+		remoteDevices: make(map[int]map[string]int),
+		remoteNodes:   make(map[string]time.Time),
+		Heartbeat:     cfg.Cluster.HeartbeatInterval,
+		inbox:         make(chan func(), loopInboxSize),
+		effects:       newEffectQueue(),
+		typing:        make(map[typingKey]*typingState),
+		Codecs:        codecs,
+		Backpressure:  backpressure,
+		Limits:        cfg.WebSocket,
+		ReconnectURL:  cfg.Cluster.ReconnectURL,
+		quit:          make(chan struct{}),
+		stopped:       make(chan struct{}),
 	}
 }
 
-// Run запускает работу менеджера
+// Run запускает цикл менеджера. Только он изменяет список соединений и статусы пользователей.
+// Цикл работает до остановки менеджера (Shutdown).
 func (manager *Manager) Run() {
-	// Запускаем мониторинг активности пользователей
-	go manager.checkUserActivity()
+	defer close(manager.stopped)
+
+	// Действия цикла, которые публикуют события, выполняются по порядку в отдельной горутине
+	go manager.effects.run()
+
+	// Подписываемся на события шины: через нее идет вся доставка клиентам этого узла
+	if err := manager.Bus.Subscribe(manager.onBusEvent); err != nil {
+		log.Printf("❌ Ошибка подписки на шину событий: %v", err)
+	}
+	log.Printf("✅ Менеджер WebSocket запущен на узле %s", manager.NodeID)
+
+	// Узел, подключившийся позже других, запрашивает у них, кто уже в сети
+	manager.effects.push(func() { manager.publish(BusEvent{Kind: busSync}) })
+	if manager.Heartbeat <= 0 {
+		manager.Heartbeat = defaultHeartbeatInterval
+	}
+	heartbeat := time.NewTicker(manager.Heartbeat)
+	defer heartbeat.Stop()
+
+	// Мониторинг активности пользователей
+	activity := time.NewTicker(manager.Limits.InactivityTimeout / 2)
+	defer activity.Stop()
+
+	// Запускаем очистку устаревших событий из очередей пользователей
+	go manager.pruneEvents()
 
 	for {
 		select {
+		case fn := <-manager.inbox:
+			fn()
+
 		case client := <-manager.Register:
-			manager.Clients[client.ID] = client
-			log.Printf("👤 Клиент %d подключился", client.ID)
+			manager.registerClient(client)
 
 		case client := <-manager.Unregister:
-			if _, ok := manager.Clients[client.ID]; ok {
-				delete(manager.Clients, client.ID)
-				close(client.Send)
-				log.Printf("👤 Клиент %d отключился", client.ID)
-
-				// Обновляем статус на "offline" при отключении
-				manager.updateUserStatus(client.ID, "offline", false)
-			}
+			manager.unregisterClient(client)
 
 		case message := <-manager.Broadcast:
-			// Рассылаем сообщение всем подключенным клиентам
-			manager.broadcast(message)
+			// Рассылаем сообщение всем подключенным клиентам на всех узлах
+			manager.effects.push(func() {
+				manager.publish(BusEvent{Kind: busBroadcast, Payload: message})
+			})
+
+		case <-activity.C:
+			manager.checkUserActivity()
+
+		case <-heartbeat.C:
+			manager.expireNodes(time.Now())
+			manager.announceSnapshot()
+
+		case <-manager.quit:
+			log.Printf("✅ Цикл менеджера WebSocket остановлен")
+			return
 		}
 	}
 }
 
-// broadcast отправляет сообщение всем подключенным клиентам
-func (manager *Manager) broadcast(message []byte) {
-	for _, client := range manager.Clients {
-		select {
-		case client.Send <- message:
-		default:
-			close(client.Send)
-			delete(manager.Clients, client.ID)
-		}
+// registerClient добавляет соединение и отмечает пользователя в сети (только в цикле Run).
+// Соединение, которое завершило подключение уже после начала остановки, не регистрируется
+// и сразу закрывается: Shutdown закрывает только зарегистрированные соединения.
+func (manager *Manager) registerClient(client *Client) {
+	if manager.isDraining() {
+		client.closeGracefully(websocket.CloseServiceRestart, manager.restartReason())
+		return
 	}
+	manager.addClient(client)
+	log.Printf("👤 Клиент %d подключился (сессия %s, устройств: %d)",
+		client.UserID, client.SessionID, len(manager.Clients[client.UserID]))
+
+	status := manager.userStatus(client.UserID)
+	status.Connected = true
+	status.ConnectionID = client.RemoteAddr
+	status.LastSeen = time.Now()
+	status.LastPing = time.Now()
+
+	// Сообщаем другим узлам о новом устройстве пользователя и рассылаем статус
+	manager.announcePresence(client.UserID, false)
+	manager.setUserStatus(client.UserID, "online", true)
 }
 
-// Инициализация базы данных
-func InitDB() (*sql.DB, error) {
-	// Настройки для подключения к базе данных
-	dbInfo := &DBInfo{
-		Username: "root",
-		Password: "Vjnbkmlf40782",
-		Host:     "localhost",
-		Port:     "3306",
-		Database: "chatdb",
-	}
-
-	// Формируем строку подключения
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		dbInfo.Username,
-		dbInfo.Password,
-		dbInfo.Host,
-		dbInfo.Port,
-		dbInfo.Database,
-	)
+// unregisterClient удаляет соединение (только в цикле Run). Пользователь уходит в offline
+// только после отключения последнего устройства (в том числе на других узлах).
+func (manager *Manager) unregisterClient(client *Client) {
+	removed, last := manager.removeClient(client)
+	if !removed {
+		return
+	}
+	client.close()
+	log.Printf("👤 Клиент %d отключился (сессия %s)", client.UserID, client.SessionID)
+
+	if !last {
+		manager.announcePresence(client.UserID, false)
+		return
+	}
+
+	// Снимаем индикаторы набора текста отключившегося пользователя
+	userID := client.UserID
+	manager.effects.push(func() { manager.clearUserTyping(userID) })
+
+	if manager.remoteDeviceCount(userID) > 0 {
+		manager.announcePresence(userID, false)
+		return
+	}
 
+	if status, exists := manager.UserStatuses[userID]; exists {
+		status.Connected = false
+	}
+	// Обновляем статус на "offline" при отключении
+	manager.setUserStatus(userID, "offline", false)
+}
+
+// Инициализация базы данных по настройкам подключения (секция database конфигурации)
+func InitDB(cfg config.DatabaseConfig) (*sql.DB, error) {
 	// Устанавливаем соединение с базой данных
-	db, err := sql.Open("mysql", dsn)
+	db, err := sql.Open("mysql", cfg.DSN("charset=utf8mb4&parseTime=True&loc=Local"))
 	if err != nil {
 		log.Printf("❌ Ошибка подключения к БД: %v", err)
 		return nil, err
@@ -105,81 +194,31 @@ func InitDB() (*sql.DB, error) {
 	// Проверяем соединение
 	if err := db.Ping(); err != nil {
 		log.Printf("❌ Ошибка проверки соединения с БД: %v", err)
+		db.Close()
 		return nil, err
 	}
 
 	// Устанавливаем параметры пула соединений
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 
-	log.Println("✅ Успешное подключение к базе данных")
+	log.Printf("✅ Успешное подключение к базе данных %s на %s:%d", cfg.Name, cfg.Host, cfg.Port)
 
-	// Проверяем существование необходимых таблиц
-	if err := createTablesIfNotExist(db); err != nil {
-		log.Printf("❌ Ошибка создания таблиц: %v", err)
-		return nil, err
-	}
-
-	return db, nil
-}
-
-// Создание необходимых таблиц, если они не существуют
-func createTablesIfNotExist(db *sql.DB) error {
-	// SQL для создания таблицы чатов
-	createChatsTable := `
-	CREATE TABLE IF NOT EXISTS chats (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		buyer_id INT NOT NULL,
-		seller_id INT NOT NULL,
-		product_id INT NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		INDEX idx_participants (buyer_id, seller_id, product_id)
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
-
-	// SQL для создания таблицы сообщений
-	createMessagesTable := `
-	CREATE TABLE IF NOT EXISTS messages (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		chat_id INT NOT NULL,
-		sender_id INT NOT NULL,
-		message TEXT NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		read_status BOOLEAN DEFAULT FALSE,
-		FOREIGN KEY (chat_id) REFERENCES chats(id),
-		INDEX idx_chat_id (chat_id)
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
-
-	// Выполняем создание таблиц
-	if _, err := db.Exec(createChatsTable); err != nil {
-		return fmt.Errorf("ошибка создания таблицы chats: %v", err)
-	}
-
-	if _, err := db.Exec(createMessagesTable); err != nil {
-		return fmt.Errorf("ошибка создания таблицы messages: %v", err)
-	}
-
-	// Проверяем наличие поля read_status и добавляем его, если отсутствует
-	var columnExists bool
-	err := db.QueryRow(`
-		SELECT COUNT(*) 
-		FROM information_schema.COLUMNS 
-		WHERE TABLE_SCHEMA = DATABASE() 
-		AND TABLE_NAME = 'messages' 
-		AND COLUMN_NAME = 'read_status'
-	`).Scan(&columnExists)
-
-	if err != nil {
-		log.Printf("⚠️ Ошибка при проверке наличия поля read_status: %v", err)
-	} else if !columnExists {
-		_, err := db.Exec(`ALTER TABLE messages ADD COLUMN read_status BOOLEAN DEFAULT FALSE`)
+	// Приводим схему к текущей версии
+	if cfg.AutoMigrate {
+		migrator, err := migrate.New(db, migrate.OLTP)
 		if err != nil {
-			log.Printf("❌ Ошибка при добавлении поля read_status: %v", err)
-		} else {
-			log.Println("✅ Добавлено поле read_status в таблицу messages")
+			db.Close()
+			return nil, err
 		}
+		if _, err := migrator.Up(context.Background(), 0); err != nil {
+			log.Printf("❌ Ошибка применения миграций: %v", err)
+			db.Close()
+			return nil, err
+		}
+		log.Println("✅ Схема базы данных актуальна")
 	}
 
-	log.Println("✅ Структура базы данных проверена и актуализирована")
-	return nil
+	return db, nil
 }
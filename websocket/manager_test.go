@@ -0,0 +1,449 @@
+// websocket/manager_test.go
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LilVoxy/coursework_chat/auth"
+	"github.com/LilVoxy/coursework_chat/config"
+	"github.com/LilVoxy/coursework_chat/processor"
+	"github.com/gorilla/websocket"
+)
+
+// startManager запускает менеджер без БД с шиной внутри процесса
+func startManager(t *testing.T) *Manager {
+	t.Helper()
+	m := NewManager(nil, nil, config.Default())
+	go m.Run()
+	// Первая команда выполняется, когда цикл уже подписан на шину
+	m.call(func() {})
+	return m
+}
+
+// startServer поднимает HTTP-сервер с обработчиком WebSocket; ID пользователя передается параметром user
+func startServer(t *testing.T, m *Manager) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.URL.Query().Get("user"))
+		if err != nil {
+			http.Error(w, "bad user", http.StatusBadRequest)
+			return
+		}
+		m.HandleConnections(w, r.WithContext(auth.WithUserID(r.Context(), userID)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dial(t *testing.T, srv *httptest.Server, userID int) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?user=" + strconv.Itoa(userID)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Errorf("подключение пользователя %d: %v", userID, err)
+		return nil
+	}
+	return conn
+}
+
+// readUntil читает кадры, пока не встретится кадр нужного типа
+func readUntil(conn *websocket.Conn, frameType string, timeout time.Duration) (Message, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return Message{}, err
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return Message{}, err
+		}
+		if msg.Type == frameType {
+			return msg, nil
+		}
+	}
+}
+
+// eventually повторяет проверку, пока она не пройдет или не истечет время
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if check() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("не дождались: %s", what)
+}
+
+func (m *Manager) clientCount() int {
+	n := 0
+	m.call(func() {
+		for _, sessions := range m.Clients {
+			n += len(sessions)
+		}
+	})
+	return n
+}
+
+func (m *Manager) statusOf(userID int) (status string, connected bool) {
+	m.call(func() {
+		if s, ok := m.UserStatuses[userID]; ok {
+			status, connected = s.Status, s.Connected
+		}
+	})
+	return status, connected
+}
+
+func TestConcurrentConnectDisconnect(t *testing.T) {
+	m := startManager(t)
+	srv := startServer(t, m)
+
+	const workers, rounds, users = 32, 8, 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID := w%users + 1
+			for i := 0; i < rounds; i++ {
+				conn := dial(t, srv, userID)
+				if conn == nil {
+					return
+				}
+				if _, err := readUntil(conn, "hello", 5*time.Second); err != nil {
+					t.Errorf("пользователь %d не получил hello: %v", userID, err)
+				}
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping"}`))
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"status","status":"online"}`))
+				conn.Close()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	eventually(t, "все соединения сняты с регистрации", func() bool { return m.clientCount() == 0 })
+	for userID := 1; userID <= users; userID++ {
+		eventually(t, fmt.Sprintf("пользователь %d offline", userID), func() bool {
+			status, connected := m.statusOf(userID)
+			return status == "offline" && !connected
+		})
+	}
+}
+
+func TestConcurrentSendsDuringChurn(t *testing.T) {
+	m := startManager(t)
+	srv := startServer(t, m)
+
+	// Постоянные получатели считают доставленные им кадры
+	const receivers = 4
+	var received [receivers]int64
+	var readers sync.WaitGroup
+	conns := make([]*websocket.Conn, receivers)
+	for i := range conns {
+		conns[i] = dial(t, srv, 100+i)
+		if conns[i] == nil {
+			t.FailNow()
+		}
+		if _, err := readUntil(conns[i], "hello", 5*time.Second); err != nil {
+			t.Fatalf("получатель %d не получил hello: %v", i, err)
+		}
+		readers.Add(1)
+		go func(i int) {
+			defer readers.Done()
+			for {
+				_, data, err := conns[i].ReadMessage()
+				if err != nil {
+					return
+				}
+				if strings.Contains(string(data), `"test"`) {
+					atomic.AddInt64(&received[i], 1)
+				}
+			}
+		}(i)
+	}
+	eventually(t, "получатели зарегистрированы", func() bool { return m.clientCount() == receivers })
+
+	frame, _ := json.Marshal(Message{Type: "test"})
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// Отправители: доставка конкретным пользователям и рассылка всем
+	for s := 0; s < 8; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				m.publishToUser(100+(s+i)%receivers, frame, false)
+				if i%10 == 0 {
+					m.Broadcast <- frame
+				}
+				m.updateUserStatus(100+s%receivers, []string{"online", "away"}[i%2], i%2 == 0)
+			}
+		}(s)
+	}
+
+	// Параллельно другие пользователи подключаются и отключаются
+	for c := 0; c < 8; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				conn := dial(t, srv, 200+c%4)
+				if conn == nil {
+					return
+				}
+				readUntil(conn, "hello", 5*time.Second)
+				conn.Close()
+			}
+		}(c)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	for i := range conns {
+		i := i
+		eventually(t, fmt.Sprintf("получатель %d получил кадры", i), func() bool {
+			return atomic.LoadInt64(&received[i]) > 0
+		})
+		conns[i].Close()
+	}
+	readers.Wait()
+	eventually(t, "все соединения сняты с регистрации", func() bool { return m.clientCount() == 0 })
+}
+
+func TestSlowClientIsEvicted(t *testing.T) {
+	m := startManager(t)
+
+	// Клиент без сокета, который не читает свою очередь
+	client := &Client{
+		ID:        7,
+		UserID:    7,
+		SessionID: "slow",
+		Send:      make(chan []byte, 1),
+		Manager:   m,
+		done:      make(chan struct{}),
+	}
+	m.Register <- client
+	eventually(t, "клиент зарегистрирован", func() bool { return m.clientCount() == 1 })
+
+	for i := 0; i < 3; i++ {
+		m.publishToUser(7, []byte(`{"type":"test"}`), false)
+	}
+	eventually(t, "медленный клиент отключен", func() bool {
+		select {
+		case <-client.done:
+			return true
+		default:
+			return false
+		}
+	})
+
+	// readPump отключенного клиента снимает его с регистрации
+	m.Unregister <- client
+	eventually(t, "клиент снят с регистрации", func() bool { return m.clientCount() == 0 })
+	if status, connected := m.statusOf(7); status != "offline" || connected {
+		t.Fatalf("статус после отключения: %q, connected=%v", status, connected)
+	}
+}
+
+func TestDroppableFrameDoesNotEvict(t *testing.T) {
+	m := startManager(t)
+
+	client := &Client{
+		ID:        8,
+		UserID:    8,
+		SessionID: "typing",
+		Send:      make(chan []byte, 1),
+		Manager:   m,
+		done:      make(chan struct{}),
+	}
+	m.Register <- client
+	eventually(t, "клиент зарегистрирован", func() bool { return m.clientCount() == 1 })
+
+	for i := 0; i < 3; i++ {
+		m.publishToUser(8, []byte(`{"type":"typing"}`), true)
+	}
+	m.call(func() {})
+	select {
+	case <-client.done:
+		t.Fatal("клиент отключен из-за некритичного кадра")
+	default:
+	}
+}
+
+func TestCheckUserActivityDoesNotDeadlock(t *testing.T) {
+	m := startManager(t)
+
+	m.call(func() {
+		status := m.userStatus(9)
+		status.Status = "online"
+		status.Connected = true
+		status.LastPing = time.Now().Add(-3 * time.Minute)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		m.call(m.checkUserActivity)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("checkUserActivity заблокировал цикл менеджера")
+	}
+
+	if status, _ := m.statusOf(9); status != "offline" {
+		t.Fatalf("ожидался статус offline, получен %q", status)
+	}
+}
+
+// Клиент, согласовавший кодеки сжатия, получает кадры бинарными сообщениями с идентификатором кодека
+func TestNegotiatedCodecsCompressFrames(t *testing.T) {
+	m := startManager(t)
+	srv := startServer(t, m)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?user=1&codecs=zstd,snappy"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType != websocket.BinaryMessage {
+		t.Fatalf("тип сообщения %d, ожидалось бинарное", messageType)
+	}
+	frame, err := processor.DecodeFrame(data)
+	if err != nil {
+		t.Fatalf("DecodeFrame: %v", err)
+	}
+	var session Message
+	if err := json.Unmarshal(frame, &session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Type != "session" || strings.Join(session.Codecs, ",") != "zstd,snappy,none" {
+		t.Fatalf("кадр session: тип %q, кодеки %v", session.Type, session.Codecs)
+	}
+
+	// Кадр hello с кодеком none отключает сжатие
+	hello, _ := json.Marshal(Message{Type: frameTypeHello, Codecs: []string{"none"}})
+	if err := conn.WriteMessage(websocket.TextMessage, hello); err != nil {
+		t.Fatal(err)
+	}
+	var reply Message
+	for reply.Type != frameTypeHello {
+		messageType, data, err = conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		if err := json.Unmarshal(data, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(reply.Codecs, ",") != "none" {
+		t.Fatalf("кодеки после hello: %v", reply.Codecs)
+	}
+}
+
+// Устройства узла, который перестал присылать снимки, забываются, и пользователь уходит в offline
+func TestSilentNodeExpires(t *testing.T) {
+	m := startManager(t)
+
+	m.onBusEvent(BusEvent{Kind: busPresence, NodeID: "crashed", UserID: 5, Devices: 1, Status: "online", Changed: true})
+	m.onBusEvent(BusEvent{Kind: busHeartbeat, NodeID: "crashed", Presence: []PresenceEntry{
+		{UserID: 5, Devices: 1, Status: "online"},
+		{UserID: 6, Devices: 2, Status: "away"},
+	}})
+	if status, _ := m.statusOf(6); status != "away" {
+		t.Fatalf("пользователь из снимка: статус %q, ожидался away", status)
+	}
+
+	// Следующий снимок узла без пользователя 6 снимает его устройства
+	m.onBusEvent(BusEvent{Kind: busHeartbeat, NodeID: "crashed", Presence: []PresenceEntry{{UserID: 5, Devices: 1, Status: "online"}}})
+	if status, _ := m.statusOf(6); status != "offline" {
+		t.Fatalf("пользователь, пропавший из снимка: статус %q, ожидался offline", status)
+	}
+
+	m.call(func() { m.expireNodes(time.Now().Add(time.Duration(nodeExpiryBeats)*m.Heartbeat - time.Second)) })
+	if status, _ := m.statusOf(5); status != "online" {
+		t.Fatalf("до истечения срока: статус %q, ожидался online", status)
+	}
+	m.call(func() { m.expireNodes(time.Now().Add(time.Duration(nodeExpiryBeats)*m.Heartbeat + time.Second)) })
+	if status, _ := m.statusOf(5); status != "offline" {
+		t.Fatalf("после истечения срока: статус %q, ожидался offline", status)
+	}
+	m.call(func() {
+		if len(m.remoteDevices) != 0 || len(m.remoteNodes) != 0 {
+			t.Errorf("остались устройства %v и узлы %v", m.remoteDevices, m.remoteNodes)
+		}
+	})
+}
+
+// Узел, подписавшийся на шину позже, получает снимки других узлов и видит их пользователей
+func TestLateNodeReceivesSnapshot(t *testing.T) {
+	bus := NewMemoryBus()
+	first := NewManager(nil, bus, config.Default())
+	go first.Run()
+	first.call(func() {})
+
+	conn := dial(t, startServer(t, first), 3)
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+	eventually(t, "регистрация клиента", func() bool { return first.clientCount() == 1 })
+
+	second := NewManager(nil, bus, config.Default())
+	go second.Run()
+	eventually(t, "снимок первого узла", func() bool {
+		status, _ := second.statusOf(3)
+		devices := 0
+		second.call(func() { devices = second.remoteDeviceCount(3) })
+		return status == "online" && devices == 1
+	})
+}
+
+// Соединение, зарегистрированное после начала остановки, закрывается сразу и не попадает в список
+func TestRegisterAfterDrainingClosesClient(t *testing.T) {
+	m := startManager(t)
+	m.shutdownMutex.Lock()
+	m.draining = true
+	m.shutdownMutex.Unlock()
+
+	client := &Client{UserID: 4, SessionID: "late", Manager: m, done: make(chan struct{})}
+	m.call(func() { m.registerClient(client) })
+
+	select {
+	case <-client.done:
+	default:
+		t.Fatal("соединение не закрыто")
+	}
+	if n := m.clientCount(); n != 0 {
+		t.Fatalf("зарегистрировано соединений: %d", n)
+	}
+}
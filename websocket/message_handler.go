@@ -2,262 +2,96 @@
 package websocket
 
 import (
-	"database/sql"
 	"encoding/json"
 	"log"
-	"time"
+
+	"github.com/LilVoxy/coursework_chat/messages"
 )
 
-// HandleMessage обрабатывает входящие сообщения
-func (m *Manager) HandleMessage(message []byte, client *Client) {
+// HandleMessage обрабатывает кадр message и возвращает сохраненное сообщение для подтверждения
+func (m *Manager) HandleMessage(message []byte, client *Client) (*Message, error) {
 	// Парсим сообщение
 	var msg Message
 	if err := json.Unmarshal(message, &msg); err != nil {
-		log.Printf("❌ Ошибка разбора сообщения: %v", err)
-		return
-	}
-
-	// Проверяем тип сообщения
-	if msg.Type != "message" {
-		log.Printf("❌ Неверный тип сообщения: %s", msg.Type)
-		return
-	}
-
-	// Проверяем обязательные поля
-	if msg.FromID == 0 || msg.ToID == 0 || msg.Content == "" {
-		log.Printf("❌ Отсутствуют обязательные поля сообщения")
-		return
-	}
-
-	// Устанавливаем текущее время, если не указано
-	if msg.Timestamp == "" {
-		msg.Timestamp = time.Now().Format("15:04")
-	}
-
-	// Сохраняем сообщение в базу данных
-	chatID := msg.ChatID // Используем chat_id из сообщения, если он есть
-	if chatID == 0 {
-		// Если chat_id не указан, ищем или создаем чат
-		var err error
-		chatID, err = m.findOrCreateChat(msg.FromID, msg.ToID, msg.ProductID)
-		if err != nil {
-			log.Printf("❌ Ошибка при поиске/создании чата: %v", err)
-			return
-		}
-	}
-
-	// Добавляем chatId к сообщению для клиента
-	msg.ChatID = chatID
-
-	// Сохраняем сообщение в базу данных
-	msgID, err := m.saveMessage(chatID, msg.FromID, msg.Content)
+		return nil, newFrameError(ErrCodeBadFrame, "некорректный кадр message: %v", err)
+	}
+
+	// Отправитель определяется аутентифицированным соединением, а не телом сообщения
+	if msg.FromID != 0 && msg.FromID != client.UserID {
+		log.Printf("⚠️ Пользователь %d указал чужой fromId=%d, значение заменено", client.UserID, msg.FromID)
+	}
+	msg.FromID = client.UserID
+
+	// Проверка полей, определение чата, шифрование, сохранение и рассылка
+	// выполняются сервисом сообщений
+	sent, err := m.Messages.Send(messages.SendRequest{
+		FromID:       msg.FromID,
+		ToID:         msg.ToID,
+		ChatID:       msg.ChatID,
+		ProductID:    msg.ProductID,
+		Content:      msg.Content,
+		AttachmentID: msg.AttachmentID,
+		Encrypted:    msg.Encrypted,
+		ClientMsgID:  msg.ClientMsgID,
+	})
 	if err != nil {
-		log.Printf("❌ Ошибка при сохранении сообщения: %v", err)
-		return
+		return nil, err
 	}
-
-	// Устанавливаем ID сообщения
-	msg.ID = msgID
-
-	// Отправляем сообщение получателю и отправителю
-	m.sendMessageToClients(msg)
-
-	// Обновляем последнее сообщение в чате
-	go m.updateLastMessage(chatID, msg.Content)
-}
-
-// Находит существующий чат или создает новый
-func (m *Manager) findOrCreateChat(fromID, toID, productID int) (int, error) {
-	// Ищем существующий чат
-	var chatID int
-	err := m.DB.QueryRow(`
-		SELECT id FROM chats
-		WHERE ((buyer_id = ? AND seller_id = ?) OR (buyer_id = ? AND seller_id = ?))
-		AND product_id = ?
-		LIMIT 1
-	`, fromID, toID, toID, fromID, productID).Scan(&chatID)
-
-	if err == nil {
-		// Чат найден, возвращаем его ID
-		return chatID, nil
-	}
-
-	if err != sql.ErrNoRows {
-		// Произошла ошибка при поиске
-		return 0, err
+	if sent.Duplicate {
+		log.Printf("⚠️ Повторная отправка сообщения %d пользователем %d (clientMsgId %s)", sent.ID, sent.FromID, sent.ClientMsgID)
 	}
-
-	// Определяем, кто покупатель, а кто продавец
-	// В реальном приложении может быть сложная логика
-	// Для упрощения считаем, что меньший ID - это продавец
-	var buyerID, sellerID int
-	if fromID < toID {
-		sellerID = fromID
-		buyerID = toID
-	} else {
-		sellerID = toID
-		buyerID = fromID
-	}
-
-	// Создаем новый чат
-	result, err := m.DB.Exec(`
-		INSERT INTO chats (buyer_id, seller_id, product_id, created_at)
-		VALUES (?, ?, ?, NOW())
-	`, buyerID, sellerID, productID)
-
-	if err != nil {
-		return 0, err
-	}
-
-	// Получаем ID нового чата
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
-
-	log.Printf("✅ Создан новый чат ID: %d между покупателем %d и продавцом %d для товара %d",
-		id, buyerID, sellerID, productID)
-
-	return int(id), nil
+	return &Message{ID: sent.ID, ChatID: sent.ChatID}, nil
 }
 
-// Сохраняет сообщение в базу данных
-func (m *Manager) saveMessage(chatID, fromID int, content string) (int, error) {
-	result, err := m.DB.Exec(`
-		INSERT INTO messages (chat_id, sender_id, message, created_at, read_status)
-		VALUES (?, ?, ?, NOW(), FALSE)
-	`, chatID, fromID, content)
-
-	if err != nil {
-		return 0, err
-	}
-
-	// Получаем ID нового сообщения
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
-
-	log.Printf("✅ Сохранено сообщение ID: %d в чат ID: %d от пользователя %d: %s",
-		id, chatID, fromID, content)
-
-	return int(id), nil
+// ChatCreated уведомляет участников о новом чате (messages.Notifier)
+func (m *Manager) ChatCreated(chatID int) {
+	m.notifyParticipantsChanged(chatID)
 }
 
-// Отправляет сообщение клиентам через WebSocket
-func (m *Manager) sendMessageToClients(msg Message) {
-	// Получаем статус прочтения для исходящего сообщения
-	var readStatus bool
-	err := m.DB.QueryRow(`
-		SELECT read_status FROM messages WHERE id = ?
-	`, msg.ID).Scan(&readStatus)
-
-	if err == nil {
-		// Устанавливаем статус прочтения в сообщении
-		msg.ReadStatus = readStatus
-	} else {
-		// Если ошибка, считаем что сообщение не прочитано
-		msg.ReadStatus = false
-		// Выводим ошибку только если это не "запись не найдена"
-		if err != sql.ErrNoRows {
-			log.Printf("⚠️ Ошибка при получении статуса прочтения: %v", err)
-		}
-	}
-
-	// Сериализуем сообщение в JSON
-	messageJSON, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("❌ Ошибка при сериализации сообщения: %v", err)
-		return
-	}
-
-	// Отправляем получателю
-	if client, ok := m.Clients[msg.ToID]; ok {
-		client.Send <- messageJSON
-		log.Printf("✅ Сообщение отправлено получателю: %d (статус прочтения: %v)", msg.ToID, msg.ReadStatus)
-	} else {
-		log.Printf("⚠️ Получатель %d не в сети", msg.ToID)
-	}
-
-	// Отправляем отправителю (для синхронизации между устройствами)
-	if msg.FromID != msg.ToID { // Избегаем дублирования для сообщений самому себе
-		if client, ok := m.Clients[msg.FromID]; ok {
-			client.Send <- messageJSON
-			log.Printf("✅ Сообщение отправлено отправителю: %d (для синхронизации, статус прочтения: %v)", msg.FromID, msg.ReadStatus)
-		}
-	}
+// ChatUpdated уведомляет участников об изменении настроек чата (messages.Notifier)
+func (m *Manager) ChatUpdated(chatID int) {
+	m.notifyParticipantsChanged(chatID)
 }
 
-// Обновляет последнее сообщение в чате
-func (m *Manager) updateLastMessage(chatID int, message string) {
-	_, err := m.DB.Exec(`
-		UPDATE chats
-		SET last_message = ?, last_message_time = NOW()
-		WHERE id = ?
-	`, message, chatID)
-
-	if err != nil {
-		log.Printf("❌ Ошибка при обновлении последнего сообщения в чате: %v", err)
-		return
-	}
-
-	log.Printf("✅ Обновлено последнее сообщение в чате ID: %d", chatID)
+// MessageSent рассылает сохраненное сообщение участникам чата (messages.Notifier)
+func (m *Manager) MessageSent(sent *messages.Message, recipients []int) {
+	msg := Message{
+		Type:         "message",
+		ID:           sent.ID,
+		ChatID:       sent.ChatID,
+		FromID:       sent.FromID,
+		ToID:         directRecipient(recipients),
+		ProductID:    sent.ProductID,
+		Content:      sent.Content,
+		AttachmentID: sent.AttachmentID,
+		Attachment:   sent.Attachment,
+		Encrypted:    sent.Encrypted,
+		ClientMsgID:  sent.ClientMsgID,
+		Timestamp:    sent.CreatedAt.Format("15:04"),
+	}
+
+	// Сообщение отправлено - индикатор набора текста больше не нужен
+	m.stopTyping(typingKey{ChatID: sent.ChatID, UserID: sent.FromID}, false)
+
+	// Отправляем сообщение всем участникам чата
+	m.sendMessageToClients(msg, recipients)
 }
 
-// Обновляет и отправляет статус прочтения для сообщений
-func (m *Manager) SendReadStatusUpdates(userID, chatWithID int) {
-	// Получаем список сообщений, которые были отмечены как прочитанные
-	rows, err := m.DB.Query(`
-		SELECT m.id, m.chat_id, m.sender_id, m.read_status, m.created_at
-		FROM messages m
-		JOIN chats c ON m.chat_id = c.id
-		WHERE m.sender_id = ? AND m.read_status = TRUE
-		AND m.chat_id IN (
-			SELECT id FROM chats
-			WHERE (buyer_id = ? AND seller_id = ?) 
-			   OR (buyer_id = ? AND seller_id = ?)
-		)
-	`, chatWithID, userID, chatWithID, chatWithID, userID)
+// Отправляет сообщение клиентам через WebSocket.
+// Сообщение попадает в очереди событий всех участников чата и отправителя, поэтому
+// устройства, которые сейчас не в сети, получат его при синхронизации.
+func (m *Manager) sendMessageToClients(msg Message, recipients []int) {
+	// Только что сохраненное сообщение находится в состоянии "отправлено"
+	msg.State = messageStateSent
 
-	if err != nil {
-		log.Printf("❌ Ошибка при получении обновлений статуса: %v", err)
-		return
+	// Отправляем каждому участнику на все его устройства. Как только сообщение попадет
+	// в очередь устройства любого из них, отправитель получит отметку о доставке (receipt)
+	for _, toID := range recipients {
+		m.deliverEvent(toID, msg)
 	}
-	defer rows.Close()
+	log.Printf("✅ Сообщение %d отправлено участникам чата %d: %v", msg.ID, msg.ChatID, recipients)
 
-	// Создаем список сообщений для обновления
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		var readStatus bool
-		var createdAt time.Time
-
-		if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.FromID, &readStatus, &createdAt); err != nil {
-			log.Printf("❌ Ошибка при сканировании сообщения: %v", err)
-			continue
-		}
-
-		msg.ToID = userID
-		msg.Type = "message"
-		msg.ReadStatus = readStatus
-		// Важно - не устанавливаем content, чтобы клиент знал, что это только обновление статуса
-		msg.Content = ""                          // Явно устанавливаем пустую строку
-		msg.Timestamp = createdAt.Format("15:04") // Добавляем timestamp для корректного отображения на клиенте
-
-		messages = append(messages, msg)
-	}
-
-	// Отправляем обновления статуса для каждого сообщения
-	for _, msg := range messages {
-		// Отправляем только отправителю (так как получатель уже видел сообщение)
-		if client, ok := m.Clients[msg.FromID]; ok {
-			if msgJSON, err := json.Marshal(msg); err == nil {
-				client.Send <- msgJSON
-				log.Printf("✅ Отправлено обновление статуса для сообщения ID: %d, FromID: %d, ToID: %d, ReadStatus: %v",
-					msg.ID, msg.FromID, msg.ToID, msg.ReadStatus)
-			}
-		} else {
-			log.Printf("⚠️ Клиент %d не в сети для получения обновления статуса", msg.FromID)
-		}
-	}
+	// Отправляем отправителю (для синхронизации между устройствами)
+	m.deliverEvent(msg.FromID, msg)
+	log.Printf("✅ Сообщение %d отправлено отправителю: %d (для синхронизации)", msg.ID, msg.FromID)
 }
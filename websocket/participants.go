@@ -0,0 +1,186 @@
+// websocket/participants.go
+package websocket
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/LilVoxy/coursework_chat/messages"
+)
+
+// Роли участников чата
+const (
+	RoleBuyer    = messages.RoleBuyer    // Покупатель
+	RoleSeller   = messages.RoleSeller   // Продавец
+	RoleCoSeller = messages.RoleCoSeller // Дополнительный продавец (сотрудник магазина)
+	RoleSupport  = messages.RoleSupport  // Агент поддержки маркетплейса
+)
+
+// Ошибки работы с участниками чата
+var (
+	ErrNotParticipant = messages.ErrNotParticipant
+	ErrForbidden      = errors.New("недостаточно прав для изменения состава чата")
+	ErrInvalidRole    = errors.New("добавить можно только участника с ролью co_seller или support")
+	ErrAlreadyMember  = messages.ErrAlreadyMember
+)
+
+// Participant - участник чата
+type Participant struct {
+	UserID            int    `json:"userId"`
+	Role              string `json:"role"`
+	LastReadMessageID int    `json:"lastReadMessageId"`
+}
+
+// isAssignableRole проверяет, что участника с этой ролью можно добавить в чат. Покупатель и
+// продавец назначаются только при создании чата, иначе их можно было бы добавить повторно
+// с другой ролью и затем удалить.
+func isAssignableRole(role string) bool {
+	return role == RoleCoSeller || role == RoleSupport
+}
+
+// canManageParticipants сообщает, может ли участник с данной ролью менять состав чата
+func canManageParticipants(role string) bool {
+	return role == RoleSeller || role == RoleSupport
+}
+
+// GetChatParticipants возвращает всех участников чата
+func (m *Manager) GetChatParticipants(chatID int) ([]Participant, error) {
+	rows, err := m.DB.Query(`
+		SELECT user_id, role, last_read_message_id
+		FROM chat_participants
+		WHERE chat_id = ?
+		ORDER BY joined_at, user_id
+	`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var participants []Participant
+	for rows.Next() {
+		var p Participant
+		if err := rows.Scan(&p.UserID, &p.Role, &p.LastReadMessageID); err != nil {
+			return nil, err
+		}
+		participants = append(participants, p)
+	}
+	return participants, rows.Err()
+}
+
+// chatRecipients возвращает участников чата, кроме userID.
+// Возвращает ErrNotParticipant, если userID не участвует в чате.
+func (m *Manager) chatRecipients(chatID, userID int) ([]int, error) {
+	return messages.ChatRecipients(m.DB, chatID, userID)
+}
+
+// participantRole возвращает роль пользователя в чате или ErrNotParticipant
+func (m *Manager) participantRole(chatID, userID int) (string, error) {
+	var role string
+	err := m.DB.QueryRow(`
+		SELECT role FROM chat_participants WHERE chat_id = ? AND user_id = ?
+	`, chatID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotParticipant
+	}
+	return role, err
+}
+
+// AddChatParticipant добавляет пользователя в чат от имени actorID.
+// Добавлять участников (co_seller или support) могут продавец и агент поддержки.
+// Роль того, кто уже участвует в чате, не меняется: возвращается ErrAlreadyMember.
+func (m *Manager) AddChatParticipant(chatID, actorID, userID int, role string) error {
+	if !isAssignableRole(role) {
+		return ErrInvalidRole
+	}
+
+	actorRole, err := m.participantRole(chatID, actorID)
+	if err != nil {
+		return err
+	}
+	if !canManageParticipants(actorRole) {
+		return ErrForbidden
+	}
+
+	if err := messages.InsertParticipant(m.DB, chatID, userID, role); err != nil {
+		return err
+	}
+
+	log.Printf("✅ Пользователь %d добавлен в чат %d с ролью %s (добавил %d)", userID, chatID, role, actorID)
+	m.notifyParticipantsChanged(chatID)
+	return nil
+}
+
+// RemoveChatParticipant удаляет пользователя из чата от имени actorID.
+// Пользователь может покинуть чат сам; удалять других могут продавец и агент поддержки.
+// Покупатель и продавец, для которых создан чат, не удаляются.
+func (m *Manager) RemoveChatParticipant(chatID, actorID, userID int) error {
+	if actorID != userID {
+		actorRole, err := m.participantRole(chatID, actorID)
+		if err != nil {
+			return err
+		}
+		if !canManageParticipants(actorRole) {
+			return ErrForbidden
+		}
+	}
+
+	role, err := m.participantRole(chatID, userID)
+	if err != nil {
+		return err
+	}
+	if role == RoleBuyer || role == RoleSeller {
+		return ErrForbidden
+	}
+
+	// Сначала уведомляем всех, включая удаляемого участника
+	m.notifyParticipantsChanged(chatID, userID)
+
+	if _, err := m.DB.Exec(`DELETE FROM chat_participants WHERE chat_id = ? AND user_id = ?`, chatID, userID); err != nil {
+		return err
+	}
+
+	log.Printf("✅ Пользователь %d удален из чата %d (удалил %d)", userID, chatID, actorID)
+	m.notifyParticipantsChanged(chatID)
+	return nil
+}
+
+// notifyParticipantsChanged отправляет участникам чата (и дополнительно extraUserIDs)
+// событие chat с актуальным составом участников
+func (m *Manager) notifyParticipantsChanged(chatID int, extraUserIDs ...int) {
+	participants, err := m.GetChatParticipants(chatID)
+	if err != nil {
+		log.Printf("⚠️ Не удалось получить участников чата %d: %v", chatID, err)
+		return
+	}
+
+	frame := Message{
+		Type:         eventTypeChat,
+		ChatID:       chatID,
+		Participants: participants,
+	}
+	if err := m.DB.QueryRow(`
+		SELECT buyer_id, seller_id, product_id, e2e FROM chats WHERE id = ?
+	`, chatID).Scan(&frame.BuyerID, &frame.SellerID, &frame.ProductID, &frame.E2E); err != nil {
+		log.Printf("⚠️ Не удалось загрузить чат %d: %v", chatID, err)
+	}
+
+	if len(extraUserIDs) > 0 {
+		for _, userID := range extraUserIDs {
+			m.deliverEvent(userID, frame)
+		}
+		return
+	}
+	for _, p := range participants {
+		m.deliverEvent(p.UserID, frame)
+	}
+}
+
+// directRecipient возвращает собеседника для чата из двух участников
+// (для совместимости с клиентами, которые используют поле toId), иначе 0
+func directRecipient(recipients []int) int {
+	if len(recipients) == 1 {
+		return recipients[0]
+	}
+	return 0
+}
@@ -0,0 +1,281 @@
+// websocket/protocol.go
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/LilVoxy/coursework_chat/attachments"
+	"github.com/LilVoxy/coursework_chat/messages"
+	"github.com/LilVoxy/coursework_chat/processor"
+)
+
+// ProtocolVersion - версия конверта кадров. Кадр без поля v считается кадром версии 1.
+const ProtocolVersion = 1
+
+// Поддерживаемые версии протокола
+var supportedVersions = []int{ProtocolVersion}
+
+// Служебные кадры протокола
+const (
+	frameTypeHello = "hello" // Возможности сервера и согласование версии
+	frameTypeAck   = "ack"   // Подтверждение кадра с clientMsgId
+	frameTypeError = "error" // Ошибка обработки кадра
+)
+
+// Коды ошибок кадра error
+const (
+	ErrCodeBadFrame           = "bad_frame"           // Кадр не разбирается как JSON-конверт
+	ErrCodeUnsupportedVersion = "unsupported_version" // Версия v не поддерживается сервером
+	ErrCodeUnknownType        = "unknown_type"        // Неизвестный тип кадра
+	ErrCodeInvalidRequest     = "invalid_request"     // Не хватает полей или они некорректны
+	ErrCodeForbidden          = "forbidden"           // Действие запрещено пользователю
+	ErrCodeNotFound           = "not_found"           // Чат, товар или сообщение не найдены
+	ErrCodeConflict           = "conflict"            // Действие противоречит состоянию (сообщение удалено и т.п.)
+	ErrCodeMissingSignature   = "missing_signature"   // Зашифрованное сообщение не подписано
+	ErrCodeInvalidSignature   = "invalid_signature"   // Подпись не подтверждает отправителя
+	ErrCodeUnavailable        = "unavailable"         // Сервер перезапускается: повторить после переподключения
+	ErrCodeInternal           = "internal"            // Внутренняя ошибка сервера
+)
+
+// Capabilities - возможности сервера, которые он объявляет в кадре hello
+type Capabilities struct {
+	Frames       []string `json:"frames"`       // Типы кадров, которые принимает сервер
+	Codecs       []string `json:"codecs"`       // Кодеки сжатия, разрешенные сервером
+	Encodings    []string `json:"encodings"`    // Кодировки кадров (подпротоколы WebSocket)
+	Features     []string `json:"features"`     // Возможности: e2e, signatures, ratchet, sync, ...
+	MaxFrameSize int64    `json:"maxFrameSize"` // Максимальный размер входящего кадра в байтах
+}
+
+// envelope - общие поля всех кадров
+type envelope struct {
+	V           int    `json:"v"`
+	Type        string `json:"type"`
+	ClientMsgID string `json:"clientMsgId"`
+}
+
+// protocolError - ошибка обработки кадра с кодом для клиента
+type protocolError struct {
+	code string
+	err  error
+}
+
+func (e *protocolError) Error() string { return e.err.Error() }
+func (e *protocolError) Unwrap() error { return e.err }
+
+// newFrameError создает ошибку с явно заданным кодом
+func newFrameError(code, format string, args ...interface{}) error {
+	return &protocolError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// errorCode определяет код ошибки для кадра error. Текст внутренних ошибок клиенту не показывается.
+func errorCode(err error) (code, text string) {
+	var fe *protocolError
+	switch {
+	case errors.As(err, &fe):
+		return fe.code, fe.Error()
+	case errors.Is(err, messages.ErrMissingSignature):
+		return ErrCodeMissingSignature, err.Error()
+	case errors.Is(err, messages.ErrInvalidSignature):
+		return ErrCodeInvalidSignature, err.Error()
+	case errors.Is(err, messages.ErrInvalidMessage), errors.Is(err, messages.ErrNoSeller),
+		errors.Is(err, messages.ErrSelfChat), errors.Is(err, messages.ErrInvalidPayload),
+		errors.Is(err, messages.ErrUnknownRecipientKey), errors.Is(err, ErrEmptyContent),
+		errors.Is(err, ErrInvalidScope), errors.Is(err, ErrInvalidRole):
+		return ErrCodeInvalidRequest, err.Error()
+	case errors.Is(err, messages.ErrNotParticipant), errors.Is(err, ErrNotAuthor),
+		errors.Is(err, ErrForbidden):
+		return ErrCodeForbidden, err.Error()
+	case errors.Is(err, messages.ErrChatNotFound), errors.Is(err, messages.ErrProductNotFound),
+		errors.Is(err, ErrMessageNotFound):
+		return ErrCodeNotFound, err.Error()
+	case errors.Is(err, messages.ErrE2ERequired), errors.Is(err, attachments.ErrNotClaimable),
+		errors.Is(err, ErrMessageDeleted), errors.Is(err, ErrEncryptedEdit), errors.Is(err, ErrAlreadyMember):
+		return ErrCodeConflict, err.Error()
+	case errors.Is(err, ErrShuttingDown):
+		return ErrCodeUnavailable, err.Error()
+	}
+	return ErrCodeInternal, "внутренняя ошибка сервера"
+}
+
+// frameHandler обрабатывает кадр клиента. Возвращенное сообщение (если есть) дополняет
+// подтверждение ack, например ID сохраненного сообщения.
+type frameHandler func(m *Manager, message []byte, c *Client) (*Message, error)
+
+// Обработчики кадров по типу
+var frameHandlers = map[string]frameHandler{
+	"ping":           handlePing,
+	frameTypeHello:   (*Manager).handleHello,
+	"message":        (*Manager).HandleMessage,
+	"typing":         (*Manager).handleTyping,
+	"typing_stopped": (*Manager).handleTyping,
+	"read":           (*Manager).handleRead,
+	"edit":           (*Manager).handleEdit,
+	"delete":         (*Manager).handleDelete,
+	"sync":           (*Manager).handleSync,
+	"status":         (*Manager).handleStatus,
+}
+
+// frameTypes возвращает типы кадров, которые принимает сервер (для hello)
+func frameTypes() []string {
+	return []string{"ping", frameTypeHello, "message", "typing", "typing_stopped", "read", "edit", "delete", "sync", "status"}
+}
+
+// dispatchFrame разбирает конверт кадра, вызывает обработчик и отвечает клиенту
+// кадром ack (если указан clientMsgId) или кадром error
+func (c *Client) dispatchFrame(message []byte) {
+	var env envelope
+	if err := json.Unmarshal(message, &env); err != nil {
+		log.Printf("Ошибка при разборе сообщения: %v", err)
+		c.sendError(env, newFrameError(ErrCodeBadFrame, "кадр должен быть JSON-объектом: %v", err))
+		return
+	}
+	if env.V == 0 {
+		env.V = ProtocolVersion
+	}
+	if !isSupportedVersion(env.V) {
+		c.sendError(env, newFrameError(ErrCodeUnsupportedVersion, "версия протокола %d не поддерживается", env.V))
+		return
+	}
+
+	handler, ok := frameHandlers[env.Type]
+	if !ok {
+		c.sendError(env, newFrameError(ErrCodeUnknownType, "неизвестный тип кадра %q", env.Type))
+		return
+	}
+
+	// Во время остановки сервера новые кадры не обрабатываются, а начатые Shutdown дожидается
+	if !c.Manager.acquire(&c.Manager.frames) {
+		c.sendError(env, ErrShuttingDown)
+		return
+	}
+	defer c.Manager.frames.Done()
+
+	result, err := handler(c.Manager, message, c)
+	if err != nil {
+		log.Printf("❌ Кадр %s пользователя %d отклонен: %v", env.Type, c.UserID, err)
+		c.sendError(env, err)
+		return
+	}
+	// На hello клиент получает ответный hello с тем же clientMsgId
+	if env.ClientMsgID != "" && env.Type != frameTypeHello {
+		ack := Message{Type: frameTypeAck, Ref: env.Type, ClientMsgID: env.ClientMsgID}
+		if result != nil {
+			ack.ID = result.ID
+			ack.ChatID = result.ChatID
+		}
+		c.sendFrame(ack)
+	}
+}
+
+// sendError отправляет клиенту кадр error с кодом ошибки
+func (c *Client) sendError(env envelope, err error) {
+	code, text := errorCode(err)
+	c.sendFrame(Message{Type: frameTypeError, Code: code, Error: text, Ref: env.Type, ClientMsgID: env.ClientMsgID})
+}
+
+// sendFrame отправляет служебный кадр только этому устройству, минуя очередь событий
+func (c *Client) sendFrame(frame Message) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("❌ Ошибка при сериализации кадра %s: %v", frame.Type, err)
+		return
+	}
+	if !c.offer(0, data, false) {
+		c.Manager.do(func() { c.Manager.evict(c) })
+	}
+}
+
+func isSupportedVersion(v int) bool {
+	for _, supported := range supportedVersions {
+		if v == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// capabilities возвращает возможности сервера для кадра hello
+func (m *Manager) capabilities() *Capabilities {
+	return &Capabilities{
+		Frames:       frameTypes(),
+		Codecs:       processor.CodecNames(m.Codecs),
+		Encodings:    subprotocols(),
+		Features:     []string{"e2e", "signatures", "ratchet", "sync", "edits", "attachments", "search", "idempotency", "backpressure"},
+		MaxFrameSize: m.Limits.MaxMessageSize,
+	}
+}
+
+// helloFrame составляет кадр hello для клиента
+func (m *Manager) helloFrame(c *Client) Message {
+	return Message{
+		Type:         frameTypeHello,
+		SessionID:    c.SessionID,
+		Encoding:     c.Encoding.Name(),
+		Versions:     supportedVersions,
+		Codecs:       c.Codecs,
+		Capabilities: m.capabilities(),
+	}
+}
+
+// handleHello отвечает на кадр hello клиента: {"type":"hello","versions":[1],"codecs":["zstd"]}.
+// Ответ содержит согласованную версию и кодеки; кодеки, предложенные клиентом, заменяют
+// согласованные при подключении.
+func (m *Manager) handleHello(message []byte, c *Client) (*Message, error) {
+	var req Message
+	if err := json.Unmarshal(message, &req); err != nil {
+		return nil, newFrameError(ErrCodeBadFrame, "некорректный кадр hello: %v", err)
+	}
+
+	version := 0
+	for _, v := range req.Versions {
+		if isSupportedVersion(v) && v > version {
+			version = v
+		}
+	}
+	if len(req.Versions) > 0 && version == 0 {
+		return nil, newFrameError(ErrCodeUnsupportedVersion, "нет общей версии протокола, сервер поддерживает %v", supportedVersions)
+	}
+	if version == 0 {
+		version = ProtocolVersion
+	}
+	if req.Codecs != nil {
+		c.setCodecs(processor.NegotiateCodecs(req.Codecs, m.Codecs))
+	}
+
+	reply := m.helloFrame(c)
+	reply.V = version
+	reply.ClientMsgID = req.ClientMsgID
+	c.sendFrame(reply)
+	return nil, nil
+}
+
+// handlePing только продлевает активность соединения (время обновляет readPump)
+func handlePing(m *Manager, message []byte, c *Client) (*Message, error) {
+	log.Printf("Получен пинг от пользователя ID: %d", c.UserID)
+	return nil, nil
+}
+
+// handleStatus обрабатывает запрос на изменение статуса: {"type":"status","status":"away"}
+func (m *Manager) handleStatus(message []byte, c *Client) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, newFrameError(ErrCodeBadFrame, "некорректный кадр status: %v", err)
+	}
+	if msg.Status == "" {
+		return nil, newFrameError(ErrCodeInvalidRequest, "не указан статус")
+	}
+	m.updateUserStatus(c.UserID, msg.Status, true)
+	return nil, nil
+}
+
+// MarshalJSON добавляет к каждому кадру версию протокола
+func (msg Message) MarshalJSON() ([]byte, error) {
+	type plain Message
+	if msg.V == 0 {
+		msg.V = ProtocolVersion
+	}
+	return json.Marshal(plain(msg))
+}
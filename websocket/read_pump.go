@@ -2,7 +2,6 @@
 package websocket
 
 import (
-	"encoding/json"
 	"log"
 	"time"
 
@@ -13,22 +12,26 @@ import (
 func (c *Client) readPump() {
 	// Откладываем закрытие до конца функции
 	defer func() {
-		c.Manager.Unregister <- c
+		// Через ту же очередь команд, что и кадры соединения, чтобы отключение
+		// не обогнало уже переданные циклу изменения статуса
+		c.Manager.do(func() { c.Manager.unregisterClient(c) })
 		c.Conn.Close()
+		c.Manager.conns.Done()
 		log.Printf("WebSocket закрыт для пользователя ID: %d", c.UserID)
 	}()
 
 	// Настраиваем соединение
-	c.Conn.SetReadLimit(maxMessageSize)
-	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	limits := c.Manager.Limits
+	c.Conn.SetReadLimit(limits.MaxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(limits.PongWait))
 	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.Conn.SetReadDeadline(time.Now().Add(limits.PongWait))
 		return nil
 	})
 
 	// Бесконечный цикл чтения сообщений от клиента
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		messageType, data, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket закрыт неожиданно: %v", err)
@@ -38,39 +41,17 @@ func (c *Client) readPump() {
 
 		// Обновляем время последней активности
 		c.LastActivity = time.Now()
+		c.Manager.touchUser(c.UserID)
 
-		// Парсим сообщение для определения типа
-		var data struct {
-			Type string `json:"type"`
-		}
-		if err := json.Unmarshal(message, &data); err != nil {
-			log.Printf("Ошибка при разборе сообщения: %v", err)
+		// Бинарные кадры переводятся в JSON, дальше обработка одинакова для всех кодировок
+		message, err := decodeFrame(c.Encoding, messageType, data)
+		if err != nil {
+			log.Printf("❌ Ошибка декодирования кадра %s от пользователя %d: %v", c.Encoding.Name(), c.UserID, err)
+			c.sendError(envelope{}, newFrameError(ErrCodeBadFrame, "%v", err))
 			continue
 		}
 
-		// Обрабатываем разные типы сообщений
-		switch data.Type {
-		case "ping":
-			// Просто обновляем время активности
-			log.Printf("Получен пинг от пользователя ID: %d", c.UserID)
-
-		case "message":
-			// Вызываем обработчик сообщений из Manager
-			c.Manager.HandleMessage(message, c)
-
-		case "status":
-			// Обрабатываем запрос на изменение статуса
-			var statusData struct {
-				Type   string `json:"type"`
-				Status string `json:"status"`
-			}
-			if err := json.Unmarshal(message, &statusData); err != nil {
-				log.Printf("Ошибка при разборе сообщения статуса: %v", err)
-				continue
-			}
-
-			// Обновляем статус пользователя
-			c.Manager.updateUserStatus(c.UserID, statusData.Status, true)
-		}
+		// Разбираем конверт кадра и передаем его обработчику
+		c.dispatchFrame(message)
 	}
 }
@@ -0,0 +1,184 @@
+// websocket/receipts.go
+package websocket
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// handleRead обрабатывает явную отметку о прочтении от клиента:
+// все сообщения других участников чата с ID не больше messageId помечаются прочитанными.
+func (m *Manager) handleRead(message []byte, client *Client) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, newFrameError(ErrCodeBadFrame, "некорректная отметка о прочтении: %v", err)
+	}
+
+	if msg.ChatID == 0 || msg.MessageID == 0 {
+		return nil, newFrameError(ErrCodeInvalidRequest, "отметка о прочтении без chatId или messageId")
+	}
+
+	// Убеждаемся, что пользователь является участником чата
+	if _, err := m.participantRole(msg.ChatID, client.UserID); err != nil {
+		return nil, err
+	}
+
+	if _, err := m.MarkMessagesRead(msg.ChatID, client.UserID, msg.MessageID); err != nil {
+		return nil, fmt.Errorf("ошибка при сохранении отметки о прочтении в чате %d: %w", msg.ChatID, err)
+	}
+	return &Message{ChatID: msg.ChatID}, nil
+}
+
+// markMessageDelivered сохраняет время доставки сообщения, если оно еще не было доставлено.
+// changed=false означает, что сообщение уже было отмечено доставленным ранее.
+func (m *Manager) markMessageDelivered(messageID int) (deliveredAt time.Time, changed bool, err error) {
+	deliveredAt = time.Now()
+	result, err := m.DB.Exec(`
+		UPDATE messages SET delivered_at = ?
+		WHERE id = ? AND delivered_at IS NULL
+	`, deliveredAt, messageID)
+	if err != nil {
+		return deliveredAt, false, err
+	}
+	n, err := result.RowsAffected()
+	return deliveredAt, n > 0, err
+}
+
+// MarkMessagesRead сдвигает курсор прочтения участника readerID в чате до upToID
+// (не дальше последнего сообщения чата).
+// Отправителям рассылается только дельта - сообщения, которые этот участник прочитал именно сейчас.
+// Сообщение получает общую отметку read_at, когда его прочитали все остальные участники чата.
+// Возвращает ID сообщений, прочитанных участником.
+func (m *Manager) MarkMessagesRead(chatID, readerID, upToID int) ([]int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Блокируем курсор участника, чтобы параллельные отметки не разослали дельту повторно
+	var lastRead int
+	err = tx.QueryRow(`
+		SELECT last_read_message_id FROM chat_participants
+		WHERE chat_id = ? AND user_id = ?
+		FOR UPDATE
+	`, chatID, readerID).Scan(&lastRead)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotParticipant
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Курсор не может опережать последнее сообщение чата: иначе будущие сообщения
+	// сразу считались бы прочитанными
+	var latestID int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM messages WHERE chat_id = ?`, chatID).Scan(&latestID); err != nil {
+		return nil, err
+	}
+	if upToID > latestID {
+		upToID = latestID
+	}
+	if upToID <= lastRead {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, sender_id FROM messages
+		WHERE chat_id = ? AND sender_id != ? AND id > ? AND id <= ?
+		ORDER BY id
+	`, chatID, readerID, lastRead, upToID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	bySender := make(map[int][]int)
+	for rows.Next() {
+		var id, senderID int
+		if err := rows.Scan(&id, &senderID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		bySender[senderID] = append(bySender[senderID], id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE chat_participants SET last_read_message_id = ?
+		WHERE chat_id = ? AND user_id = ?
+	`, upToID, chatID, readerID); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, tx.Commit()
+	}
+
+	readAt := time.Now()
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := []interface{}{readAt}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	// Прочитанное сообщение считается и доставленным
+	if _, err := tx.Exec(fmt.Sprintf(`
+		UPDATE messages SET delivered_at = COALESCE(delivered_at, ?)
+		WHERE id IN (%s)
+	`, placeholders), args...); err != nil {
+		return nil, err
+	}
+
+	// Общая отметка о прочтении - когда сообщение прочитали все, кроме отправителя
+	if _, err := tx.Exec(fmt.Sprintf(`
+		UPDATE messages m
+		SET m.read_status = TRUE, m.read_at = ?
+		WHERE m.id IN (%s) AND m.read_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM chat_participants p
+			WHERE p.chat_id = m.chat_id AND p.user_id != m.sender_id
+			AND p.last_read_message_id < m.id
+		)
+	`, placeholders), args...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("✅ Пользователь %d прочитал %d сообщений в чате %d", readerID, len(ids), chatID)
+
+	for senderID, messageIDs := range bySender {
+		m.SendReadStatusUpdates(chatID, senderID, readerID, messageIDs, readAt)
+	}
+
+	return ids, nil
+}
+
+// SendReadStatusUpdates отправляет отправителю отметку о прочтении его сообщений участником readerID.
+// Отметка сохраняется в очередь событий, поэтому дойдет и до устройств, которые сейчас не в сети.
+func (m *Manager) SendReadStatusUpdates(chatID, senderID, readerID int, messageIDs []int, readAt time.Time) {
+	receipt := Message{
+		Type:       eventTypeReceipt,
+		ChatID:     chatID,
+		UserID:     readerID,
+		MessageIDs: messageIDs,
+		State:      messageStateRead,
+		ReadStatus: true,
+		ReadAt:     readAt.Format(time.RFC3339),
+	}
+
+	// Отметка нужна на всех устройствах отправителя
+	m.deliverEvent(senderID, receipt)
+	log.Printf("✅ Отметка о прочтении %d сообщений чата %d пользователем %d отправлена пользователю %d",
+		len(messageIDs), chatID, readerID, senderID)
+}
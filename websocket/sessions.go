@@ -0,0 +1,113 @@
+// websocket/sessions.go
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+
+	"github.com/gorilla/websocket"
+)
+
+// newSessionID генерирует случайный идентификатор сессии (устройства) пользователя
+func newSessionID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("❌ Ошибка генерации ID сессии: %v", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// addClient регистрирует соединение пользователя в списке его устройств (только в цикле Run)
+func (m *Manager) addClient(client *Client) {
+	sessions, ok := m.Clients[client.UserID]
+	if !ok {
+		sessions = make(map[string]*Client)
+		m.Clients[client.UserID] = sessions
+	}
+	sessions[client.SessionID] = client
+}
+
+// removeClient удаляет соединение из списка устройств пользователя.
+// Возвращает removed=false, если соединение уже было удалено,
+// и last=true, если это было последнее устройство пользователя.
+func (m *Manager) removeClient(client *Client) (removed, last bool) {
+	sessions, ok := m.Clients[client.UserID]
+	if !ok {
+		return false, false
+	}
+	if existing, ok := sessions[client.SessionID]; !ok || existing != client {
+		return false, false
+	}
+
+	delete(sessions, client.SessionID)
+	if len(sessions) == 0 {
+		delete(m.Clients, client.UserID)
+		return true, true
+	}
+	return true, false
+}
+
+// userClients возвращает все активные соединения (устройства) пользователя (только в цикле Run)
+func (m *Manager) userClients(userID int) []*Client {
+	sessions := m.Clients[userID]
+	clients := make([]*Client, 0, len(sessions))
+	for _, client := range sessions {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// close отключает соединение: writePump закрывает сокет, после чего readPump
+// снимает клиента с регистрации. Повторные вызовы ничего не делают.
+func (c *Client) close() {
+	c.closeWith(0, "")
+}
+
+// closeWith отключает соединение, отправляя клиенту кадр закрытия с кодом и причиной
+// (code=0 - обычное закрытие без кода)
+func (c *Client) closeWith(code int, reason string) {
+	c.closeOnce.Do(func() {
+		if code != 0 {
+			c.closeFrame = websocket.FormatCloseMessage(code, reason)
+		}
+		close(c.done)
+	})
+}
+
+// isClosed сообщает, что соединение уже отключено
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueue ставит кадр в очередь отправки, не блокируясь.
+// Возвращает false, если очередь переполнена или соединение закрыто.
+func (c *Client) enqueue(data []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.Send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// send ставит кадр в очередь отправки, дожидаясь места в ней. Используется горутинами
+// самого соединения (подключение, синхронизация); возвращает false, если соединение закрыто.
+func (c *Client) send(data []byte) bool {
+	select {
+	case c.Send <- data:
+		return true
+	case <-c.done:
+		return false
+	}
+}
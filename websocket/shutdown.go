@@ -0,0 +1,174 @@
+// websocket/shutdown.go
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrShuttingDown - менеджер останавливается и не принимает новые соединения и кадры
+var ErrShuttingDown = errors.New("сервер перезапускается")
+
+// Наибольшая длина причины в кадре закрытия WebSocket (125 байт минус код)
+const maxCloseReason = 123
+
+// acquire учитывает новую работу (соединение или кадр) в wg, если менеджер еще не останавливается.
+// Проверка и учет выполняются под одной блокировкой, поэтому после начала остановки счетчик
+// больше не увеличивается и его можно дождаться.
+func (m *Manager) acquire(wg *sync.WaitGroup) bool {
+	m.shutdownMutex.RLock()
+	defer m.shutdownMutex.RUnlock()
+	if m.draining {
+		return false
+	}
+	wg.Add(1)
+	return true
+}
+
+// isDraining сообщает, что менеджер останавливается
+func (m *Manager) isDraining() bool {
+	m.shutdownMutex.RLock()
+	defer m.shutdownMutex.RUnlock()
+	return m.draining
+}
+
+// spawn запускает фоновую работу с БД (подтверждение доставки), которую Shutdown
+// дожидается перед закрытием БД
+func (m *Manager) spawn(fn func()) {
+	m.tasks.Add(1)
+	go func() {
+		defer m.tasks.Done()
+		fn()
+	}()
+}
+
+// Shutdown останавливает менеджер, не теряя событий:
+//
+//  1. новые соединения и кадры отклоняются (кадр error с кодом unavailable);
+//  2. дожидается обработки уже принятых кадров (сохранение сообщений) и доставки их событий;
+//  3. закрывает соединения кадром 1012 (Service Restart) с подсказкой, куда переподключиться,
+//     предварительно отправив клиентам все, что уже стоит в их очередях;
+//  4. останавливает цикл Run, публикацию в шину и фоновые задачи.
+//
+// После возврата менеджер не обращается к БД и шине, и их можно закрыть. Если ctx истекает
+// раньше, возвращается ошибка, а оставшиеся события клиенты получат синхронизацией.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.shutdownMutex.Lock()
+	if m.draining {
+		m.shutdownMutex.Unlock()
+		return ErrShuttingDown
+	}
+	m.draining = true
+	m.shutdownMutex.Unlock()
+	log.Printf("⚠️ Менеджер WebSocket останавливается на узле %s", m.NodeID)
+
+	// Кадры, которые уже обрабатываются, доводим до конца
+	if err := waitGroup(ctx, &m.frames); err != nil {
+		return fmt.Errorf("обработка кадров: %w", err)
+	}
+	m.settle()
+
+	// Закрываем соединения: writePump отправит очередь клиента и кадр закрытия. Соединения,
+	// которые зарегистрируются позже (подключение завершалось во время остановки), registerClient
+	// закрывает сам: draining уже выставлен, а команды цикла выполняются по порядку.
+	reason := m.restartReason()
+	var finished []chan struct{}
+	m.call(func() {
+		for _, sessions := range m.Clients {
+			for _, client := range sessions {
+				client.closeGracefully(websocket.CloseServiceRestart, reason)
+				if client.finished != nil {
+					finished = append(finished, client.finished)
+				}
+			}
+		}
+	})
+	for _, ch := range finished {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return fmt.Errorf("отправка очередей клиентам: %w", ctx.Err())
+		}
+	}
+
+	// readPump снимает соединения с регистрации, и другие узлы узнают, что устройства отключились
+	if err := waitGroup(ctx, &m.conns); err != nil {
+		return fmt.Errorf("отключение клиентов: %w", err)
+	}
+	m.settle()
+
+	// Останавливаем цикл, затем публикацию в шину и фоновые задачи
+	close(m.quit)
+	select {
+	case <-m.stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("остановка цикла менеджера: %w", ctx.Err())
+	}
+	m.effects.stop()
+	select {
+	case <-m.effects.done:
+	case <-ctx.Done():
+		return fmt.Errorf("публикация событий: %w", ctx.Err())
+	}
+	if err := waitGroup(ctx, &m.tasks); err != nil {
+		return fmt.Errorf("фоновые задачи: %w", err)
+	}
+
+	log.Printf("✅ Менеджер WebSocket остановлен")
+	return nil
+}
+
+// settle дожидается, пока цикл выполнит переданные ему команды, а очередь effects - действия,
+// добавленные ими. Шина внутри процесса доставляет события командами цикла, поэтому после
+// settle они уже в очередях клиентов. Шина Redis доставляет события асинхронно: не успевшие
+// дойти события клиенты получат синхронизацией после переподключения.
+func (m *Manager) settle() {
+	m.call(func() {})
+	published := make(chan struct{})
+	m.effects.push(func() { close(published) })
+	<-published
+	m.call(func() {})
+}
+
+// restartReason составляет причину закрытия с адресом для переподключения (CHAT_RECONNECT_URL)
+func (m *Manager) restartReason() string {
+	reason := "server restarting, reconnect"
+	if m.ReconnectURL != "" {
+		reason += " to " + m.ReconnectURL
+	}
+	if len(reason) > maxCloseReason {
+		log.Printf("⚠️ CHAT_RECONNECT_URL не помещается в кадр закрытия, адрес не передается клиентам")
+		reason = "server restarting, reconnect"
+	}
+	return reason
+}
+
+// closeGracefully отключает соединение, как closeWith, но writePump сначала отправляет
+// клиенту все кадры, уже стоящие в очереди
+func (c *Client) closeGracefully(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeFrame = websocket.FormatCloseMessage(code, reason)
+		c.flushOnClose = true
+		close(c.done)
+	})
+}
+
+// waitGroup дожидается wg или истечения ctx
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
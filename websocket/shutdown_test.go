@@ -0,0 +1,136 @@
+// websocket/shutdown_test.go
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdownFlushesQueuesAndCloses(t *testing.T) {
+	m := startManager(t)
+	m.ReconnectURL = "wss://chat-2.example.com/ws"
+	srv := startServer(t, m)
+
+	const users = 4
+	conns := make([]*websocket.Conn, users)
+	for i := range conns {
+		conns[i] = dial(t, srv, 300+i)
+		if conns[i] == nil {
+			t.FailNow()
+		}
+		if _, err := readUntil(conns[i], "hello", 5*time.Second); err != nil {
+			t.Fatalf("клиент %d не получил hello: %v", i, err)
+		}
+	}
+	eventually(t, "клиенты зарегистрированы", func() bool { return m.clientCount() == users })
+
+	// Кадры, стоящие в очередях в момент остановки, должны дойти до клиентов
+	const frames = 20
+	for i := 0; i < frames; i++ {
+		for u := 0; u < users; u++ {
+			m.publishToUser(300+u, []byte(fmt.Sprintf(`{"type":"test","eventId":%d}`, i+1)), false)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- m.Shutdown(ctx) }()
+
+	for i, conn := range conns {
+		received := 0
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				var closeErr *websocket.CloseError
+				if !errors.As(err, &closeErr) {
+					t.Fatalf("клиент %d: ожидался кадр закрытия, получено %v", i, err)
+				}
+				if closeErr.Code != websocket.CloseServiceRestart || !strings.Contains(closeErr.Text, m.ReconnectURL) {
+					t.Fatalf("клиент %d: кадр закрытия %d %q", i, closeErr.Code, closeErr.Text)
+				}
+				break
+			}
+			if strings.Contains(string(data), `"test"`) {
+				received++
+			}
+		}
+		if received != frames {
+			t.Fatalf("клиент %d получил %d кадров из %d", i, received, frames)
+		}
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	select {
+	case <-m.stopped:
+	default:
+		t.Fatal("цикл менеджера не остановлен")
+	}
+	if err := m.Shutdown(ctx); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("повторный Shutdown: %v", err)
+	}
+
+	// Новые подключения отклоняются
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?user=1"
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("подключение после остановки: %v, %v", resp, err)
+	}
+}
+
+func TestShutdownRejectsNewFrames(t *testing.T) {
+	m := startManager(t)
+	srv := startServer(t, m)
+
+	conn := dial(t, srv, 400)
+	if conn == nil {
+		t.FailNow()
+	}
+	defer conn.Close()
+	if _, err := readUntil(conn, "hello", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Кадр, который обрабатывается, задерживает остановку: пока он учтен, Shutdown ждет
+	if !m.acquire(&m.frames) {
+		t.Fatal("кадр не принят до остановки")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- m.Shutdown(ctx) }()
+	eventually(t, "остановка началась", func() bool {
+		m.shutdownMutex.RLock()
+		defer m.shutdownMutex.RUnlock()
+		return m.draining
+	})
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping","clientMsgId":"p1"}`))
+	msg, err := readUntil(conn, frameTypeError, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != ErrCodeUnavailable || msg.ClientMsgID != "p1" {
+		t.Fatalf("ответ на кадр во время остановки: %+v", msg)
+	}
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown завершился, не дождавшись кадра: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	m.frames.Done()
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
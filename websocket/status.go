@@ -6,90 +6,99 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/LilVoxy/coursework_chat/auth"
 )
 
-// updateUserStatus обновляет статус пользователя
+// updateUserStatus обновляет статус пользователя и рассылает его через шину всем узлам.
+// Изменение выполняет цикл менеджера; функция не ждет его завершения.
 func (manager *Manager) updateUserStatus(userID int, status string, isActive bool) {
-	manager.statusMutex.Lock()
-	defer manager.statusMutex.Unlock()
+	manager.do(func() { manager.setUserStatus(userID, status, isActive) })
+}
 
-	if _, exists := manager.UserStatuses[userID]; !exists {
-		manager.UserStatuses[userID] = &UserStatus{
-			LastSeen: time.Now(),
-		}
+// userStatus возвращает статус пользователя, создавая его при необходимости (только в цикле Run)
+func (manager *Manager) userStatus(userID int) *UserStatus {
+	status, exists := manager.UserStatuses[userID]
+	if !exists {
+		status = &UserStatus{LastSeen: time.Now()}
+		manager.UserStatuses[userID] = status
 	}
+	return status
+}
 
-	statusObj := manager.UserStatuses[userID]
+// setUserStatus изменяет статус пользователя (только в цикле Run)
+func (manager *Manager) setUserStatus(userID int, status string, isActive bool) {
+	statusObj := manager.userStatus(userID)
 	oldStatus := statusObj.Status
 
 	// Обновляем статус только если он действительно изменился
-	if statusObj.Status != status || statusObj.IsActive != isActive {
-		statusObj.Status = status
-		statusObj.IsActive = isActive
-		statusObj.LastPing = time.Now()
-		statusObj.LastSeen = time.Now()
-
-		// Логируем изменение статуса
-		log.Printf("📊 Статус пользователя %d изменен: %s -> %s (активен: %v)",
-			userID, oldStatus, status, isActive)
-
-		// Создаем сообщение о статусе
-		statusMsg := Message{
-			Type:   "status",
-			UserID: userID,
-			Status: status,
-		}
-
-		// Отправляем статус всем клиентам, кроме самого пользователя
-		if data, err := json.Marshal(statusMsg); err == nil {
-			for clientID, client := range manager.Clients {
-				// Исключаем отправку статуса самому пользователю, чтобы избежать дублирования
-				if clientID != userID {
-					select {
-					case client.Send <- data:
-					default:
-						close(client.Send)
-						delete(manager.Clients, client.ID)
-					}
-				}
-			}
-		}
+	if statusObj.Status == status && statusObj.IsActive == isActive {
+		return
 	}
-}
+	statusObj.Status = status
+	statusObj.IsActive = isActive
+	statusObj.LastPing = time.Now()
+	statusObj.LastSeen = time.Now()
 
-// checkUserActivity проверяет активность пользователей и обновляет их статусы
-func (manager *Manager) checkUserActivity() {
-	for {
-		time.Sleep(inactivityTimeout / 2) // Проверяем каждые 30 секунд
+	// Логируем изменение статуса
+	log.Printf("📊 Статус пользователя %d изменен: %s -> %s (активен: %v)",
+		userID, oldStatus, status, isActive)
 
-		manager.statusMutex.Lock()
-		now := time.Now()
+	// Отправляем статус всем клиентам, кроме самого пользователя (рассылку выполняет каждый узел)
+	manager.announcePresence(userID, true)
+}
 
+// touchUser отмечает активность соединения пользователя (кадр или ответ на пинг)
+func (manager *Manager) touchUser(userID int) {
+	now := time.Now()
+	manager.do(func() {
+		if status, exists := manager.UserStatuses[userID]; exists {
+			status.LastPing = now
+			status.LastSeen = now
+		}
+	})
+}
+
+// statusSnapshot возвращает статусы всех пользователей, кроме excludeUserID
+func (manager *Manager) statusSnapshot(excludeUserID int) []Message {
+	var statuses []Message
+	manager.call(func() {
 		for userID, status := range manager.UserStatuses {
-			// Проверяем время последней активности
-			timeSinceLastSeen := now.Sub(status.LastSeen)
-			timeSinceLastPing := now.Sub(status.LastPing)
-
-			// Логируем состояние пользователя
-			log.Printf("👤 Проверка пользователя %d: статус=%s, активен=%v, последняя активность=%v назад, последний пинг=%v назад",
-				userID, status.Status, status.IsActive, timeSinceLastSeen, timeSinceLastPing)
-
-			// Если пользователь подключен и неактивен более 60 секунд
-			if status.Connected && status.Status == "online" && timeSinceLastPing > 60*time.Second {
-				// Помечаем пользователя как неактивного
-				manager.updateUserStatus(userID, "away", false)
-				log.Printf("⚠️ Пользователь %d помечен как неактивный", userID)
+			if userID != excludeUserID {
+				statuses = append(statuses, Message{Type: "status", UserID: userID, Status: status.Status})
 			}
+		}
+	})
+	return statuses
+}
 
-			// Если пользователь не пинговал сервер более 120 секунд
-			if status.Connected && timeSinceLastPing > 120*time.Second {
-				// Помечаем как отключенного
-				manager.updateUserStatus(userID, "offline", false)
-				log.Printf("❌ Пользователь %d помечен как отключенный", userID)
-			}
+// checkUserActivity проверяет активность пользователей и обновляет их статусы.
+// Вызывается циклом Run, поэтому статусы меняются напрямую, без повторного входа в цикл.
+func (manager *Manager) checkUserActivity() {
+	now := time.Now()
+
+	for userID, status := range manager.UserStatuses {
+		// Проверяем время последней активности
+		timeSinceLastSeen := now.Sub(status.LastSeen)
+		timeSinceLastPing := now.Sub(status.LastPing)
+
+		// Логируем состояние пользователя
+		log.Printf("👤 Проверка пользователя %d: статус=%s, активен=%v, последняя активность=%v назад, последний пинг=%v назад",
+			userID, status.Status, status.IsActive, timeSinceLastSeen, timeSinceLastPing)
+
+		// Если пользователь подключен и неактивен дольше таймаута бездействия
+		if status.Connected && status.Status == "online" && timeSinceLastPing > manager.Limits.InactivityTimeout {
+			// Помечаем пользователя как неактивного
+			manager.setUserStatus(userID, "away", false)
+			log.Printf("⚠️ Пользователь %d помечен как неактивный", userID)
 		}
 
-		manager.statusMutex.Unlock()
+		// Если пользователь не пинговал сервер вдвое дольше таймаута бездействия
+		if status.Connected && timeSinceLastPing > 2*manager.Limits.InactivityTimeout {
+			// Помечаем как отключенного
+			manager.setUserStatus(userID, "offline", false)
+			log.Printf("❌ Пользователь %d помечен как отключенный", userID)
+		}
 	}
 }
 
@@ -111,6 +120,18 @@ func (manager *Manager) HandleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Пользователь может менять только собственный статус
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if msg.UserID != 0 && msg.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	msg.UserID = userID
+
 	// Обновляем статус с учетом активности через единый метод
 	manager.updateUserStatus(msg.UserID, msg.Status, msg.IsActive)
 
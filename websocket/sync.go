@@ -0,0 +1,407 @@
+// websocket/sync.go
+package websocket
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/LilVoxy/coursework_chat/attachments"
+	"github.com/LilVoxy/coursework_chat/messages"
+)
+
+// Типы событий, которые сохраняются в очередь пользователя и воспроизводятся при синхронизации
+const (
+	eventTypeMessage = "message" // Новое сообщение (содержимое загружается из messages при воспроизведении)
+	eventTypeReceipt = "receipt" // Изменение состояния доставки/прочтения
+	eventTypeChat    = "chat"    // Создание чата и изменение состава участников
+	eventTypeEdit    = "edit"    // Правка сообщения (текст загружается из messages при воспроизведении)
+	eventTypeDelete  = "delete"  // Удаление сообщения
+)
+
+const (
+	// Размер пачки событий, читаемых из БД при синхронизации
+	syncBatchSize = 200
+
+	// Срок хранения событий в очереди пользователя
+	eventRetention = 30 * 24 * time.Hour
+
+	// Период очистки устаревших событий
+	eventPruneInterval = time.Hour
+)
+
+// pendingFrame - событие, пришедшее клиенту во время синхронизации
+type pendingFrame struct {
+	EventID int64
+	Data    []byte
+}
+
+// recordEvent сохраняет событие в очередь пользователя и возвращает его ID.
+// Содержимое сообщений в очередь не копируется.
+func (m *Manager) recordEvent(userID int, frame Message) (int64, error) {
+	frame.Content = ""
+	frame.Encrypted = nil
+	frame.EventID = 0
+
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		return 0, err
+	}
+
+	var messageID sql.NullInt64
+	if frame.Type == eventTypeMessage && frame.ID > 0 {
+		messageID = sql.NullInt64{Int64: int64(frame.ID), Valid: true}
+	} else if (frame.Type == eventTypeEdit || frame.Type == eventTypeDelete) && frame.MessageID > 0 {
+		messageID = sql.NullInt64{Int64: int64(frame.MessageID), Valid: true}
+	}
+
+	result, err := m.DB.Exec(`
+		INSERT INTO user_events (user_id, chat_id, message_id, event_type, payload)
+		VALUES (?, ?, ?, ?, ?)
+	`, userID, frame.ChatID, messageID, frame.Type, string(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// deliverEvent сохраняет событие в очередь пользователя и через шину отправляет его
+// на все устройства, которые сейчас в сети на любом узле.
+// Если пользователь не в сети, событие будет доставлено при следующей синхронизации.
+func (m *Manager) deliverEvent(userID int, frame Message) {
+	eventID, err := m.recordEvent(userID, frame)
+	if err != nil {
+		log.Printf("⚠️ Ошибка при сохранении события %s для пользователя %d: %v", frame.Type, userID, err)
+	}
+	frame.EventID = eventID
+
+	data, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("❌ Ошибка при сериализации события %s: %v", frame.Type, err)
+		return
+	}
+
+	event := BusEvent{Kind: busDeliver, UserID: userID, EventID: eventID, Payload: data}
+
+	// Узел, доставивший новое сообщение получателю, сохранит отметку о доставке
+	if frame.Type == eventTypeMessage && userID != frame.FromID && frame.State == messageStateSent {
+		event.MessageID = frame.ID
+		event.ChatID = frame.ChatID
+		event.SenderID = frame.FromID
+	}
+
+	m.publish(event)
+}
+
+// handleSync воспроизводит клиенту все события, пропущенные с момента, заданного курсором:
+// lastEventId, lastMessageId или курсоры по отдельным чатам (chatId -> ID последнего сообщения).
+// Пока идет воспроизведение, новые события для этого устройства откладываются.
+func (m *Manager) handleSync(message []byte, client *Client) (*Message, error) {
+	var req Message
+	if err := json.Unmarshal(message, &req); err != nil {
+		return nil, newFrameError(ErrCodeBadFrame, "некорректный запрос синхронизации: %v", err)
+	}
+
+	// Синхронизация забирает и события, оставленные в БД политикой spill
+	client.syncMutex.Lock()
+	client.syncing = true
+	client.spilling = false
+	client.syncMutex.Unlock()
+
+	// Даже если воспроизведение прервалось, отложенные события нужно отправить
+	lastEventID, replayErr := m.replayEvents(client, req)
+
+	// Отправляем события, накопившиеся во время воспроизведения, пропуская уже отправленные
+	client.syncMutex.Lock()
+	pending := client.pending
+	client.pending = nil
+	client.syncing = false
+	client.syncMutex.Unlock()
+
+	for _, frame := range pending {
+		if frame.EventID != 0 && frame.EventID <= lastEventID {
+			continue
+		}
+		client.send(frame.Data)
+		if frame.EventID > lastEventID {
+			lastEventID = frame.EventID
+		}
+	}
+
+	if data, err := json.Marshal(Message{Type: "sync_complete", LastEventID: lastEventID}); err == nil {
+		client.send(data)
+	}
+
+	if replayErr != nil {
+		return nil, fmt.Errorf("ошибка синхронизации сессии %s: %w", client.SessionID, replayErr)
+	}
+	log.Printf("✅ Синхронизация пользователя %d (сессия %s) завершена, последнее событие: %d",
+		client.UserID, client.SessionID, lastEventID)
+	return nil, nil
+}
+
+// replayEvents отправляет клиенту события из очереди пользователя по порядку.
+// Возвращает ID последнего отправленного события.
+func (m *Manager) replayEvents(client *Client, req Message) (int64, error) {
+	globalStart := req.LastEventID
+	if globalStart == 0 && req.LastMessageID > 0 {
+		start, err := m.eventIDForMessage(client.UserID, 0, req.LastMessageID)
+		if err != nil {
+			return 0, err
+		}
+		globalStart = start
+	}
+
+	// Курсоры по отдельным чатам переводим в ID событий
+	chatStarts := make(map[int]int64)
+	minStart := globalStart
+	for chatID, lastMessageID := range req.Cursors {
+		start, err := m.eventIDForMessage(client.UserID, chatID, lastMessageID)
+		if err != nil {
+			return 0, err
+		}
+		chatStarts[chatID] = start
+		if start < minStart {
+			minStart = start
+		}
+	}
+
+	lastSent := minStart
+	replayed := 0
+	for {
+		rows, err := m.DB.Query(`
+			SELECT id, chat_id, message_id, event_type, payload
+			FROM user_events
+			WHERE user_id = ? AND id > ?
+			ORDER BY id
+			LIMIT ?
+		`, client.UserID, lastSent, syncBatchSize)
+		if err != nil {
+			return lastSent, err
+		}
+
+		type storedEvent struct {
+			ID        int64
+			ChatID    int
+			MessageID sql.NullInt64
+			Type      string
+			Payload   string
+		}
+		var batch []storedEvent
+		for rows.Next() {
+			var ev storedEvent
+			if err := rows.Scan(&ev.ID, &ev.ChatID, &ev.MessageID, &ev.Type, &ev.Payload); err != nil {
+				rows.Close()
+				return lastSent, err
+			}
+			batch = append(batch, ev)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return lastSent, err
+		}
+
+		for _, ev := range batch {
+			lastSent = ev.ID
+
+			// Событие уже видено клиентом согласно курсору его чата
+			start, ok := chatStarts[ev.ChatID]
+			if !ok {
+				start = globalStart
+			}
+			if ev.ID <= start {
+				continue
+			}
+
+			var frame Message
+			if ev.Type == eventTypeMessage && ev.MessageID.Valid {
+				frame, err = m.loadMessageFrame(int(ev.MessageID.Int64))
+				if err == sql.ErrNoRows {
+					continue
+				}
+				if err != nil {
+					log.Printf("⚠️ Не удалось загрузить сообщение %d для синхронизации: %v", ev.MessageID.Int64, err)
+					continue
+				}
+
+				if hidden, err := m.isMessageHidden(frame.ID, client.UserID); err == nil && hidden {
+					continue
+				}
+
+				// Сообщение, полученное при синхронизации, считается доставленным
+				if frame.FromID != client.UserID && frame.State == messageStateSent && !frame.Deleted {
+					m.confirmDelivery(frame)
+					frame.State = messageStateDelivered
+				}
+			} else if ev.Type == eventTypeEdit && ev.MessageID.Valid {
+				// Отправляем актуальный текст; если сообщение уже удалено, в очереди есть событие delete
+				current, err := m.loadMessageFrame(int(ev.MessageID.Int64))
+				if err != nil || current.Deleted {
+					continue
+				}
+				frame = Message{
+					Type:      eventTypeEdit,
+					ChatID:    current.ChatID,
+					MessageID: current.ID,
+					FromID:    current.FromID,
+					Content:   current.Content,
+					EditedAt:  current.EditedAt,
+				}
+			} else if err := json.Unmarshal([]byte(ev.Payload), &frame); err != nil {
+				log.Printf("⚠️ Поврежденное событие %d в очереди пользователя %d: %v", ev.ID, client.UserID, err)
+				continue
+			}
+
+			frame.EventID = ev.ID
+			data, err := json.Marshal(frame)
+			if err != nil {
+				continue
+			}
+			if !client.send(data) {
+				return lastSent, nil
+			}
+			replayed++
+		}
+
+		if len(batch) < syncBatchSize {
+			break
+		}
+	}
+
+	log.Printf("📨 Пользователю %d (сессия %s) воспроизведено %d событий", client.UserID, client.SessionID, replayed)
+	return lastSent, nil
+}
+
+// eventIDForMessage возвращает ID события, в котором пользователь получил сообщение lastMessageID
+// (или последнее сообщение до него). Если chatID не равен нулю, учитываются только события этого чата.
+func (m *Manager) eventIDForMessage(userID, chatID, lastMessageID int) (int64, error) {
+	query := `
+		SELECT COALESCE(MAX(id), 0) FROM user_events
+		WHERE user_id = ? AND event_type = ? AND message_id <= ?`
+	args := []interface{}{userID, eventTypeMessage, lastMessageID}
+	if chatID != 0 {
+		query += " AND chat_id = ?"
+		args = append(args, chatID)
+	}
+
+	var eventID int64
+	err := m.DB.QueryRow(query, args...).Scan(&eventID)
+	return eventID, err
+}
+
+// loadMessageFrame загружает сообщение из БД в формате WebSocket с актуальным состоянием доставки
+func (m *Manager) loadMessageFrame(messageID int) (Message, error) {
+	var msg Message
+	var createdAt time.Time
+	var readStatus sql.NullBool
+	var deliveredAt, readAt, editedAt, deletedAt sql.NullTime
+	var attachmentID sql.NullInt64
+	var payload sql.NullString
+
+	err := m.DB.QueryRow(`
+		SELECT m.id, m.chat_id, m.sender_id, m.message, m.created_at, m.read_status,
+		       m.delivered_at, m.read_at, m.edited_at, m.deleted_at, m.attachment_id, c.product_id, e.payload
+		FROM messages m
+		JOIN chats c ON m.chat_id = c.id
+		LEFT JOIN message_e2e_payloads e ON e.message_id = m.id
+		WHERE m.id = ?
+	`, messageID).Scan(&msg.ID, &msg.ChatID, &msg.FromID, &msg.Content, &createdAt, &readStatus,
+		&deliveredAt, &readAt, &editedAt, &deletedAt, &attachmentID, &msg.ProductID, &payload)
+	if err != nil {
+		return msg, err
+	}
+	msg.Content = m.Messages.Decrypt(msg.Content)
+	msg.Encrypted = messages.ParsePayload(payload)
+
+	if editedAt.Valid {
+		msg.EditedAt = editedAt.Time.Format(time.RFC3339)
+	}
+	if deletedAt.Valid {
+		msg.Deleted = true
+		msg.DeletedAt = deletedAt.Time.Format(time.RFC3339)
+		msg.Scope = DeleteScopeEveryone
+		msg.Content = ""
+	} else if attachmentID.Valid {
+		msg.AttachmentID = int(attachmentID.Int64)
+		if msg.Attachment, err = attachments.Load(m.DB, msg.AttachmentID); err != nil {
+			log.Printf("⚠️ Не удалось загрузить вложение %d сообщения %d: %v", msg.AttachmentID, msg.ID, err)
+		}
+	}
+
+	msg.Type = eventTypeMessage
+	msg.Timestamp = createdAt.Format("15:04")
+
+	// В диалоге двух участников указываем собеседника, в групповом чате toId не заполняется
+	participants, err := m.GetChatParticipants(msg.ChatID)
+	if err != nil {
+		return msg, err
+	}
+	var recipients []int
+	for _, p := range participants {
+		if p.UserID != msg.FromID {
+			recipients = append(recipients, p.UserID)
+		}
+	}
+	msg.ToID = directRecipient(recipients)
+
+	msg.State = messageStateSent
+	if deliveredAt.Valid {
+		msg.State = messageStateDelivered
+		msg.DeliveredAt = deliveredAt.Time.Format(time.RFC3339)
+	}
+	if readAt.Valid || (readStatus.Valid && readStatus.Bool) {
+		msg.State = messageStateRead
+		msg.ReadStatus = true
+	}
+	if readAt.Valid {
+		msg.ReadAt = readAt.Time.Format(time.RFC3339)
+	}
+
+	return msg, nil
+}
+
+// confirmDelivery сохраняет время доставки сообщения и уведомляет отправителя
+func (m *Manager) confirmDelivery(msg Message) {
+	deliveredAt, changed, err := m.markMessageDelivered(msg.ID)
+	if err != nil {
+		log.Printf("⚠️ Ошибка при сохранении отметки о доставке сообщения %d: %v", msg.ID, err)
+		return
+	}
+	if !changed {
+		// Сообщение уже доставлено на другое устройство, отправитель об этом знает
+		return
+	}
+
+	m.deliverEvent(msg.FromID, Message{
+		Type:        eventTypeReceipt,
+		ChatID:      msg.ChatID,
+		MessageIDs:  []int{msg.ID},
+		State:       messageStateDelivered,
+		DeliveredAt: deliveredAt.Format(time.RFC3339),
+	})
+}
+
+// pruneEvents периодически удаляет из очередей события старше eventRetention
+func (m *Manager) pruneEvents() {
+	ticker := time.NewTicker(eventPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			return
+		}
+		result, err := m.DB.Exec(`DELETE FROM user_events WHERE created_at < ?`, time.Now().Add(-eventRetention))
+		if err != nil {
+			log.Printf("⚠️ Ошибка при очистке очереди событий: %v", err)
+			continue
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			log.Printf("🧹 Удалено %d устаревших событий из очередей пользователей", n)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// websocket/transport.go
+package websocket
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/LilVoxy/coursework_chat/processor"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Подпротоколы WebSocket (заголовок Sec-WebSocket-Protocol), определяющие кодировку кадров
+const (
+	SubprotocolJSON    = "mychat.json.v1"
+	SubprotocolMsgpack = "mychat.msgpack.v1"
+)
+
+// ErrUnexpectedFrame - кадр не соответствует кодировке соединения
+var ErrUnexpectedFrame = errors.New("тип кадра WebSocket не соответствует кодировке соединения")
+
+// FrameEncoding - кодировка кадров соединения. Внутри сервера (очередь событий, шина, воспроизведение)
+// кадры всегда хранятся в JSON, кодировка применяется только при чтении из сокета и записи в него,
+// поэтому типы и поля кадров одинаковы для всех кодировок.
+type FrameEncoding interface {
+	Name() string
+	MessageType() int                    // Тип кадра WebSocket для исходящих кадров
+	Encode(frame []byte) ([]byte, error) // JSON -> кодировка соединения
+	Decode(data []byte) ([]byte, error)  // Кодировка соединения -> JSON
+}
+
+// Кодировки в порядке предпочтения сервера: если клиент предлагает несколько, выбирается первая
+var frameEncodings = []FrameEncoding{MsgpackEncoding{}, JSONEncoding{}}
+
+// subprotocols возвращает подпротоколы для upgrader
+func subprotocols() []string {
+	names := make([]string, len(frameEncodings))
+	for i, enc := range frameEncodings {
+		names[i] = enc.Name()
+	}
+	return names
+}
+
+// encodingBySubprotocol возвращает кодировку выбранного подпротокола. Без подпротокола используется JSON.
+func encodingBySubprotocol(name string) FrameEncoding {
+	for _, enc := range frameEncodings {
+		if enc.Name() == name {
+			return enc
+		}
+	}
+	return JSONEncoding{}
+}
+
+// setCodecs запоминает кодеки, согласованные с клиентом. Если среди них есть кодек сжатия,
+// все исходящие кадры соединения отправляются бинарными сообщениями WebSocket: первый байт -
+// идентификатор кодека (processor.DecodeFrame), дальше кадр в кодировке соединения, сжатый
+// кодеком, выбранным по размеру кадра (короткие кадры - с кодеком none). Входящие кадры не сжимаются.
+func (c *Client) setCodecs(codecs []processor.Codec) {
+	c.Codecs = processor.CodecNames(codecs)
+	for _, codec := range codecs {
+		if codec.ID() != processor.CodecIDNone {
+			c.compress.Store(processor.NewCompressStage(codecs))
+			return
+		}
+	}
+	c.compress.Store(nil)
+}
+
+// decodeFrame переводит прочитанный из сокета кадр в JSON.
+// Текстовые кадры всегда содержат JSON, бинарные принимаются только в бинарной кодировке.
+func decodeFrame(enc FrameEncoding, messageType int, data []byte) ([]byte, error) {
+	if messageType == websocket.TextMessage {
+		return data, nil
+	}
+	if enc.MessageType() != websocket.BinaryMessage {
+		return nil, ErrUnexpectedFrame
+	}
+	return enc.Decode(data)
+}
+
+// JSONEncoding - текстовые кадры JSON (по умолчанию)
+type JSONEncoding struct{}
+
+func (JSONEncoding) Name() string                        { return SubprotocolJSON }
+func (JSONEncoding) MessageType() int                    { return websocket.TextMessage }
+func (JSONEncoding) Encode(frame []byte) ([]byte, error) { return frame, nil }
+func (JSONEncoding) Decode(data []byte) ([]byte, error)  { return data, nil }
+
+// MsgpackEncoding - бинарные кадры MessagePack для мобильных клиентов.
+// Поля те же, что в JSON, а шифротекст, подпись и завернутые ключи зашифрованного сообщения
+// передаются как двоичные данные (bin) без base64. Любое двоичное значение во входящем кадре
+// соответствует строке base64 в JSON.
+type MsgpackEncoding struct{}
+
+func (MsgpackEncoding) Name() string     { return SubprotocolMsgpack }
+func (MsgpackEncoding) MessageType() int { return websocket.BinaryMessage }
+
+func (MsgpackEncoding) Encode(frame []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(frame))
+	dec.UseNumber()
+	var value map[string]interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if encrypted, ok := value["encrypted"].(map[string]interface{}); ok {
+		if err := binaryEncryptedFields(encrypted); err != nil {
+			return nil, err
+		}
+	}
+	return msgpack.Marshal(fromJSONValue(value))
+}
+
+func (MsgpackEncoding) Decode(data []byte) ([]byte, error) {
+	var value map[string]interface{}
+	if err := msgpack.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("некорректный кадр MessagePack: %w", err)
+	}
+	// []byte кодируется encoding/json как строка base64
+	return json.Marshal(value)
+}
+
+// binaryEncryptedFields заменяет поля base64 зашифрованного сообщения двоичными данными
+func binaryEncryptedFields(encrypted map[string]interface{}) error {
+	if err := decodeBase64Field(encrypted, "ciphertext"); err != nil {
+		return err
+	}
+	if err := decodeBase64Field(encrypted, "signature"); err != nil {
+		return err
+	}
+	keys, _ := encrypted["keys"].([]interface{})
+	for _, k := range keys {
+		if key, ok := k.(map[string]interface{}); ok {
+			if err := decodeBase64Field(key, "encryptedKey"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func decodeBase64Field(obj map[string]interface{}, field string) error {
+	s, ok := obj[field].(string)
+	if !ok || s == "" {
+		return nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("поле %s: %w", field, err)
+	}
+	obj[field] = raw
+	return nil
+}
+
+// fromJSONValue переводит числа json.Number в целые (или дробные) значения MessagePack
+func fromJSONValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = fromJSONValue(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = fromJSONValue(item)
+		}
+		return value
+	}
+	return v
+}
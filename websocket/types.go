@@ -5,36 +5,118 @@ import (
 	"database/sql"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/LilVoxy/coursework_chat/attachments"
+	"github.com/LilVoxy/coursework_chat/config"
+	"github.com/LilVoxy/coursework_chat/messages"
+	"github.com/LilVoxy/coursework_chat/processor"
 	"github.com/gorilla/websocket"
 )
 
 // Структура сообщения для обмена через WebSocket
 type Message struct {
-	Type       string `json:"type"`
-	FromID     int    `json:"fromId,omitempty"`
-	ToID       int    `json:"toId,omitempty"`
-	ProductID  int    `json:"productId,omitempty"`
-	ChatID     int    `json:"chatId,omitempty"` // ID чата, к которому относится сообщение
-	Content    string `json:"content,omitempty"`
-	UserID     int    `json:"userId,omitempty"`
-	Status     string `json:"status,omitempty"`
-	IsActive   bool   `json:"isActive,omitempty"`
-	ID         int    `json:"id,omitempty"`
-	Timestamp  string `json:"timestamp,omitempty"`  // Время отправки сообщения
-	ReadStatus bool   `json:"readStatus,omitempty"` // Статус прочтения сообщения
+	V          int      `json:"v,omitempty"` // Версия протокола (см. ProtocolVersion)
+	Type       string   `json:"type"`
+	FromID     int      `json:"fromId,omitempty"`
+	ToID       int      `json:"toId,omitempty"`
+	ProductID  int      `json:"productId,omitempty"`
+	ChatID     int      `json:"chatId,omitempty"` // ID чата, к которому относится сообщение
+	Content    string   `json:"content,omitempty"`
+	UserID     int      `json:"userId,omitempty"`
+	Status     string   `json:"status,omitempty"`
+	IsActive   bool     `json:"isActive,omitempty"`
+	ID         int      `json:"id,omitempty"`
+	Timestamp  string   `json:"timestamp,omitempty"`  // Время отправки сообщения
+	SessionID  string   `json:"sessionId,omitempty"`  // ID сессии (устройства), выданный сервером
+	Encoding   string   `json:"encoding,omitempty"`   // Кодировка кадров соединения (подпротокол WebSocket)
+	Codecs     []string `json:"codecs,omitempty"`     // Кодеки сжатия, согласованные при подключении
+	ReadStatus bool     `json:"readStatus,omitempty"` // Статус прочтения сообщения
+
+	// Поля для отметок о доставке и прочтении
+	MessageID   int    `json:"messageId,omitempty"`   // ID сообщения, до которого (включительно) прочитан чат
+	MessageIDs  []int  `json:"messageIds,omitempty"`  // ID сообщений, затронутых изменением состояния
+	State       string `json:"state,omitempty"`       // Состояние сообщения: sent, delivered, read
+	DeliveredAt string `json:"deliveredAt,omitempty"` // Время доставки (RFC3339)
+	ReadAt      string `json:"readAt,omitempty"`      // Время прочтения (RFC3339)
+
+	// Поля для синхронизации после переподключения
+	EventID       int64       `json:"eventId,omitempty"`       // ID события в очереди пользователя
+	LastEventID   int64       `json:"lastEventId,omitempty"`   // Последнее событие, полученное клиентом
+	LastMessageID int         `json:"lastMessageId,omitempty"` // Последнее сообщение, полученное клиентом
+	Cursors       map[int]int `json:"cursors,omitempty"`       // Курсоры по чатам: ID чата -> ID последнего сообщения
+	BuyerID       int         `json:"buyerId,omitempty"`       // Покупатель (для события создания чата)
+	SellerID      int         `json:"sellerId,omitempty"`      // Продавец (для события создания чата)
+
+	// Поля для редактирования и удаления сообщений
+	EditedAt  string `json:"editedAt,omitempty"`  // Время последней правки (RFC3339)
+	Deleted   bool   `json:"deleted,omitempty"`   // Сообщение удалено
+	DeletedAt string `json:"deletedAt,omitempty"` // Время удаления (RFC3339)
+	Scope     string `json:"scope,omitempty"`     // Область удаления: me или everyone
+
+	// Вложение: клиент передает attachmentId загруженного файла, сервер добавляет его метаданные
+	AttachmentID int                     `json:"attachmentId,omitempty"`
+	Attachment   *attachments.Attachment `json:"attachment,omitempty"`
+
+	// Состав участников и настройки чата (для события chat)
+	Participants []Participant `json:"participants,omitempty"`
+	E2E          bool          `json:"e2e,omitempty"` // В чате включено сквозное шифрование
+
+	// Сообщение, зашифрованное на клиенте: сервер пересылает его без изменений
+	Encrypted *messages.EncryptedPayload `json:"encrypted,omitempty"`
+
+	// ID кадра, созданный клиентом: по нему сервер отвечает ack или error и распознает повторную отправку
+	ClientMsgID string `json:"clientMsgId,omitempty"`
+
+	// Поля кадров ack и error
+	Ref   string `json:"ref,omitempty"`   // Тип кадра, к которому относится ответ
+	Code  string `json:"code,omitempty"`  // Код ошибки (ErrCode*)
+	Error string `json:"error,omitempty"` // Описание ошибки
+
+	// Поля кадра hello
+	Versions     []int         `json:"versions,omitempty"`     // Поддерживаемые версии протокола
+	Capabilities *Capabilities `json:"capabilities,omitempty"` // Возможности сервера
 }
 
 // Клиент WebSocket
 type Client struct {
 	ID           int
-	UserID       int // ID пользователя
+	UserID       int    // ID пользователя
+	SessionID    string // ID сессии (устройства) пользователя
 	Socket       *websocket.Conn
 	Conn         *websocket.Conn // Псевдоним для Socket для совместимости
 	Send         chan []byte
-	Manager      *Manager  // Ссылка на менеджер
-	LastActivity time.Time // Время последней активности
+	Manager      *Manager         // Ссылка на менеджер
+	LastActivity time.Time        // Время последней активности
+	Codecs       []string         // Кодеки сжатия, согласованные при подключении (в порядке предпочтения)
+	Encoding     FrameEncoding    // Кодировка кадров, выбранная подпротоколом WebSocket
+	RemoteAddr   string           // Адрес клиента
+	Policy       SlowClientPolicy // Что делать при переполнении очереди отправки
+
+	// Сжатие исходящих кадров согласованными кодеками (nil - кадры не сжимаются, см. setCodecs).
+	// Меняется кадром hello в readPump, читается в writePump.
+	compress atomic.Pointer[processor.CompressStage]
+
+	// Счетчики очереди отправки и запрос кадра sync_required (см. backpressure.go)
+	stats        queueStats
+	syncRequired atomic.Bool
+	notify       chan struct{}
+
+	// Канал Send никогда не закрывается: его читает writePump, а писать в него могут
+	// несколько горутин. Отключение соединения обозначается закрытием done (см. close).
+	done         chan struct{}
+	closeOnce    sync.Once
+	closeFrame   []byte        // Кадр закрытия, который writePump отправит клиенту
+	flushOnClose bool          // Перед закрытием отправить клиенту кадры из очереди (остановка сервера)
+	finished     chan struct{} // Закрывается, когда writePump завершился
+
+	// Состояние синхронизации: пока идет воспроизведение пропущенных событий,
+	// новые события откладываются в pending
+	syncMutex sync.Mutex
+	syncing   bool
+	pending   []pendingFrame
+	spilling  bool // Политика spill: события ждут синхронизации в очереди пользователя
 }
 
 // Добавляем структуру для хранения статусов пользователей
@@ -48,20 +130,64 @@ type UserStatus struct {
 }
 
 // Менеджер WebSocket-соединений
+// Clients, UserStatuses и remoteDevices принадлежат циклу Run (см. loop.go).
 type Manager struct {
-	Clients      map[int]*Client
+	Clients      map[int]map[string]*Client // Соединения пользователей: ID пользователя -> ID сессии -> клиент
 	Broadcast    chan []byte
-	Register     chan *Client
-	Unregister   chan *Client
+	Register     chan *Client // Регистрация соединения (то же, что registerClient в цикле)
+	Unregister   chan *Client // Отключение соединения (то же, что unregisterClient в цикле)
 	DB           *sql.DB
 	UserStatuses map[int]*UserStatus
-	statusMutex  sync.RWMutex
+
+	// Команды циклу менеджера и действия, которые он выполняет вне своей горутины
+	inbox   chan func()
+	effects *effectQueue
+
+	// Шина событий между экземплярами сервера
+	Bus    Bus
+	NodeID string
+
+	// Количество устройств пользователей на других узлах: ID пользователя -> ID узла -> устройств.
+	// remoteNodes - время последнего события каждого узла: узел, молчащий дольше nodeExpiryBeats
+	// периодов Heartbeat, считается остановленным, и его устройства забываются.
+	remoteDevices map[int]map[string]int
+	remoteNodes   map[string]time.Time
+	Heartbeat     time.Duration // Период снимков присутствия (cluster.heartbeat_interval)
+
+	// Активные индикаторы набора текста (не сохраняются в БД)
+	typing      map[typingKey]*typingState
+	typingMutex sync.Mutex
+
+	// Сервис сообщений: сохранение, шифрование и рассылка
+	Messages *messages.Service
+
+	// Кодеки сжатия, которые сервер разрешает клиентам (CHAT_CODECS)
+	Codecs []processor.Codec
+
+	// Таймауты соединений и наибольший размер кадра
+	Limits config.WebSocketConfig
+
+	// Очередь отправки соединений и политика медленного клиента
+	Backpressure    BackpressureConfig
+	slowDisconnects atomic.Int64
+
+	// Остановка (см. shutdown.go): после draining новые соединения и кадры не принимаются,
+	// conns, frames и tasks учитывают соединения, обрабатываемые кадры и фоновую работу с БД
+	ReconnectURL  string // Адрес для переподключения в кадре закрытия (CHAT_RECONNECT_URL)
+	shutdownMutex sync.RWMutex
+	draining      bool
+	conns         sync.WaitGroup
+	frames        sync.WaitGroup
+	tasks         sync.WaitGroup
+	quit          chan struct{} // Закрывается Shutdown, чтобы остановить цикл Run
+	stopped       chan struct{} // Закрывается, когда цикл Run завершился
 }
 
 // Конфигурация WebSocket-соединения
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    subprotocols(),
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Разрешаем подключения с любого источника (для разработки)
 	},
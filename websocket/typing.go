@@ -0,0 +1,125 @@
+// websocket/typing.go
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// typingKey идентифицирует индикатор набора текста пользователя в конкретном чате
+type typingKey struct {
+	ChatID int
+	UserID int
+}
+
+// typingState хранит получателей уведомления и таймер автоматического снятия индикатора
+type typingState struct {
+	Recipients []int
+	timer      *time.Timer
+}
+
+// handleTyping обрабатывает сообщения typing и typing_stopped.
+// Уведомление пересылается остальным участникам указанного чата
+// и никогда не сохраняется в базу данных.
+func (m *Manager) handleTyping(message []byte, client *Client) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, newFrameError(ErrCodeBadFrame, "некорректное уведомление о наборе текста: %v", err)
+	}
+
+	if msg.ChatID == 0 {
+		return nil, newFrameError(ErrCodeInvalidRequest, "уведомление о наборе текста без chatId")
+	}
+
+	key := typingKey{ChatID: msg.ChatID, UserID: client.UserID}
+
+	if msg.Type == "typing_stopped" {
+		m.stopTyping(key, true)
+		return nil, nil
+	}
+
+	m.typingMutex.Lock()
+	state, exists := m.typing[key]
+	if exists {
+		// Индикатор уже показан получателю - только продлеваем его
+		state.timer.Reset(typingTimeout)
+		m.typingMutex.Unlock()
+		return nil, nil
+	}
+	m.typingMutex.Unlock()
+
+	recipients, err := m.chatRecipients(msg.ChatID, client.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.typingMutex.Lock()
+	if _, exists := m.typing[key]; exists {
+		// Пока мы обращались к БД, индикатор уже был установлен другим сообщением
+		m.typingMutex.Unlock()
+		return nil, nil
+	}
+	m.typing[key] = &typingState{
+		Recipients: recipients,
+		timer: time.AfterFunc(typingTimeout, func() {
+			m.stopTyping(key, true)
+		}),
+	}
+	m.typingMutex.Unlock()
+
+	m.sendTypingNotification("typing", key, recipients)
+	return nil, nil
+}
+
+// stopTyping снимает индикатор набора текста и при необходимости уведомляет получателя
+func (m *Manager) stopTyping(key typingKey, notify bool) {
+	m.typingMutex.Lock()
+	state, exists := m.typing[key]
+	if !exists {
+		m.typingMutex.Unlock()
+		return
+	}
+	state.timer.Stop()
+	delete(m.typing, key)
+	m.typingMutex.Unlock()
+
+	if notify {
+		m.sendTypingNotification("typing_stopped", key, state.Recipients)
+	}
+}
+
+// clearUserTyping снимает все индикаторы набора текста пользователя (например, при отключении)
+func (m *Manager) clearUserTyping(userID int) {
+	m.typingMutex.Lock()
+	var keys []typingKey
+	for key := range m.typing {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	m.typingMutex.Unlock()
+
+	for _, key := range keys {
+		m.stopTyping(key, true)
+	}
+}
+
+// sendTypingNotification отправляет уведомление о наборе текста получателям, которые в сети
+func (m *Manager) sendTypingNotification(msgType string, key typingKey, recipients []int) {
+	data, err := json.Marshal(Message{
+		Type:   msgType,
+		ChatID: key.ChatID,
+		FromID: key.UserID,
+		ToID:   directRecipient(recipients),
+	})
+	if err != nil {
+		log.Printf("❌ Ошибка при сериализации уведомления о наборе текста: %v", err)
+		return
+	}
+
+	// Уведомление о наборе текста не критично, при переполнении очереди его можно пропустить
+	for _, toID := range recipients {
+		m.publishToUser(toID, data, true)
+	}
+}
@@ -5,12 +5,13 @@ import (
 	"log"
 	"time"
 
+	"github.com/LilVoxy/coursework_chat/processor"
 	"github.com/gorilla/websocket"
 )
 
 // writePump отвечает за отправку сообщений клиенту
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.Manager.Limits.PingPeriod)
 	defer func() {
 		// Обработка паники при закрытии канала
 		if r := recover(); r != nil {
@@ -19,25 +20,43 @@ func (c *Client) writePump() {
 
 		ticker.Stop()
 
-		// Безопасно закрываем соединение
+		// Безопасно закрываем соединение и освобождаем горутины, ожидающие места в очереди
+		c.close()
 		c.Socket.Close()
 
 		log.Printf("Завершение writePump для клиента %d", c.ID)
+		if c.finished != nil {
+			close(c.finished)
+		}
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.Send:
-			c.Socket.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// Канал закрыт
-				c.Socket.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-c.done:
+			// Соединение отключено менеджером (для медленного клиента - с кодом 1013,
+			// при остановке сервера - с кодом 1012 после отправки очереди)
+			if c.flushOnClose && !c.flushQueue() {
 				return
 			}
+			c.Socket.SetWriteDeadline(time.Now().Add(c.Manager.Limits.WriteWait))
+			c.Socket.WriteMessage(websocket.CloseMessage, c.closeFrame)
+			return
+
+		case <-c.notify:
+			// Часть кадров не попала в очередь: клиент должен выполнить синхронизацию
+			if frame := c.syncRequiredFrame(); frame != nil {
+				c.Socket.SetWriteDeadline(time.Now().Add(c.Manager.Limits.WriteWait))
+				if err := c.writeFrame(frame); err != nil {
+					return
+				}
+			}
+
+		case message := <-c.Send:
+			c.Socket.SetWriteDeadline(time.Now().Add(c.Manager.Limits.WriteWait))
 
 			// Отправляем каждое сообщение отдельно, без добавления newline
 			// Это решает проблему с парсингом JSON на клиенте
-			if err := c.Socket.WriteMessage(websocket.TextMessage, message); err != nil {
+			if err := c.writeFrame(message); err != nil {
 				return
 			}
 
@@ -45,15 +64,51 @@ func (c *Client) writePump() {
 			n := len(c.Send)
 			for i := 0; i < n; i++ {
 				message := <-c.Send
-				if err := c.Socket.WriteMessage(websocket.TextMessage, message); err != nil {
+				if err := c.writeFrame(message); err != nil {
 					return
 				}
 			}
 		case <-ticker.C:
-			c.Socket.SetWriteDeadline(time.Now().Add(writeWait))
+			c.Socket.SetWriteDeadline(time.Now().Add(c.Manager.Limits.WriteWait))
 			if err := c.Socket.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		}
 	}
 }
+
+// flushQueue отправляет кадры, оставшиеся в очереди. Возвращает false при ошибке записи в сокет.
+func (c *Client) flushQueue() bool {
+	for {
+		select {
+		case message := <-c.Send:
+			c.Socket.SetWriteDeadline(time.Now().Add(c.Manager.Limits.WriteWait))
+			if err := c.writeFrame(message); err != nil {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// writeFrame отправляет JSON-кадр в кодировке соединения, сжатый согласованным кодеком
+// (см. setCodecs). Кадр, который не удалось перекодировать, пропускается; ошибка
+// возвращается только при ошибке записи в сокет.
+func (c *Client) writeFrame(frame []byte) error {
+	data, err := c.Encoding.Encode(frame)
+	if err != nil {
+		log.Printf("❌ Ошибка кодирования кадра %s для клиента %d: %v", c.Encoding.Name(), c.ID, err)
+		return nil
+	}
+	messageType := c.Encoding.MessageType()
+	if stage := c.compress.Load(); stage != nil {
+		p := &processor.Packet{Data: data}
+		if err := stage.Outbound(p); err != nil {
+			log.Printf("❌ Ошибка сжатия кадра для клиента %d: %v", c.ID, err)
+			return nil
+		}
+		data, messageType = p.Data, websocket.BinaryMessage
+	}
+	return c.Socket.WriteMessage(messageType, data)
+}